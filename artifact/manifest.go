@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/snow-ghost/agent/core"
@@ -25,6 +26,9 @@ type Manifest struct {
 	Embedding      []float32       `json:"embedding,omitempty"`
 	Tests          []core.TestCase `json:"tests"`
 	CreatedAt      string          `json:"created_at"`
+	// Capabilities are the host capabilities a WASM artifact may use; see
+	// core.Capabilities. Undeclared ones are denied.
+	Capabilities []string `json:"capabilities,omitempty"`
 }
 
 // NewManifest creates a new manifest with default values
@@ -103,6 +107,15 @@ func (m *Manifest) Validate() error {
 		}
 	}
 
+	for _, c := range m.Capabilities {
+		if m.Lang != "wasm" {
+			return fmt.Errorf("only WASM artifacts declare capabilities")
+		}
+		if !slices.Contains(core.Capabilities, c) {
+			return fmt.Errorf("unknown capability %q", c)
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,887 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CriteriaEnv is what criteria are evaluated against
+type CriteriaEnv struct {
+	Metrics map[string]float64
+	Output  json.RawMessage // nil when there is no output to check
+}
+
+// Criterion is a parsed entry of Spec.SuccessCriteria. Criteria are either
+// expressions or descriptions. Expressions are conditions over metrics and
+// the output, such as
+//
+//	cases_failed == 0
+//	p95_latency_ms < 50 && fuel_used < 1e6
+//	len(output.sorted) > 0 || output.empty == true
+//
+// Bare names are metrics, which are numbers; output.field, output[i] and
+// output["field"] select parts of the JSON output. The operators are
+// ! * / + - < <= > >= == != && || with the usual precedence, and len(x) and
+// abs(x) are available. Anything without a comparison or logical operator,
+// such as "handles_empty_input", is a description, which holds when every
+// test passes. Prose that contains an operator is marked as a description
+// with the "desc:" prefix, as in "desc: handles arrays with > 1000 elements".
+type Criterion struct {
+	Source string
+	expr   node // nil for descriptions
+}
+
+// ParseCriterion parses and type checks a criterion
+func ParseCriterion(src string) (*Criterion, error) {
+	c := &Criterion{Source: strings.TrimSpace(src)}
+	if strings.HasPrefix(c.Source, descriptionPrefix) || !isExpression(c.Source) {
+		return c, nil
+	}
+
+	expr, err := parseExpression(c.Source)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := expr.check()
+	if err != nil {
+		return nil, err
+	}
+	if typ != typeBool && typ != typeAny {
+		return nil, fmt.Errorf("criterion is a %s, not a condition", typ)
+	}
+	c.expr = expr
+	return c, nil
+}
+
+// Descriptive reports whether the criterion is a description rather than
+// an expression
+func (c *Criterion) Descriptive() bool { return c.expr == nil }
+
+// Check evaluates an expression, returning nil if it holds and otherwise
+// why it does not. Descriptions are checked by EvaluateCriteria.
+func (c *Criterion) Check(env CriteriaEnv) error {
+	if c.expr == nil {
+		return nil
+	}
+	e := &evalEnv{CriteriaEnv: env}
+	v, err := c.expr.eval(e)
+	if err != nil {
+		return err
+	}
+	ok, isBool := v.(bool)
+	if !isBool {
+		return fmt.Errorf("%s is %s, not a condition", formatValue(v), withArticle(jsonKind(v)))
+	}
+	if !ok {
+		return fmt.Errorf("false with %s", e.describe())
+	}
+	return nil
+}
+
+// EvaluateCriteria checks criteria against env, returning a reason for each
+// one that is malformed or does not hold. Descriptions are checked together:
+// they need cases_failed to be reported and zero.
+func EvaluateCriteria(criteria []string, env CriteriaEnv) (bool, []string) {
+	var reasons, descriptions []string
+	for _, src := range criteria {
+		c, err := ParseCriterion(src)
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("%s: invalid: %v", src, err))
+			continue
+		}
+		if c.Descriptive() {
+			descriptions = append(descriptions, c.Source)
+			continue
+		}
+		if err := c.Check(env); err != nil {
+			reasons = append(reasons, fmt.Sprintf("%s: %v", c.Source, err))
+		}
+	}
+
+	if len(descriptions) > 0 {
+		failed, ok := env.Metrics["cases_failed"]
+		switch {
+		case !ok:
+			reasons = append(reasons, fmt.Sprintf("%s: no test metrics", strings.Join(descriptions, ", ")))
+		case failed > 0:
+			reasons = append(reasons, fmt.Sprintf("%s: %g test cases failed", strings.Join(descriptions, ", "), failed))
+		}
+	}
+	return len(reasons) == 0, reasons
+}
+
+// parseExpression parses an expression without type checking it
+func parseExpression(src string) (node, error) {
+	p := &parser{src: src}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return expr, nil
+}
+
+// descriptionPrefix marks a criterion as a description whatever it contains
+const descriptionPrefix = "desc:"
+
+// isExpression tells expressions from descriptions by their operators
+func isExpression(src string) bool {
+	if src == "true" || src == "false" {
+		return true
+	}
+	for _, op := range []string{"==", "!=", "<", ">", "&&", "||"} {
+		if strings.Contains(src, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// exprType is the static type of an expression
+type exprType int
+
+const (
+	typeAny exprType = iota // part of the output, known once evaluated
+	typeNumber
+	typeBool
+	typeString
+)
+
+func (t exprType) String() string {
+	switch t {
+	case typeNumber:
+		return "number"
+	case typeBool:
+		return "bool"
+	case typeString:
+		return "string"
+	default:
+		return "output value"
+	}
+}
+
+// fits reports whether a value of type t may be used where want is needed
+func (t exprType) fits(want exprType) bool {
+	return t == want || t == typeAny
+}
+
+// Tokens
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int // byte offset in the source
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of criterion"
+	}
+	return strconv.Quote(t.text)
+}
+
+// operators, longest first so that <= is not read as <
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "(", ")", "[", "]", ".", ","}
+
+// Parser
+
+type parser struct {
+	src    string
+	tokens []token
+	next   int
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf("column %d: %s", t.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) lex() error {
+	src := p.src
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r >= '0' && r <= '9':
+			j := i
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.' ||
+				src[j] == 'e' || src[j] == 'E' ||
+				((src[j] == '+' || src[j] == '-') && (src[j-1] == 'e' || src[j-1] == 'E'))) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{tokNumber, src[i:j], i})
+			i = j
+		case r == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return fmt.Errorf("column %d: unterminated string", i+1)
+			}
+			p.tokens = append(p.tokens, token{tokString, src[i : j+1], i})
+			i = j + 1
+		case r == '_' || unicode.IsLetter(r):
+			j := i
+			for j < len(src) {
+				r, size := utf8.DecodeRuneInString(src[j:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				j += size
+			}
+			p.tokens = append(p.tokens, token{tokIdent, src[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return fmt.Errorf("column %d: unexpected %q", i+1, r)
+			}
+			p.tokens = append(p.tokens, token{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	p.tokens = append(p.tokens, token{tokEOF, "", len(src)})
+	return nil
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+func (p *parser) peek() token { return p.tokens[p.next] }
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokEOF {
+		p.next++
+	}
+	return t
+}
+
+// accept takes the next token if it is one of ops
+func (p *parser) accept(ops ...string) (token, bool) {
+	t := p.peek()
+	if t.kind == tokOp {
+		for _, op := range ops {
+			if t.text == op {
+				return p.take(), true
+			}
+		}
+	}
+	return t, false
+}
+
+func (p *parser) expect(op string) error {
+	if t, ok := p.accept(op); !ok {
+		return p.errorf(t, "expected %q, found %s", op, t)
+	}
+	return nil
+}
+
+// binaryLevel parses operands separated by any of ops, left to right
+func (p *parser) binaryLevel(operand func() (node, error), ops ...string) (node, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.accept(ops...)
+		if !ok {
+			return x, nil
+		}
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op: t.text, x: x, y: y}
+	}
+}
+
+func (p *parser) parseOr() (node, error) { return p.binaryLevel(p.parseAnd, "||") }
+
+func (p *parser) parseAnd() (node, error) { return p.binaryLevel(p.parseComparison, "&&") }
+
+func (p *parser) parseComparison() (node, error) {
+	x, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := p.accept("==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		return x, nil
+	}
+	y, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if next, chained := p.accept("==", "!=", "<", "<=", ">", ">="); chained {
+		return nil, p.errorf(next, "comparisons cannot be chained; join them with &&")
+	}
+	return &binaryNode{op: t.text, x: x, y: y}, nil
+}
+
+func (p *parser) parseSum() (node, error) { return p.binaryLevel(p.parseProduct, "+", "-") }
+
+func (p *parser) parseProduct() (node, error) { return p.binaryLevel(p.parseUnary, "*", "/") }
+
+func (p *parser) parseUnary() (node, error) {
+	if t, ok := p.accept("!", "-"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: t.text, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.take()
+	switch t.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number %s", t.text)
+		}
+		return literal{v}, nil
+	case tokString:
+		s, err := strconv.Unquote(t.text)
+		if err != nil {
+			return nil, p.errorf(t, "invalid string %s", t.text)
+		}
+		return literal{s}, nil
+	case tokIdent:
+		switch {
+		case t.text == "true" || t.text == "false":
+			return literal{t.text == "true"}, nil
+		case t.text == "output":
+			return p.parseOutput()
+		}
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(t)
+		}
+		return metricNode{name: t.text}, nil
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+	}
+	return nil, p.errorf(t, "expected a value, found %s", t)
+}
+
+// parseOutput parses the selectors after output
+func (p *parser) parseOutput() (node, error) {
+	out := outputNode{}
+	for {
+		if _, ok := p.accept("."); ok {
+			t := p.take()
+			if t.kind != tokIdent {
+				return nil, p.errorf(t, "expected a field name after \".\", found %s", t)
+			}
+			out.path = append(out.path, t.text)
+			continue
+		}
+		if _, ok := p.accept("["); ok {
+			t := p.take()
+			switch t.kind {
+			case tokNumber:
+				i, err := strconv.Atoi(t.text)
+				if err != nil || i < 0 {
+					return nil, p.errorf(t, "invalid index %s", t.text)
+				}
+				out.path = append(out.path, i)
+			case tokString:
+				s, err := strconv.Unquote(t.text)
+				if err != nil {
+					return nil, p.errorf(t, "invalid string %s", t.text)
+				}
+				out.path = append(out.path, s)
+			default:
+				return nil, p.errorf(t, "expected an index or a quoted field name, found %s", t)
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return out, nil
+	}
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown function %s", name.text)
+	}
+	call := &callNode{name: name.text, fn: fn}
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	}
+	if len(call.args) != len(fn.params) {
+		return nil, p.errorf(name, "%s takes %d argument(s), got %d", name.text, len(fn.params), len(call.args))
+	}
+	return call, nil
+}
+
+// Expressions
+
+type node interface {
+	// check type checks the expression and returns its type
+	check() (exprType, error)
+	eval(env *evalEnv) (any, error)
+}
+
+// evalEnv is a CriteriaEnv being evaluated against. It decodes the output
+// once and remembers the metrics and output parts it was asked for, to
+// explain why a criterion is false.
+type evalEnv struct {
+	CriteriaEnv
+	output  any
+	decoded bool
+	seen    map[string]any
+}
+
+func (e *evalEnv) remember(name string, v any) {
+	if e.seen == nil {
+		e.seen = make(map[string]any)
+	}
+	e.seen[name] = v
+}
+
+// describe lists the values a false criterion saw
+func (e *evalEnv) describe() string {
+	names := make([]string, 0, len(e.seen))
+	for name := range e.seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + " = " + formatValue(e.seen[name])
+	}
+	if len(parts) == 0 {
+		return "no metrics"
+	}
+	return strings.Join(parts, ", ")
+}
+
+type literal struct{ value any }
+
+func (l literal) check() (exprType, error) {
+	switch l.value.(type) {
+	case float64:
+		return typeNumber, nil
+	case bool:
+		return typeBool, nil
+	default:
+		return typeString, nil
+	}
+}
+
+func (l literal) eval(*evalEnv) (any, error) { return l.value, nil }
+
+type metricNode struct{ name string }
+
+func (m metricNode) check() (exprType, error) { return typeNumber, nil }
+
+func (m metricNode) eval(env *evalEnv) (any, error) {
+	v, ok := env.Metrics[m.name]
+	if !ok {
+		return nil, fmt.Errorf("metric %s is not reported", m.name)
+	}
+	env.remember(m.name, v)
+	return v, nil
+}
+
+type outputNode struct {
+	path []any // field names and indexes
+}
+
+func (o outputNode) String() string {
+	var b strings.Builder
+	b.WriteString("output")
+	for _, step := range o.path {
+		switch step := step.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", step)
+		case string:
+			fmt.Fprintf(&b, ".%s", step)
+		}
+	}
+	return b.String()
+}
+
+func (o outputNode) check() (exprType, error) { return typeAny, nil }
+
+func (o outputNode) eval(env *evalEnv) (any, error) {
+	if !env.decoded {
+		if len(env.Output) == 0 {
+			return nil, fmt.Errorf("%s: there is no output to check", o)
+		}
+		if err := json.Unmarshal(env.Output, &env.output); err != nil {
+			return nil, fmt.Errorf("output is not JSON: %w", err)
+		}
+		env.decoded = true
+	}
+
+	v := env.output
+	at := outputNode{}
+	for _, step := range o.path {
+		at.path = append(at.path, step)
+		switch step := step.(type) {
+		case int:
+			arr, ok := v.([]any)
+			if !ok || step >= len(arr) {
+				return nil, fmt.Errorf("%s: no such element", at)
+			}
+			v = arr[step]
+		case string:
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: not an object", at)
+			}
+			if v, ok = obj[step]; !ok {
+				return nil, fmt.Errorf("%s: no such field", at)
+			}
+		}
+	}
+	env.remember(o.String(), v)
+	return v, nil
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+func (u *unaryNode) check() (exprType, error) {
+	t, err := u.x.check()
+	if err != nil {
+		return 0, err
+	}
+	want := typeNumber
+	if u.op == "!" {
+		want = typeBool
+	}
+	if !t.fits(want) {
+		return 0, fmt.Errorf("%s needs a %s, not a %s", u.op, want, t)
+	}
+	return want, nil
+}
+
+func (u *unaryNode) eval(env *evalEnv) (any, error) {
+	v, err := u.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if u.op == "!" {
+		b, err := asBool(v)
+		return !b, err
+	}
+	n, err := asNumber(v)
+	return -n, err
+}
+
+type binaryNode struct {
+	op   string
+	x, y node
+}
+
+func (b *binaryNode) check() (exprType, error) {
+	tx, err := b.x.check()
+	if err != nil {
+		return 0, err
+	}
+	ty, err := b.y.check()
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case "&&", "||":
+		if !tx.fits(typeBool) || !ty.fits(typeBool) {
+			return 0, fmt.Errorf("%s needs bools, not a %s and a %s", b.op, tx, ty)
+		}
+		return typeBool, nil
+	case "+", "-", "*", "/":
+		if !tx.fits(typeNumber) || !ty.fits(typeNumber) {
+			return 0, fmt.Errorf("%s needs numbers, not a %s and a %s", b.op, tx, ty)
+		}
+		return typeNumber, nil
+	case "<", "<=", ">", ">=":
+		if tx == typeBool || ty == typeBool {
+			return 0, fmt.Errorf("%s cannot order bools", b.op)
+		}
+		fallthrough
+	default: // == and !=
+		if tx != ty && tx != typeAny && ty != typeAny {
+			return 0, fmt.Errorf("cannot compare a %s with a %s", tx, ty)
+		}
+		return typeBool, nil
+	}
+}
+
+func (b *binaryNode) eval(env *evalEnv) (any, error) {
+	x, err := b.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logical operators short-circuit
+	switch b.op {
+	case "&&", "||":
+		l, err := asBool(x)
+		if err != nil || l == (b.op == "||") {
+			return l, err
+		}
+		y, err := b.y.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return asBool(y)
+	}
+
+	y, err := b.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "==":
+		return equalValues(x, y), nil
+	case "!=":
+		return !equalValues(x, y), nil
+	case "<", "<=", ">", ">=":
+		c, err := compareValues(x, y)
+		if err != nil {
+			return nil, err
+		}
+		switch b.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	}
+
+	l, err := asNumber(x)
+	if err != nil {
+		return nil, err
+	}
+	r, err := asNumber(y)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	default:
+		return l / r, nil
+	}
+}
+
+// function is a function criteria can call
+type function struct {
+	params []exprType
+	result exprType
+	fn     func(args []any) (any, error)
+}
+
+var functions = map[string]function{
+	"len": {
+		params: []exprType{typeAny},
+		result: typeNumber,
+		fn: func(args []any) (any, error) {
+			switch v := args[0].(type) {
+			case string:
+				return float64(utf8.RuneCountInString(v)), nil
+			case []any:
+				return float64(len(v)), nil
+			case map[string]any:
+				return float64(len(v)), nil
+			default:
+				return nil, fmt.Errorf("%s has no length", withArticle(jsonKind(v)))
+			}
+		},
+	},
+	"abs": {
+		params: []exprType{typeNumber},
+		result: typeNumber,
+		fn: func(args []any) (any, error) {
+			n, err := asNumber(args[0])
+			return math.Abs(n), err
+		},
+	},
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (c *callNode) check() (exprType, error) {
+	for i, arg := range c.args {
+		t, err := arg.check()
+		if err != nil {
+			return 0, err
+		}
+		want := c.fn.params[i]
+		if want == typeAny {
+			// len takes strings and output values
+			if t == typeNumber || t == typeBool {
+				return 0, fmt.Errorf("%s needs a string or output value, not a %s", c.name, t)
+			}
+			continue
+		}
+		if !t.fits(want) {
+			return 0, fmt.Errorf("%s needs a %s, not a %s", c.name, want, t)
+		}
+	}
+	return c.fn.result, nil
+}
+
+func (c *callNode) eval(env *evalEnv) (any, error) {
+	args := make([]any, len(c.args))
+	for i, arg := range c.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return c.fn.fn(args)
+}
+
+// Values
+
+func asNumber(v any) (float64, error) {
+	n, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%s is %s, not a number", formatValue(v), withArticle(jsonKind(v)))
+	}
+	return n, nil
+}
+
+func asBool(v any) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s is %s, not a bool", formatValue(v), withArticle(jsonKind(v)))
+	}
+	return b, nil
+}
+
+func equalValues(x, y any) bool { return reflect.DeepEqual(x, y) }
+
+func compareValues(x, y any) (int, error) {
+	switch x := x.(type) {
+	case float64:
+		if y, ok := y.(float64); ok {
+			return compareOrdered(x, y), nil
+		}
+	case string:
+		if y, ok := y.(string); ok {
+			return compareOrdered(x, y), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot order %s and %s", withArticle(jsonKind(x)), withArticle(jsonKind(y)))
+}
+
+func compareOrdered[T float64 | string](x, y T) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// jsonKind names the kind of a decoded JSON value
+func jsonKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func withArticle(kind string) string {
+	if kind == "array" || kind == "object" {
+		return "an " + kind
+	}
+	return "a " + kind
+}
+
+func formatValue(v any) string {
+	if n, ok := v.(float64); ok {
+		return strconv.FormatFloat(n, 'g', -1, 64)
+	}
+	b, _ := json.Marshal(v)
+	if len(b) > 64 {
+		return string(b[:61]) + "..."
+	}
+	return string(b)
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCriterion_Check(t *testing.T) {
+	env := CriteriaEnv{
+		Metrics: map[string]float64{"cases_failed": 0, "cases_total": 4, "p95_latency_ms": 73.5, "fuel_used": 2e6},
+		Output:  json.RawMessage(`{"sorted": [1, 2, 3], "label": "ok", "empty": false}`),
+	}
+
+	cases := []struct {
+		src  string
+		want string // error, empty if the criterion holds
+	}{
+		{"cases_failed == 0", ""},
+		{"cases_failed == 0 && cases_total >= 4", ""},
+		{"p95_latency_ms < 50", "false with p95_latency_ms = 73.5"},
+		{"fuel_used < 1e6", "false with fuel_used = 2e+06"},
+		{"fuel_used / cases_total <= 5e5", ""},
+		{"-cases_total + 2 * 3 == 2", ""},
+		{"!(cases_failed > 0) || missing > 1", ""},
+		{"missing > 1 || cases_failed == 0", "metric missing is not reported"},
+		{"len(output.sorted) == 3 && output.sorted[0] == 1", ""},
+		{`output["label"] == "ok" && len(output.label) == 2`, ""},
+		{"output.empty == true", "false with output.empty = false"},
+		{"output.empty", ""}, // a description, checked with the others
+		{"output.sorted[5] > 0", "output.sorted[5]: no such element"},
+		{"output.sorted.first > 0", "output.sorted.first: not an object"},
+		{"output.label > 3", `cannot order a string and a number`},
+		{"output.sorted || true", `[1,2,3] is an array, not a bool`},
+		{"abs(cases_total - 10) < 7", ""},
+		{"true", ""},
+	}
+	for _, tc := range cases {
+		c, err := ParseCriterion(tc.src)
+		require.NoError(t, err, tc.src)
+		err = c.Check(env)
+		if tc.want == "" {
+			assert.NoError(t, err, tc.src)
+		} else {
+			assert.EqualError(t, err, tc.want, tc.src)
+		}
+	}
+
+	c, err := ParseCriterion("output.sorted[0] == 1")
+	require.NoError(t, err)
+	assert.EqualError(t, c.Check(CriteriaEnv{}), "output.sorted[0]: there is no output to check")
+}
+
+func TestParseCriterion_Errors(t *testing.T) {
+	for src, want := range map[string]string{
+		"cases_failed == true":      "cannot compare a number with a bool",
+		"cases_failed + 1 != false": "cannot compare a number with a bool",
+		"cases_failed && true":      "&& needs bools, not a number and a bool",
+		`!cases_failed == 0`:        "! needs a bool, not a number",
+		"true < false":              "< cannot order bools",
+		`"a" + 1 > 0`:               "+ needs numbers, not a string and a number",
+		"len(cases_total) > 0":      "len needs a string or output value, not a number",
+		"size(output) > 0":          "column 1: unknown function size",
+		"abs(1, 2) > 0":             "column 1: abs takes 1 argument(s), got 2",
+		"0 < cases_failed < 2":      "column 18: comparisons cannot be chained; join them with &&",
+		"cases_failed ==":           "column 16: expected a value, found end of criterion",
+		"(cases_failed == 0":        `column 19: expected ")", found end of criterion`,
+		"cases_failed == 0 0":       `column 19: unexpected "0"`,
+		"output. == 1":              `column 9: expected a field name after ".", found "=="`,
+		"output[x] == 1":            `column 8: expected an index or a quoted field name, found "x"`,
+		`output.label == "ok`:       "column 17: unterminated string",
+		"cases_failed == 0 # note":  `column 19: unexpected '#'`,
+		"p95_latency_ms < 50ms":     `column 20: unexpected "ms"`,
+		"p95_latency_ms < ":         "column 17: expected a value, found end of criterion",
+		"cases_failed === 0":        "column 16: unexpected '='",
+		"fuel_used <> 1e6":          `column 12: expected a value, found ">"`,
+		"returns < 5 items":         `column 13: unexpected "items"`,
+		"cases_failed + 1 == 0 + 2": "",
+	} {
+		_, err := ParseCriterion(src)
+		if want == "" {
+			assert.NoError(t, err, src)
+		} else {
+			assert.EqualError(t, err, want, src)
+		}
+	}
+
+	// Without operators, or marked with desc:, a criterion is a description
+	for _, src := range []string{
+		"sorted_non_decreasing", "handles empty input", "cases_failed",
+		"desc: handles arrays with > 1000 elements", "desc:cases_failed ==",
+	} {
+		c, err := ParseCriterion(src)
+		require.NoError(t, err, src)
+		assert.True(t, c.Descriptive(), src)
+	}
+}
+
+func TestRuleCritic(t *testing.T) {
+	critic := NewRuleCritic()
+	task := Task{Spec: Spec{SuccessCriteria: []string{"permutes", "handles_empty_input", "p95_latency_ms < 50"}}}
+
+	ok, reason := critic.Accept(task, map[string]float64{"cases_failed": 0, "p95_latency_ms": 12})
+	assert.True(t, ok)
+	assert.Equal(t, "all criteria met", reason)
+
+	ok, reason = critic.Accept(task, map[string]float64{"cases_failed": 2, "p95_latency_ms": 80})
+	assert.False(t, ok)
+	assert.Equal(t, "p95_latency_ms < 50: false with p95_latency_ms = 80; permutes, handles_empty_input: 2 test cases failed", reason)
+
+	ok, reason = critic.Accept(task, map[string]float64{})
+	assert.False(t, ok)
+	assert.Equal(t, "p95_latency_ms < 50: metric p95_latency_ms is not reported; permutes, handles_empty_input: no test metrics", reason)
+
+	// Prose with operators is a description when marked as one
+	task.Spec.SuccessCriteria = []string{"desc: handles arrays with > 1000 elements"}
+	ok, reason = critic.Accept(task, map[string]float64{"cases_failed": 0})
+	assert.True(t, ok, reason)
+
+	ok, reason = critic.Accept(Task{}, nil)
+	assert.True(t, ok)
+	assert.Equal(t, "no criteria", reason)
+
+	// Critics see no output
+	task.Spec.SuccessCriteria = []string{"len(output.sorted) > 0"}
+	ok, reason = critic.Accept(task, map[string]float64{"cases_failed": 0})
+	assert.False(t, ok)
+	assert.Contains(t, reason, "there is no output to check")
+}
+
+func TestValidators(t *testing.T) {
+	task := Task{Spec: Spec{SuccessCriteria: []string{"cases_failed == 0", "len(output.sorted) == output_count", "fuel_used == true"}}}
+
+	ok, problems := ValidateCriteria(task)
+	assert.False(t, ok)
+	assert.Equal(t, []string{"fuel_used == true: cannot compare a number with a bool"}, problems)
+
+	task.Spec.SuccessCriteria = task.Spec.SuccessCriteria[:2]
+	ok, problems = ValidateCriteria(task)
+	assert.True(t, ok)
+	assert.Empty(t, problems)
+
+	result := Result{Output: json.RawMessage(`{"sorted": [1, 2]}`), Metrics: map[string]float64{"cases_failed": 0, "output_count": 2}}
+	ok, reasons := ValidateMetrics(task, result)
+	assert.True(t, ok)
+	assert.Empty(t, reasons)
+
+	result.Metrics["output_count"] = 3
+	ok, reasons = ValidateMetrics(task, result)
+	assert.False(t, ok)
+	assert.Equal(t, []string{"len(output.sorted) == output_count: false with output.sorted = [1,2], output_count = 3"}, reasons)
+}
@@ -1,5 +1,7 @@
 package core
 
+import "strings"
+
 // SimpleCritic accepts if required checks are satisfied per metrics or logs.
 // For MVP: if metrics contain pass=true or checks list is empty, accept.
 type SimpleCritic struct{}
@@ -22,3 +24,23 @@ func (c *SimpleCritic) Accept(task Task, metrics map[string]float64) (bool, stri
 	// If no metric provided, be conservative.
 	return false, "no test metrics"
 }
+
+// RuleCritic accepts when every success criterion holds, evaluating
+// expressions over the metrics and descriptions as requiring all tests to
+// pass; see Criterion. Rejections list the reason for each criterion that
+// failed.
+type RuleCritic struct{}
+
+func NewRuleCritic() *RuleCritic { return &RuleCritic{} }
+
+// Accept implements Critic. Criteria that read the output fail, since
+// critics only see metrics.
+func (c *RuleCritic) Accept(task Task, metrics map[string]float64) (bool, string) {
+	if len(task.Spec.SuccessCriteria) == 0 {
+		return true, "no criteria"
+	}
+	if ok, reasons := EvaluateCriteria(task.Spec.SuccessCriteria, CriteriaEnv{Metrics: metrics}); !ok {
+		return false, strings.Join(reasons, "; ")
+	}
+	return true, "all criteria met"
+}
@@ -1,6 +1,10 @@
 package core
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
 
 type Skill interface {
 	Name() string
@@ -20,6 +24,19 @@ type LLMClient interface {
 	ProposeWithCaller(ctx context.Context, task Task, caller string) (algo string, tests []TestCase, criteria []string, err error)
 }
 
+// SourceRepairer is implemented by LLM clients that can revise proposed
+// source given the error it failed to build with
+type SourceRepairer interface {
+	RepairWithCaller(ctx context.Context, task Task, source string, buildErr string, caller string) (string, error)
+}
+
+// SourceEvolver is implemented by LLM clients that can rewrite working source:
+// a targeted change guided by test feedback, or a merge of two parents
+type SourceEvolver interface {
+	MutateWithCaller(ctx context.Context, task Task, source string, feedback Feedback, caller string) (string, error)
+	CrossoverWithCaller(ctx context.Context, task Task, a, b string, caller string) (string, error)
+}
+
 // LLMOptions holds options for LLM requests
 type LLMOptions struct {
 	Model        string
@@ -49,6 +66,18 @@ type Interpreter interface {
 	Execute(ctx context.Context, h Hypothesis, task Task) (Result, error)
 }
 
+// StateReleaser is implemented by interpreters that keep state between
+// executions of a task, such as the WASM key-value store. ReleaseState drops
+// the state of the task with the given ID and of every task whose ID extends
+// it with "/".
+type StateReleaser interface {
+	ReleaseState(taskID string)
+}
+
+// ErrBudgetExceeded is wrapped by the errors of executions an Interpreter
+// stopped because they used up their CPU budget
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
 type PolicyGuard interface {
 	Wrap(ctx context.Context, b Budget, run func(ctx context.Context) error) error
 	AllowTool(name string) bool
@@ -66,6 +95,36 @@ type TestRunner interface {
 	Run(ctx context.Context, h Hypothesis, cases []TestCase, exec Interpreter) (metrics map[string]float64, pass bool, err error)
 }
 
+// TaskTestRunner is a TestRunner that can run cases on behalf of a task, so
+// that they execute with its domain and budget
+type TaskTestRunner interface {
+	TestRunner
+	RunTask(ctx context.Context, task Task, h Hypothesis, cases []TestCase, exec Interpreter) (metrics map[string]float64, pass bool, err error)
+}
+
+// Counterexample is an input on which a hypothesis breaks a property of its
+// task, shrunk to be as small as the search could make it
+type Counterexample struct {
+	Case    TestCase        // the input, checked against the task's properties
+	Output  json.RawMessage // the hypothesis's output, if it returned one
+	Reason  string          // the property that failed and why, or the execution error
+	Shrinks int             // how many times the input was made smaller
+}
+
+// PropertyTester searches inputs generated from Spec.InputSchema for one
+// that breaks Spec.Properties. It returns a nil Counterexample if none was
+// found, and metrics describing the search.
+type PropertyTester interface {
+	FindCounterexample(ctx context.Context, h Hypothesis, task Task, exec Interpreter) (*Counterexample, map[string]float64, error)
+}
+
+// ReferenceTester compares a hypothesis with a trusted skill for its task's
+// domain, on the inputs of cases and others of its choosing. It returns a nil
+// Counterexample if they agree, and metrics describing the comparison.
+type ReferenceTester interface {
+	FindDisagreement(ctx context.Context, h Hypothesis, task Task, cases []TestCase, exec Interpreter) (*Counterexample, map[string]float64, error)
+}
+
 type FitnessEvaluator interface {
 	Score(task Task, metrics map[string]float64, sizeBytes int) float64
 	Passed(score float64, threshold float64) bool
@@ -78,3 +137,24 @@ type Critic interface {
 type Mutator interface {
 	Mutate(base Hypothesis) []Hypothesis // набор кандидатов
 }
+
+// Feedback is how a hypothesis fared against its tests
+type Feedback struct {
+	Metrics map[string]float64
+	Failing []TestCase
+}
+
+// GuidedMutator is a Mutator that can also use test feedback, and combine two
+// parents, to produce candidates for a task
+type GuidedMutator interface {
+	Mutator
+	MutateGuided(ctx context.Context, task Task, base Hypothesis, feedback Feedback) []Hypothesis
+	Crossover(ctx context.Context, task Task, a, b Hypothesis) []Hypothesis
+}
+
+// RunScoped is implemented by mutators that keep per-task state, such as a
+// request budget, for one evolution run. BeginRun is called as a run on the
+// task starts, and the function it returns as the run ends.
+type RunScoped interface {
+	BeginRun(taskID string) (end func())
+}
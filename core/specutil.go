@@ -1,11 +1,19 @@
 package core
 
-// ValidateCriteria checks whether a task's spec meets criteria. Stub returns true.
+// ValidateCriteria checks that every success criterion of a task's spec
+// parses and type checks, returning the problems with those that do not
 func ValidateCriteria(task Task) (bool, []string) {
-	return true, nil
+	var problems []string
+	for _, src := range task.Spec.SuccessCriteria {
+		if _, err := ParseCriterion(src); err != nil {
+			problems = append(problems, src+": "+err.Error())
+		}
+	}
+	return len(problems) == 0, problems
 }
 
-// ValidateMetrics checks metrics against thresholds. Stub returns true.
-func ValidateMetrics(result Result) (bool, []string) {
-	return true, nil
+// ValidateMetrics checks a result's metrics and output against the task's
+// success criteria, returning the reason for each one that does not hold
+func ValidateMetrics(task Task, result Result) (bool, []string) {
+	return EvaluateCriteria(task.Spec.SuccessCriteria, CriteriaEnv{Metrics: result.Metrics, Output: result.Output})
 }
@@ -71,6 +71,8 @@ type Spec struct {
 	SuccessCriteria []string          // declarative/readable
 	Props           map[string]string // key properties
 	MetricsWeights  map[string]float64
+	InputSchema     json.RawMessage `json:"input_schema,omitempty"` // JSON schema of inputs, for property-based testing
+	Properties      []string        `json:"properties,omitempty"`   // checks every input must pass, see testkit.ParseCheck
 }
 
 type Result struct {
@@ -88,3 +90,22 @@ type Hypothesis struct {
 	Bytes  []byte            // code/bytecode/IR
 	Meta   map[string]string // domain, version, etc.
 }
+
+// MetaSource is the Meta key holding the source a hypothesis was built from.
+// Mutators that edit Bytes directly must drop it.
+const MetaSource = "source"
+
+// MetaCapabilities is the Meta key listing, comma-separated, the host
+// capabilities a hypothesis is granted. Hypotheses without it get none.
+const MetaCapabilities = "capabilities"
+
+// Host capabilities a WASM module may be granted
+const (
+	CapabilityLog  = "log"  // agent.log
+	CapabilityHTTP = "http" // agent.http_get_json, subject to PolicyGuard.AllowTool
+	CapabilityKV   = "kv"   // agent.kv_get and agent.kv_set, scoped to the task
+	CapabilityNow  = "now"  // agent.now
+)
+
+// Capabilities lists every host capability
+var Capabilities = []string{CapabilityLog, CapabilityHTTP, CapabilityKV, CapabilityNow}
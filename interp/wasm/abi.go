@@ -0,0 +1,126 @@
+// Package wasm runs hypotheses compiled to WebAssembly.
+//
+// # Module ABI
+//
+// A module exports its linear memory as "memory" and a solve function. solve
+// receives the pointer and length of its input, the UTF-8 JSON object
+// {"input": <task input>, "spec": <task spec>}, and returns the pointer and
+// length of a UTF-8 JSON object, either as two results or packed into one
+// i64 with the pointer in the high 32 bits:
+//
+//	(func (export "solve") (param i32 i32) (result i32 i32))
+//	(func (export "solve") (param i32 i32) (result i64))
+//
+// The host writes the input into memory the module does not otherwise use.
+// Modules with an allocator export it as
+//
+//	(func (export "alloc") (param $size i32) (result i32))               ;; Rust style
+//	(func (export "dealloc") (param $ptr i32) (param $size i32))         ;; optional
+//
+// or
+//
+//	(func (export "malloc") (param $size i32) (result i32))              ;; TinyGo style
+//	(func (export "free") (param $ptr i32))                              ;; optional
+//
+// and the input is written to a buffer from alloc, which is handed back to
+// dealloc once solve returns. Modules without one have memory grown for the
+// input, which is written at the first page boundary past both the initial
+// memory and the module's __heap_base global, if it exports one. The output
+// stays owned by the module.
+package wasm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tetratelabs/wazero/api"
+)
+
+const pageSize = 65536
+
+// allocator names a pair of allocator exports
+type allocator struct {
+	alloc, free string
+	// sized is set when free also takes the size of the buffer
+	sized bool
+}
+
+// allocators are tried in order
+var allocators = []allocator{
+	{alloc: "alloc", free: "dealloc", sized: true},
+	{alloc: "malloc", free: "free"},
+}
+
+// writeInput places the input in the instance's memory. release hands an
+// allocated buffer back to the module; it is a no-op for grown memory.
+func writeInput(ctx context.Context, instance api.Module, input []byte) (ptr uint32, release func() error, err error) {
+	mem := instance.Memory()
+	if mem == nil {
+		return 0, nil, fmt.Errorf("module has no memory")
+	}
+	size := uint32(len(input))
+
+	for _, a := range allocators {
+		alloc := instance.ExportedFunction(a.alloc)
+		if alloc == nil {
+			continue
+		}
+
+		results, err := alloc.Call(ctx, uint64(size))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%s(%d) failed: %w", a.alloc, size, err)
+		}
+		ptr = uint32(results[0])
+		if ptr == 0 {
+			return 0, nil, fmt.Errorf("%s(%d) returned a null pointer", a.alloc, size)
+		}
+		if !mem.Write(ptr, input) {
+			return 0, nil, fmt.Errorf("%s(%d) returned %d, outside memory of %d bytes", a.alloc, size, ptr, mem.Size())
+		}
+
+		release = func() error { return nil }
+		if free := instance.ExportedFunction(a.free); free != nil {
+			release = func() error {
+				params := []uint64{uint64(ptr)}
+				if a.sized {
+					params = append(params, uint64(size))
+				}
+				if _, err := free.Call(ctx, params...); err != nil {
+					return fmt.Errorf("%s(%d) failed: %w", a.free, ptr, err)
+				}
+				return nil
+			}
+		}
+		return ptr, release, nil
+	}
+
+	// No allocator: grow memory and write past everything the module has
+	base := uint64(mem.Size())
+	if heapBase := instance.ExportedGlobal("__heap_base"); heapBase != nil {
+		base = max(base, uint64(uint32(heapBase.Get())))
+	}
+	base = (base + pageSize - 1) / pageSize * pageSize
+
+	if end := base + uint64(size); end > uint64(mem.Size()) {
+		pages := (end - uint64(mem.Size()) + pageSize - 1) / pageSize
+		if _, ok := mem.Grow(uint32(pages)); !ok {
+			return 0, nil, fmt.Errorf("not enough memory: input of %d bytes needs %d more pages", size, pages)
+		}
+	}
+	if !mem.Write(uint32(base), input) {
+		return 0, nil, fmt.Errorf("failed to write to memory")
+	}
+	return uint32(base), func() error { return nil }, nil
+}
+
+// outputLocation unpacks solve's results into a pointer and length
+func outputLocation(results []uint64) (ptr, size uint32, err error) {
+	switch len(results) {
+	case 1:
+		return uint32(results[0] >> 32), uint32(results[0]), nil
+	case 2:
+		return uint32(results[0]), uint32(results[1]), nil
+	default:
+		return 0, 0, fmt.Errorf("solve function should return (ptr, size) or a packed i64, got %d results", len(results))
+	}
+}
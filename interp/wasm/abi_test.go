@@ -0,0 +1,147 @@
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// sdkModule assembles one of the SDK test modules in testdata
+func sdkModule(t *testing.T, name string) []byte {
+	t.Helper()
+	src, err := os.ReadFile("testdata/" + name + ".wat")
+	require.NoError(t, err)
+	bin, err := wat.Assemble(string(src))
+	require.NoError(t, err)
+	require.NoError(t, Validate(context.Background(), bin))
+	return bin
+}
+
+// instantiate runs a module on the interpreter's runtime for inspection
+func instantiate(t *testing.T, interp *Interpreter, bin []byte) api.Module {
+	t.Helper()
+	ctx := context.Background()
+	compiled, err := interp.runtime.CompileModule(ctx, bin)
+	require.NoError(t, err)
+	instance, err := interp.runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().WithName(""))
+	require.NoError(t, err)
+	t.Cleanup(func() { instance.Close(ctx) })
+	return instance
+}
+
+func TestExecute_SDKModules(t *testing.T) {
+	interp := NewInterpreter()
+	defer interp.Close(context.Background())
+
+	// The large input needs more memory than either module starts with
+	large, err := json.Marshal(strings.Repeat("x", 3*pageSize))
+	require.NoError(t, err)
+
+	for _, sdk := range []string{"rust", "tinygo"} {
+		bin := sdkModule(t, sdk)
+		for name, input := range map[string]json.RawMessage{
+			"small": json.RawMessage(`{"numbers":[3,1,2]}`),
+			"large": large,
+		} {
+			t.Run(sdk+"/"+name, func(t *testing.T) {
+				task := core.Task{ID: "sdk", Input: input, Budget: core.Budget{CPUMillis: 5000}}
+				res, err := interp.Execute(context.Background(), core.Hypothesis{ID: sdk, Lang: "wasm", Bytes: bin}, task)
+				require.NoError(t, err)
+				require.True(t, res.Success)
+
+				var out struct {
+					SDK  string `json:"sdk"`
+					Echo struct {
+						Input json.RawMessage `json:"input"`
+					} `json:"echo"`
+				}
+				require.NoError(t, json.Unmarshal(res.Output, &out))
+				assert.Equal(t, sdk, out.SDK)
+				assert.JSONEq(t, string(input), string(out.Echo.Input))
+			})
+		}
+	}
+}
+
+func TestWriteInput_Allocator(t *testing.T) {
+	interp := NewInterpreter()
+	defer interp.Close(context.Background())
+	ctx := context.Background()
+
+	for _, sdk := range []string{"rust", "tinygo"} {
+		t.Run(sdk, func(t *testing.T) {
+			instance := instantiate(t, interp, sdkModule(t, sdk))
+			live := instance.ExportedGlobal("live")
+			heapBase := uint32(instance.ExportedGlobal("__heap_base").Get())
+
+			ptr, release, err := writeInput(ctx, instance, []byte(`{"a":1}`))
+			require.NoError(t, err)
+			assert.Equal(t, heapBase, ptr, "input should come from the module's heap")
+			assert.Equal(t, uint64(1), live.Get())
+
+			got, ok := instance.Memory().Read(ptr, 7)
+			require.True(t, ok)
+			assert.Equal(t, `{"a":1}`, string(got))
+
+			require.NoError(t, release())
+			assert.Equal(t, uint64(0), live.Get())
+		})
+	}
+}
+
+func TestWriteInput_GrowsMemory(t *testing.T) {
+	interp := NewInterpreter()
+	defer interp.Close(context.Background())
+	ctx := context.Background()
+
+	// Data at offset 0 used to be overwritten by the input
+	bin, err := wat.Assemble(`(module
+	  (memory (export "memory") 1)
+	  (global (export "__heap_base") i32 (i32.const 70000))
+	  (data (i32.const 0) "{\"ok\":true}")
+	  (func (export "solve") (param i32 i32) (result i32 i32) (i32.const 0) (i32.const 11)))`)
+	require.NoError(t, err)
+
+	res, err := interp.Execute(ctx, core.Hypothesis{ID: "static", Lang: "wasm", Bytes: bin},
+		core.Task{ID: "static", Input: json.RawMessage(`{"padding":"` + strings.Repeat("x", 64) + `"}`)})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ok":true}`, string(res.Output))
+
+	// The input goes past __heap_base, on a page boundary
+	instance := instantiate(t, interp, bin)
+	input := []byte(strings.Repeat("y", pageSize+1))
+	ptr, release, err := writeInput(ctx, instance, input)
+	require.NoError(t, err)
+	require.NoError(t, release())
+	assert.Equal(t, uint32(2*pageSize), ptr)
+	assert.Equal(t, uint32(4*pageSize), instance.Memory().Size())
+
+	// Inputs beyond the memory limit are refused
+	_, _, err = writeInput(ctx, instance, make([]byte, 64*pageSize))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not enough memory")
+}
+
+func TestOutputLocation(t *testing.T) {
+	ptr, size, err := outputLocation([]uint64{0x0001_0000_0000_002a})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x10000), ptr)
+	assert.Equal(t, uint32(42), size)
+
+	ptr, size, err = outputLocation([]uint64{8, 3})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(8), ptr)
+	assert.Equal(t, uint32(3), size)
+
+	_, _, err = outputLocation(nil)
+	assert.Error(t, err)
+}
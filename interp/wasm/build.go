@@ -0,0 +1,30 @@
+package wasm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/snow-ghost/agent/interp/wat"
+)
+
+// Build turns WAT source, or a WASM binary passed through as text, into a
+// validated module the interpreter can run. Source may be surrounded by
+// prose or code fences.
+func Build(ctx context.Context, source string) ([]byte, error) {
+	var bin []byte
+	if strings.HasPrefix(source, "\x00asm") {
+		bin = []byte(source)
+	} else {
+		var err error
+		bin, err = wat.Assemble(wat.Extract(source))
+		if err != nil {
+			return nil, fmt.Errorf("WAT assembly failed at %w", err)
+		}
+	}
+
+	if err := Validate(ctx, bin); err != nil {
+		return nil, err
+	}
+	return bin, nil
+}
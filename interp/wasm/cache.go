@@ -0,0 +1,111 @@
+package wasm
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// moduleKey identifies a module by the SHA-256 of its bytes, so hypotheses
+// that share an ID but not their code never share a compiled module
+func moduleKey(bin []byte) string {
+	sum := sha256.Sum256(bin)
+	return hex.EncodeToString(sum[:])
+}
+
+// moduleCache keeps the most recently used compiled modules. Callers hold
+// a module from get or add until they release it, and an evicted module is
+// closed once nobody holds it, so eviction never closes a module between
+// lookup and instantiation.
+type moduleCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *cachedModule, most recently used first
+	modules map[string]*list.Element
+}
+
+type cachedModule struct {
+	key     string
+	module  wazero.CompiledModule
+	holds   int  // callers that have not released the module
+	evicted bool // no longer cached; closed when holds drops to zero
+}
+
+func newModuleCache(size int) *moduleCache {
+	return &moduleCache{
+		size:    size,
+		order:   list.New(),
+		modules: make(map[string]*list.Element),
+	}
+}
+
+// get returns a cached module, held until release is called, and marks it
+// as recently used
+func (c *moduleCache) get(key string) (module wazero.CompiledModule, release func(context.Context), ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.modules[key]
+	if !ok {
+		return nil, nil, false
+	}
+	c.order.MoveToFront(elem)
+	entry := elem.Value.(*cachedModule)
+	return entry.module, c.hold(entry), true
+}
+
+// add caches a module and returns the one to use, held until release is
+// called. When another caller cached the same module first, that one wins
+// and module is closed.
+func (c *moduleCache) add(ctx context.Context, key string, module wazero.CompiledModule) (wazero.CompiledModule, func(context.Context)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.modules[key]; ok {
+		_ = module.Close(ctx)
+		c.order.MoveToFront(elem)
+		entry := elem.Value.(*cachedModule)
+		return entry.module, c.hold(entry)
+	}
+
+	entry := &cachedModule{key: key, module: module}
+	c.modules[key] = c.order.PushFront(entry)
+	release := c.hold(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Remove(c.order.Back()).(*cachedModule)
+		delete(c.modules, oldest.key)
+		oldest.evicted = true
+		if oldest.holds == 0 {
+			_ = oldest.module.Close(ctx)
+		}
+	}
+	return module, release
+}
+
+// hold takes a hold on an entry and returns the function that releases it.
+// c.mu must be held.
+func (c *moduleCache) hold(entry *cachedModule) func(context.Context) {
+	entry.holds++
+	var once sync.Once
+	return func(ctx context.Context) {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			entry.holds--
+			if entry.evicted && entry.holds == 0 {
+				_ = entry.module.Close(ctx)
+			}
+		})
+	}
+}
+
+// len returns the number of cached modules
+func (c *moduleCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
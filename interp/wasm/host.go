@@ -0,0 +1,313 @@
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// HostModule names the module of host functions modules may import:
+//
+//	(func $log (import "agent" "log") (param $ptr i32) (param $len i32))
+//	(func $http_get_json (import "agent" "http_get_json")
+//	  (param $url_ptr i32) (param $url_len i32) (param $out_ptr i32) (param $out_cap i32) (result i32))
+//	(func $kv_get (import "agent" "kv_get")
+//	  (param $key_ptr i32) (param $key_len i32) (param $out_ptr i32) (param $out_cap i32) (result i32))
+//	(func $kv_set (import "agent" "kv_set")
+//	  (param $key_ptr i32) (param $key_len i32) (param $val_ptr i32) (param $val_len i32) (result i32))
+//	(func $now (import "agent" "now") (result i64))
+//
+// http_get_json and kv_get return the length of the value, and write it to
+// out only if it fits in out_cap bytes, or -1 if there is none; fetches go
+// through the interpreter's Tools, so PolicyGuard.AllowTool applies. kv_set
+// returns 0, or -1 if the task's store is full. now returns Unix
+// milliseconds.
+//
+// Each function needs a capability, granted through the hypothesis's
+// core.MetaCapabilities; modules that import a function they are not granted
+// are refused. Every call is recorded in the result's logs and metrics.
+const HostModule = "agent"
+
+// Tools are the external tools host functions reach; *tools.Adapter
+// satisfies it
+type Tools interface {
+	HTTPGetJSON(ctx context.Context, rawURL string, out any) error
+}
+
+// Limits on what host functions accept and keep
+const (
+	maxLogBytes     = 1 << 10
+	maxKVKeys       = 256
+	maxKVValueBytes = 64 << 10
+	maxAuditEntries = 100
+	kvTTL           = time.Hour
+)
+
+// hostFunc is a host function and the capability it needs
+type hostFunc struct {
+	name       string
+	capability string
+	params     []api.ValueType
+	results    []api.ValueType
+	call       func(ctx context.Context, c *hostCall, mem api.Memory, stack []uint64)
+}
+
+var (
+	twoI32s  = []api.ValueType{api.ValueTypeI32, api.ValueTypeI32}
+	fourI32s = []api.ValueType{api.ValueTypeI32, api.ValueTypeI32, api.ValueTypeI32, api.ValueTypeI32}
+	oneI32   = []api.ValueType{api.ValueTypeI32}
+	oneI64   = []api.ValueType{api.ValueTypeI64}
+)
+
+var hostFuncs = []hostFunc{
+	{"log", core.CapabilityLog, twoI32s, nil, hostLog},
+	{"http_get_json", core.CapabilityHTTP, fourI32s, oneI32, hostHTTPGetJSON},
+	{"kv_get", core.CapabilityKV, fourI32s, oneI32, hostKVGet},
+	{"kv_set", core.CapabilityKV, fourI32s, oneI32, hostKVSet},
+	{"now", core.CapabilityNow, nil, oneI64, hostNow},
+}
+
+func lookupHostFunc(name string) (hostFunc, bool) {
+	for _, f := range hostFuncs {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return hostFunc{}, false
+}
+
+// instantiateHost adds the host module to a runtime
+func instantiateHost(ctx context.Context, runtime wazero.Runtime) error {
+	builder := runtime.NewHostModuleBuilder(HostModule)
+	for _, f := range hostFuncs {
+		f := f
+		builder.NewFunctionBuilder().
+			WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+				c, _ := ctx.Value(hostCallKey{}).(*hostCall)
+				if c == nil || !c.granted[f.capability] {
+					panic(fmt.Errorf("%s.%s needs the %q capability", HostModule, f.name, f.capability))
+				}
+				f.call(ctx, c, mod.Memory(), stack)
+			}), f.params, f.results).
+			Export(f.name)
+	}
+	_, err := builder.Instantiate(ctx)
+	return err
+}
+
+// grantedCapabilities checks a module's host imports against the
+// capabilities granted to its hypothesis
+func grantedCapabilities(module wazero.CompiledModule, h core.Hypothesis) (map[string]bool, error) {
+	granted := map[string]bool{}
+	for _, c := range strings.Split(h.Meta[core.MetaCapabilities], ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			granted[c] = true
+		}
+	}
+
+	for _, def := range module.ImportedFunctions() {
+		moduleName, name, _ := def.Import()
+		if moduleName != HostModule {
+			continue
+		}
+		f, ok := lookupHostFunc(name)
+		if !ok {
+			return nil, fmt.Errorf("module imports unknown host function %s.%s", HostModule, name)
+		}
+		if !granted[f.capability] {
+			return nil, fmt.Errorf("module imports %s.%s, which needs the %q capability; it is not declared for %s",
+				HostModule, name, f.capability, h.ID)
+		}
+	}
+	return granted, nil
+}
+
+// hostCallKey is the context key of the current execution's hostCall
+type hostCallKey struct{}
+
+// hostCall is the host-side state of one execution. Calls within an
+// execution are sequential, so it needs no lock.
+type hostCall struct {
+	task    string
+	granted map[string]bool
+	tools   Tools
+	kv      *kvStore
+
+	audit   []string
+	metrics map[string]float64
+}
+
+func newHostCall(task string, granted map[string]bool, tools Tools, kv *kvStore) *hostCall {
+	return &hostCall{
+		task:    task,
+		granted: granted,
+		tools:   tools,
+		kv:      kv,
+		metrics: map[string]float64{"host_calls": 0},
+	}
+}
+
+// record audits a call; failed calls are also counted as such
+func (c *hostCall) record(name string, failed bool, format string, args ...any) {
+	c.metrics["host_calls"]++
+	c.metrics["host_calls_"+name]++
+	if failed {
+		c.metrics["host_calls_failed"]++
+	}
+
+	switch n := len(c.audit); {
+	case n < maxAuditEntries:
+		c.audit = append(c.audit, fmt.Sprintf("%s.%s"+format, append([]any{HostModule, name}, args...)...))
+	case n == maxAuditEntries:
+		c.audit = append(c.audit, "further host calls are counted but not logged")
+	}
+}
+
+func hostLog(ctx context.Context, c *hostCall, mem api.Memory, stack []uint64) {
+	msg := readGuest(mem, stack[0], stack[1], maxLogBytes)
+	c.record("log", false, ": %s", msg)
+}
+
+func hostHTTPGetJSON(ctx context.Context, c *hostCall, mem api.Memory, stack []uint64) {
+	url := readGuest(mem, stack[0], stack[1], maxLogBytes)
+	if c.tools == nil {
+		c.record("http_get_json", true, "(%q) failed: no tools available", url)
+		stack[0] = api.EncodeI32(-1)
+		return
+	}
+
+	var body json.RawMessage
+	if err := c.tools.HTTPGetJSON(ctx, url, &body); err != nil {
+		c.record("http_get_json", true, "(%q) failed: %v", url, err)
+		stack[0] = api.EncodeI32(-1)
+		return
+	}
+	c.record("http_get_json", false, "(%q) = %d bytes", url, len(body))
+	stack[0] = api.EncodeI32(writeGuest(mem, stack[2], stack[3], body))
+}
+
+func hostKVGet(ctx context.Context, c *hostCall, mem api.Memory, stack []uint64) {
+	key := readGuest(mem, stack[0], stack[1], maxLogBytes)
+	value, ok := c.kv.get(c.task, key)
+	if !ok {
+		c.record("kv_get", false, "(%q) = none", key)
+		stack[0] = api.EncodeI32(-1)
+		return
+	}
+	c.record("kv_get", false, "(%q) = %d bytes", key, len(value))
+	stack[0] = api.EncodeI32(writeGuest(mem, stack[2], stack[3], value))
+}
+
+func hostKVSet(ctx context.Context, c *hostCall, mem api.Memory, stack []uint64) {
+	key := readGuest(mem, stack[0], stack[1], maxLogBytes)
+	value := readGuest(mem, stack[2], stack[3], maxKVValueBytes)
+	if !c.kv.set(c.task, key, []byte(value)) {
+		c.record("kv_set", true, "(%q, %d bytes) failed: store is full", key, len(value))
+		stack[0] = api.EncodeI32(-1)
+		return
+	}
+	c.record("kv_set", false, "(%q, %d bytes)", key, len(value))
+	stack[0] = 0
+}
+
+func hostNow(ctx context.Context, c *hostCall, mem api.Memory, stack []uint64) {
+	now := time.Now().UnixMilli()
+	c.record("now", false, "() = %d", now)
+	stack[0] = api.EncodeI64(now)
+}
+
+// readGuest reads a string argument, trapping if it is out of bounds or
+// longer than limit
+func readGuest(mem api.Memory, ptr, size uint64, limit int) string {
+	if size > uint64(limit) {
+		panic(fmt.Errorf("host function argument of %d bytes exceeds %d", size, limit))
+	}
+	data, ok := mem.Read(uint32(ptr), uint32(size))
+	if !ok {
+		panic(fmt.Errorf("host function argument at %d+%d is out of bounds", ptr, size))
+	}
+	return string(data)
+}
+
+// writeGuest writes a value to out if it fits and returns its length
+func writeGuest(mem api.Memory, ptr, capacity uint64, value []byte) int32 {
+	if uint64(len(value)) <= capacity && !mem.Write(uint32(ptr), value) {
+		panic(fmt.Errorf("host function output at %d+%d is out of bounds", ptr, len(value)))
+	}
+	return int32(len(value))
+}
+
+// kvStore holds each task's key-value pairs, forgetting tasks unused for
+// kvTTL
+type kvStore struct {
+	mu    sync.Mutex
+	tasks map[string]*taskKV
+}
+
+type taskKV struct {
+	values map[string][]byte
+	last   time.Time
+}
+
+func newKVStore() *kvStore {
+	return &kvStore{tasks: make(map[string]*taskKV)}
+}
+
+func (s *kvStore) get(task, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kv := s.task(task)
+	value, ok := kv.values[key]
+	return value, ok
+}
+
+func (s *kvStore) set(task, key string, value []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kv := s.task(task)
+	if _, exists := kv.values[key]; !exists && len(kv.values) >= maxKVKeys {
+		return false
+	}
+	kv.values[key] = value
+	return true
+}
+
+// release drops the pairs of a task and of the tasks whose IDs extend it
+// with "/"
+func (s *kvStore) release(task string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range s.tasks {
+		if id == task || strings.HasPrefix(id, task+"/") {
+			delete(s.tasks, id)
+		}
+	}
+}
+
+// task returns a task's pairs, expiring idle tasks; s.mu must be held
+func (s *kvStore) task(task string) *taskKV {
+	now := time.Now()
+	for id, kv := range s.tasks {
+		if now.Sub(kv.last) > kvTTL {
+			delete(s.tasks, id)
+		}
+	}
+
+	kv, ok := s.tasks[task]
+	if !ok {
+		kv = &taskKV{values: make(map[string][]byte)}
+		s.tasks[task] = kv
+	}
+	kv.last = now
+	return kv
+}
@@ -0,0 +1,149 @@
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/policy/local"
+	"github.com/snow-ghost/agent/worker/tools"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fetchModule logs, reads the clock and answers with the JSON at its URL, or
+// {"error":true} if the fetch fails
+const fetchModule = `(module
+  (import "agent" "log" (func $log (param i32 i32)))
+  (import "agent" "http_get_json" (func $get (param i32 i32 i32 i32) (result i32)))
+  (import "agent" "now" (func $now (result i64)))
+  (memory (export "memory") 1)
+  (data (i32.const 0) "fetching")
+  (data (i32.const 16) "{\"error\":true}")
+  (data (i32.const 64) "%s")
+  (func (export "solve") (param i32 i32) (result i32 i32)
+    (local $n i32)
+    (call $log (i32.const 0) (i32.const 8))
+    (drop (call $now))
+    (local.set $n (call $get (i32.const 64) (i32.const %d) (i32.const 4096) (i32.const 1024)))
+    (if (i32.lt_s (local.get $n) (i32.const 0))
+      (then (return (i32.const 16) (i32.const 14))))
+    (i32.const 4096) (local.get $n)))`
+
+// seenModule answers whether an earlier execution of its task stored "k"
+const seenModule = `(module
+  (import "agent" "kv_get" (func $get (param i32 i32 i32 i32) (result i32)))
+  (import "agent" "kv_set" (func $set (param i32 i32 i32 i32) (result i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 0) "k")
+  (data (i32.const 16) "{\"seen\":true}")
+  (data (i32.const 32) "{\"seen\":false}")
+  (func (export "solve") (param i32 i32) (result i32 i32)
+    (local $n i32)
+    (local.set $n (call $get (i32.const 0) (i32.const 1) (i32.const 1024) (i32.const 64)))
+    (if (i32.lt_s (local.get $n) (i32.const 0))
+      (then
+        (drop (call $set (i32.const 0) (i32.const 1) (i32.const 16) (i32.const 13)))
+        (return (i32.const 32) (i32.const 14))))
+    (i32.const 1024) (local.get $n)))`
+
+func withCapabilities(h core.Hypothesis, caps ...string) core.Hypothesis {
+	h.Meta = map[string]string{core.MetaCapabilities: strings.Join(caps, ",")}
+	return h
+}
+
+func TestHost_DeniedByDefault(t *testing.T) {
+	interp := NewInterpreter()
+	defer interp.Close(context.Background())
+
+	h := core.Hypothesis{ID: "seen", Lang: "wasm", Bytes: assemble(t, seenModule)}
+	task := core.Task{ID: "t1", Input: json.RawMessage(`{}`)}
+
+	for _, granted := range [][]string{nil, {core.CapabilityLog, core.CapabilityNow}} {
+		_, err := interp.Execute(context.Background(), withCapabilities(h, granted...), task)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `agent.kv_get, which needs the "kv" capability`)
+	}
+}
+
+func TestHost_KVScopedToTask(t *testing.T) {
+	interp := NewInterpreter()
+	defer interp.Close(context.Background())
+
+	h := withCapabilities(core.Hypothesis{ID: "seen", Lang: "wasm", Bytes: assemble(t, seenModule)}, core.CapabilityKV)
+	run := func(taskID string) core.Result {
+		res, err := interp.Execute(context.Background(), h, core.Task{ID: taskID, Input: json.RawMessage(`{}`)})
+		require.NoError(t, err)
+		return res
+	}
+
+	first := run("t1")
+	assert.JSONEq(t, `{"seen":false}`, string(first.Output))
+	assert.Equal(t, 2.0, first.Metrics["host_calls"])
+	assert.Equal(t, 1.0, first.Metrics["host_calls_kv_set"])
+	assert.Contains(t, first.Logs, `agent.kv_get("k") = none`)
+	assert.Contains(t, first.Logs, `agent.kv_set("k", 13 bytes)`)
+
+	second := run("t1")
+	assert.JSONEq(t, `{"seen":true}`, string(second.Output))
+	assert.Contains(t, second.Logs, `agent.kv_get("k") = 13 bytes`)
+
+	assert.JSONEq(t, `{"seen":false}`, string(run("t2").Output))
+
+	// Releasing a task drops its pairs and those of its sub-tasks only
+	run("t1/case:a")
+	run("t10")
+	interp.ReleaseState("t1")
+	assert.JSONEq(t, `{"seen":false}`, string(run("t1").Output))
+	assert.JSONEq(t, `{"seen":false}`, string(run("t1/case:a").Output))
+	assert.JSONEq(t, `{"seen":true}`, string(run("t10").Output))
+}
+
+func TestHost_HTTPGetJSONUsesPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"temp":21}`)
+	}))
+	defer server.Close()
+	host, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	src := fmt.Sprintf(fetchModule, server.URL, len(server.URL))
+	h := withCapabilities(core.Hypothesis{ID: "fetch", Lang: "wasm", Bytes: assemble(t, src)},
+		core.CapabilityLog, core.CapabilityHTTP, core.CapabilityNow)
+	task := core.Task{ID: "t1", Input: json.RawMessage(`{}`)}
+
+	cases := []struct {
+		name   string
+		allow  []string
+		output string
+		failed float64
+		log    string
+	}{
+		{"allowed", []string{"http:" + host.Host}, `{"temp":21}`, 0, fmt.Sprintf("agent.http_get_json(%q) = 11 bytes", server.URL)},
+		{"not allowlisted", []string{"example.com"}, `{"error":true}`, 1, "tool not allowed"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			interp := NewInterpreter()
+			defer interp.Close(context.Background())
+			interp.SetTools(tools.NewAdapter(local.NewGuard(tc.allow)))
+
+			res, err := interp.Execute(context.Background(), h, task)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.output, string(res.Output))
+
+			assert.Equal(t, 3.0, res.Metrics["host_calls"])
+			assert.Equal(t, 1.0, res.Metrics["host_calls_http_get_json"])
+			assert.Equal(t, tc.failed, res.Metrics["host_calls_failed"])
+			assert.Contains(t, res.Logs, "agent.log: fetching")
+			assert.Contains(t, res.Logs, "agent.now() = ")
+			assert.Contains(t, res.Logs, tc.log)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package wasm
+
+import (
+	"math"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// Defaults for Config fields left at zero
+const (
+	DefaultMemoryLimitMB = 4
+	// DefaultFuelPerMilli allows about 10^8 instructions per CPU second,
+	// below what metered code runs at, so that fuel rather than the clock
+	// ends runaway executions
+	DefaultFuelPerMilli = 100_000
+	// DefaultCacheSize is how many compiled modules an interpreter keeps
+	DefaultCacheSize = 64
+)
+
+// Config sets the limits of an interpreter
+type Config struct {
+	// MemoryLimitMB caps the linear memory of every execution; a task's
+	// Budget.MemMB may lower it
+	MemoryLimitMB int
+	// FuelPerMilli is how many instructions an execution may run per
+	// millisecond of the task's Budget.CPUMillis
+	FuelPerMilli int64
+	// CacheSize is how many compiled modules are kept in memory; the least
+	// recently used are closed beyond it
+	CacheSize int
+	// CacheDir, if set, holds wazero's compilation cache, so that modules
+	// compiled before a restart need not be compiled again
+	CacheDir string
+}
+
+// withDefaults fills zero fields
+func (c Config) withDefaults() Config {
+	if c.MemoryLimitMB <= 0 {
+		c.MemoryLimitMB = DefaultMemoryLimitMB
+	}
+	if c.FuelPerMilli <= 0 {
+		c.FuelPerMilli = DefaultFuelPerMilli
+	}
+	if c.CacheSize <= 0 {
+		c.CacheSize = DefaultCacheSize
+	}
+	return c
+}
+
+// limits are the resources one execution may use
+type limits struct {
+	fuel        int64
+	memoryBytes uint64
+}
+
+// limitsFor derives an execution's limits from the task budget
+func (c Config) limitsFor(b core.Budget) limits {
+	l := limits{
+		fuel:        math.MaxInt64,
+		memoryBytes: uint64(c.MemoryLimitMB) << 20,
+	}
+	if b.CPUMillis > 0 && int64(b.CPUMillis) <= math.MaxInt64/c.FuelPerMilli {
+		l.fuel = int64(b.CPUMillis) * c.FuelPerMilli
+	}
+	if b.MemMB > 0 && uint64(b.MemMB)<<20 < l.memoryBytes {
+		l.memoryBytes = uint64(b.MemMB) << 20
+	}
+	return l
+}
+
+// limitedMemory backs one instance's linear memory. It refuses to grow past
+// its limit, which makes memory.grow return -1, and records the largest size
+// reached.
+type limitedMemory struct {
+	buf   []byte
+	limit uint64
+	peak  uint64
+}
+
+// Allocate implements experimental.MemoryAllocator; each execution uses its
+// own limitedMemory, so it is only ever asked for one memory
+func (m *limitedMemory) Allocate(cap, max uint64) experimental.LinearMemory {
+	m.buf = make([]byte, 0, min(cap, m.limit))
+	return m
+}
+
+// Reallocate implements experimental.LinearMemory
+func (m *limitedMemory) Reallocate(size uint64) []byte {
+	if size > m.limit {
+		return nil
+	}
+	if size > uint64(cap(m.buf)) {
+		grown := make([]byte, size, min(max(size, 2*uint64(cap(m.buf))), m.limit))
+		copy(grown, m.buf)
+		m.buf = grown
+	} else {
+		m.buf = m.buf[:size]
+	}
+	m.peak = max(m.peak, size)
+	return m.buf
+}
+
+// Free implements experimental.LinearMemory
+func (m *limitedMemory) Free() {
+	m.buf = nil
+}
@@ -0,0 +1,116 @@
+package wasm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wasmbin"
+)
+
+// fuelExport names the global that meter adds. It starts at the maximum, so
+// start functions are only bounded by the clock; the host then sets it to
+// the execution's fuel and reads what is left afterwards. It goes negative
+// when the fuel runs out.
+const fuelExport = "__agent_fuel"
+
+// meter instruments a module for deterministic instruction metering. Code is
+// split into straight-line segments at control instructions, and each
+// segment starts by charging the fuel global for its instructions and
+// trapping once the fuel is exhausted. A segment is charged in full when it
+// is entered, so the count is an upper bound on the instructions executed.
+func meter(bin []byte) ([]byte, error) {
+	mod, err := wasmbin.Decode(bin)
+	if err != nil {
+		return nil, err
+	}
+
+	// The new global goes at the end of the global index space
+	defined, err := wasmbin.VectorLen(mod.Section(wasmbin.SectionGlobal))
+	if err != nil {
+		return nil, err
+	}
+	fuel := uint32(mod.ImportedGlobals + defined)
+
+	global := []byte{wasmbin.TypeI64, 0x01} // mutable i64
+	global = append(global, wasmbin.OpI64Const)
+	global = wasmbin.AppendS64(global, math.MaxInt64)
+	global = append(global, wasmbin.OpEnd)
+	globals, err := wasmbin.AppendToVector(mod.Section(wasmbin.SectionGlobal), global)
+	if err != nil {
+		return nil, err
+	}
+	mod.SetSection(wasmbin.SectionGlobal, globals)
+
+	entry := wasmbin.AppendU32(nil, uint32(len(fuelExport)))
+	entry = append(entry, fuelExport...)
+	entry = append(entry, wasmbin.ExternGlobal)
+	entry = wasmbin.AppendU32(entry, fuel)
+	exports, err := wasmbin.AppendToVector(mod.Section(wasmbin.SectionExport), entry)
+	if err != nil {
+		return nil, err
+	}
+	mod.SetSection(wasmbin.SectionExport, exports)
+
+	funcs := make([]*wasmbin.Func, len(mod.Funcs))
+	for i, fn := range mod.Funcs {
+		metered := *fn
+		metered.Code = meterCode(fn.Code, fuel)
+		funcs[i] = &metered
+	}
+	return mod.Encode(funcs), nil
+}
+
+// meterCode inserts a charge at the start of each segment of code
+func meterCode(code []wasmbin.Instr, fuel uint32) []wasmbin.Instr {
+	out := make([]wasmbin.Instr, 0, len(code)*2)
+	for start := 0; start < len(code); {
+		end := start
+		for end < len(code) && !endsSegment(code[end].Op) {
+			end++
+		}
+		if end < len(code) {
+			end++ // the control instruction is part of the segment
+		}
+
+		out = append(out, charge(fuel, int64(end-start))...)
+		out = append(out, code[start:end]...)
+		start = end
+	}
+	return out
+}
+
+// endsSegment reports whether control may leave or enter code after op
+func endsSegment(op byte) bool {
+	switch op {
+	case wasmbin.OpBlock, wasmbin.OpLoop, wasmbin.OpIf, wasmbin.OpElse, wasmbin.OpEnd,
+		wasmbin.OpBr, wasmbin.OpBrIf, wasmbin.OpBrTable, wasmbin.OpReturn:
+		return true
+	}
+	return false
+}
+
+// charge subtracts cost from the fuel global and traps if it goes negative:
+//
+//	global.get $fuel  i64.const cost  i64.sub  global.set $fuel
+//	global.get $fuel  i64.const 0  i64.lt_s  if  unreachable  end
+func charge(fuel uint32, cost int64) []wasmbin.Instr {
+	global := wasmbin.AppendU32(nil, fuel)
+	return []wasmbin.Instr{
+		{Op: wasmbin.OpGlobalGet, Imm: global},
+		{Op: wasmbin.OpI64Const, Imm: wasmbin.AppendS64(nil, cost)},
+		{Op: wasmbin.OpI64Sub},
+		{Op: wasmbin.OpGlobalSet, Imm: global},
+		{Op: wasmbin.OpGlobalGet, Imm: global},
+		{Op: wasmbin.OpI64Const, Imm: wasmbin.AppendS64(nil, 0)},
+		{Op: wasmbin.OpI64LtS},
+		{Op: wasmbin.OpIf, Imm: []byte{wasmbin.BlockTypeEmpty}},
+		{Op: wasmbin.OpUnreachable},
+		{Op: wasmbin.OpEnd},
+	}
+}
+
+// errOutOfFuel reports an execution stopped by metering
+func errOutOfFuel(limit int64) error {
+	return fmt.Errorf("%w: out of fuel, used all %d instructions of the CPU budget", core.ErrBudgetExceeded, limit)
+}
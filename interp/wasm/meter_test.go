@@ -0,0 +1,120 @@
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// spinModule loops forever
+const spinModule = `(module
+  (memory (export "memory") 1)
+  (func (export "solve") (param i32 i32) (result i32 i32)
+    (loop $spin (br $spin))
+    (local.get 0) (local.get 1)))`
+
+// hogModule grows its memory until refused, then answers {"ok":true}
+const hogModule = `(module
+  (memory (export "memory") 1)
+  (data (i32.const 0) "{\"ok\":true}")
+  (func (export "solve") (param i32 i32) (result i32 i32)
+    (loop $grow
+      (br_if $grow (i32.ne (memory.grow (i32.const 1)) (i32.const -1))))
+    (i32.const 0) (i32.const 11)))`
+
+func assemble(t *testing.T, src string) []byte {
+	t.Helper()
+	bin, err := wat.Assemble(src)
+	require.NoError(t, err)
+	return bin
+}
+
+func TestMeter_KeepsBehaviour(t *testing.T) {
+	for name, bin := range map[string][]byte{
+		"test module": GetTestModule(),
+		"rust sdk":    sdkModule(t, "rust"),
+		"tinygo sdk":  sdkModule(t, "tinygo"),
+	} {
+		metered, err := meter(bin)
+		require.NoError(t, err, name)
+		assert.NoError(t, Validate(context.Background(), metered), name)
+	}
+
+	_, err := meter([]byte("not wasm"))
+	assert.Error(t, err)
+}
+
+func TestExecute_OutOfFuel(t *testing.T) {
+	interp := NewInterpreter()
+	defer interp.Close(context.Background())
+
+	task := core.Task{ID: "spin", Input: json.RawMessage(`{}`), Budget: core.Budget{CPUMillis: 5, Timeout: 10 * time.Second}}
+	start := time.Now()
+	_, err := interp.Execute(context.Background(), core.Hypothesis{ID: "spin", Lang: "wasm", Bytes: assemble(t, spinModule)}, task)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "out of fuel")
+	assert.ErrorIs(t, err, core.ErrBudgetExceeded)
+	assert.Less(t, time.Since(start), time.Second, "fuel, not the clock, should stop the loop")
+}
+
+func TestExecute_FuelIsDeterministic(t *testing.T) {
+	interp := NewInterpreter()
+	defer interp.Close(context.Background())
+
+	h := core.Hypothesis{ID: "tinygo", Lang: "wasm", Bytes: sdkModule(t, "tinygo")}
+	fuelFor := func(input string) float64 {
+		res, err := interp.Execute(context.Background(), h, core.Task{ID: "fuel", Input: json.RawMessage(input)})
+		require.NoError(t, err)
+		return res.Metrics["fuel_used"]
+	}
+
+	small := fuelFor(`[1,2,3]`)
+	assert.Greater(t, small, 0.0)
+	assert.Equal(t, small, fuelFor(`[1,2,3]`))
+	assert.Greater(t, fuelFor(`[1,2,3,4,5,6,7,8,9,10]`), small)
+}
+
+func TestExecute_MemoryBudget(t *testing.T) {
+	ctx := context.Background()
+	hog := core.Hypothesis{ID: "hog", Lang: "wasm", Bytes: assemble(t, hogModule)}
+	input := json.RawMessage(`{}`)
+
+	cases := []struct {
+		name    string
+		limitMB int
+		budget  int
+		want    float64
+	}{
+		{"interpreter limit", 2, 0, 2 << 20},
+		{"task budget", 4, 1, 1 << 20},
+		{"budget above limit", 1, 8, 1 << 20},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			interp, err := NewInterpreterWithConfig(Config{MemoryLimitMB: tc.limitMB})
+			require.NoError(t, err)
+			defer interp.Close(ctx)
+
+			res, err := interp.Execute(ctx, hog, core.Task{ID: "hog", Input: input, Budget: core.Budget{MemMB: tc.budget}})
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"ok":true}`, string(res.Output))
+			assert.Equal(t, tc.want, res.Metrics["peak_memory_bytes"])
+		})
+	}
+
+	// Modules that start out larger than the budget are refused
+	interp := NewInterpreter()
+	defer interp.Close(ctx)
+	big := core.Hypothesis{ID: "big", Lang: "wasm", Bytes: assemble(t, `(module
+	  (memory (export "memory") 32)
+	  (func (export "solve") (param i32 i32) (result i32 i32) (local.get 0) (local.get 1)))`)}
+	_, err := interp.Execute(ctx, big, core.Task{ID: "big", Input: input, Budget: core.Budget{MemMB: 1}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not enough memory")
+}
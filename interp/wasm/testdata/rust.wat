@@ -0,0 +1,46 @@
+;; An SDK test module laid out the way rustc lays out wasm32-unknown-unknown
+;; cdylibs: a 1 MiB shadow stack at the bottom of memory, static data above
+;; it and a bump heap from __heap_base. The host gets its input buffer from
+;; alloc and hands it back to dealloc; solve returns its output packed into
+;; an i64.
+;;
+;; solve answers {"sdk":"rust","echo":<input>}.
+(module
+  (memory (export "memory") 17)
+
+  (global $__stack_pointer (mut i32) (i32.const 1048576))
+  (global $heap (mut i32) (i32.const 1048600))
+  (global (export "__data_end") i32 (i32.const 1048597))
+  (global (export "__heap_base") i32 (i32.const 1048600))
+  ;; live counts the allocations not yet handed back
+  (global $live (export "live") (mut i32) (i32.const 0))
+
+  (data (i32.const 1048576) "{\"sdk\":\"rust\",\"echo\":")
+
+  (func $alloc (export "alloc") (param $size i32) (result i32)
+    (local $ptr i32) (local $end i32) (local $short i32)
+    (local.set $ptr (global.get $heap))
+    (local.set $end (i32.add (local.get $ptr) (local.get $size)))
+    (local.set $short (i32.sub (local.get $end) (i32.shl (memory.size) (i32.const 16))))
+    (if (i32.gt_s (local.get $short) (i32.const 0))
+      (then
+        (if (i32.eq (memory.grow (i32.shr_u (i32.add (local.get $short) (i32.const 65535)) (i32.const 16)))
+                    (i32.const -1))
+          (then (return (i32.const 0))))))
+    (global.set $heap (i32.and (i32.add (local.get $end) (i32.const 7)) (i32.const -8)))
+    (global.set $live (i32.add (global.get $live) (i32.const 1)))
+    (local.get $ptr))
+
+  (func (export "dealloc") (param $ptr i32) (param $size i32)
+    (global.set $live (i32.sub (global.get $live) (i32.const 1))))
+
+  (func (export "solve") (param $ptr i32) (param $len i32) (result i64)
+    (local $out i32) (local $n i32)
+    (local.set $n (i32.add (local.get $len) (i32.const 22)))
+    (local.set $out (call $alloc (local.get $n)))
+    (memory.copy (local.get $out) (i32.const 1048576) (i32.const 21))
+    (memory.copy (i32.add (local.get $out) (i32.const 21)) (local.get $ptr) (local.get $len))
+    (i32.store8 (i32.add (local.get $out) (i32.sub (local.get $n) (i32.const 1))) (i32.const 125))
+    (i64.or
+      (i64.shl (i64.extend_i32_u (local.get $out)) (i64.const 32))
+      (i64.extend_i32_u (local.get $n)))))
@@ -0,0 +1,65 @@
+;; An SDK test module laid out the way TinyGo lays out wasm targets: a 64 KiB
+;; stack growing down from 65536, static data above it and a bump heap from
+;; __heap_base. Like TinyGo programs it imports WASI and exports malloc and
+;; free, which the host uses for its input buffer; solve returns its output
+;; packed into an i64.
+;;
+;; solve answers {"sdk":"tinygo","echo":<input>}.
+(module
+  (import "wasi_snapshot_preview1" "fd_write"
+    (func $fd_write (param i32 i32 i32 i32) (result i32)))
+  (memory (export "memory") 2)
+
+  (global $__stack_pointer (mut i32) (i32.const 65536))
+  (global $heap (mut i32) (i32.const 65560))
+  (global (export "__heap_base") i32 (i32.const 65560))
+  ;; live counts the allocations not yet freed
+  (global $live (export "live") (mut i32) (i32.const 0))
+
+  (data (i32.const 65536) "{\"sdk\":\"tinygo\",\"echo\":")
+
+  (func $malloc (export "malloc") (param $size i32) (result i32)
+    (local $ptr i32) (local $end i32) (local $short i32)
+    (local.set $ptr (global.get $heap))
+    (local.set $end (i32.add (local.get $ptr) (local.get $size)))
+    (local.set $short (i32.sub (local.get $end) (i32.shl (memory.size) (i32.const 16))))
+    (if (i32.gt_s (local.get $short) (i32.const 0))
+      (then
+        (if (i32.eq (memory.grow (i32.shr_u (i32.add (local.get $short) (i32.const 65535)) (i32.const 16)))
+                    (i32.const -1))
+          (then (return (i32.const 0))))))
+    (global.set $heap (i32.and (i32.add (local.get $end) (i32.const 7)) (i32.const -8)))
+    (global.set $live (i32.add (global.get $live) (i32.const 1)))
+    (local.get $ptr))
+
+  (func (export "free") (param $ptr i32)
+    (global.set $live (i32.sub (global.get $live) (i32.const 1))))
+
+  (func (export "solve") (param $ptr i32) (param $len i32) (result i64)
+    (local $out i32) (local $n i32) (local $i i32)
+    (local.set $n (i32.add (local.get $len) (i32.const 24)))
+    (local.set $out (call $malloc (local.get $n)))
+    (local.set $i (i32.const 0))
+    block $done
+      loop $copy
+        (br_if $done (i32.ge_u (local.get $i) (i32.const 23)))
+        (i32.store8 (i32.add (local.get $out) (local.get $i))
+          (i32.load8_u (i32.add (i32.const 65536) (local.get $i))))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        br $copy
+      end
+    end
+    (local.set $i (i32.const 0))
+    block $done
+      loop $copy
+        (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+        (i32.store8 (i32.add (local.get $out) (i32.add (local.get $i) (i32.const 23)))
+          (i32.load8_u (i32.add (local.get $ptr) (local.get $i))))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        br $copy
+      end
+    end
+    (i32.store8 (i32.add (local.get $out) (i32.sub (local.get $n) (i32.const 1))) (i32.const 125))
+    (i64.or
+      (i64.shl (i64.extend_i32_u (local.get $out)) (i64.const 32))
+      (i64.extend_i32_u (local.get $n)))))
@@ -0,0 +1,120 @@
+package wasm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Validate compiles a module and checks it against the interpreter's ABI: it
+// must export memory and solve(ptr, len i32) -> (ptr, len i32) or -> i64,
+// allocator exports must have the documented types, and it may only import
+// WASI and host functions. The error describes the first problem found, phrased so that
+// it can be handed back to whoever wrote the module.
+func Validate(ctx context.Context, bin []byte) error {
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter())
+	defer runtime.Close(ctx)
+
+	module, err := runtime.CompileModule(ctx, bin)
+	if err != nil {
+		return fmt.Errorf("invalid module: %w", err)
+	}
+	defer module.Close(ctx)
+
+	solve, ok := module.ExportedFunctions()["solve"]
+	if !ok {
+		return fmt.Errorf("module does not export a \"solve\" function")
+	}
+	i32, i64 := api.ValueTypeI32, api.ValueTypeI64
+	if !sameTypes(solve.ParamTypes(), i32, i32) ||
+		!sameTypes(solve.ResultTypes(), i32, i32) && !sameTypes(solve.ResultTypes(), i64) {
+		return fmt.Errorf("solve must have type (param i32 i32) (result i32 i32) or (param i32 i32) (result i64), got (param %s) (result %s)",
+			typeNames(solve.ParamTypes()), typeNames(solve.ResultTypes()))
+	}
+
+	for _, a := range allocators {
+		if alloc, ok := module.ExportedFunctions()[a.alloc]; ok {
+			if !sameTypes(alloc.ParamTypes(), i32) || !sameTypes(alloc.ResultTypes(), i32) {
+				return fmt.Errorf("%s must have type (param i32) (result i32), got (param %s) (result %s)",
+					a.alloc, typeNames(alloc.ParamTypes()), typeNames(alloc.ResultTypes()))
+			}
+		}
+		if free, ok := module.ExportedFunctions()[a.free]; ok {
+			params, want := []api.ValueType{i32}, "(param i32)"
+			if a.sized {
+				params, want = []api.ValueType{i32, i32}, "(param i32 i32)"
+			}
+			if !sameTypes(free.ParamTypes(), params...) || len(free.ResultTypes()) != 0 {
+				return fmt.Errorf("%s must have type %s, got (param %s) (result %s)",
+					a.free, want, typeNames(free.ParamTypes()), typeNames(free.ResultTypes()))
+			}
+		}
+	}
+
+	if len(module.ExportedMemories()) == 0 {
+		return fmt.Errorf("module does not export its memory; add (export \"memory\" (memory 0))")
+	}
+
+	for _, imported := range module.ImportedFunctions() {
+		moduleName, name, _ := imported.Import()
+		switch moduleName {
+		case wasi_snapshot_preview1.ModuleName:
+		case HostModule:
+			f, ok := lookupHostFunc(name)
+			if !ok {
+				return fmt.Errorf("module imports %s.%s, which is not a host function", moduleName, name)
+			}
+			if !sameTypes(imported.ParamTypes(), f.params...) || !sameTypes(imported.ResultTypes(), f.results...) {
+				return fmt.Errorf("%s.%s must have type %s, got %s", moduleName, name,
+					signature(f.params, f.results), signature(imported.ParamTypes(), imported.ResultTypes()))
+			}
+		default:
+			return fmt.Errorf("module imports %s.%s; only %s and %s functions are available",
+				moduleName, name, wasi_snapshot_preview1.ModuleName, HostModule)
+		}
+	}
+
+	return nil
+}
+
+func sameTypes(got []api.ValueType, want ...api.ValueType) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func typeNames(types []api.ValueType) string {
+	names := ""
+	for i, t := range types {
+		if i > 0 {
+			names += " "
+		}
+		names += api.ValueTypeName(t)
+	}
+	return names
+}
+
+// signature formats a function type, leaving out empty params and results
+func signature(params, results []api.ValueType) string {
+	var parts []string
+	if len(params) > 0 {
+		parts = append(parts, "(param "+typeNames(params)+")")
+	}
+	if len(results) > 0 {
+		parts = append(parts, "(result "+typeNames(results)+")")
+	}
+	if len(parts) == 0 {
+		return "(func)"
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,41 @@
+package wasm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/snow-ghost/agent/interp/wat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	assert.NoError(t, Validate(ctx, GetTestModule()))
+
+	cases := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"no solve", `(module (memory (export "memory") 1))`, `does not export a "solve" function`},
+		{"wrong signature", `(module (memory (export "memory") 1) (func (export "solve") (param i32) (result i32) (local.get 0)))`, "got (param i32) (result i32)"},
+		{"no memory", `(module (memory 1) (func (export "solve") (param i32 i32) (result i32 i32) (local.get 0) (local.get 1)))`, "does not export its memory"},
+		{"foreign import", `(module (import "env" "f" (func)) (memory (export "memory") 1) (func (export "solve") (param i32 i32) (result i32 i32) (local.get 0) (local.get 1)))`, "imports env.f"},
+		{"bad host import", `(module (import "agent" "now" (func (result i32))) (memory (export "memory") 1) (func (export "solve") (param i32 i32) (result i64) (i64.const 0)))`, "agent.now must have type (result i64), got (result i32)"},
+		{"unknown host import", `(module (import "agent" "exec" (func)) (memory (export "memory") 1) (func (export "solve") (param i32 i32) (result i64) (i64.const 0)))`, "not a host function"},
+		{"bad alloc", `(module (memory (export "memory") 1) (func (export "alloc") (param i64) (result i32) (i32.const 8)) (func (export "solve") (param i32 i32) (result i64) (i64.const 0)))`, "alloc must have type (param i32) (result i32)"},
+		{"bad free", `(module (memory (export "memory") 1) (func (export "free") (param i32 i32)) (func (export "solve") (param i32 i32) (result i64) (i64.const 0)))`, "free must have type (param i32)"},
+		{"type error", `(module (memory (export "memory") 1) (func (export "solve") (param i32 i32) (result i32 i32) (local.get 0)))`, "invalid module"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			bin, err := wat.Assemble(tc.src)
+			require.NoError(t, err)
+
+			err = Validate(ctx, bin)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+}
@@ -1,66 +1,153 @@
 package wasm
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/snow-ghost/agent/core"
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
 	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
 )
 
-// Interpreter implements core.Interpreter interface using wazero WASM runtime
+// defaultTimeout bounds executions of tasks without a timeout
+const defaultTimeout = 30 * time.Second
+
+// Interpreter implements core.Interpreter interface using wazero WASM runtime.
+// Modules are instrumented for fuel metering when compiled, and each
+// execution runs with the fuel and memory its task's budget allows. Compiled
+// modules are cached by the hash of their bytes.
 type Interpreter struct {
 	runtime wazero.Runtime
-	cache   map[string]wazero.CompiledModule
+	config  Config
+	tools   Tools
+	kv      *kvStore
+	cache   *moduleCache
+	// compiled is the on-disk compilation cache, if Config.CacheDir is set
+	compiled wazero.CompilationCache
 }
 
 // NewInterpreter creates a new WASM interpreter with default configuration
 func NewInterpreter() *Interpreter {
+	interp, err := NewInterpreterWithConfig(Config{})
+	if err != nil {
+		panic(err)
+	}
+	return interp
+}
+
+// NewInterpreterWithConfig creates a WASM interpreter with the given limits
+// and caching. It fails if Config.CacheDir cannot be used.
+func NewInterpreterWithConfig(config Config) (*Interpreter, error) {
+	config = config.withDefaults()
+
 	// Create runtime with memory and timeout limits
-	config := wazero.NewRuntimeConfig().
-		WithMemoryLimitPages(64). // 64 pages = 4MB
+	runtimeConfig := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(config.MemoryLimitMB) << 20 / pageSize).
 		WithCloseOnContextDone(true)
 
-	runtime := wazero.NewRuntimeWithConfig(context.Background(), config)
+	var compiled wazero.CompilationCache
+	if config.CacheDir != "" {
+		var err error
+		if compiled, err = wazero.NewCompilationCacheWithDir(config.CacheDir); err != nil {
+			return nil, fmt.Errorf("failed to open WASM compilation cache: %w", err)
+		}
+		runtimeConfig = runtimeConfig.WithCompilationCache(compiled)
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, runtimeConfig)
 
 	// Enable WASI for basic functionality
-	wasi_snapshot_preview1.MustInstantiate(context.Background(), runtime)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+	if err := instantiateHost(ctx, runtime); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, err
+	}
 
 	return &Interpreter{
-		runtime: runtime,
-		cache:   make(map[string]wazero.CompiledModule),
-	}
+		runtime:  runtime,
+		config:   config,
+		kv:       newKVStore(),
+		cache:    newModuleCache(config.CacheSize),
+		compiled: compiled,
+	}, nil
 }
 
-// Execute runs a WASM module with the given hypothesis and task
+// SetTools sets the tools host functions use; without them http_get_json
+// always fails. It must be called before the first Execute.
+func (i *Interpreter) SetTools(tools Tools) {
+	i.tools = tools
+}
+
+// Execute runs a WASM module with the given hypothesis and task. CPU is
+// limited by fuel, at Config.FuelPerMilli instructions per millisecond of
+// the budget, and wall-clock time by the task's timeout. Result.Metrics
+// reports fuel_used and peak_memory_bytes.
 func (i *Interpreter) Execute(ctx context.Context, h core.Hypothesis, task core.Task) (core.Result, error) {
+	start := time.Now()
+	lim := i.config.limitsFor(task.Budget)
+
 	// Create context with timeout from task budget
-	timeout := time.Duration(task.Budget.CPUMillis) * time.Millisecond
+	timeout := task.Budget.Timeout
 	if timeout == 0 {
-		timeout = 30 * time.Second // Default timeout
+		timeout = defaultTimeout
 	}
 
 	execCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// Get or compile the module
-	module, err := i.getOrCompileModule(execCtx, h)
+	module, releaseModule, err := i.getOrCompileModule(execCtx, h)
 	if err != nil {
 		return core.Result{}, fmt.Errorf("failed to compile module: %w", err)
 	}
+	// Eviction must not close the module while this execution uses it
+	defer releaseModule(context.Background())
+	granted, err := grantedCapabilities(module, h)
+	if err != nil {
+		return core.Result{}, err
+	}
+	host := newHostCall(task.ID, granted, i.tools, i.kv)
+	execCtx = context.WithValue(execCtx, hostCallKey{}, host)
 
-	// Create module instance
-	instance, err := i.runtime.InstantiateModule(execCtx, module, wazero.NewModuleConfig().
-		WithName(h.ID))
+	for _, def := range module.ExportedMemories() {
+		if need := uint64(def.Min()) * pageSize; need > lim.memoryBytes {
+			return core.Result{}, fmt.Errorf("not enough memory: module needs %d bytes, budget allows %d", need, lim.memoryBytes)
+		}
+	}
+
+	// Create module instance. Instances are anonymous so that the same
+	// hypothesis can run concurrently.
+	mem := &limitedMemory{limit: lim.memoryBytes}
+	instance, err := i.runtime.InstantiateModule(experimental.WithMemoryAllocator(execCtx, mem), module,
+		wazero.NewModuleConfig().WithName(""))
 	if err != nil {
 		return core.Result{}, fmt.Errorf("failed to instantiate module: %w", err)
 	}
 	defer instance.Close(execCtx)
 
+	fuel, ok := instance.ExportedGlobal(fuelExport).(api.MutableGlobal)
+	if !ok {
+		return core.Result{}, fmt.Errorf("module is not metered")
+	}
+	fuel.Set(uint64(lim.fuel))
+	// failed explains an error, which fuel running out may have caused
+	failed := func(format string, err error) error {
+		if int64(fuel.Get()) < 0 {
+			return errOutOfFuel(lim.fuel)
+		}
+		return fmt.Errorf(format, err)
+	}
+
 	// Prepare input data
 	inputData := map[string]any{
 		"input": json.RawMessage(task.Input),
@@ -77,30 +164,31 @@ func (i *Interpreter) Execute(ctx context.Context, h core.Hypothesis, task core.
 		return core.Result{}, fmt.Errorf("module does not export 'solve' function")
 	}
 
-	// Allocate memory for input
-	inputPtr, inputSize, err := i.allocateString(instance, execCtx, string(inputJSON))
+	// Place the input where the module expects it
+	inputPtr, release, err := writeInput(execCtx, instance, inputJSON)
 	if err != nil {
-		return core.Result{}, fmt.Errorf("failed to allocate input: %w", err)
+		return core.Result{}, failed("failed to allocate input: %w", err)
 	}
 
 	// Call solve function
-	results, err := solveFunc.Call(execCtx, uint64(inputPtr), uint64(inputSize))
+	results, err := solveFunc.Call(execCtx, uint64(inputPtr), uint64(len(inputJSON)))
 	if err != nil {
-		return core.Result{}, fmt.Errorf("failed to call solve function: %w", err)
+		return core.Result{}, failed("failed to call solve function: %w", err)
 	}
 
-	if len(results) != 2 {
-		return core.Result{}, fmt.Errorf("solve function should return (ptr, size), got %d results", len(results))
+	outputPtr, outputSize, err := outputLocation(results)
+	if err != nil {
+		return core.Result{}, err
 	}
 
-	outputPtr := uint32(results[0])
-	outputSize := uint32(results[1])
-
 	// Read output from memory
 	outputBytes, err := i.readString(instance, execCtx, outputPtr, outputSize)
 	if err != nil {
 		return core.Result{}, fmt.Errorf("failed to read output: %w", err)
 	}
+	if err := release(); err != nil {
+		return core.Result{}, failed("failed to release input: %w", err)
+	}
 
 	// Parse output as JSON
 	var output map[string]any
@@ -110,64 +198,49 @@ func (i *Interpreter) Execute(ctx context.Context, h core.Hypothesis, task core.
 
 	// Create result
 	outputJSON, _ := json.Marshal(output)
+	metrics := map[string]float64{
+		"execution_time_ms": float64(time.Since(start).Milliseconds()),
+		"output_size":       float64(len(outputBytes)),
+		"fuel_used":         float64(lim.fuel - int64(fuel.Get())),
+		"peak_memory_bytes": float64(mem.peak),
+	}
+	for k, v := range host.metrics {
+		metrics[k] = v
+	}
 	return core.Result{
 		Success: true,
 		Score:   1.0,
 		Output:  outputJSON,
-		Logs:    fmt.Sprintf("WASM module %s executed successfully", h.ID),
-		Metrics: map[string]float64{
-			"execution_time_ms": float64(time.Since(time.Now()).Milliseconds()),
-			"output_size":       float64(len(outputBytes)),
-		},
+		Logs:    strings.Join(append([]string{fmt.Sprintf("WASM module %s executed successfully", h.ID)}, host.audit...), "\n"),
+		Metrics: metrics,
 	}, nil
 }
 
-// getOrCompileModule returns a compiled module, using cache if available
-func (i *Interpreter) getOrCompileModule(ctx context.Context, h core.Hypothesis) (wazero.CompiledModule, error) {
+// getOrCompileModule returns a compiled module, using cache if available,
+// and the function that releases it; the module stays open until then.
+// Modules are cached by the hash of their bytes, not the hypothesis ID. It is
+// safe for concurrent use; when two callers compile the same module at once,
+// the first to finish wins and the other's copy is closed.
+func (i *Interpreter) getOrCompileModule(ctx context.Context, h core.Hypothesis) (wazero.CompiledModule, func(context.Context), error) {
 	// Check cache first
-	if module, exists := i.cache[h.ID]; exists {
-		return module, nil
+	key := moduleKey(h.Bytes)
+	if module, release, ok := i.cache.get(key); ok {
+		return module, release, nil
 	}
 
 	// Compile the module
-	module, err := i.runtime.CompileModule(ctx, h.Bytes)
+	metered, err := meter(h.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to compile WASM module: %w", err)
-	}
-
-	// Cache the compiled module
-	i.cache[h.ID] = module
-	return module, nil
-}
-
-// allocateString allocates memory and writes a string to it
-func (i *Interpreter) allocateString(instance api.Module, ctx context.Context, str string) (uint32, uint32, error) {
-	// Get memory
-	mem := instance.Memory()
-	if mem == nil {
-		return 0, 0, fmt.Errorf("module has no memory")
-	}
-
-	// Allocate memory (simple linear allocator)
-	// In a real implementation, you'd want a proper allocator
-	strBytes := []byte(str)
-	strLen := uint32(len(strBytes))
-
-	// For simplicity, write at offset 0
-	// In a real implementation, you'd want proper memory management
-	offset := uint32(0)
-
-	// Check if we have enough space
-	if uint64(offset)+uint64(strLen) > uint64(mem.Size()) {
-		return 0, 0, fmt.Errorf("not enough memory: need %d bytes, have %d", strLen, mem.Size())
+		return nil, nil, fmt.Errorf("failed to meter WASM module: %w", err)
 	}
-
-	// Write string to memory
-	if !mem.Write(offset, strBytes) {
-		return 0, 0, fmt.Errorf("failed to write to memory")
+	module, err := i.runtime.CompileModule(ctx, metered)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compile WASM module: %w", err)
 	}
 
-	return offset, strLen, nil
+	// Cache the compiled module
+	module, release := i.cache.add(ctx, key, module)
+	return module, release, nil
 }
 
 // readString reads a string from memory
@@ -183,10 +256,23 @@ func (i *Interpreter) readString(instance api.Module, ctx context.Context, ptr,
 		return nil, fmt.Errorf("failed to read from memory")
 	}
 
-	return data, nil
+	// Read returns a view of memory, which the module may still change
+	return bytes.Clone(data), nil
+}
+
+// ReleaseState implements core.StateReleaser, dropping the key-value pairs
+// of a task and its sub-tasks
+func (i *Interpreter) ReleaseState(taskID string) {
+	i.kv.release(taskID)
 }
 
 // Close closes the interpreter and cleans up resources
 func (i *Interpreter) Close(ctx context.Context) error {
-	return i.runtime.Close(ctx)
+	err := i.runtime.Close(ctx)
+	if i.compiled != nil {
+		if cerr := i.compiled.Close(ctx); err == nil {
+			err = cerr
+		}
+	}
+	return err
 }
@@ -3,12 +3,18 @@ package wasm
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/snow-ghost/agent/core"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
 )
 
 func TestInterpreter_Execute(t *testing.T) {
@@ -182,6 +188,131 @@ func TestInterpreter_Cache(t *testing.T) {
 	assert.Equal(t, result1.Success, result2.Success)
 }
 
+func TestInterpreter_ConcurrentExecute(t *testing.T) {
+	interpreter := NewInterpreter()
+	defer interpreter.Close(context.Background())
+
+	ctx := context.Background()
+	task := core.Task{
+		ID:     "task1",
+		Domain: "test",
+		Input:  json.RawMessage(`{"test": "data"}`),
+		Budget: core.Budget{CPUMillis: 5000},
+	}
+
+	// Several goroutines share each hypothesis, so compiles and instances
+	// of the same module overlap
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			h := core.Hypothesis{ID: fmt.Sprintf("concurrent-%d", i%4), Lang: "wasm", Bytes: GetTestModule()}
+			res, err := interpreter.Execute(ctx, h, task)
+			if err == nil && !res.Success {
+				err = fmt.Errorf("%s did not succeed", h.ID)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	// The hypotheses share their bytes, so they share a compiled module
+	assert.Equal(t, 1, interpreter.cache.len())
+}
+
+func TestInterpreter_CacheKeyedByContent(t *testing.T) {
+	interpreter, err := NewInterpreterWithConfig(Config{CacheSize: 2})
+	require.NoError(t, err)
+	defer interpreter.Close(context.Background())
+
+	ctx := context.Background()
+	task := core.Task{ID: "task1", Input: json.RawMessage(`{}`)}
+	answer := func(n int) []byte {
+		return assemble(t, fmt.Sprintf(`(module
+		  (memory (export "memory") 1)
+		  (data (i32.const 0) "{\"n\":%d}")
+		  (func (export "solve") (param i32 i32) (result i32 i32) (i32.const 0) (i32.const 7)))`, n))
+	}
+
+	// Hypotheses with the same ID but different code each run their own
+	for n := 1; n <= 3; n++ {
+		res, err := interpreter.Execute(ctx, core.Hypothesis{ID: "llm-0", Lang: "wasm", Bytes: answer(n)}, task)
+		require.NoError(t, err)
+		assert.JSONEq(t, fmt.Sprintf(`{"n":%d}`, n), string(res.Output))
+	}
+
+	// Only the two most recently used are kept
+	assert.Equal(t, 2, interpreter.cache.len())
+	_, _, ok := interpreter.cache.get(moduleKey(answer(1)))
+	assert.False(t, ok)
+	_, release, ok := interpreter.cache.get(moduleKey(answer(3)))
+	require.True(t, ok)
+	release(ctx)
+}
+
+func TestInterpreter_EvictionWhileInUse(t *testing.T) {
+	interpreter, err := NewInterpreterWithConfig(Config{CacheSize: 1})
+	require.NoError(t, err)
+	defer interpreter.Close(context.Background())
+
+	ctx := context.Background()
+	held, release, err := interpreter.getOrCompileModule(ctx, core.Hypothesis{Bytes: GetTestModule()})
+	require.NoError(t, err)
+
+	// Compiling another module evicts the held one, which stays usable
+	other := assemble(t, `(module (memory (export "memory") 1) (func (export "solve") (param i32 i32) (result i32 i32) (i32.const 0) (i32.const 0)))`)
+	_, releaseOther, err := interpreter.getOrCompileModule(ctx, core.Hypothesis{Bytes: other})
+	require.NoError(t, err)
+	releaseOther(ctx)
+	_, _, ok := interpreter.cache.get(moduleKey(GetTestModule()))
+	require.False(t, ok)
+
+	instance, err := interpreter.runtime.InstantiateModule(ctx, held, wazero.NewModuleConfig().WithName(""))
+	require.NoError(t, err)
+	require.NoError(t, instance.Close(ctx))
+	release(ctx)
+	release(ctx) // releasing twice is harmless
+
+	// Once released, the evicted module is closed
+	_, err = interpreter.runtime.InstantiateModule(ctx, held, wazero.NewModuleConfig().WithName(""))
+	assert.Error(t, err)
+}
+
+func TestInterpreter_CompilationCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	h := core.Hypothesis{ID: "test-wasm", Lang: "wasm", Bytes: GetTestModule()}
+	task := core.Task{ID: "task1", Input: json.RawMessage(`{"test": "data"}`)}
+
+	// A second interpreter, as after a restart, reads what the first wrote
+	for run := 0; run < 2; run++ {
+		interpreter, err := NewInterpreterWithConfig(Config{CacheDir: dir})
+		require.NoError(t, err)
+		res, err := interpreter.Execute(ctx, h, task)
+		require.NoError(t, err)
+		assert.True(t, res.Success)
+		require.NoError(t, interpreter.Close(ctx))
+	}
+
+	if platformCompiles() {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.NotEmpty(t, entries)
+	}
+
+	// An unusable directory is an error, not a panic
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	_, err := NewInterpreterWithConfig(Config{CacheDir: file})
+	assert.ErrorContains(t, err, "failed to open WASM compilation cache")
+}
+
 func TestInterpreter_MemoryLimits(t *testing.T) {
 	interpreter := NewInterpreter()
 	defer interpreter.Close(context.Background())
@@ -231,3 +362,10 @@ func TestInterpreter_MemoryLimits(t *testing.T) {
 		assert.True(t, result.Success)
 	}
 }
+
+// platformCompiles reports whether wazero uses its compiler here; only then
+// does it write a compilation cache
+func platformCompiles() bool {
+	return (runtime.GOARCH == "amd64" || runtime.GOARCH == "arm64") &&
+		(runtime.GOOS == "linux" || runtime.GOOS == "darwin" || runtime.GOOS == "windows")
+}
@@ -0,0 +1,539 @@
+// Package wasmbin decodes WebAssembly binaries far enough to rewrite
+// function bodies and re-encodes them. Sections other than code are kept as
+// raw bytes.
+package wasmbin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Section IDs
+const (
+	SectionCustom    = 0
+	SectionType      = 1
+	SectionImport    = 2
+	SectionFunction  = 3
+	SectionGlobal    = 6
+	SectionExport    = 7
+	SectionCode      = 10
+	SectionDataCount = 12
+)
+
+// Opcodes
+const (
+	OpUnreachable = 0x00
+	OpBlock       = 0x02
+	OpLoop        = 0x03
+	OpIf          = 0x04
+	OpElse        = 0x05
+	OpEnd         = 0x0b
+	OpBr          = 0x0c
+	OpBrIf        = 0x0d
+	OpBrTable     = 0x0e
+	OpReturn      = 0x0f
+	OpDrop        = 0x1a
+	OpI32Eqz      = 0x45
+	OpI64LtS      = 0x53
+	OpI64Sub      = 0x7d
+
+	OpLocalGet  = 0x20
+	OpLocalSet  = 0x21
+	OpLocalTee  = 0x22
+	OpGlobalGet = 0x23
+	OpGlobalSet = 0x24
+
+	OpI32Const = 0x41
+	OpI64Const = 0x42
+	OpF32Const = 0x43
+	OpF64Const = 0x44
+
+	OpPrefixFC = 0xfc
+
+	BlockTypeEmpty = 0x40
+)
+
+// Value types and external kinds
+const (
+	TypeI64      = 0x7e
+	ExternGlobal = 0x03
+)
+
+var wasmMagic = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+var errTruncated = errors.New("unexpected end of module")
+
+// Section is a raw section of a module
+type Section struct {
+	ID   byte
+	Data []byte
+}
+
+// Instr is a decoded instruction with its immediates kept as raw bytes
+type Instr struct {
+	Op  byte
+	Sub uint32 // sub-opcode of 0xFC-prefixed instructions
+	Imm []byte
+}
+
+// Func is a decoded function from the code section
+type Func struct {
+	Locals     []byte // raw local declarations
+	LocalTypes []byte // types of params and locals by index
+	Code       []Instr
+}
+
+// Module is a module decoded far enough to edit function bodies
+type Module struct {
+	Sections []Section
+	Funcs    []*Func
+
+	// ImportedFuncs and ImportedGlobals count the imports that come first
+	// in their index spaces
+	ImportedFuncs   int
+	ImportedGlobals int
+
+	code int // index of the code section
+}
+
+// Decode splits a module into sections and decodes its function bodies
+func Decode(bin []byte) (*Module, error) {
+	if !bytes.HasPrefix(bin, wasmMagic) {
+		return nil, fmt.Errorf("not a WASM module")
+	}
+
+	m := &Module{code: -1}
+	r := &reader{b: bin, pos: len(wasmMagic)}
+	for !r.done() {
+		id := r.byte()
+		size := r.u32()
+		data := r.bytes(int(size))
+		if r.err != nil {
+			return nil, r.err
+		}
+		if id == SectionCode {
+			m.code = len(m.Sections)
+		}
+		m.Sections = append(m.Sections, Section{ID: id, Data: data})
+	}
+	if m.code < 0 {
+		return nil, fmt.Errorf("module has no code section")
+	}
+
+	var (
+		typeParams [][]byte
+		funcTypes  []uint32
+	)
+	for _, s := range m.Sections {
+		var err error
+		switch s.ID {
+		case SectionType:
+			typeParams, err = decodeTypes(s.Data)
+		case SectionImport:
+			m.ImportedFuncs, m.ImportedGlobals, err = countImports(s.Data)
+		case SectionFunction:
+			funcTypes, err = decodeFuncTypes(s.Data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r = &reader{b: m.Sections[m.code].Data}
+	count := r.u32()
+	if r.err == nil && int(count) != len(funcTypes) {
+		return nil, fmt.Errorf("code section has %d bodies for %d functions", count, len(funcTypes))
+	}
+	for i := 0; i < int(count) && r.err == nil; i++ {
+		size := r.u32()
+		body := r.bytes(int(size))
+		if r.err != nil {
+			break
+		}
+		typeIdx := funcTypes[i]
+		if int(typeIdx) >= len(typeParams) {
+			return nil, fmt.Errorf("function %d has unknown type %d", m.ImportedFuncs+i, typeIdx)
+		}
+		fn, err := decodeBody(body, typeParams[typeIdx])
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %w", m.ImportedFuncs+i, err)
+		}
+		m.Funcs = append(m.Funcs, fn)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return m, nil
+}
+
+// Encode rebuilds the module with the given function bodies in place of the
+// original code section
+func (m *Module) Encode(funcs []*Func) []byte {
+	code := AppendU32(nil, uint32(len(funcs)))
+	for _, fn := range funcs {
+		body := append([]byte(nil), fn.Locals...)
+		for _, in := range fn.Code {
+			body = in.Append(body)
+		}
+		code = AppendU32(code, uint32(len(body)))
+		code = append(code, body...)
+	}
+
+	out := append([]byte(nil), wasmMagic...)
+	for i, s := range m.Sections {
+		data := s.Data
+		if i == m.code {
+			data = code
+		}
+		out = append(out, s.ID)
+		out = AppendU32(out, uint32(len(data)))
+		out = append(out, data...)
+	}
+	return out
+}
+
+// Section returns the data of the first section with the given ID, or nil
+func (m *Module) Section(id byte) []byte {
+	for _, s := range m.Sections {
+		if s.ID == id {
+			return s.Data
+		}
+	}
+	return nil
+}
+
+// SetSection replaces the data of a section, adding the section in its
+// place in the module if there is none. The code section cannot be set.
+func (m *Module) SetSection(id byte, data []byte) {
+	for i, s := range m.Sections {
+		if s.ID == id {
+			m.Sections[i].Data = data
+			return
+		}
+	}
+
+	at := len(m.Sections)
+	for i, s := range m.Sections {
+		if s.ID != SectionCustom && sectionOrder(s.ID) > sectionOrder(id) {
+			at = i
+			break
+		}
+	}
+	m.Sections = append(m.Sections[:at], append([]Section{{ID: id, Data: data}}, m.Sections[at:]...)...)
+	if at <= m.code {
+		m.code++
+	}
+}
+
+// sectionOrder ranks sections in the order they must appear; the data count
+// section comes between element and code
+func sectionOrder(id byte) float64 {
+	if id == SectionDataCount {
+		return 9.5
+	}
+	return float64(id)
+}
+
+// Append appends the instruction's encoding to b
+func (in Instr) Append(b []byte) []byte {
+	b = append(b, in.Op)
+	if in.Op == OpPrefixFC {
+		b = AppendU32(b, in.Sub)
+	}
+	return append(b, in.Imm...)
+}
+
+// AppendToVector appends an encoded entry to the vector a section holds,
+// which may be empty
+func AppendToVector(vec []byte, entry []byte) ([]byte, error) {
+	r := &reader{b: vec}
+	count := uint32(0)
+	if len(vec) > 0 {
+		count = r.u32()
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	out := AppendU32(nil, count+1)
+	out = append(out, vec[r.pos:]...)
+	return append(out, entry...), nil
+}
+
+// VectorLen returns the number of entries in a section's vector
+func VectorLen(vec []byte) (int, error) {
+	if len(vec) == 0 {
+		return 0, nil
+	}
+	r := &reader{b: vec}
+	n := r.u32()
+	return int(n), r.err
+}
+
+// ReadU32 decodes an unsigned LEB128 immediate
+func ReadU32(imm []byte) uint32 {
+	return (&reader{b: imm}).u32()
+}
+
+// ReadS64 decodes a signed LEB128 immediate
+func ReadS64(imm []byte) int64 {
+	return (&reader{b: imm}).s64()
+}
+
+func decodeTypes(data []byte) ([][]byte, error) {
+	r := &reader{b: data}
+	count := r.u32()
+	params := make([][]byte, 0, count)
+	for i := 0; i < int(count) && r.err == nil; i++ {
+		if form := r.byte(); form != 0x60 && r.err == nil {
+			return nil, fmt.Errorf("unsupported type form 0x%02x", form)
+		}
+		params = append(params, r.bytes(int(r.u32())))
+		r.bytes(int(r.u32())) // results
+	}
+	return params, r.err
+}
+
+func decodeFuncTypes(data []byte) ([]uint32, error) {
+	r := &reader{b: data}
+	count := r.u32()
+	types := make([]uint32, 0, count)
+	for i := 0; i < int(count) && r.err == nil; i++ {
+		types = append(types, r.u32())
+	}
+	return types, r.err
+}
+
+// countImports counts the imported functions and globals
+func countImports(data []byte) (funcs, globals int, err error) {
+	r := &reader{b: data}
+	count := r.u32()
+	for i := 0; i < int(count) && r.err == nil; i++ {
+		r.bytes(int(r.u32())) // module
+		r.bytes(int(r.u32())) // name
+		switch kind := r.byte(); kind {
+		case 0x00: // func
+			r.u32()
+			funcs++
+		case 0x01: // table
+			r.byte()
+			r.limits()
+		case 0x02: // memory
+			r.limits()
+		case 0x03: // global
+			r.byte()
+			r.byte()
+			globals++
+		default:
+			if r.err == nil {
+				return 0, 0, fmt.Errorf("unsupported import kind 0x%02x", kind)
+			}
+		}
+	}
+	return funcs, globals, r.err
+}
+
+// decodeBody decodes a function body; params are the types of its params
+func decodeBody(body []byte, params []byte) (*Func, error) {
+	r := &reader{b: body}
+	fn := &Func{LocalTypes: append([]byte(nil), params...)}
+
+	groups := r.u32()
+	for i := 0; i < int(groups) && r.err == nil; i++ {
+		n := r.u32()
+		t := r.byte()
+		if len(fn.LocalTypes)+int(n) > 50000 {
+			return nil, fmt.Errorf("too many locals")
+		}
+		fn.LocalTypes = append(fn.LocalTypes, bytes.Repeat([]byte{t}, int(n))...)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	fn.Locals = body[:r.pos]
+
+	for !r.done() {
+		in, err := decodeInstr(r)
+		if err != nil {
+			return nil, err
+		}
+		fn.Code = append(fn.Code, in)
+	}
+	if len(fn.Code) == 0 || fn.Code[len(fn.Code)-1].Op != OpEnd {
+		return nil, fmt.Errorf("function body does not end with end")
+	}
+	return fn, nil
+}
+
+// decodeInstr reads one instruction and the raw bytes of its immediates
+func decodeInstr(r *reader) (Instr, error) {
+	in := Instr{Op: r.byte()}
+	if in.Op == OpPrefixFC {
+		in.Sub = r.u32()
+	}
+	start := r.pos
+
+	switch op := in.Op; {
+	case op == OpBlock || op == OpLoop || op == OpIf:
+		if t := r.byte(); t != BlockTypeEmpty && (t < 0x6f || t > 0x7f) {
+			r.pos--
+			r.s64() // type index
+		}
+	case op == OpBr || op == OpBrIf || op == 0x10 || op == 0xd2 ||
+		(op >= 0x20 && op <= 0x26):
+		r.u32()
+	case op == 0x0e: // br_table
+		n := r.u32()
+		for i := 0; i <= int(n) && r.err == nil; i++ {
+			r.u32()
+		}
+	case op == 0x11: // call_indirect
+		r.u32()
+		r.u32()
+	case op == 0x1c: // select t*
+		r.bytes(int(r.u32()))
+	case op >= 0x28 && op <= 0x3e: // memarg
+		r.u32()
+		r.u32()
+	case op == 0x3f || op == 0x40 || op == 0xd0:
+		r.byte()
+	case op == OpI32Const || op == OpI64Const:
+		r.s64()
+	case op == OpF32Const:
+		r.bytes(4)
+	case op == OpF64Const:
+		r.bytes(8)
+	case op <= 0x01 || op == OpElse || op == OpEnd || op == 0x0f || op == OpDrop || op == 0x1b ||
+		(op >= 0x45 && op <= 0xc4) || op == 0xd1:
+		// no immediates
+	case op == OpPrefixFC:
+		switch {
+		case in.Sub <= 7:
+		case in.Sub == 8: // memory.init
+			r.u32()
+			r.byte()
+		case in.Sub == 10: // memory.copy
+			r.byte()
+			r.byte()
+		case in.Sub == 11: // memory.fill
+			r.byte()
+		case in.Sub == 12 || in.Sub == 14: // table.init, table.copy
+			r.u32()
+			r.u32()
+		case in.Sub == 9 || in.Sub == 13 || (in.Sub >= 15 && in.Sub <= 17):
+			r.u32()
+		default:
+			return in, fmt.Errorf("unsupported instruction 0xfc %d", in.Sub)
+		}
+	default:
+		return in, fmt.Errorf("unsupported instruction 0x%02x", in.Op)
+	}
+
+	if r.err != nil {
+		return in, r.err
+	}
+	in.Imm = r.b[start:r.pos]
+	return in, nil
+}
+
+// reader decodes the binary format; the first error sticks
+type reader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func (r *reader) done() bool {
+	return r.err != nil || r.pos >= len(r.b)
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos >= len(r.b) {
+		r.err = errTruncated
+		return 0
+	}
+	c := r.b[r.pos]
+	r.pos++
+	return c
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.b) {
+		r.err = errTruncated
+		return nil
+	}
+	b := r.b[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *reader) u32() uint32 {
+	var v uint32
+	for shift := 0; shift < 35; shift += 7 {
+		c := r.byte()
+		v |= uint32(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v
+		}
+	}
+	if r.err == nil {
+		r.err = fmt.Errorf("integer too large")
+	}
+	return 0
+}
+
+func (r *reader) s64() int64 {
+	var v int64
+	for shift := 0; shift < 70; shift += 7 {
+		c := r.byte()
+		v |= int64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			if shift+7 < 64 && c&0x40 != 0 {
+				v |= -1 << (shift + 7)
+			}
+			return v
+		}
+	}
+	if r.err == nil {
+		r.err = fmt.Errorf("integer too large")
+	}
+	return 0
+}
+
+func (r *reader) limits() {
+	flags := r.byte()
+	r.u32()
+	if flags&0x01 != 0 {
+		r.u32()
+	}
+}
+
+func AppendU32(b []byte, v uint32) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b = append(b, c|0x80)
+			continue
+		}
+		return append(b, c)
+	}
+}
+
+func AppendS64(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
@@ -0,0 +1,68 @@
+package wasmbin_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/snow-ghost/agent/interp/wasm"
+	"github.com/snow-ghost/agent/interp/wasmbin"
+	"github.com/snow-ghost/agent/interp/wat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const importModule = `(module
+  (import "wasi_snapshot_preview1" "proc_exit" (func $exit (param i32)))
+  (memory (export "memory") 1)
+  (global $n (mut i32) (i32.const 0))
+  (func (export "solve") (param $ptr i32) (param $len i32) (result i32 i32)
+    (global.set $n (i32.add (global.get $n) (i32.const 1)))
+    (if (i32.eqz (local.get $len)) (then (call $exit (i32.const 1))))
+    (local.get $ptr) (local.get $len)))`
+
+func TestDecodeRoundTrip(t *testing.T) {
+	bin, err := wat.Assemble(importModule)
+	require.NoError(t, err)
+
+	for _, module := range [][]byte{wasm.GetTestModule(), bin} {
+		mod, err := wasmbin.Decode(module)
+		require.NoError(t, err)
+		assert.Equal(t, module, mod.Encode(mod.Funcs))
+	}
+
+	mod, err := wasmbin.Decode(bin)
+	require.NoError(t, err)
+	assert.Equal(t, 1, mod.ImportedFuncs)
+	assert.Equal(t, 0, mod.ImportedGlobals)
+	require.Len(t, mod.Funcs, 1)
+	assert.Equal(t, []byte{0x7f, 0x7f}, mod.Funcs[0].LocalTypes)
+
+	_, err = wasmbin.Decode([]byte{1, 2, 3})
+	assert.Error(t, err)
+}
+
+func TestSetSection(t *testing.T) {
+	// The test module has no global section; one is added before exports
+	mod, err := wasmbin.Decode(wasm.GetTestModule())
+	require.NoError(t, err)
+	require.Nil(t, mod.Section(wasmbin.SectionGlobal))
+
+	global := []byte{wasmbin.TypeI64, 0x01, wasmbin.OpI64Const, 0x00, wasmbin.OpEnd}
+	globals, err := wasmbin.AppendToVector(nil, global)
+	require.NoError(t, err)
+	mod.SetSection(wasmbin.SectionGlobal, globals)
+
+	exports, err := wasmbin.AppendToVector(mod.Section(wasmbin.SectionExport),
+		append([]byte{4, 'f', 'u', 'e', 'l'}, wasmbin.ExternGlobal, 0))
+	require.NoError(t, err)
+	mod.SetSection(wasmbin.SectionExport, exports)
+	n, err := wasmbin.VectorLen(mod.Section(wasmbin.SectionExport))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	bin := mod.Encode(mod.Funcs)
+	require.NoError(t, wasm.Validate(context.Background(), bin))
+	again, err := wasmbin.Decode(bin)
+	require.NoError(t, err)
+	assert.Equal(t, bin, again.Encode(again.Funcs))
+}
@@ -0,0 +1,97 @@
+package wat
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Section IDs of the binary format
+const (
+	sectionType   = 1
+	sectionImport = 2
+	sectionFunc   = 3
+	sectionMemory = 5
+	sectionGlobal = 6
+	sectionExport = 7
+	sectionStart  = 8
+	sectionCode   = 10
+	sectionData   = 11
+)
+
+// External kinds used by imports and exports
+const (
+	externFunc   = 0x00
+	externMemory = 0x02
+	externGlobal = 0x03
+)
+
+// Value types
+var valueTypeCodes = map[string]byte{
+	"i32": 0x7f,
+	"i64": 0x7e,
+	"f32": 0x7d,
+	"f64": 0x7c,
+}
+
+// blockTypeEmpty marks a block without results
+const blockTypeEmpty = 0x40
+
+func appendU32(b []byte, v uint32) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b = append(b, c|0x80)
+			continue
+		}
+		return append(b, c)
+	}
+}
+
+func appendS64(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendF32(b []byte, v float32) []byte {
+	return binary.LittleEndian.AppendUint32(b, math.Float32bits(v))
+}
+
+func appendF64(b []byte, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+func appendName(b []byte, name string) []byte {
+	b = appendU32(b, uint32(len(name)))
+	return append(b, name...)
+}
+
+// appendSection appends a section with its size prefix, skipping empty ones
+func appendSection(b []byte, id byte, count int, content []byte) []byte {
+	if count == 0 {
+		return b
+	}
+	body := appendU32(nil, uint32(count))
+	body = append(body, content...)
+
+	b = append(b, id)
+	b = appendU32(b, uint32(len(body)))
+	return append(b, body...)
+}
+
+// appendLimits encodes memory limits
+func appendLimits(b []byte, min uint32, max *uint32) []byte {
+	if max == nil {
+		b = append(b, 0x00)
+		return appendU32(b, min)
+	}
+	b = append(b, 0x01)
+	b = appendU32(b, min)
+	return appendU32(b, *max)
+}
@@ -0,0 +1,551 @@
+package wat
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// immediate describes the operands encoded after an opcode
+type immediate int
+
+const (
+	immNone immediate = iota
+	immLocal
+	immGlobal
+	immFunc
+	immLabel
+	immLabelTable
+	immMemArg
+	immMemIdx
+	immMemCopy
+	immI32
+	immI64
+	immF32
+	immF64
+)
+
+// instruction is an opcode with its immediates; prefixed opcodes carry the
+// 0xFC prefix and a sub-opcode
+type instruction struct {
+	opcode    byte
+	prefixed  bool
+	subOpcode uint32
+	imm       immediate
+	align     uint32 // natural alignment of memory accesses, as log2 bytes
+}
+
+// numericOps lists the operand-free numeric instructions from opcode 0x45
+// through 0xC4 in order
+var numericOps = []string{
+	"i32.eqz", "i32.eq", "i32.ne", "i32.lt_s", "i32.lt_u", "i32.gt_s", "i32.gt_u", "i32.le_s", "i32.le_u", "i32.ge_s", "i32.ge_u",
+	"i64.eqz", "i64.eq", "i64.ne", "i64.lt_s", "i64.lt_u", "i64.gt_s", "i64.gt_u", "i64.le_s", "i64.le_u", "i64.ge_s", "i64.ge_u",
+	"f32.eq", "f32.ne", "f32.lt", "f32.gt", "f32.le", "f32.ge",
+	"f64.eq", "f64.ne", "f64.lt", "f64.gt", "f64.le", "f64.ge",
+	"i32.clz", "i32.ctz", "i32.popcnt", "i32.add", "i32.sub", "i32.mul", "i32.div_s", "i32.div_u", "i32.rem_s", "i32.rem_u",
+	"i32.and", "i32.or", "i32.xor", "i32.shl", "i32.shr_s", "i32.shr_u", "i32.rotl", "i32.rotr",
+	"i64.clz", "i64.ctz", "i64.popcnt", "i64.add", "i64.sub", "i64.mul", "i64.div_s", "i64.div_u", "i64.rem_s", "i64.rem_u",
+	"i64.and", "i64.or", "i64.xor", "i64.shl", "i64.shr_s", "i64.shr_u", "i64.rotl", "i64.rotr",
+	"f32.abs", "f32.neg", "f32.ceil", "f32.floor", "f32.trunc", "f32.nearest", "f32.sqrt",
+	"f32.add", "f32.sub", "f32.mul", "f32.div", "f32.min", "f32.max", "f32.copysign",
+	"f64.abs", "f64.neg", "f64.ceil", "f64.floor", "f64.trunc", "f64.nearest", "f64.sqrt",
+	"f64.add", "f64.sub", "f64.mul", "f64.div", "f64.min", "f64.max", "f64.copysign",
+	"i32.wrap_i64", "i32.trunc_f32_s", "i32.trunc_f32_u", "i32.trunc_f64_s", "i32.trunc_f64_u",
+	"i64.extend_i32_s", "i64.extend_i32_u", "i64.trunc_f32_s", "i64.trunc_f32_u", "i64.trunc_f64_s", "i64.trunc_f64_u",
+	"f32.convert_i32_s", "f32.convert_i32_u", "f32.convert_i64_s", "f32.convert_i64_u", "f32.demote_f64",
+	"f64.convert_i32_s", "f64.convert_i32_u", "f64.convert_i64_s", "f64.convert_i64_u", "f64.promote_f32",
+	"i32.reinterpret_f32", "i64.reinterpret_f64", "f32.reinterpret_i32", "f64.reinterpret_i64",
+	"i32.extend8_s", "i32.extend16_s", "i64.extend8_s", "i64.extend16_s", "i64.extend32_s",
+}
+
+// memoryOps lists loads and stores from opcode 0x28 with their natural
+// alignment
+var memoryOps = []struct {
+	name  string
+	align uint32
+}{
+	{"i32.load", 2}, {"i64.load", 3}, {"f32.load", 2}, {"f64.load", 3},
+	{"i32.load8_s", 0}, {"i32.load8_u", 0}, {"i32.load16_s", 1}, {"i32.load16_u", 1},
+	{"i64.load8_s", 0}, {"i64.load8_u", 0}, {"i64.load16_s", 1}, {"i64.load16_u", 1},
+	{"i64.load32_s", 2}, {"i64.load32_u", 2},
+	{"i32.store", 2}, {"i64.store", 3}, {"f32.store", 2}, {"f64.store", 3},
+	{"i32.store8", 0}, {"i32.store16", 1},
+	{"i64.store8", 0}, {"i64.store16", 1}, {"i64.store32", 2},
+}
+
+// saturatingOps lists the non-trapping conversions, sub-opcodes 0 through 7
+var saturatingOps = []string{
+	"i32.trunc_sat_f32_s", "i32.trunc_sat_f32_u", "i32.trunc_sat_f64_s", "i32.trunc_sat_f64_u",
+	"i64.trunc_sat_f32_s", "i64.trunc_sat_f32_u", "i64.trunc_sat_f64_s", "i64.trunc_sat_f64_u",
+}
+
+var instructions = buildInstructions()
+
+func buildInstructions() map[string]instruction {
+	table := map[string]instruction{
+		"unreachable":   {opcode: 0x00},
+		"nop":           {opcode: 0x01},
+		"br":            {opcode: 0x0c, imm: immLabel},
+		"br_if":         {opcode: 0x0d, imm: immLabel},
+		"br_table":      {opcode: 0x0e, imm: immLabelTable},
+		"return":        {opcode: 0x0f},
+		"call":          {opcode: 0x10, imm: immFunc},
+		"drop":          {opcode: 0x1a},
+		"select":        {opcode: 0x1b},
+		"local.get":     {opcode: 0x20, imm: immLocal},
+		"local.set":     {opcode: 0x21, imm: immLocal},
+		"local.tee":     {opcode: 0x22, imm: immLocal},
+		"global.get":    {opcode: 0x23, imm: immGlobal},
+		"global.set":    {opcode: 0x24, imm: immGlobal},
+		"memory.size":   {opcode: 0x3f, imm: immMemIdx},
+		"memory.grow":   {opcode: 0x40, imm: immMemIdx},
+		"i32.const":     {opcode: 0x41, imm: immI32},
+		"i64.const":     {opcode: 0x42, imm: immI64},
+		"f32.const":     {opcode: 0x43, imm: immF32},
+		"f64.const":     {opcode: 0x44, imm: immF64},
+		"memory.copy":   {opcode: 0xfc, prefixed: true, subOpcode: 10, imm: immMemCopy},
+		"memory.fill":   {opcode: 0xfc, prefixed: true, subOpcode: 11, imm: immMemIdx},
+		"call_indirect": {opcode: 0x11},
+	}
+	for i, name := range numericOps {
+		table[name] = instruction{opcode: byte(0x45 + i)}
+	}
+	for i, op := range memoryOps {
+		table[op.name] = instruction{opcode: byte(0x28 + i), imm: immMemArg, align: op.align}
+	}
+	for i, name := range saturatingOps {
+		table[name] = instruction{opcode: 0xfc, prefixed: true, subOpcode: uint32(i)}
+	}
+	return table
+}
+
+// funcCompiler assembles one function body
+type funcCompiler struct {
+	m      *module
+	locals map[string]uint32
+	labels []string // enclosing block labels, innermost last
+	code   []byte
+}
+
+// compileSeq assembles a sequence of flat and folded instructions
+func (fc *funcCompiler) compileSeq(nodes []*node) error {
+	for i := 0; i < len(nodes); {
+		n := nodes[i]
+		if n.isList {
+			if err := fc.compileFolded(n); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+		if !n.isAtom() {
+			return n.errorf("unexpected %s in function body", n.describe())
+		}
+
+		i++
+		switch n.atom {
+		case "block", "loop", "if":
+			label, blockType, next, err := fc.blockHeader(nodes, i)
+			if err != nil {
+				return err
+			}
+			i = next
+			fc.code = append(fc.code, blockOpcode(n.atom))
+			fc.code = append(fc.code, blockType...)
+			fc.labels = append(fc.labels, label)
+		case "else", "end":
+			if len(fc.labels) == 0 {
+				return n.errorf("%s without matching block", n.atom)
+			}
+			// A trailing label repeats the block's own
+			if i < len(nodes) && nodes[i].isID() {
+				i++
+			}
+			if n.atom == "else" {
+				fc.code = append(fc.code, 0x05)
+				continue
+			}
+			fc.code = append(fc.code, 0x0b)
+			fc.labels = fc.labels[:len(fc.labels)-1]
+		default:
+			next, err := fc.compilePlain(n, nodes, i)
+			if err != nil {
+				return err
+			}
+			i = next
+		}
+	}
+	return nil
+}
+
+// compileFolded assembles a folded instruction: operands first, then the
+// operator
+func (fc *funcCompiler) compileFolded(n *node) error {
+	op := n.keyword()
+	if op == "" {
+		return n.errorf("expected an instruction, got %s", n.describe())
+	}
+	args := n.list[1:]
+
+	switch op {
+	case "block", "loop":
+		label, blockType, next, err := fc.blockHeader(args, 0)
+		if err != nil {
+			return err
+		}
+		fc.code = append(fc.code, blockOpcode(op))
+		fc.code = append(fc.code, blockType...)
+		fc.labels = append(fc.labels, label)
+		if err := fc.compileSeq(args[next:]); err != nil {
+			return err
+		}
+		fc.code = append(fc.code, 0x0b)
+		fc.labels = fc.labels[:len(fc.labels)-1]
+		return nil
+
+	case "if":
+		label, blockType, next, err := fc.blockHeader(args, 0)
+		if err != nil {
+			return err
+		}
+
+		var thenBody, elseBody *node
+		for _, arg := range args[next:] {
+			switch arg.keyword() {
+			case "then":
+				thenBody = arg
+			case "else":
+				elseBody = arg
+			default:
+				if thenBody != nil {
+					return arg.errorf("unexpected %s after (then ...)", arg.describe())
+				}
+				if err := fc.compileFolded(arg); err != nil {
+					return err
+				}
+			}
+		}
+		if thenBody == nil {
+			return n.errorf("folded if requires a (then ...) clause")
+		}
+
+		fc.code = append(fc.code, 0x04)
+		fc.code = append(fc.code, blockType...)
+		fc.labels = append(fc.labels, label)
+		if err := fc.compileSeq(thenBody.list[1:]); err != nil {
+			return err
+		}
+		if elseBody != nil {
+			fc.code = append(fc.code, 0x05)
+			if err := fc.compileSeq(elseBody.list[1:]); err != nil {
+				return err
+			}
+		}
+		fc.code = append(fc.code, 0x0b)
+		fc.labels = fc.labels[:len(fc.labels)-1]
+		return nil
+	}
+
+	instr, ok := instructions[op]
+	if !ok {
+		return n.list[0].errorf("unknown instruction %q", op)
+	}
+
+	imm, next, err := fc.immediates(n.list[0], instr, args, 0)
+	if err != nil {
+		return err
+	}
+	for _, arg := range args[next:] {
+		if !arg.isList {
+			return arg.errorf("unexpected %s in folded %s", arg.describe(), op)
+		}
+		if err := fc.compileFolded(arg); err != nil {
+			return err
+		}
+	}
+
+	fc.emit(instr, imm)
+	return nil
+}
+
+// compilePlain assembles a flat instruction whose immediates start at
+// nodes[i], returning the index after them
+func (fc *funcCompiler) compilePlain(n *node, nodes []*node, i int) (int, error) {
+	instr, ok := instructions[n.atom]
+	if !ok {
+		return 0, n.errorf("unknown instruction %q", n.atom)
+	}
+
+	imm, next, err := fc.immediates(n, instr, nodes, i)
+	if err != nil {
+		return 0, err
+	}
+	fc.emit(instr, imm)
+	return next, nil
+}
+
+func (fc *funcCompiler) emit(instr instruction, imm []byte) {
+	fc.code = append(fc.code, instr.opcode)
+	if instr.prefixed {
+		fc.code = appendU32(fc.code, instr.subOpcode)
+	}
+	fc.code = append(fc.code, imm...)
+}
+
+// blockHeader parses an optional label and block type starting at nodes[i]
+func (fc *funcCompiler) blockHeader(nodes []*node, i int) (string, []byte, int, error) {
+	label := ""
+	if i < len(nodes) && nodes[i].isID() {
+		label = nodes[i].atom
+		i++
+	}
+
+	var typeRef *node
+	var params, results []byte
+	for i < len(nodes) {
+		switch nodes[i].keyword() {
+		case "type":
+			typeRef = nodes[i]
+		case "param":
+			types, err := valueTypeList(nodes[i])
+			if err != nil {
+				return "", nil, 0, err
+			}
+			params = append(params, types...)
+		case "result":
+			types, err := valueTypeList(nodes[i])
+			if err != nil {
+				return "", nil, 0, err
+			}
+			results = append(results, types...)
+		default:
+			return label, fc.blockType(typeRef, params, results), i, fc.checkTypeRef(typeRef)
+		}
+		i++
+	}
+	return label, fc.blockType(typeRef, params, results), i, fc.checkTypeRef(typeRef)
+}
+
+func (fc *funcCompiler) checkTypeRef(typeRef *node) error {
+	if typeRef == nil {
+		return nil
+	}
+	if len(typeRef.list) != 2 {
+		return typeRef.errorf("expected (type $name)")
+	}
+	_, err := fc.m.resolve(typeRef.list[1], fc.m.typeNames, len(fc.m.types), "type")
+	return err
+}
+
+// blockType encodes a block type as empty, a single result, or a type index
+func (fc *funcCompiler) blockType(typeRef *node, params, results []byte) []byte {
+	if typeRef != nil && len(typeRef.list) == 2 {
+		idx, _ := fc.m.resolve(typeRef.list[1], fc.m.typeNames, len(fc.m.types), "type")
+		return appendS64(nil, int64(idx))
+	}
+	switch {
+	case len(params) == 0 && len(results) == 0:
+		return []byte{blockTypeEmpty}
+	case len(params) == 0 && len(results) == 1:
+		return []byte{results[0]}
+	default:
+		return appendS64(nil, int64(fc.m.typeIndex(funcType{params: params, results: results})))
+	}
+}
+
+// immediates encodes the operands of instr found in nodes from index i
+func (fc *funcCompiler) immediates(op *node, instr instruction, nodes []*node, i int) ([]byte, int, error) {
+	atomAt := func(i int) *node {
+		if i < len(nodes) && nodes[i].isAtom() {
+			return nodes[i]
+		}
+		return nil
+	}
+
+	switch instr.imm {
+	case immNone:
+		if instr.opcode == 0x11 {
+			return nil, 0, op.errorf("call_indirect is not supported; use call")
+		}
+		return nil, i, nil
+
+	case immLocal, immGlobal, immFunc, immLabel:
+		arg := atomAt(i)
+		if arg == nil {
+			return nil, 0, op.errorf("%s requires an index or $name", op.atom)
+		}
+		idx, err := fc.index(instr.imm, arg)
+		if err != nil {
+			return nil, 0, err
+		}
+		return appendU32(nil, idx), i + 1, nil
+
+	case immLabelTable:
+		var labels []uint32
+		for arg := atomAt(i); arg != nil && (arg.isID() || isUnsigned(arg.atom)); arg = atomAt(i) {
+			idx, err := fc.index(immLabel, arg)
+			if err != nil {
+				return nil, 0, err
+			}
+			labels = append(labels, idx)
+			i++
+		}
+		if len(labels) == 0 {
+			return nil, 0, op.errorf("br_table requires at least a default label")
+		}
+		out := appendU32(nil, uint32(len(labels)-1))
+		for _, idx := range labels {
+			out = appendU32(out, idx)
+		}
+		return out, i, nil
+
+	case immMemArg:
+		offset, align := uint64(0), instr.align
+		for arg := atomAt(i); arg != nil; arg = atomAt(i) {
+			if value, ok := strings.CutPrefix(arg.atom, "offset="); ok {
+				v, err := parseUnsigned(value, 32)
+				if err != nil {
+					return nil, 0, arg.errorf("invalid offset %q", value)
+				}
+				offset = v
+			} else if value, ok := strings.CutPrefix(arg.atom, "align="); ok {
+				v, err := parseUnsigned(value, 32)
+				if err != nil || v == 0 || v&(v-1) != 0 {
+					return nil, 0, arg.errorf("alignment must be a power of two, got %q", value)
+				}
+				align = uint32(bits.TrailingZeros64(v))
+			} else {
+				break
+			}
+			i++
+		}
+		out := appendU32(nil, align)
+		return appendU32(out, uint32(offset)), i, nil
+
+	case immMemIdx:
+		// Only memory 0 exists; an explicit index is accepted
+		if arg := atomAt(i); arg != nil && (arg.atom == "0" || arg.isID()) {
+			i++
+		}
+		return []byte{0x00}, i, nil
+
+	case immMemCopy:
+		return []byte{0x00, 0x00}, i, nil
+	}
+
+	// Constants
+	arg := atomAt(i)
+	if arg == nil {
+		return nil, 0, op.errorf("%s requires a value", op.atom)
+	}
+	var out []byte
+	switch instr.imm {
+	case immI32:
+		v, err := parseInteger(arg.atom, 32)
+		if err != nil {
+			return nil, 0, arg.errorf("invalid i32 constant %q", arg.atom)
+		}
+		out = appendS64(nil, int64(int32(uint32(v))))
+	case immI64:
+		v, err := parseInteger(arg.atom, 64)
+		if err != nil {
+			return nil, 0, arg.errorf("invalid i64 constant %q", arg.atom)
+		}
+		out = appendS64(nil, int64(v))
+	case immF32:
+		v, err := parseFloat(arg.atom, 32)
+		if err != nil {
+			return nil, 0, arg.errorf("invalid f32 constant %q", arg.atom)
+		}
+		out = appendF32(nil, float32(v))
+	case immF64:
+		v, err := parseFloat(arg.atom, 64)
+		if err != nil {
+			return nil, 0, arg.errorf("invalid f64 constant %q", arg.atom)
+		}
+		out = appendF64(nil, v)
+	}
+	return out, i + 1, nil
+}
+
+// index resolves a local, global, function or label reference
+func (fc *funcCompiler) index(kind immediate, arg *node) (uint32, error) {
+	switch kind {
+	case immLocal:
+		return fc.m.resolve(arg, fc.locals, math.MaxUint32, "local")
+	case immGlobal:
+		return fc.m.resolve(arg, fc.m.globalNames, len(fc.m.globals), "global")
+	case immFunc:
+		return fc.m.resolve(arg, fc.m.funcNames, len(fc.m.funcs), "function")
+	}
+
+	// Labels are relative depths; names are looked up innermost first
+	if !arg.isID() {
+		v, err := parseUnsigned(arg.atom, 32)
+		if err != nil {
+			return 0, arg.errorf("invalid label %q", arg.atom)
+		}
+		return uint32(v), nil
+	}
+	for depth := 0; depth < len(fc.labels); depth++ {
+		if fc.labels[len(fc.labels)-1-depth] == arg.atom {
+			return uint32(depth), nil
+		}
+	}
+	return 0, arg.errorf("unknown label %s", arg.atom)
+}
+
+func blockOpcode(op string) byte {
+	switch op {
+	case "loop":
+		return 0x03
+	case "if":
+		return 0x04
+	default:
+		return 0x02
+	}
+}
+
+// isUnsigned reports whether s looks like an unsigned integer literal
+func isUnsigned(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
+
+// parseUnsigned parses a decimal or hex literal with optional underscores
+func parseUnsigned(s string, bitSize int) (uint64, error) {
+	return strconv.ParseUint(strings.ReplaceAll(s, "_", ""), 0, bitSize)
+}
+
+// parseInteger parses an integer constant that may be written signed or
+// unsigned, returning its two's complement bits
+func parseInteger(s string, bitSize int) (uint64, error) {
+	s = strings.ReplaceAll(s, "_", "")
+	if strings.HasPrefix(s, "-") {
+		v, err := strconv.ParseInt(s, 0, bitSize)
+		return uint64(v), err
+	}
+	return strconv.ParseUint(strings.TrimPrefix(s, "+"), 0, bitSize)
+}
+
+// parseFloat parses a float constant including inf, nan and hex floats
+func parseFloat(s string, bitSize int) (float64, error) {
+	s = strings.ReplaceAll(s, "_", "")
+	sign := 1.0
+	unsigned := s
+	if strings.HasPrefix(s, "-") {
+		sign, unsigned = -1, s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		unsigned = s[1:]
+	}
+
+	switch {
+	case unsigned == "inf":
+		return math.Inf(int(sign)), nil
+	case unsigned == "nan" || strings.HasPrefix(unsigned, "nan:"):
+		return math.Copysign(math.NaN(), sign), nil
+	case strings.HasPrefix(unsigned, "0x") && !strings.ContainsAny(unsigned, "pP"):
+		s += "p0"
+	}
+	return strconv.ParseFloat(s, bitSize)
+}
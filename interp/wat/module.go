@@ -0,0 +1,754 @@
+package wat
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// funcType is a function signature
+type funcType struct {
+	params  []byte
+	results []byte
+}
+
+func (t funcType) equal(other funcType) bool {
+	return bytes.Equal(t.params, other.params) && bytes.Equal(t.results, other.results)
+}
+
+// importRef names the module and field an import comes from
+type importRef struct {
+	module string
+	name   string
+}
+
+type function struct {
+	pos
+	typeIdx    uint32
+	imported   *importRef
+	locals     map[string]uint32 // named parameters and locals
+	numParams  int
+	localTypes []byte
+	body       []*node
+}
+
+type memory struct {
+	imported *importRef
+	min      uint32
+	max      *uint32
+}
+
+type global struct {
+	valueType byte
+	mutable   bool
+	init      []*node
+}
+
+type export struct {
+	name string
+	kind byte
+	ref  *node
+}
+
+type dataSegment struct {
+	offset []*node
+	bytes  []byte
+}
+
+// module collects the fields of a WAT module before encoding
+type module struct {
+	types     []funcType
+	typeNames map[string]uint32
+
+	funcs     []*function
+	funcNames map[string]uint32
+
+	memories    []*memory
+	memoryNames map[string]uint32
+
+	globals     []*global
+	globalNames map[string]uint32
+
+	exports []export
+	start   *node
+	data    []dataSegment
+
+	anonymous int
+}
+
+// Assemble translates a WebAssembly text module into the binary format. It
+// supports the instructions of WebAssembly 2.0 without tables, SIMD or
+// reference types. Errors are *Error values carrying the source position.
+func Assemble(src string) ([]byte, error) {
+	nodes, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nodes) == 0 {
+		return nil, pos{line: 1, col: 1}.errorf("expected (module ...)")
+	}
+	if !nodes[0].isList {
+		return nil, nodes[0].errorf("expected (module ...), got %s", nodes[0].describe())
+	}
+
+	fields := nodes
+	if len(nodes) == 1 && nodes[0].keyword() == "module" {
+		fields = nodes[0].list[1:]
+		if len(fields) > 0 && fields[0].isID() {
+			fields = fields[1:]
+		}
+	}
+
+	m := &module{
+		typeNames:   make(map[string]uint32),
+		funcNames:   make(map[string]uint32),
+		memoryNames: make(map[string]uint32),
+		globalNames: make(map[string]uint32),
+	}
+	if err := m.collect(fields); err != nil {
+		return nil, err
+	}
+	return m.encode()
+}
+
+// collect records every module field. Explicit types come first so that
+// inline signatures can reuse them, and imports precede definitions in each
+// index space.
+func (m *module) collect(fields []*node) error {
+	for _, field := range fields {
+		if field.keyword() == "type" {
+			if err := m.collectType(field); err != nil {
+				return err
+			}
+		}
+	}
+
+	var funcs, importedFuncs []*function
+	var funcIDs, importedFuncIDs []*node
+	var memories, importedMemories []*memory
+	var memoryIDs, importedMemoryIDs []*node
+
+	for _, field := range fields {
+		switch kw := field.keyword(); kw {
+		case "type":
+		case "func":
+			fn, id, err := m.collectFunc(field, field.list[1:], nil)
+			if err != nil {
+				return err
+			}
+			if fn.imported != nil {
+				importedFuncs, importedFuncIDs = append(importedFuncs, fn), append(importedFuncIDs, id)
+			} else {
+				funcs, funcIDs = append(funcs, fn), append(funcIDs, id)
+			}
+		case "import":
+			ref, desc, err := importParts(field)
+			if err != nil {
+				return err
+			}
+			switch desc.keyword() {
+			case "func":
+				fn, id, err := m.collectFunc(desc, desc.list[1:], ref)
+				if err != nil {
+					return err
+				}
+				importedFuncs, importedFuncIDs = append(importedFuncs, fn), append(importedFuncIDs, id)
+			case "memory":
+				mem, id, err := m.collectMemory(desc, ref)
+				if err != nil {
+					return err
+				}
+				importedMemories, importedMemoryIDs = append(importedMemories, mem), append(importedMemoryIDs, id)
+			default:
+				return desc.errorf("unsupported import %s; only functions and memories can be imported", desc.describe())
+			}
+		case "memory":
+			mem, id, err := m.collectMemory(field, nil)
+			if err != nil {
+				return err
+			}
+			if mem.imported != nil {
+				importedMemories, importedMemoryIDs = append(importedMemories, mem), append(importedMemoryIDs, id)
+			} else {
+				memories, memoryIDs = append(memories, mem), append(memoryIDs, id)
+			}
+		case "global":
+			if err := m.collectGlobal(field); err != nil {
+				return err
+			}
+		case "export":
+			if len(field.list) != 3 || !field.list[1].isString || !field.list[2].isList || len(field.list[2].list) != 2 {
+				return field.errorf("expected (export \"name\" (func|memory|global $ref))")
+			}
+			kind, ok := externKinds[field.list[2].keyword()]
+			if !ok {
+				return field.list[2].errorf("cannot export %s", field.list[2].describe())
+			}
+			m.exports = append(m.exports, export{name: string(field.list[1].str), kind: kind, ref: field.list[2].list[1]})
+		case "start":
+			if len(field.list) != 2 {
+				return field.errorf("expected (start $func)")
+			}
+			m.start = field.list[1]
+		case "data":
+			if err := m.collectData(field); err != nil {
+				return err
+			}
+		case "table", "elem":
+			return field.errorf("%s is not supported", kw)
+		default:
+			return field.errorf("unexpected module field %s", field.describe())
+		}
+	}
+
+	m.funcs = append(importedFuncs, funcs...)
+	if err := nameIndexSpace(m.funcNames, append(importedFuncIDs, funcIDs...), "function"); err != nil {
+		return err
+	}
+	m.memories = append(importedMemories, memories...)
+	if err := nameIndexSpace(m.memoryNames, append(importedMemoryIDs, memoryIDs...), "memory"); err != nil {
+		return err
+	}
+	if len(m.memories) > 1 {
+		return fields[0].errorf("at most one memory is allowed")
+	}
+	return nil
+}
+
+var externKinds = map[string]byte{
+	"func":   externFunc,
+	"memory": externMemory,
+	"global": externGlobal,
+}
+
+// nameIndexSpace assigns indices to the named entries of an index space
+func nameIndexSpace(names map[string]uint32, ids []*node, what string) error {
+	for i, id := range ids {
+		if _, exists := names[id.atom]; exists {
+			return id.errorf("duplicate %s %s", what, id.atom)
+		}
+		names[id.atom] = uint32(i)
+	}
+	return nil
+}
+
+func (m *module) collectType(field *node) error {
+	args := field.list[1:]
+	if len(args) > 0 && args[0].isID() {
+		if _, exists := m.typeNames[args[0].atom]; exists {
+			return args[0].errorf("duplicate type %s", args[0].atom)
+		}
+		m.typeNames[args[0].atom] = uint32(len(m.types))
+		args = args[1:]
+	}
+	if len(args) != 1 || args[0].keyword() != "func" {
+		return field.errorf("expected (type $name (func ...))")
+	}
+
+	var t funcType
+	for _, item := range args[0].list[1:] {
+		types, err := valueTypeList(item)
+		if err != nil {
+			return err
+		}
+		switch item.keyword() {
+		case "param":
+			t.params = append(t.params, types...)
+		case "result":
+			t.results = append(t.results, types...)
+		default:
+			return item.errorf("unexpected %s in function type", item.describe())
+		}
+	}
+	m.types = append(m.types, t)
+	return nil
+}
+
+// collectFunc parses a function definition or import. args follows the
+// func keyword; ref is set for (import "m" "n" (func ...)).
+func (m *module) collectFunc(field *node, args []*node, ref *importRef) (*function, *node, error) {
+	fn := &function{pos: field.pos, imported: ref, locals: make(map[string]uint32)}
+
+	id, args := m.entryID(field, args)
+	args, err := m.inlineExports(args, externFunc, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(args) > 0 && args[0].keyword() == "import" {
+		if fn.imported, err = importRefOf(args[0]); err != nil {
+			return nil, nil, err
+		}
+		args = args[1:]
+	}
+
+	var typeRef *node
+	var sig funcType
+	numLocals := 0
+	for len(args) > 0 {
+		item := args[0]
+		kw := item.keyword()
+		if kw != "type" && kw != "param" && kw != "result" && kw != "local" {
+			break
+		}
+		args = args[1:]
+
+		if kw == "type" {
+			if len(item.list) != 2 {
+				return nil, nil, item.errorf("expected (type $name)")
+			}
+			typeRef = item.list[1]
+			continue
+		}
+
+		// A named entry declares exactly one value: (param $x i32)
+		items := item.list[1:]
+		if len(items) == 2 && items[0].isID() {
+			index := fn.numParams + numLocals
+			if _, exists := fn.locals[items[0].atom]; exists {
+				return nil, nil, items[0].errorf("duplicate local %s", items[0].atom)
+			}
+			if kw != "result" {
+				fn.locals[items[0].atom] = uint32(index)
+			}
+			items = items[1:]
+		}
+		types, err := valueTypes(items)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch kw {
+		case "param":
+			if numLocals > 0 {
+				return nil, nil, item.errorf("params must precede locals")
+			}
+			sig.params = append(sig.params, types...)
+			fn.numParams += len(types)
+		case "result":
+			sig.results = append(sig.results, types...)
+		case "local":
+			fn.localTypes = append(fn.localTypes, types...)
+			numLocals += len(types)
+		}
+	}
+
+	if typeRef != nil {
+		idx, err := m.resolve(typeRef, m.typeNames, len(m.types), "type")
+		if err != nil {
+			return nil, nil, err
+		}
+		fn.typeIdx = idx
+		if len(sig.params) == 0 && len(sig.results) == 0 {
+			fn.numParams = len(m.types[idx].params)
+		} else if !m.types[idx].equal(sig) {
+			return nil, nil, typeRef.errorf("inline signature does not match type %s", typeRef.atom)
+		}
+	} else {
+		fn.typeIdx = m.typeIndex(sig)
+	}
+
+	if fn.imported != nil {
+		if len(args) > 0 || len(fn.localTypes) > 0 {
+			return nil, nil, field.errorf("imported function cannot have a body")
+		}
+	} else {
+		fn.body = args
+	}
+	return fn, id, nil
+}
+
+func (m *module) collectMemory(field *node, ref *importRef) (*memory, *node, error) {
+	args := field.list[1:]
+	mem := &memory{imported: ref}
+
+	id, args := m.entryID(field, args)
+	args, err := m.inlineExports(args, externMemory, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(args) > 0 && args[0].keyword() == "import" {
+		if mem.imported, err = importRefOf(args[0]); err != nil {
+			return nil, nil, err
+		}
+		args = args[1:]
+	}
+
+	if len(args) == 0 || len(args) > 2 {
+		return nil, nil, field.errorf("expected memory limits: (memory min max?)")
+	}
+	min, err := parseUnsigned(args[0].atom, 32)
+	if err != nil || !args[0].isAtom() {
+		return nil, nil, args[0].errorf("invalid memory size %s", args[0].describe())
+	}
+	mem.min = uint32(min)
+	if len(args) == 2 {
+		max, err := parseUnsigned(args[1].atom, 32)
+		if err != nil || !args[1].isAtom() {
+			return nil, nil, args[1].errorf("invalid memory size %s", args[1].describe())
+		}
+		max32 := uint32(max)
+		mem.max = &max32
+	}
+	return mem, id, nil
+}
+
+func (m *module) collectGlobal(field *node) error {
+	// Globals cannot be imported, so their index is known now
+	id, args := m.entryID(field, field.list[1:])
+	if _, exists := m.globalNames[id.atom]; exists {
+		return id.errorf("duplicate global %s", id.atom)
+	}
+	m.globalNames[id.atom] = uint32(len(m.globals))
+
+	args, err := m.inlineExports(args, externGlobal, id)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && args[0].keyword() == "import" {
+		return args[0].errorf("global imports are not supported")
+	}
+	if len(args) == 0 {
+		return field.errorf("expected global type")
+	}
+
+	g := &global{}
+	typ := args[0]
+	if typ.keyword() == "mut" {
+		if len(typ.list) != 2 {
+			return typ.errorf("expected (mut type)")
+		}
+		g.mutable, typ = true, typ.list[1]
+	}
+	vt, ok := valueTypeCodes[typ.atom]
+	if !ok || !typ.isAtom() {
+		return typ.errorf("unknown value type %s", typ.describe())
+	}
+	g.valueType = vt
+	g.init = args[1:]
+	m.globals = append(m.globals, g)
+	return nil
+}
+
+func (m *module) collectData(field *node) error {
+	args := field.list[1:]
+	if len(args) > 0 && args[0].isID() {
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0].keyword() == "memory" {
+		args = args[1:]
+	}
+	if len(args) == 0 || !args[0].isList {
+		return field.errorf("passive data segments are not supported; give an offset such as (i32.const 1024)")
+	}
+
+	seg := dataSegment{offset: []*node{args[0]}}
+	if args[0].keyword() == "offset" {
+		seg.offset = args[0].list[1:]
+	}
+	for _, arg := range args[1:] {
+		if !arg.isString {
+			return arg.errorf("expected a string in data segment, got %s", arg.describe())
+		}
+		seg.bytes = append(seg.bytes, arg.str...)
+	}
+	m.data = append(m.data, seg)
+	return nil
+}
+
+// inlineExports consumes (export "name") abbreviations for the entry with
+// the given id
+func (m *module) inlineExports(args []*node, kind byte, id *node) ([]*node, error) {
+	for len(args) > 0 && args[0].keyword() == "export" {
+		item := args[0]
+		if len(item.list) != 2 || !item.list[1].isString {
+			return nil, item.errorf("expected (export \"name\")")
+		}
+		m.exports = append(m.exports, export{name: string(item.list[1].str), kind: kind, ref: id})
+		args = args[1:]
+	}
+	return args, nil
+}
+
+// entryID returns the entry's $name, or a unique internal name that cannot
+// be written in source for anonymous entries
+func (m *module) entryID(field *node, args []*node) (*node, []*node) {
+	if len(args) > 0 && args[0].isID() {
+		return args[0], args[1:]
+	}
+	m.anonymous++
+	return &node{pos: field.pos, atom: fmt.Sprintf("$ anonymous %d", m.anonymous)}, args
+}
+
+func importParts(field *node) (*importRef, *node, error) {
+	if len(field.list) != 4 || !field.list[1].isString || !field.list[2].isString || !field.list[3].isList {
+		return nil, nil, field.errorf("expected (import \"module\" \"name\" (func|memory ...))")
+	}
+	ref := &importRef{module: string(field.list[1].str), name: string(field.list[2].str)}
+	return ref, field.list[3], nil
+}
+
+func importRefOf(item *node) (*importRef, error) {
+	if len(item.list) != 3 || !item.list[1].isString || !item.list[2].isString {
+		return nil, item.errorf("expected (import \"module\" \"name\")")
+	}
+	return &importRef{module: string(item.list[1].str), name: string(item.list[2].str)}, nil
+}
+
+// valueTypeList returns the types of a (param ...), (result ...) or
+// (local ...) list, which may name a single value
+func valueTypeList(item *node) ([]byte, error) {
+	items := item.list[1:]
+	if len(items) == 2 && items[0].isID() {
+		items = items[1:]
+	}
+	return valueTypes(items)
+}
+
+func valueTypes(items []*node) ([]byte, error) {
+	types := make([]byte, 0, len(items))
+	for _, item := range items {
+		vt, ok := valueTypeCodes[item.atom]
+		if !ok || !item.isAtom() {
+			return nil, item.errorf("unknown value type %s", item.describe())
+		}
+		types = append(types, vt)
+	}
+	return types, nil
+}
+
+// typeIndex returns the index of a signature, adding it if it is new
+func (m *module) typeIndex(sig funcType) uint32 {
+	for i, t := range m.types {
+		if t.equal(sig) {
+			return uint32(i)
+		}
+	}
+	m.types = append(m.types, sig)
+	return uint32(len(m.types) - 1)
+}
+
+// resolve turns a $name or numeric reference into an index
+func (m *module) resolve(ref *node, names map[string]uint32, count int, what string) (uint32, error) {
+	if ref.isID() {
+		idx, ok := names[ref.atom]
+		if !ok {
+			return 0, ref.errorf("unknown %s %s", what, ref.atom)
+		}
+		return idx, nil
+	}
+	if !ref.isAtom() {
+		return 0, ref.errorf("expected %s index, got %s", what, ref.describe())
+	}
+	idx, err := parseUnsigned(ref.atom, 32)
+	if err != nil {
+		return 0, ref.errorf("invalid %s index %q", what, ref.atom)
+	}
+	if int64(idx) >= int64(count) {
+		return 0, ref.errorf("%s index %d out of range", what, idx)
+	}
+	return uint32(idx), nil
+}
+
+// constExpr assembles an initializer expression
+func (m *module) constExpr(expr []*node) ([]byte, error) {
+	fc := &funcCompiler{m: m}
+	if err := fc.compileSeq(expr); err != nil {
+		return nil, err
+	}
+	return append(fc.code, 0x0b), nil
+}
+
+// encode produces the binary module
+func (m *module) encode() ([]byte, error) {
+	// Bodies first: block types may add signatures
+	var code []byte
+	var funcDecls []byte
+	var imports []byte
+	numImports, numDefined := 0, 0
+	for _, fn := range m.funcs {
+		if fn.imported != nil {
+			imports = appendName(imports, fn.imported.module)
+			imports = appendName(imports, fn.imported.name)
+			imports = append(imports, externFunc)
+			imports = appendU32(imports, fn.typeIdx)
+			numImports++
+			continue
+		}
+
+		body, err := m.encodeBody(fn)
+		if err != nil {
+			return nil, err
+		}
+		code = appendU32(code, uint32(len(body)))
+		code = append(code, body...)
+		funcDecls = appendU32(funcDecls, fn.typeIdx)
+		numDefined++
+	}
+
+	var memories []byte
+	numMemories := 0
+	for _, mem := range m.memories {
+		if mem.imported != nil {
+			imports = appendName(imports, mem.imported.module)
+			imports = appendName(imports, mem.imported.name)
+			imports = append(imports, externMemory)
+			imports = appendLimits(imports, mem.min, mem.max)
+			numImports++
+			continue
+		}
+		memories = appendLimits(memories, mem.min, mem.max)
+		numMemories++
+	}
+
+	var globals []byte
+	for _, g := range m.globals {
+		init, err := m.constExpr(g.init)
+		if err != nil {
+			return nil, err
+		}
+		globals = append(globals, g.valueType)
+		if g.mutable {
+			globals = append(globals, 0x01)
+		} else {
+			globals = append(globals, 0x00)
+		}
+		globals = append(globals, init...)
+	}
+
+	var exports []byte
+	seen := make(map[string]bool)
+	for _, e := range m.exports {
+		if seen[e.name] {
+			return nil, e.ref.errorf("duplicate export %q", e.name)
+		}
+		seen[e.name] = true
+
+		var idx uint32
+		var err error
+		switch e.kind {
+		case externFunc:
+			idx, err = m.resolve(e.ref, m.funcNames, len(m.funcs), "function")
+		case externMemory:
+			idx, err = m.resolve(e.ref, m.memoryNames, len(m.memories), "memory")
+		case externGlobal:
+			idx, err = m.resolve(e.ref, m.globalNames, len(m.globals), "global")
+		}
+		if err != nil {
+			return nil, err
+		}
+		exports = appendName(exports, e.name)
+		exports = append(exports, e.kind)
+		exports = appendU32(exports, idx)
+	}
+
+	var data []byte
+	for _, seg := range m.data {
+		offset, err := m.constExpr(seg.offset)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, 0x00) // active, memory 0
+		data = append(data, offset...)
+		data = appendU32(data, uint32(len(seg.bytes)))
+		data = append(data, seg.bytes...)
+	}
+
+	var types []byte
+	for _, t := range m.types {
+		types = append(types, 0x60)
+		types = appendU32(types, uint32(len(t.params)))
+		types = append(types, t.params...)
+		types = appendU32(types, uint32(len(t.results)))
+		types = append(types, t.results...)
+	}
+
+	out := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	out = appendSection(out, sectionType, len(m.types), types)
+	out = appendSection(out, sectionImport, numImports, imports)
+	out = appendSection(out, sectionFunc, numDefined, funcDecls)
+	out = appendSection(out, sectionMemory, numMemories, memories)
+	out = appendSection(out, sectionGlobal, len(m.globals), globals)
+	out = appendSection(out, sectionExport, len(m.exports), exports)
+	if m.start != nil {
+		idx, err := m.resolve(m.start, m.funcNames, len(m.funcs), "function")
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sectionStart)
+		start := appendU32(nil, idx)
+		out = appendU32(out, uint32(len(start)))
+		out = append(out, start...)
+	}
+	out = appendSection(out, sectionCode, numDefined, code)
+	out = appendSection(out, sectionData, len(m.data), data)
+	return out, nil
+}
+
+// encodeBody encodes a function's locals and instructions
+func (m *module) encodeBody(fn *function) ([]byte, error) {
+	var groups []byte
+	numGroups := 0
+	for i := 0; i < len(fn.localTypes); {
+		j := i
+		for j < len(fn.localTypes) && fn.localTypes[j] == fn.localTypes[i] {
+			j++
+		}
+		groups = appendU32(groups, uint32(j-i))
+		groups = append(groups, fn.localTypes[i])
+		numGroups++
+		i = j
+	}
+
+	fc := &funcCompiler{m: m, locals: fn.locals}
+	if err := fc.compileSeq(fn.body); err != nil {
+		return nil, err
+	}
+	if len(fc.labels) > 0 {
+		return nil, fn.errorf("function has %d unclosed block(s)", len(fc.labels))
+	}
+
+	body := appendU32(nil, uint32(numGroups))
+	body = append(body, groups...)
+	body = append(body, fc.code...)
+	return append(body, 0x0b), nil
+}
+
+// Extract returns the WAT module in an LLM reply, dropping markdown fences
+// and surrounding prose. The reply is returned trimmed if no module is found.
+func Extract(reply string) string {
+	start := strings.Index(reply, "(module")
+	if start < 0 {
+		return strings.TrimSpace(reply)
+	}
+
+	// Scan to the matching parenthesis, skipping strings and comments
+	depth := 0
+	for i := start; i < len(reply); i++ {
+		switch {
+		case reply[i] == '"':
+			for i++; i < len(reply) && reply[i] != '"'; i++ {
+				if reply[i] == '\\' {
+					i++
+				}
+			}
+		case strings.HasPrefix(reply[i:], ";;"):
+			for i < len(reply) && reply[i] != '\n' {
+				i++
+			}
+		case reply[i] == '(':
+			depth++
+		case reply[i] == ')':
+			depth--
+			if depth == 0 {
+				return reply[start : i+1]
+			}
+		}
+	}
+
+	// Unbalanced; hand the rest to the assembler to report
+	rest := reply[start:]
+	if end := strings.Index(rest, "```"); end >= 0 {
+		rest = rest[:end]
+	}
+	return strings.TrimSpace(rest)
+}
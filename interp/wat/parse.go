@@ -0,0 +1,261 @@
+package wat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error is an assembly error at a position in the WAT source
+type Error struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// pos is a line and column in the source, both 1-based
+type pos struct {
+	line int
+	col  int
+}
+
+func (p pos) errorf(format string, args ...interface{}) *Error {
+	return &Error{Line: p.line, Col: p.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// node is an S-expression: an atom, a string literal or a parenthesized list
+type node struct {
+	pos
+	atom     string
+	str      []byte
+	isString bool
+	list     []*node
+	isList   bool
+}
+
+// keyword returns the leading atom of a list, or "" if there is none
+func (n *node) keyword() string {
+	if !n.isList || len(n.list) == 0 || n.list[0].isList || n.list[0].isString {
+		return ""
+	}
+	return n.list[0].atom
+}
+
+// isAtom reports whether n is a bare atom
+func (n *node) isAtom() bool {
+	return !n.isList && !n.isString
+}
+
+// isID reports whether n is a symbolic identifier such as $name
+func (n *node) isID() bool {
+	return n.isAtom() && strings.HasPrefix(n.atom, "$")
+}
+
+// describe renders a node for error messages
+func (n *node) describe() string {
+	switch {
+	case n.isList:
+		if kw := n.keyword(); kw != "" {
+			return "(" + kw + " ...)"
+		}
+		return "(...)"
+	case n.isString:
+		return strconv.Quote(string(n.str))
+	default:
+		return n.atom
+	}
+}
+
+// parser turns WAT source into S-expressions
+type parser struct {
+	src  string
+	off  int
+	line int
+	col  int
+}
+
+// parse reads every top-level S-expression in src
+func parse(src string) ([]*node, error) {
+	p := &parser{src: src, line: 1, col: 1}
+
+	var nodes []*node
+	for {
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		if p.off >= len(p.src) {
+			return nodes, nil
+		}
+		n, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+func (p *parser) here() pos {
+	return pos{line: p.line, col: p.col}
+}
+
+// advance consumes n bytes, tracking lines and columns
+func (p *parser) advance(n int) {
+	for i := 0; i < n && p.off < len(p.src); i++ {
+		if p.src[p.off] == '\n' {
+			p.line++
+			p.col = 1
+		} else {
+			p.col++
+		}
+		p.off++
+	}
+}
+
+// skipSpace skips whitespace, line comments and nested block comments
+func (p *parser) skipSpace() error {
+	for p.off < len(p.src) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(p.src[p.off])):
+			p.advance(1)
+		case strings.HasPrefix(p.src[p.off:], ";;"):
+			for p.off < len(p.src) && p.src[p.off] != '\n' {
+				p.advance(1)
+			}
+		case strings.HasPrefix(p.src[p.off:], "(;"):
+			start := p.here()
+			depth := 0
+			for {
+				if p.off >= len(p.src) {
+					return start.errorf("unterminated block comment")
+				}
+				if strings.HasPrefix(p.src[p.off:], "(;") {
+					depth++
+					p.advance(2)
+				} else if strings.HasPrefix(p.src[p.off:], ";)") {
+					depth--
+					p.advance(2)
+					if depth == 0 {
+						break
+					}
+				} else {
+					p.advance(1)
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseNode() (*node, error) {
+	start := p.here()
+
+	switch p.src[p.off] {
+	case '(':
+		p.advance(1)
+		n := &node{pos: start, isList: true}
+		for {
+			if err := p.skipSpace(); err != nil {
+				return nil, err
+			}
+			if p.off >= len(p.src) {
+				return nil, start.errorf("unclosed parenthesis")
+			}
+			if p.src[p.off] == ')' {
+				p.advance(1)
+				return n, nil
+			}
+			child, err := p.parseNode()
+			if err != nil {
+				return nil, err
+			}
+			n.list = append(n.list, child)
+		}
+	case ')':
+		return nil, start.errorf("unexpected )")
+	case '"':
+		str, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return &node{pos: start, str: str, isString: true}, nil
+	default:
+		end := p.off
+		for end < len(p.src) && !strings.ContainsRune(" \t\r\n()\";", rune(p.src[end])) {
+			end++
+		}
+		atom := p.src[p.off:end]
+		p.advance(end - p.off)
+		return &node{pos: start, atom: atom}, nil
+	}
+}
+
+// parseString reads a string literal with WAT escapes
+func (p *parser) parseString() ([]byte, error) {
+	start := p.here()
+	p.advance(1)
+
+	var out []byte
+	for {
+		if p.off >= len(p.src) || p.src[p.off] == '\n' {
+			return nil, start.errorf("unterminated string")
+		}
+
+		c := p.src[p.off]
+		if c == '"' {
+			p.advance(1)
+			return out, nil
+		}
+		if c != '\\' {
+			out = append(out, c)
+			p.advance(1)
+			continue
+		}
+
+		escape := p.here()
+		if p.off+1 >= len(p.src) {
+			return nil, escape.errorf("unterminated string")
+		}
+		switch e := p.src[p.off+1]; e {
+		case 'n':
+			out = append(out, '\n')
+			p.advance(2)
+		case 't':
+			out = append(out, '\t')
+			p.advance(2)
+		case 'r':
+			out = append(out, '\r')
+			p.advance(2)
+		case '\\', '"', '\'':
+			out = append(out, e)
+			p.advance(2)
+		case 'u':
+			end := strings.IndexByte(p.src[p.off:], '}')
+			if !strings.HasPrefix(p.src[p.off+2:], "{") || end < 0 {
+				return nil, escape.errorf("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(p.src[p.off+3:p.off+end], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return nil, escape.errorf("invalid unicode escape")
+			}
+			out = utf8.AppendRune(out, rune(code))
+			p.advance(end + 1)
+		default:
+			if p.off+2 >= len(p.src) {
+				return nil, escape.errorf("invalid escape")
+			}
+			b, err := strconv.ParseUint(p.src[p.off+1:p.off+3], 16, 8)
+			if err != nil {
+				return nil, escape.errorf("invalid escape \\%c", e)
+			}
+			out = append(out, byte(b))
+			p.advance(3)
+		}
+	}
+}
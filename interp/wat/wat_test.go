@@ -0,0 +1,192 @@
+package wat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// instantiate assembles src and instantiates it in a fresh runtime
+func instantiate(t *testing.T, src string, setup func(wazero.Runtime)) api.Module {
+	t.Helper()
+
+	bin, err := Assemble(src)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { runtime.Close(ctx) })
+	if setup != nil {
+		setup(runtime)
+	}
+
+	mod, err := runtime.Instantiate(ctx, bin)
+	require.NoError(t, err)
+	return mod
+}
+
+func call(t *testing.T, mod api.Module, name string, args ...uint64) []uint64 {
+	t.Helper()
+
+	results, err := mod.ExportedFunction(name).Call(context.Background(), args...)
+	require.NoError(t, err)
+	return results
+}
+
+func TestAssembleControlFlow(t *testing.T) {
+	mod := instantiate(t, `
+(module
+  ;; flat loop with named labels
+  (func $fact (export "fact") (param $n i64) (result i64)
+    (local $acc i64)
+    i64.const 1
+    local.set $acc
+    block $done
+      loop $next
+        local.get $n
+        i64.eqz
+        br_if $done
+        local.get $acc
+        local.get $n
+        i64.mul
+        local.set $acc
+        local.get $n
+        i64.const 1
+        i64.sub
+        local.set $n
+        br $next
+      end
+    end
+    local.get $acc)
+
+  (; folded if with a result ;)
+  (func (export "max") (param i32 i32) (result i32)
+    (if (result i32) (i32.gt_s (local.get 0) (local.get 1))
+      (then (local.get 0))
+      (else (local.get 1))))
+
+  (func (export "classify") (param $x i32) (result i32)
+    (block $two
+      (block $one
+        (block $zero
+          (br_table $zero $one $two (local.get $x)))
+        (return (i32.const 100)))
+      (return (i32.const 101)))
+    i32.const 102)
+
+  (func (export "sum") (param $a i32) (param $b i32) (result i32)
+    (call $add (local.get $a) (local.get $b)))
+  (func $add (param i32 i32) (result i32)
+    local.get 0
+    local.get 1
+    i32.add)
+)`, nil)
+
+	assert.Equal(t, uint64(120), call(t, mod, "fact", 5)[0])
+	assert.Equal(t, uint64(7), call(t, mod, "max", 7, 3)[0])
+	assert.Equal(t, uint64(9), call(t, mod, "max", 2, 9)[0])
+	assert.Equal(t, uint64(100), call(t, mod, "classify", 0)[0])
+	assert.Equal(t, uint64(101), call(t, mod, "classify", 1)[0])
+	assert.Equal(t, uint64(102), call(t, mod, "classify", 5)[0])
+	assert.Equal(t, uint64(12), call(t, mod, "sum", 5, 7)[0])
+}
+
+func TestAssembleMemoryAndData(t *testing.T) {
+	mod := instantiate(t, `
+(module
+  (memory (export "memory") 1 2)
+  (global $count (mut i32) (i32.const 0))
+  (global (export "answer") i32 (i32.const 42))
+  (data (i32.const 1024) "{\"ok\":true}\0a")
+
+  (func (export "solve") (param i32 i32) (result i32 i32)
+    (global.set $count (i32.add (global.get $count) (i32.const 1)))
+    (memory.copy (i32.const 2048) (i32.const 1024) (i32.const 12))
+    (memory.fill (i32.const 2060) (i32.const 0x20) (i32.const 4))
+    (i32.store8 offset=2064 (i32.const 0) (i32.const 0x21))
+    (i32.const 2048)
+    (i32.const 17))
+
+  (func (export "count") (result i32) global.get $count)
+  (func (export "pages") (result i32) (memory.size))
+  (func (export "floats") (result f64)
+    (f64.add (f64.const 0x1p-1) (f64.promote_f32 (f32.const 1.25))))
+)`, nil)
+
+	results := call(t, mod, "solve", 0, 0)
+	out, ok := mod.Memory().Read(uint32(results[0]), uint32(results[1]))
+	require.True(t, ok)
+	assert.Equal(t, "{\"ok\":true}\n    !", string(out))
+
+	assert.Equal(t, uint64(1), call(t, mod, "count")[0])
+	assert.Equal(t, uint64(1), call(t, mod, "pages")[0])
+	assert.Equal(t, 1.75, api.DecodeF64(call(t, mod, "floats")[0]))
+	assert.Equal(t, uint64(42), mod.ExportedGlobal("answer").Get())
+}
+
+func TestAssembleImports(t *testing.T) {
+	var logged []uint32
+	mod := instantiate(t, `
+(module
+  (type $log_t (func (param i32)))
+  (import "env" "log" (func $log (type $log_t)))
+  (func (export "run") (param $x i32)
+    (call $log (i32.mul (local.get $x) (i32.const -1)))))
+`, func(r wazero.Runtime) {
+		_, err := r.NewHostModuleBuilder("env").
+			NewFunctionBuilder().
+			WithFunc(func(v uint32) { logged = append(logged, v) }).
+			Export("log").
+			Instantiate(context.Background())
+		require.NoError(t, err)
+	})
+
+	call(t, mod, "run", 3)
+	require.Len(t, logged, 1)
+	assert.Equal(t, int32(-3), int32(logged[0]))
+}
+
+func TestAssembleErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		line int
+		col  int
+		msg  string
+	}{
+		{"unknown instruction", "(module\n  (func\n    i32.cnst 1))", 3, 5, `unknown instruction "i32.cnst"`},
+		{"unknown label", "(module (func (br $nowhere)))", 1, 19, "unknown label $nowhere"},
+		{"unknown function", "(module (func (call $missing)))", 1, 21, "unknown function $missing"},
+		{"unclosed", "(module (func nop)", 1, 1, "unclosed parenthesis"},
+		{"bad constant", "(module (func (drop (i32.const 1x))))", 1, 32, `invalid i32 constant "1x"`},
+		{"unclosed block", "(module (func block nop))", 1, 9, "unclosed block"},
+		{"tables", "(module (table 1 funcref))", 1, 9, "table is not supported"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Assemble(tc.src)
+			var watErr *Error
+			require.True(t, errors.As(err, &watErr), "expected *Error, got %v", err)
+			assert.Equal(t, tc.line, watErr.Line)
+			assert.Equal(t, tc.col, watErr.Col)
+			assert.Contains(t, watErr.Msg, tc.msg)
+		})
+	}
+}
+
+func TestExtract(t *testing.T) {
+	reply := "Here is the module:\n```wat\n(module\n  (func (export \"f\") (result i32) ;; returns ) one\n    (i32.const 1)))\n```\nIt returns one."
+	src := Extract(reply)
+	assert.Equal(t, "(module\n  (func (export \"f\") (result i32) ;; returns ) one\n    (i32.const 1)))", src)
+
+	_, err := Assemble(src)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "no module here", Extract("  no module here\n"))
+}
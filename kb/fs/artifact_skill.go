@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/snow-ghost/agent/artifact"
 	"github.com/snow-ghost/agent/core"
@@ -113,6 +114,9 @@ func (as *ArtifactSkill) executeWASM(ctx context.Context, task core.Task) (core.
 			"domain":      as.manifest.Domain,
 		},
 	}
+	if len(as.manifest.Capabilities) > 0 {
+		hypothesis.Meta[core.MetaCapabilities] = strings.Join(as.manifest.Capabilities, ",")
+	}
 
 	// Execute WASM
 	return as.wasmExec.Execute(ctx, hypothesis, task)
@@ -238,7 +238,7 @@ func CreateSampleArtifact(artifactsDir string) error {
 		Name:   "sort_test_1",
 		Input:  []byte(`[3,1,2]`),
 		Oracle: []byte(`[1,2,3]`),
-		Checks: []string{"sorted_non_decreasing"},
+		Checks: []string{"monotone($)"},
 		Weight: 1.0,
 	}
 	manifest.AddTest(test)
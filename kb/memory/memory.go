@@ -346,7 +346,7 @@ func (s *SortSkill) Execute(ctx context.Context, task core.Task) (core.Result, e
 	}
 
 	// Convert to []float64
-	var nums []float64
+	nums := []float64{}
 	switch v := numbers.(type) {
 	case []interface{}:
 		for _, item := range v {
@@ -395,7 +395,7 @@ func (s *SortSkill) Tests() []core.TestCase {
 			Name:   "sort_simple",
 			Input:  []byte(`{"numbers": [3, 1, 4, 1, 5]}`),
 			Oracle: []byte(`{"sorted": [1, 1, 3, 4, 5], "count": 5}`),
-			Checks: []string{"sorted_order", "preserves_count"},
+			Checks: []string{"monotone($.sorted)", "length_preserved($.numbers, $.sorted)"},
 			Weight: 1.0,
 		},
 	}
@@ -484,7 +484,7 @@ func (s *ReverseSkill) Tests() []core.TestCase {
 			Name:   "reverse_simple",
 			Input:  []byte(`{"text": "hello"}`),
 			Oracle: []byte(`{"reversed": "olleh", "original": "hello", "length": 5}`),
-			Checks: []string{"reversed_correctly", "preserves_original"},
+			Checks: []string{"length_preserved($.text, $.reversed)"},
 			Weight: 1.0,
 		},
 	}
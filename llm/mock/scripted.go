@@ -0,0 +1,63 @@
+package mock
+
+import (
+	"context"
+	"sync"
+
+	llmclient "github.com/snow-ghost/agent/pkg/llm/client"
+	routercore "github.com/snow-ghost/agent/pkg/router/core"
+)
+
+// ScriptedChat is a router client that replays canned chat replies in order,
+// repeating the last one, and records the requests it gets. Wrapped in
+// llmclient.NewAdapter it drives the LLM pipeline in tests.
+type ScriptedChat struct {
+	mu       sync.Mutex
+	replies  []string
+	requests []llmclient.ChatRequest
+}
+
+// NewScriptedChat creates a client answering with replies in order
+func NewScriptedChat(replies ...string) *ScriptedChat {
+	return &ScriptedChat{replies: replies}
+}
+
+// Chat records the request and answers with the next reply
+func (s *ScriptedChat) Chat(ctx context.Context, req llmclient.ChatRequest) (*llmclient.ChatResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, req)
+	reply := s.replies[0]
+	if len(s.replies) > 1 {
+		s.replies = s.replies[1:]
+	}
+	return &llmclient.ChatResponse{Text: reply}, nil
+}
+
+// Requests returns the chat requests received so far
+func (s *ScriptedChat) Requests() []llmclient.ChatRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]llmclient.ChatRequest(nil), s.requests...)
+}
+
+func (s *ScriptedChat) Complete(ctx context.Context, prompt string, caller string) (string, error) {
+	return "", nil
+}
+
+func (s *ScriptedChat) Embed(ctx context.Context, texts []string, caller string) ([][]float32, error) {
+	return nil, nil
+}
+
+func (s *ScriptedChat) GetModels(ctx context.Context) ([]routercore.Model, error) {
+	return nil, nil
+}
+
+func (s *ScriptedChat) Health(ctx context.Context) error {
+	return nil
+}
+
+// Ensure ScriptedChat implements the router client interface
+var _ llmclient.LLMClient = (*ScriptedChat)(nil)
@@ -0,0 +1,159 @@
+package accounting
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultAlertThreshold is the fraction of a budget at which alerts start
+const DefaultAlertThreshold = 0.8
+
+// ErrBudgetNotFound is returned when resetting a budget that does not exist
+var ErrBudgetNotFound = errors.New("budget not found")
+
+// Bounds returns the start and end of the window containing t, in UTC
+func (w BudgetWindow) Bounds(t time.Time) (time.Time, time.Time, error) {
+	t = t.UTC()
+
+	switch w {
+	case BudgetWindowDaily:
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1), nil
+	case BudgetWindowMonthly:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0), nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("unknown budget window: %q", w)
+	}
+}
+
+// Blocks reports whether the budget rejects further requests
+func (s BudgetStatus) Blocks() bool {
+	return s.Exceeded && s.Enforcement == BudgetEnforcementHard
+}
+
+// SetBudget validates a budget, fills in defaults and stores it
+func (m *Manager) SetBudget(budget Budget) (Budget, error) {
+	if budget.Caller == "" {
+		return Budget{}, fmt.Errorf("budget caller is required")
+	}
+	if _, _, err := budget.Window.Bounds(time.Now()); err != nil {
+		return Budget{}, err
+	}
+	if budget.Amount <= 0 {
+		return Budget{}, fmt.Errorf("budget amount must be positive")
+	}
+	if budget.AlertThreshold < 0 || budget.AlertThreshold > 1 {
+		return Budget{}, fmt.Errorf("budget alert threshold must be between 0 and 1")
+	}
+
+	if budget.Currency == "" {
+		budget.Currency = "USD"
+	}
+	if budget.AlertThreshold == 0 {
+		budget.AlertThreshold = DefaultAlertThreshold
+	}
+	switch budget.Enforcement {
+	case "":
+		budget.Enforcement = BudgetEnforcementHard
+	case BudgetEnforcementHard, BudgetEnforcementSoft:
+	default:
+		return Budget{}, fmt.Errorf("unknown budget enforcement: %q", budget.Enforcement)
+	}
+	budget.UpdatedAt = time.Now().UTC()
+
+	if err := m.aggregator.SetBudget(budget); err != nil {
+		return Budget{}, fmt.Errorf("failed to store budget: %w", err)
+	}
+	return budget, nil
+}
+
+// GetBudgets lists budgets, for a single caller if caller is not empty
+func (m *Manager) GetBudgets(caller string) ([]Budget, error) {
+	return m.aggregator.GetBudgets(caller)
+}
+
+// DeleteBudget removes the budget for a caller and window
+func (m *Manager) DeleteBudget(caller string, window BudgetWindow) error {
+	return m.aggregator.DeleteBudget(caller, window)
+}
+
+// ResetBudget discards the spend counted against a budget in its current
+// window; costs recorded afterwards count as usual
+func (m *Manager) ResetBudget(caller string, window BudgetWindow) (Budget, error) {
+	budgets, err := m.aggregator.GetBudgets(caller)
+	if err != nil {
+		return Budget{}, err
+	}
+
+	for _, budget := range budgets {
+		if budget.Window != window {
+			continue
+		}
+
+		now := time.Now().UTC()
+		budget.ResetAt = &now
+		budget.UpdatedAt = now
+		if err := m.aggregator.SetBudget(budget); err != nil {
+			return Budget{}, fmt.Errorf("failed to store budget: %w", err)
+		}
+		return budget, nil
+	}
+
+	return Budget{}, fmt.Errorf("%w: %s/%s", ErrBudgetNotFound, caller, window)
+}
+
+// GetBudgetStatuses returns the spend against every budget configured for a
+// caller, or for all callers if caller is empty
+func (m *Manager) GetBudgetStatuses(caller string) ([]BudgetStatus, error) {
+	budgets, err := m.aggregator.GetBudgets(caller)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	statuses := make([]BudgetStatus, 0, len(budgets))
+	for _, budget := range budgets {
+		status, err := m.budgetStatus(budget, now)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// budgetStatus sums the caller's spend in the budget's current window
+func (m *Manager) budgetStatus(budget Budget, now time.Time) (BudgetStatus, error) {
+	start, end, err := budget.Window.Bounds(now)
+	if err != nil {
+		return BudgetStatus{}, err
+	}
+
+	from := start
+	if budget.ResetAt != nil && budget.ResetAt.After(from) {
+		from = *budget.ResetAt
+	}
+
+	summary, err := m.aggregator.GetCostSummary(CostFilter{
+		From:     &from,
+		Caller:   budget.Caller,
+		Currency: budget.Currency,
+	})
+	if err != nil {
+		return BudgetStatus{}, fmt.Errorf("failed to sum spend for %s: %w", budget.Caller, err)
+	}
+
+	used := summary.TotalCost
+	return BudgetStatus{
+		Budget:      budget,
+		WindowStart: start,
+		WindowEnd:   end,
+		Used:        used,
+		Remaining:   budget.Amount - used,
+		Alert:       used >= budget.Amount*budget.AlertThreshold,
+		Exceeded:    used >= budget.Amount,
+	}, nil
+}
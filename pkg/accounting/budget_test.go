@@ -0,0 +1,134 @@
+package accounting
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestManagers returns an in-memory and a SQLite-backed manager
+func newTestManagers(t *testing.T) map[string]*Manager {
+	t.Helper()
+
+	memory, err := NewManager(Config{})
+	if err != nil {
+		t.Fatalf("failed to create memory manager: %v", err)
+	}
+
+	sqlite, err := NewManager(Config{UseSQLite: true, DBPath: filepath.Join(t.TempDir(), "costs.db")})
+	if err != nil {
+		t.Fatalf("failed to create SQLite manager: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+
+	return map[string]*Manager{"memory": memory, "sqlite": sqlite}
+}
+
+func TestBudgetWindowBounds(t *testing.T) {
+	now := time.Date(2024, 3, 15, 13, 45, 0, 0, time.UTC)
+
+	start, end, err := BudgetWindowDaily.Bounds(now)
+	if err != nil || !start.Equal(time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected daily bounds %v - %v (%v)", start, end, err)
+	}
+
+	start, end, err = BudgetWindowMonthly.Bounds(now)
+	if err != nil || !start.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected monthly bounds %v - %v (%v)", start, end, err)
+	}
+
+	if _, _, err := BudgetWindow("weekly").Bounds(now); err == nil {
+		t.Error("Expected error for unknown window")
+	}
+}
+
+func TestSetBudgetValidation(t *testing.T) {
+	m, _ := NewManager(Config{})
+
+	invalid := []Budget{
+		{Window: BudgetWindowDaily, Amount: 1},
+		{Caller: "team-a", Window: "weekly", Amount: 1},
+		{Caller: "team-a", Window: BudgetWindowDaily},
+		{Caller: "team-a", Window: BudgetWindowDaily, Amount: 1, AlertThreshold: 1.5},
+		{Caller: "team-a", Window: BudgetWindowDaily, Amount: 1, Enforcement: "strict"},
+	}
+	for _, budget := range invalid {
+		if _, err := m.SetBudget(budget); err == nil {
+			t.Errorf("Expected error for budget %+v", budget)
+		}
+	}
+
+	budget, err := m.SetBudget(Budget{Caller: "team-a", Window: BudgetWindowDaily, Amount: 1})
+	if err != nil {
+		t.Fatalf("SetBudget failed: %v", err)
+	}
+	if budget.Currency != "USD" || budget.AlertThreshold != DefaultAlertThreshold || budget.Enforcement != BudgetEnforcementHard {
+		t.Errorf("Expected defaults to be filled in, got %+v", budget)
+	}
+}
+
+func TestBudgetStatuses(t *testing.T) {
+	for name, m := range newTestManagers(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := m.SetBudget(Budget{Caller: "team-a", Window: BudgetWindowDaily, Amount: 1.0}); err != nil {
+				t.Fatalf("SetBudget failed: %v", err)
+			}
+			if _, err := m.SetBudget(Budget{Caller: "team-a", Window: BudgetWindowMonthly, Amount: 10.0, Enforcement: BudgetEnforcementSoft}); err != nil {
+				t.Fatalf("SetBudget failed: %v", err)
+			}
+
+			// Spend from yesterday only counts against the monthly window,
+			// unless today is the first of the month
+			yesterday := time.Now().UTC().AddDate(0, 0, -1)
+			m.RecordCost(CostRecord{Timestamp: yesterday, Caller: "team-a", Provider: "p", Model: "m", Currency: "USD", CostTotal: 5.0})
+			m.RecordCost(CostRecord{Timestamp: time.Now(), Caller: "team-a", Provider: "p", Model: "m", Currency: "USD", CostTotal: 0.85})
+			m.RecordCost(CostRecord{Timestamp: time.Now(), Caller: "team-b", Provider: "p", Model: "m", Currency: "USD", CostTotal: 3.0})
+
+			statuses, err := m.GetBudgetStatuses("team-a")
+			if err != nil {
+				t.Fatalf("GetBudgetStatuses failed: %v", err)
+			}
+			if len(statuses) != 2 {
+				t.Fatalf("Expected 2 budgets, got %d", len(statuses))
+			}
+
+			daily := statuses[0]
+			if daily.Window != BudgetWindowDaily || daily.Used != 0.85 {
+				t.Errorf("Expected daily usage 0.85, got %+v", daily)
+			}
+			if !daily.Alert || daily.Exceeded || daily.Blocks() {
+				t.Errorf("Expected daily budget to alert without blocking, got %+v", daily)
+			}
+
+			m.RecordCost(CostRecord{Timestamp: time.Now(), Caller: "team-a", Provider: "p", Model: "m", Currency: "USD", CostTotal: 0.2})
+			statuses, _ = m.GetBudgetStatuses("team-a")
+			if !statuses[0].Blocks() {
+				t.Errorf("Expected exhausted hard budget to block, got %+v", statuses[0])
+			}
+			if statuses[1].Blocks() {
+				t.Errorf("Expected soft budget never to block, got %+v", statuses[1])
+			}
+
+			if _, err := m.ResetBudget("team-a", BudgetWindowDaily); err != nil {
+				t.Fatalf("ResetBudget failed: %v", err)
+			}
+			statuses, _ = m.GetBudgetStatuses("team-a")
+			if statuses[0].Used != 0 || statuses[0].Blocks() {
+				t.Errorf("Expected reset budget to start from zero, got %+v", statuses[0])
+			}
+
+			if _, err := m.ResetBudget("team-b", BudgetWindowDaily); !errors.Is(err, ErrBudgetNotFound) {
+				t.Errorf("Expected ErrBudgetNotFound, got %v", err)
+			}
+
+			if err := m.DeleteBudget("team-a", BudgetWindowMonthly); err != nil {
+				t.Fatalf("DeleteBudget failed: %v", err)
+			}
+			budgets, _ := m.GetBudgets("")
+			if len(budgets) != 1 || budgets[0].Window != BudgetWindowDaily {
+				t.Errorf("Expected only the daily budget to remain, got %+v", budgets)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package accounting
 
 import (
 	"fmt"
+	"log/slog"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -10,12 +12,47 @@ import (
 // Manager manages cost accounting
 type Manager struct {
 	aggregator CostAggregator
+	retention  time.Duration
+	stop       chan struct{}
+	done       chan struct{}
 }
 
 // Config holds accounting configuration
 type Config struct {
 	UseSQLite bool
 	DBPath    string
+
+	// RetentionDays keeps raw SQLite records for this many days before they
+	// are compacted into daily aggregates; 0 keeps raw records forever
+	RetentionDays int
+
+	// CompactionInterval is how often compaction runs (default 1h)
+	CompactionInterval time.Duration
+}
+
+// Compactor is implemented by aggregators that can roll old records into
+// daily aggregates
+type Compactor interface {
+	Compact(before time.Time) (int64, error)
+}
+
+// ConfigFromEnv overrides config with USE_SQLITE, DB_PATH and
+// ACCOUNTING_RETENTION_DAYS when they are set
+func ConfigFromEnv(config Config) Config {
+	if value := os.Getenv("USE_SQLITE"); value != "" {
+		if useSQLite, err := strconv.ParseBool(value); err == nil {
+			config.UseSQLite = useSQLite
+		}
+	}
+	if value := os.Getenv("DB_PATH"); value != "" {
+		config.DBPath = value
+	}
+	if value := os.Getenv("ACCOUNTING_RETENTION_DAYS"); value != "" {
+		if days, err := strconv.Atoi(value); err == nil {
+			config.RetentionDays = days
+		}
+	}
+	return config
 }
 
 // NewManager creates a new accounting manager
@@ -24,6 +61,9 @@ func NewManager(config Config) (*Manager, error) {
 	var err error
 
 	if config.UseSQLite {
+		if config.DBPath == "" {
+			config.DBPath = "costs.db"
+		}
 		aggregator, err = NewSQLiteAggregator(config.DBPath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create SQLite aggregator: %w", err)
@@ -32,9 +72,54 @@ func NewManager(config Config) (*Manager, error) {
 		aggregator = NewMemoryAggregator()
 	}
 
-	return &Manager{
+	m := &Manager{
 		aggregator: aggregator,
-	}, nil
+		retention:  time.Duration(config.RetentionDays) * 24 * time.Hour,
+	}
+
+	if _, ok := aggregator.(Compactor); ok && m.retention > 0 {
+		interval := config.CompactionInterval
+		if interval <= 0 {
+			interval = time.Hour
+		}
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.compactLoop(interval)
+	}
+
+	return m, nil
+}
+
+// Compact rolls records older than the retention period into daily
+// aggregates. It is a no-op without retention or for in-memory accounting.
+func (m *Manager) Compact() (int64, error) {
+	compactor, ok := m.aggregator.(Compactor)
+	if !ok || m.retention <= 0 {
+		return 0, nil
+	}
+	return compactor.Compact(time.Now().Add(-m.retention))
+}
+
+// compactLoop compacts on start and then every interval until Close
+func (m *Manager) compactLoop(interval time.Duration) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if removed, err := m.Compact(); err != nil {
+			slog.Warn("cost compaction failed", "error", err)
+		} else if removed > 0 {
+			slog.Info("compacted cost records into daily aggregates", "records", removed)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.stop:
+			return
+		}
+	}
 }
 
 // RecordCost records a cost
@@ -67,8 +152,13 @@ func (m *Manager) ExportCosts(filter CostFilter, format ExportFormat) ([]byte, e
 	return m.aggregator.ExportCosts(filter, format)
 }
 
-// Close closes the manager
+// Close stops background compaction and closes the manager
 func (m *Manager) Close() error {
+	if m.stop != nil {
+		close(m.stop)
+		<-m.done
+		m.stop = nil
+	}
 	return m.aggregator.Close()
 }
 
@@ -13,6 +13,7 @@ import (
 // MemoryAggregator implements in-memory cost aggregation
 type MemoryAggregator struct {
 	records []CostRecord
+	budgets map[string]Budget
 	mu      sync.RWMutex
 }
 
@@ -20,6 +21,7 @@ type MemoryAggregator struct {
 func NewMemoryAggregator() *MemoryAggregator {
 	return &MemoryAggregator{
 		records: make([]CostRecord, 0),
+		budgets: make(map[string]Budget),
 	}
 }
 
@@ -168,6 +170,51 @@ func (m *MemoryAggregator) GetBudgetInfo(caller string, amount float64, currency
 	}, nil
 }
 
+// SetBudget creates or replaces the budget for a caller and window
+func (m *MemoryAggregator) SetBudget(budget Budget) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.budgets[budgetKey(budget.Caller, budget.Window)] = budget
+	return nil
+}
+
+// GetBudgets lists budgets, for a single caller if caller is not empty
+func (m *MemoryAggregator) GetBudgets(caller string) ([]Budget, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var budgets []Budget
+	for _, budget := range m.budgets {
+		if caller == "" || budget.Caller == caller {
+			budgets = append(budgets, budget)
+		}
+	}
+
+	sort.Slice(budgets, func(i, j int) bool {
+		if budgets[i].Caller != budgets[j].Caller {
+			return budgets[i].Caller < budgets[j].Caller
+		}
+		return budgets[i].Window < budgets[j].Window
+	})
+
+	return budgets, nil
+}
+
+// DeleteBudget removes the budget for a caller and window
+func (m *MemoryAggregator) DeleteBudget(caller string, window BudgetWindow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.budgets, budgetKey(caller, window))
+	return nil
+}
+
+// budgetKey identifies a caller's budget for a window
+func budgetKey(caller string, window BudgetWindow) string {
+	return caller + "|" + string(window)
+}
+
 // ExportCosts exports costs in specified format
 func (m *MemoryAggregator) ExportCosts(filter CostFilter, format ExportFormat) ([]byte, error) {
 	records, err := m.GetCosts(filter)
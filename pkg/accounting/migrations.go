@@ -0,0 +1,153 @@
+package accounting
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migration is a forward-only schema change. Versions start at 1 and are
+// tracked in SQLite's user_version pragma.
+type migration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// migrations lists every schema change in order. Never edit an entry once
+// released; append a new one instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create costs table",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS costs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				timestamp DATETIME NOT NULL,
+				caller TEXT NOT NULL,
+				provider TEXT NOT NULL,
+				model TEXT NOT NULL,
+				prompt_tokens INTEGER NOT NULL,
+				completion_tokens INTEGER NOT NULL,
+				currency TEXT NOT NULL,
+				cost_input REAL NOT NULL,
+				cost_output REAL NOT NULL,
+				cost_total REAL NOT NULL,
+				request_id TEXT,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_costs_timestamp ON costs(timestamp)`,
+			`CREATE INDEX IF NOT EXISTS idx_costs_caller ON costs(caller)`,
+			`CREATE INDEX IF NOT EXISTS idx_costs_provider ON costs(provider)`,
+			`CREATE INDEX IF NOT EXISTS idx_costs_model ON costs(model)`,
+			`CREATE INDEX IF NOT EXISTS idx_costs_currency ON costs(currency)`,
+		},
+	},
+	{
+		version:     2,
+		description: "create budgets table",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS budgets (
+				caller TEXT NOT NULL,
+				budget_window TEXT NOT NULL,
+				amount REAL NOT NULL,
+				currency TEXT NOT NULL,
+				alert_threshold REAL NOT NULL,
+				enforcement TEXT NOT NULL,
+				reset_at DATETIME,
+				updated_at DATETIME NOT NULL,
+				PRIMARY KEY (caller, budget_window)
+			)`,
+		},
+	},
+	{
+		version:     3,
+		description: "add request outcome columns to costs",
+		statements: []string{
+			`ALTER TABLE costs ADD COLUMN status TEXT NOT NULL DEFAULT 'success'`,
+			`ALTER TABLE costs ADD COLUMN attempt INTEGER NOT NULL DEFAULT 1`,
+			`ALTER TABLE costs ADD COLUMN error TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE costs ADD COLUMN latency_ms INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE costs ADD COLUMN cache_hit INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE costs ADD COLUMN strategy TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     4,
+		description: "create daily aggregates for compacted costs",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS daily_costs (
+				day TEXT NOT NULL,
+				caller TEXT NOT NULL,
+				provider TEXT NOT NULL,
+				model TEXT NOT NULL,
+				currency TEXT NOT NULL,
+				requests INTEGER NOT NULL,
+				errors INTEGER NOT NULL,
+				cache_hits INTEGER NOT NULL,
+				fallback_attempts INTEGER NOT NULL,
+				prompt_tokens INTEGER NOT NULL,
+				completion_tokens INTEGER NOT NULL,
+				cost_input REAL NOT NULL,
+				cost_output REAL NOT NULL,
+				cost_total REAL NOT NULL,
+				latency_ms_total INTEGER NOT NULL,
+				PRIMARY KEY (day, caller, provider, model, currency)
+			)`,
+		},
+	},
+	{
+		version:     5,
+		description: "record which request a deduplicated request shared",
+		statements: []string{
+			`ALTER TABLE costs ADD COLUMN deduplicated_from TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+}
+
+// schemaVersion returns the latest migration version
+func schemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate applies every migration newer than the database's user_version,
+// each in its own transaction
+func migrate(db *sql.DB) error {
+	var current int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if current > schemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", current, schemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", m.version, err)
+		}
+
+		for _, stmt := range m.statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+			}
+		}
+
+		// PRAGMA does not accept placeholders
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", m.version, err)
+		}
+	}
+
+	return nil
+}
@@ -11,6 +11,9 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// dayFormat is the layout of daily aggregate keys
+const dayFormat = "2006-01-02"
+
 // SQLiteAggregator implements SQLite-based cost aggregation
 type SQLiteAggregator struct {
 	db *sql.DB
@@ -25,55 +28,37 @@ func NewSQLiteAggregator(dbPath string) (*SQLiteAggregator, error) {
 
 	aggregator := &SQLiteAggregator{db: db}
 
-	// Create table if not exists
-	if err := aggregator.createTable(); err != nil {
-		return nil, fmt.Errorf("failed to create table: %w", err)
+	// Bring the schema up to date
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
 	return aggregator, nil
 }
 
-// createTable creates the costs table
-func (s *SQLiteAggregator) createTable() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS costs (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		timestamp DATETIME NOT NULL,
-		caller TEXT NOT NULL,
-		provider TEXT NOT NULL,
-		model TEXT NOT NULL,
-		prompt_tokens INTEGER NOT NULL,
-		completion_tokens INTEGER NOT NULL,
-		currency TEXT NOT NULL,
-		cost_input REAL NOT NULL,
-		cost_output REAL NOT NULL,
-		cost_total REAL NOT NULL,
-		request_id TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_costs_timestamp ON costs(timestamp);
-	CREATE INDEX IF NOT EXISTS idx_costs_caller ON costs(caller);
-	CREATE INDEX IF NOT EXISTS idx_costs_provider ON costs(provider);
-	CREATE INDEX IF NOT EXISTS idx_costs_model ON costs(model);
-	CREATE INDEX IF NOT EXISTS idx_costs_currency ON costs(currency);
-	`
-
-	_, err := s.db.Exec(query)
-	return err
-}
-
 // RecordCost records a cost
 func (s *SQLiteAggregator) RecordCost(record CostRecord) error {
 	query := `
 	INSERT INTO costs (
 		timestamp, caller, provider, model, prompt_tokens, completion_tokens,
-		currency, cost_input, cost_output, cost_total, request_id
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		currency, cost_input, cost_output, cost_total, request_id,
+		status, attempt, error, latency_ms, cache_hit, strategy, deduplicated_from
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
+	}
+	if record.Status == "" {
+		record.Status = "success"
+	}
+	if record.Attempt == 0 {
+		record.Attempt = 1
+	}
+
 	_, err := s.db.Exec(query,
-		record.Timestamp,
+		record.Timestamp.UTC(),
 		record.Caller,
 		record.Provider,
 		record.Model,
@@ -84,6 +69,13 @@ func (s *SQLiteAggregator) RecordCost(record CostRecord) error {
 		record.CostOutput,
 		record.CostTotal,
 		record.RequestID,
+		record.Status,
+		record.Attempt,
+		record.Error,
+		record.LatencyMS,
+		record.CacheHit,
+		record.Strategy,
+		record.DeduplicatedFrom,
 	)
 
 	return err
@@ -115,6 +107,13 @@ func (s *SQLiteAggregator) GetCosts(filter CostFilter) ([]CostRecord, error) {
 			&record.CostOutput,
 			&record.CostTotal,
 			&record.RequestID,
+			&record.Status,
+			&record.Attempt,
+			&record.Error,
+			&record.LatencyMS,
+			&record.CacheHit,
+			&record.Strategy,
+			&record.DeduplicatedFrom,
 		)
 		if err != nil {
 			return nil, err
@@ -152,10 +151,145 @@ func (s *SQLiteAggregator) GetCostSummary(filter CostFilter) (CostSummary, error
 		&summary.TotalCompletionTokens,
 		&summary.Currency,
 	)
+	if err != nil {
+		return summary, err
+	}
+
+	// Include days that have been compacted out of the raw table
+	compacted, err := s.getCompactedSummary(filter)
+	if err != nil {
+		return summary, err
+	}
+	summary.TotalRecords += compacted.TotalRecords
+	summary.TotalCost += compacted.TotalCost
+	summary.TotalInputCost += compacted.TotalInputCost
+	summary.TotalOutputCost += compacted.TotalOutputCost
+	summary.TotalPromptTokens += compacted.TotalPromptTokens
+	summary.TotalCompletionTokens += compacted.TotalCompletionTokens
+
+	return summary, nil
+}
+
+// getCompactedSummary sums daily aggregates matching the filter. Time bounds
+// are applied at day granularity since aggregates hold whole days.
+func (s *SQLiteAggregator) getCompactedSummary(filter CostFilter) (CostSummary, error) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.From != nil {
+		conditions = append(conditions, "day >= ?")
+		args = append(args, filter.From.UTC().Format(dayFormat))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "day <= ?")
+		args = append(args, filter.To.UTC().Format(dayFormat))
+	}
+	if filter.Caller != "" {
+		conditions = append(conditions, "caller = ?")
+		args = append(args, filter.Caller)
+	}
+	if filter.Provider != "" {
+		conditions = append(conditions, "provider = ?")
+		args = append(args, filter.Provider)
+	}
+	if filter.Model != "" {
+		conditions = append(conditions, "model = ?")
+		args = append(args, filter.Model)
+	}
+	if filter.Currency != "" {
+		conditions = append(conditions, "currency = ?")
+		args = append(args, filter.Currency)
+	}
+
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := fmt.Sprintf(`
+		SELECT
+			COALESCE(SUM(requests), 0),
+			COALESCE(SUM(cost_total), 0),
+			COALESCE(SUM(cost_input), 0),
+			COALESCE(SUM(cost_output), 0),
+			COALESCE(SUM(prompt_tokens), 0),
+			COALESCE(SUM(completion_tokens), 0)
+		FROM daily_costs
+		%s
+	`, whereClause)
+
+	var summary CostSummary
+	err := s.db.QueryRow(query, args...).Scan(
+		&summary.TotalRecords,
+		&summary.TotalCost,
+		&summary.TotalInputCost,
+		&summary.TotalOutputCost,
+		&summary.TotalPromptTokens,
+		&summary.TotalCompletionTokens,
+	)
 
 	return summary, err
 }
 
+// Compact rolls raw cost rows from before the UTC day containing before into
+// daily aggregates and deletes them. It returns the number of rows removed.
+func (s *SQLiteAggregator) Compact(before time.Time) (int64, error) {
+	// Only whole days are compacted so a day is never split between tables
+	cutoff := before.UTC().Truncate(24 * time.Hour)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Timestamps are stored in UTC, so the first 10 characters are the day
+	_, err = tx.Exec(`
+		INSERT INTO daily_costs (
+			day, caller, provider, model, currency, requests, errors, cache_hits,
+			fallback_attempts, prompt_tokens, completion_tokens, cost_input,
+			cost_output, cost_total, latency_ms_total
+		)
+		SELECT
+			substr(timestamp, 1, 10) AS day, caller, provider, model, currency,
+			COUNT(*),
+			SUM(CASE WHEN status = 'error' THEN 1 ELSE 0 END),
+			SUM(cache_hit),
+			SUM(CASE WHEN attempt > 1 THEN 1 ELSE 0 END),
+			SUM(prompt_tokens), SUM(completion_tokens),
+			SUM(cost_input), SUM(cost_output), SUM(cost_total),
+			SUM(latency_ms)
+		FROM costs
+		WHERE timestamp < ?
+		GROUP BY day, caller, provider, model, currency
+		ON CONFLICT (day, caller, provider, model, currency) DO UPDATE SET
+			requests = requests + excluded.requests,
+			errors = errors + excluded.errors,
+			cache_hits = cache_hits + excluded.cache_hits,
+			fallback_attempts = fallback_attempts + excluded.fallback_attempts,
+			prompt_tokens = prompt_tokens + excluded.prompt_tokens,
+			completion_tokens = completion_tokens + excluded.completion_tokens,
+			cost_input = cost_input + excluded.cost_input,
+			cost_output = cost_output + excluded.cost_output,
+			cost_total = cost_total + excluded.cost_total,
+			latency_ms_total = latency_ms_total + excluded.latency_ms_total
+	`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to aggregate costs: %w", err)
+	}
+
+	result, err := tx.Exec("DELETE FROM costs WHERE timestamp < ?", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete compacted costs: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
 // GetCostReport generates a cost report
 func (s *SQLiteAggregator) GetCostReport(filter CostFilter) (CostReport, error) {
 	records, err := s.GetCosts(filter)
@@ -222,6 +356,84 @@ func (s *SQLiteAggregator) GetBudgetInfo(caller string, amount float64, currency
 	}, nil
 }
 
+// SetBudget creates or replaces the budget for a caller and window
+func (s *SQLiteAggregator) SetBudget(budget Budget) error {
+	query := `
+	INSERT OR REPLACE INTO budgets (
+		caller, budget_window, amount, currency, alert_threshold, enforcement, reset_at, updated_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	var resetAt interface{}
+	if budget.ResetAt != nil {
+		resetAt = budget.ResetAt.UTC()
+	}
+
+	_, err := s.db.Exec(query,
+		budget.Caller,
+		string(budget.Window),
+		budget.Amount,
+		budget.Currency,
+		budget.AlertThreshold,
+		string(budget.Enforcement),
+		resetAt,
+		budget.UpdatedAt.UTC(),
+	)
+
+	return err
+}
+
+// GetBudgets lists budgets, for a single caller if caller is not empty
+func (s *SQLiteAggregator) GetBudgets(caller string) ([]Budget, error) {
+	query := `
+		SELECT caller, budget_window, amount, currency, alert_threshold, enforcement, reset_at, updated_at
+		FROM budgets
+	`
+	var args []interface{}
+	if caller != "" {
+		query += " WHERE caller = ?"
+		args = append(args, caller)
+	}
+	query += " ORDER BY caller, budget_window"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var budgets []Budget
+	for rows.Next() {
+		var budget Budget
+		var resetAt sql.NullTime
+		err := rows.Scan(
+			&budget.Caller,
+			&budget.Window,
+			&budget.Amount,
+			&budget.Currency,
+			&budget.AlertThreshold,
+			&budget.Enforcement,
+			&resetAt,
+			&budget.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if resetAt.Valid {
+			budget.ResetAt = &resetAt.Time
+		}
+		budgets = append(budgets, budget)
+	}
+
+	return budgets, rows.Err()
+}
+
+// DeleteBudget removes the budget for a caller and window
+func (s *SQLiteAggregator) DeleteBudget(caller string, window BudgetWindow) error {
+	_, err := s.db.Exec("DELETE FROM budgets WHERE caller = ? AND budget_window = ?", caller, string(window))
+	return err
+}
+
 // ExportCosts exports costs in specified format
 func (s *SQLiteAggregator) ExportCosts(filter CostFilter, format ExportFormat) ([]byte, error) {
 	records, err := s.GetCosts(filter)
@@ -246,7 +458,8 @@ func (s *SQLiteAggregator) buildQuery(filter CostFilter) (string, []interface{})
 	query := fmt.Sprintf(`
 		SELECT 
 			id, timestamp, caller, provider, model, prompt_tokens, completion_tokens,
-			currency, cost_input, cost_output, cost_total, request_id
+			currency, cost_input, cost_output, cost_total, request_id,
+			status, attempt, error, latency_ms, cache_hit, strategy, deduplicated_from
 		FROM costs
 		%s
 		ORDER BY timestamp DESC
@@ -270,11 +483,11 @@ func (s *SQLiteAggregator) buildWhereClause(filter CostFilter) (string, []interf
 
 	if filter.From != nil {
 		conditions = append(conditions, "timestamp >= ?")
-		args = append(args, *filter.From)
+		args = append(args, filter.From.UTC())
 	}
 	if filter.To != nil {
 		conditions = append(conditions, "timestamp <= ?")
-		args = append(args, *filter.To)
+		args = append(args, filter.To.UTC())
 	}
 	if filter.Caller != "" {
 		conditions = append(conditions, "caller = ?")
@@ -0,0 +1,160 @@
+package accounting
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLiteMigratesLegacySchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "costs.db")
+
+	// Schema as created before versioned migrations existed
+	legacy, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	_, err = legacy.Exec(`
+		CREATE TABLE costs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp DATETIME NOT NULL,
+			caller TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			model TEXT NOT NULL,
+			prompt_tokens INTEGER NOT NULL,
+			completion_tokens INTEGER NOT NULL,
+			currency TEXT NOT NULL,
+			cost_input REAL NOT NULL,
+			cost_output REAL NOT NULL,
+			cost_total REAL NOT NULL,
+			request_id TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO costs (timestamp, caller, provider, model, prompt_tokens, completion_tokens, currency, cost_input, cost_output, cost_total, request_id)
+		VALUES ('2024-01-01 10:00:00+00:00', 'legacy', 'openai', 'gpt', 10, 20, 'USD', 0.1, 0.2, 0.3, 'req-1');
+	`)
+	legacy.Close()
+	if err != nil {
+		t.Fatalf("failed to create legacy schema: %v", err)
+	}
+
+	aggregator, err := NewSQLiteAggregator(dbPath)
+	if err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	var version int
+	aggregator.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if version != schemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", schemaVersion(), version)
+	}
+
+	records, err := aggregator.GetCosts(CostFilter{})
+	if err != nil {
+		t.Fatalf("GetCosts failed: %v", err)
+	}
+	if len(records) != 1 || records[0].Caller != "legacy" || records[0].Status != "success" || records[0].Attempt != 1 {
+		t.Errorf("Expected legacy row with defaulted columns, got %+v", records)
+	}
+
+	// Reopening an up-to-date database is a no-op
+	aggregator.Close()
+	reopened, err := NewSQLiteAggregator(dbPath)
+	if err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	reopened.Close()
+}
+
+func TestSQLiteRecordsOutcomeColumns(t *testing.T) {
+	aggregator, err := NewSQLiteAggregator(filepath.Join(t.TempDir(), "costs.db"))
+	if err != nil {
+		t.Fatalf("failed to create aggregator: %v", err)
+	}
+	defer aggregator.Close()
+
+	err = aggregator.RecordCost(CostRecord{
+		Timestamp:        time.Now(),
+		Caller:           "team-a",
+		Provider:         "ollama",
+		Model:            "ollama:llama3.2",
+		Currency:         "USD",
+		Status:           "error",
+		Attempt:          2,
+		Error:            "upstream unavailable",
+		LatencyMS:        120,
+		CacheHit:         true,
+		Strategy:         "adaptive",
+		DeduplicatedFrom: "req-1",
+	})
+	if err != nil {
+		t.Fatalf("RecordCost failed: %v", err)
+	}
+
+	records, _ := aggregator.GetCosts(CostFilter{})
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, got %d", len(records))
+	}
+	r := records[0]
+	if r.Status != "error" || r.Attempt != 2 || r.Error != "upstream unavailable" || r.LatencyMS != 120 || !r.CacheHit || r.Strategy != "adaptive" || r.DeduplicatedFrom != "req-1" {
+		t.Errorf("Outcome columns did not round-trip: %+v", r)
+	}
+}
+
+func TestSQLiteCompaction(t *testing.T) {
+	aggregator, err := NewSQLiteAggregator(filepath.Join(t.TempDir(), "costs.db"))
+	if err != nil {
+		t.Fatalf("failed to create aggregator: %v", err)
+	}
+	defer aggregator.Close()
+
+	now := time.Now().UTC()
+	old := now.AddDate(0, 0, -40)
+	records := []CostRecord{
+		{Timestamp: old, Caller: "a", Provider: "p", Model: "m", Currency: "USD", PromptTokens: 10, CostTotal: 1.0, LatencyMS: 100},
+		{Timestamp: old.Add(time.Minute), Caller: "a", Provider: "p", Model: "m", Currency: "USD", PromptTokens: 5, CostTotal: 0.5, Status: "error", Attempt: 1},
+		{Timestamp: old.Add(2 * time.Minute), Caller: "a", Provider: "p", Model: "m", Currency: "USD", PromptTokens: 5, CostTotal: 0.25, Attempt: 2},
+		{Timestamp: now, Caller: "a", Provider: "p", Model: "m", Currency: "USD", PromptTokens: 1, CostTotal: 2.0},
+	}
+	for _, r := range records {
+		if err := aggregator.RecordCost(r); err != nil {
+			t.Fatalf("RecordCost failed: %v", err)
+		}
+	}
+
+	before, _ := aggregator.GetCostSummary(CostFilter{Caller: "a"})
+
+	removed, err := aggregator.Compact(now.AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("Expected 3 raw rows compacted, got %d", removed)
+	}
+
+	raw, _ := aggregator.GetCosts(CostFilter{})
+	if len(raw) != 1 {
+		t.Errorf("Expected only the recent raw row to remain, got %d", len(raw))
+	}
+
+	after, _ := aggregator.GetCostSummary(CostFilter{Caller: "a"})
+	if after.TotalCost != before.TotalCost || after.TotalPromptTokens != before.TotalPromptTokens || after.TotalRecords != before.TotalRecords {
+		t.Errorf("Expected summary to survive compaction: before %+v, after %+v", before, after)
+	}
+
+	var requests, errors, fallbacks, latency int64
+	aggregator.db.QueryRow("SELECT requests, errors, fallback_attempts, latency_ms_total FROM daily_costs").Scan(&requests, &errors, &fallbacks, &latency)
+	if requests != 3 || errors != 1 || fallbacks != 1 || latency != 100 {
+		t.Errorf("Unexpected daily aggregate: requests=%d errors=%d fallbacks=%d latency=%d", requests, errors, fallbacks, latency)
+	}
+
+	// Compacting again folds nothing new in
+	if removed, _ := aggregator.Compact(now.AddDate(0, 0, -30)); removed != 0 {
+		t.Errorf("Expected second compaction to be a no-op, got %d", removed)
+	}
+	recent, _ := aggregator.GetCostSummary(CostFilter{Caller: "a", From: &now})
+	if recent.TotalCost != 2.0 {
+		t.Errorf("Expected today's spend to exclude compacted days, got %v", recent.TotalCost)
+	}
+}
@@ -18,6 +18,13 @@ type CostRecord struct {
 	CostOutput       float64   `json:"cost_output" db:"cost_output"`
 	CostTotal        float64   `json:"cost_total" db:"cost_total"`
 	RequestID        string    `json:"request_id" db:"request_id"`
+	Status           string    `json:"status,omitempty" db:"status"`   // success|error
+	Attempt          int       `json:"attempt,omitempty" db:"attempt"` // 1-based position in the fallback chain
+	Error            string    `json:"error,omitempty" db:"error"`
+	LatencyMS        int64     `json:"latency_ms,omitempty" db:"latency_ms"`
+	CacheHit         bool      `json:"cache_hit,omitempty" db:"cache_hit"`
+	Strategy         string    `json:"strategy,omitempty" db:"strategy"`                   // routing strategy that picked the model
+	DeduplicatedFrom string    `json:"deduplicated_from,omitempty" db:"deduplicated_from"` // request ID whose in-flight result was reused
 }
 
 // CostSummary represents aggregated cost data
@@ -58,6 +65,45 @@ type BudgetInfo struct {
 	Exceeded  bool    `json:"exceeded"`
 }
 
+// BudgetWindow is the period over which a budget's spend is measured
+type BudgetWindow string
+
+const (
+	BudgetWindowDaily   BudgetWindow = "daily"
+	BudgetWindowMonthly BudgetWindow = "monthly"
+)
+
+// BudgetEnforcement decides what happens once a budget is exhausted
+type BudgetEnforcement string
+
+const (
+	BudgetEnforcementHard BudgetEnforcement = "hard" // reject further requests
+	BudgetEnforcementSoft BudgetEnforcement = "soft" // allow requests but flag them
+)
+
+// Budget is a spending limit configured for a caller over a window
+type Budget struct {
+	Caller         string            `json:"caller" db:"caller"`
+	Window         BudgetWindow      `json:"window" db:"window"`
+	Amount         float64           `json:"amount" db:"amount"`
+	Currency       string            `json:"currency" db:"currency"`
+	AlertThreshold float64           `json:"alert_threshold" db:"alert_threshold"` // fraction of Amount, e.g. 0.8
+	Enforcement    BudgetEnforcement `json:"enforcement" db:"enforcement"`
+	ResetAt        *time.Time        `json:"reset_at,omitempty" db:"reset_at"` // spend before this is ignored
+	UpdatedAt      time.Time         `json:"updated_at" db:"updated_at"`
+}
+
+// BudgetStatus reports a budget's spend in its current window
+type BudgetStatus struct {
+	Budget
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	Used        float64   `json:"used"`
+	Remaining   float64   `json:"remaining"`
+	Alert       bool      `json:"alert"`
+	Exceeded    bool      `json:"exceeded"`
+}
+
 // CostFilter represents filters for cost queries
 type CostFilter struct {
 	From     *time.Time `json:"from,omitempty"`
@@ -96,6 +142,15 @@ type CostAggregator interface {
 	// GetBudgetInfo gets budget information for a caller
 	GetBudgetInfo(caller string, amount float64, currency string) (BudgetInfo, error)
 
+	// SetBudget creates or replaces the budget for a caller and window
+	SetBudget(budget Budget) error
+
+	// GetBudgets lists budgets, for a single caller if caller is not empty
+	GetBudgets(caller string) ([]Budget, error)
+
+	// DeleteBudget removes the budget for a caller and window
+	DeleteBudget(caller string, window BudgetWindow) error
+
 	// ExportCosts exports costs in specified format
 	ExportCosts(filter CostFilter, format ExportFormat) ([]byte, error)
 
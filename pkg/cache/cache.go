@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/snow-ghost/agent/pkg/router/core"
+)
+
+// Cache is a response cache backend
+type Cache interface {
+	// Get retrieves an unexpired entry
+	Get(key CacheKey) (*CacheEntry, bool)
+
+	// Set stores a response for ttl, or the default TTL if ttl <= 0
+	Set(key CacheKey, response core.ChatResponse, ttl time.Duration) error
+
+	// Delete removes an entry
+	Delete(key CacheKey)
+
+	// Clear removes all entries
+	Clear()
+
+	// Keys returns the keys of all entries
+	Keys() []CacheKey
+
+	// Len returns the number of entries
+	Len() int
+
+	// Stats returns cache statistics
+	Stats() CacheStats
+
+	// Close releases the backend's resources
+	Close()
+}
+
+// Cache backends
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+// NewCache creates the cache backend selected by config.Backend
+func NewCache(config *CacheConfig) (Cache, error) {
+	if config == nil {
+		config = DefaultCacheConfig()
+	}
+
+	switch config.Backend {
+	case "", BackendMemory:
+		return NewLRUCache(config)
+	case BackendRedis:
+		return NewRedisCache(config)
+	default:
+		return nil, fmt.Errorf("unknown cache backend: %s", config.Backend)
+	}
+}
+
+// CacheConfigFromEnv overrides config with CACHE_BACKEND, CACHE_MAX_SIZE,
+// CACHE_DEFAULT_TTL, REDIS_ADDR, REDIS_PASSWORD, REDIS_DB,
+// REDIS_DEDICATED_DB and CACHE_KEY_PREFIX when they are set.
+// REDIS_DEDICATED_DB=true declares that REDIS_DB holds nothing but the
+// cache, so that its size can be read with DBSIZE; otherwise the size is
+// reported as unknown.
+func CacheConfigFromEnv(config *CacheConfig) *CacheConfig {
+	if value := os.Getenv("CACHE_BACKEND"); value != "" {
+		config.Backend = value
+	}
+	if value := os.Getenv("CACHE_MAX_SIZE"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size > 0 {
+			config.MaxSize = size
+		}
+	}
+	if value := os.Getenv("CACHE_DEFAULT_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil && ttl > 0 {
+			config.DefaultTTL = ttl
+		}
+	}
+	if value := os.Getenv("REDIS_ADDR"); value != "" {
+		config.Redis.Addr = value
+	}
+	if value := os.Getenv("REDIS_PASSWORD"); value != "" {
+		config.Redis.Password = value
+	}
+	if value := os.Getenv("REDIS_DB"); value != "" {
+		if db, err := strconv.Atoi(value); err == nil {
+			config.Redis.DB = db
+		}
+	}
+	if value := os.Getenv("REDIS_DEDICATED_DB"); value != "" {
+		if dedicated, err := strconv.ParseBool(value); err == nil {
+			config.Redis.DedicatedDB = dedicated
+		}
+	}
+	if value := os.Getenv("CACHE_KEY_PREFIX"); value != "" {
+		config.Redis.KeyPrefix = value
+	}
+	return config
+}
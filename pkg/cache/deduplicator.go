@@ -2,18 +2,31 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/hashicorp/golang-lru/v2/simplelru"
 	"github.com/snow-ghost/agent/pkg/router/core"
 	"golang.org/x/sync/singleflight"
 )
 
-// Deduplicator handles in-flight request deduplication
+// keyStatsLimit is how many keys the deduplicator keeps statistics for
+const keyStatsLimit = 1024
+
+// Deduplicator handles in-flight request deduplication. Statistics are kept
+// as totals over all keys and, for the keyStatsLimit most recently used
+// keys, per key, so they take bounded memory however many distinct requests
+// pass through.
 type Deduplicator struct {
-	group singleflight.Group
-	mu    sync.RWMutex
-	stats map[CacheKey]*DedupStats
+	group        singleflight.Group
+	requests     atomic.Int64
+	deduplicated atomic.Int64
+	cacheHits    atomic.Int64
+
+	mu   sync.Mutex
+	keys *simplelru.LRU[CacheKey, *DedupStats]
 }
 
 // DedupStats represents deduplication statistics
@@ -23,42 +36,100 @@ type DedupStats struct {
 	CacheHits    int64 `json:"cache_hits"`
 }
 
+// DedupRate returns the fraction of requests that shared an in-flight result
+func (s DedupStats) DedupRate() float64 {
+	if s.Requests == 0 {
+		return 0.0
+	}
+	return float64(s.Deduplicated) / float64(s.Requests)
+}
+
+// CacheHitRate returns the fraction of requests answered from the cache
+func (s DedupStats) CacheHitRate() float64 {
+	if s.Requests == 0 {
+		return 0.0
+	}
+	return float64(s.CacheHits) / float64(s.Requests)
+}
+
 // NewDeduplicator creates a new deduplicator
 func NewDeduplicator() *Deduplicator {
-	return &Deduplicator{
-		stats: make(map[CacheKey]*DedupStats),
-	}
+	keys, _ := simplelru.NewLRU[CacheKey, *DedupStats](keyStatsLimit, nil)
+	return &Deduplicator{keys: keys}
 }
 
-// Execute executes a function with deduplication
-func (d *Deduplicator) Execute(ctx context.Context, key CacheKey, fn func() (core.ChatResponse, error)) (core.ChatResponse, error) {
-	// Update stats
+// SharedResult is the outcome of a call that may have been collapsed into
+// an identical in-flight call
+type SharedResult struct {
+	Value interface{}
+
+	// LeaderID is the request ID of the call that did the work
+	LeaderID string
+
+	// Deduplicated is true if this call reused another call's result
+	Deduplicated bool
+}
+
+// sharedValue carries the leader's identity through singleflight
+type sharedValue struct {
+	value    interface{}
+	leaderID string
+}
+
+// ExecuteShared collapses concurrent calls with the same key into one call of
+// fn. The first caller runs fn; callers that arrive while it is in flight wait
+// for and share its value and error. A waiting caller gives up when its own
+// context is done, and runs fn itself if the leader was cancelled by its
+// context while the waiting caller's context is still live.
+func (d *Deduplicator) ExecuteShared(ctx context.Context, key CacheKey, requestID string, fn func() (interface{}, error)) (SharedResult, error) {
 	d.updateStats(key, false, false)
 
-	// Use singleflight to deduplicate concurrent requests
-	result, err, shared := d.group.Do(string(key), func() (interface{}, error) {
-		return fn()
+	ran := false
+	resultCh := d.group.DoChan(string(key), func() (interface{}, error) {
+		ran = true
+		value, err := fn()
+		return sharedValue{value: value, leaderID: requestID}, err
 	})
 
-	if err != nil {
-		return core.ChatResponse{}, err
+	var result singleflight.Result
+	select {
+	case result = <-resultCh:
+	case <-ctx.Done():
+		return SharedResult{}, ctx.Err()
 	}
 
-	response := result.(core.ChatResponse)
+	shared, _ := result.Val.(sharedValue)
+	if ran {
+		return SharedResult{Value: shared.value, LeaderID: requestID}, result.Err
+	}
 
-	// Update stats based on whether this was a shared result
-	if shared {
-		d.updateStats(key, true, false)
+	if isContextError(result.Err) && ctx.Err() == nil {
+		// The leader's client went away; this request still wants an answer
+		value, err := fn()
+		return SharedResult{Value: value, LeaderID: requestID}, err
 	}
 
-	return response, nil
+	d.updateStats(key, true, false)
+	return SharedResult{Value: shared.value, LeaderID: shared.leaderID, Deduplicated: true}, result.Err
+}
+
+// Execute executes a function with deduplication
+func (d *Deduplicator) Execute(ctx context.Context, key CacheKey, fn func() (core.ChatResponse, error)) (core.ChatResponse, error) {
+	result, err := d.ExecuteShared(ctx, key, "", func() (interface{}, error) {
+		return fn()
+	})
+	if err != nil {
+		return core.ChatResponse{}, err
+	}
+
+	return result.Value.(core.ChatResponse), nil
 }
 
 // ExecuteWithCache executes a function with both deduplication and caching
 func (d *Deduplicator) ExecuteWithCache(
 	ctx context.Context,
 	key CacheKey,
-	cache *LRUCache,
+	cache Cache,
 	ttl time.Duration,
 	fn func() (core.ChatResponse, error),
 ) (core.ChatResponse, error) {
@@ -70,11 +141,7 @@ func (d *Deduplicator) ExecuteWithCache(
 		}
 	}
 
-	// Update stats
-	d.updateStats(key, false, false)
-
-	// Use singleflight to deduplicate concurrent requests
-	result, err, shared := d.group.Do(string(key), func() (interface{}, error) {
+	result, err := d.ExecuteShared(ctx, key, "", func() (interface{}, error) {
 		response, err := fn()
 		if err != nil {
 			return nil, err
@@ -87,34 +154,46 @@ func (d *Deduplicator) ExecuteWithCache(
 
 		return response, nil
 	})
-
 	if err != nil {
 		return core.ChatResponse{}, err
 	}
 
-	response := result.(core.ChatResponse)
-
-	// Update stats based on whether this was a shared result
-	if shared {
-		d.updateStats(key, true, false)
-	}
+	return result.Value.(core.ChatResponse), nil
+}
 
-	return response, nil
+// isContextError reports whether err is a context cancellation or deadline
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
 }
 
-// updateStats updates deduplication statistics
+// updateStats counts a request, or with deduplicated set the reuse of an
+// in-flight result by a request already counted
 func (d *Deduplicator) updateStats(key CacheKey, deduplicated, cacheHit bool) {
+	if !deduplicated {
+		d.requests.Add(1)
+	} else {
+		d.deduplicated.Add(1)
+	}
+	if cacheHit {
+		d.cacheHits.Add(1)
+	}
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	stats, exists := d.stats[key]
+	stats, exists := d.keys.Get(key)
 	if !exists {
+		if deduplicated {
+			// The key's request was evicted from the per-key statistics
+			return
+		}
 		stats = &DedupStats{}
-		d.stats[key] = stats
+		d.keys.Add(key, stats)
 	}
 
-	stats.Requests++
-	if deduplicated {
+	if !deduplicated {
+		stats.Requests++
+	} else {
 		stats.Deduplicated++
 	}
 	if cacheHit {
@@ -122,33 +201,40 @@ func (d *Deduplicator) updateStats(key CacheKey, deduplicated, cacheHit bool) {
 	}
 }
 
-// GetStats returns deduplication statistics for a key
+// Stats returns deduplication statistics totalled over all keys
+func (d *Deduplicator) Stats() DedupStats {
+	return DedupStats{
+		Requests:     d.requests.Load(),
+		Deduplicated: d.deduplicated.Load(),
+		CacheHits:    d.cacheHits.Load(),
+	}
+}
+
+// GetStats returns deduplication statistics for a key, which are empty once
+// the key is no longer among the most recently used
 func (d *Deduplicator) GetStats(key CacheKey) *DedupStats {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
-
-	if stats, exists := d.stats[key]; exists {
-		return &DedupStats{
-			Requests:     stats.Requests,
-			Deduplicated: stats.Deduplicated,
-			CacheHits:    stats.CacheHits,
-		}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if stats, exists := d.keys.Peek(key); exists {
+		copied := *stats
+		return &copied
 	}
 
 	return &DedupStats{}
 }
 
-// GetAllStats returns all deduplication statistics
+// GetAllStats returns the deduplication statistics of the most recently
+// used keys
 func (d *Deduplicator) GetAllStats() map[CacheKey]*DedupStats {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
-
-	result := make(map[CacheKey]*DedupStats)
-	for key, stats := range d.stats {
-		result[key] = &DedupStats{
-			Requests:     stats.Requests,
-			Deduplicated: stats.Deduplicated,
-			CacheHits:    stats.CacheHits,
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result := make(map[CacheKey]*DedupStats, d.keys.Len())
+	for _, key := range d.keys.Keys() {
+		if stats, exists := d.keys.Peek(key); exists {
+			copied := *stats
+			result[key] = &copied
 		}
 	}
 
@@ -157,34 +243,30 @@ func (d *Deduplicator) GetAllStats() map[CacheKey]*DedupStats {
 
 // Reset resets all statistics
 func (d *Deduplicator) Reset() {
+	d.requests.Store(0)
+	d.deduplicated.Store(0)
+	d.cacheHits.Store(0)
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	d.stats = make(map[CacheKey]*DedupStats)
+	d.keys.Purge()
 }
 
-// ResetKey resets statistics for a specific key
+// ResetKey resets statistics for a specific key. The totals are kept.
 func (d *Deduplicator) ResetKey(key CacheKey) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	delete(d.stats, key)
+	d.keys.Remove(key)
 }
 
 // GetDedupRate calculates the deduplication rate for a key
 func (d *Deduplicator) GetDedupRate(key CacheKey) float64 {
-	stats := d.GetStats(key)
-	if stats.Requests == 0 {
-		return 0.0
-	}
-	return float64(stats.Deduplicated) / float64(stats.Requests)
+	return d.GetStats(key).DedupRate()
 }
 
 // GetCacheHitRate calculates the cache hit rate for a key
 func (d *Deduplicator) GetCacheHitRate(key CacheKey) float64 {
-	stats := d.GetStats(key)
-	if stats.Requests == 0 {
-		return 0.0
-	}
-	return float64(stats.CacheHits) / float64(stats.Requests)
+	return d.GetStats(key).CacheHitRate()
 }
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -190,3 +191,131 @@ func TestDeduplicatorRates(t *testing.T) {
 		t.Errorf("Cache hit rate should be between 0 and 1, got %f", cacheHitRate)
 	}
 }
+
+func TestDeduplicatorExecuteShared(t *testing.T) {
+	dedup := NewDeduplicator()
+	key := CacheKey("shared-key")
+
+	var calls int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]SharedResult)
+
+	numRequests := 5
+	for i := 0; i < numRequests; i++ {
+		wg.Add(1)
+		go func(requestID string) {
+			defer wg.Done()
+
+			result, err := dedup.ExecuteShared(context.Background(), key, requestID, func() (interface{}, error) {
+				mu.Lock()
+				calls++
+				mu.Unlock()
+				time.Sleep(100 * time.Millisecond)
+				return "shared", nil
+			})
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+
+			mu.Lock()
+			results[requestID] = result
+			mu.Unlock()
+		}(string(rune('a' + i)))
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("Expected fn to run once, ran %d times", calls)
+	}
+
+	var leaders []string
+	for requestID, result := range results {
+		if result.Value != "shared" {
+			t.Errorf("Request %s: expected shared value, got %v", requestID, result.Value)
+		}
+		if !result.Deduplicated {
+			leaders = append(leaders, requestID)
+		}
+	}
+	if len(leaders) != 1 {
+		t.Fatalf("Expected exactly one leader, got %v", leaders)
+	}
+	for requestID, result := range results {
+		if result.LeaderID != leaders[0] {
+			t.Errorf("Request %s: expected leader %s, got %s", requestID, leaders[0], result.LeaderID)
+		}
+	}
+
+	stats := dedup.GetStats(key)
+	if stats.Requests != int64(numRequests) || stats.Deduplicated != int64(numRequests-1) {
+		t.Errorf("Expected %d requests and %d deduplicated, got %+v", numRequests, numRequests-1, stats)
+	}
+}
+
+func TestDeduplicatorLeaderCancelled(t *testing.T) {
+	dedup := NewDeduplicator()
+	key := CacheKey("cancel-key")
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dedup.ExecuteShared(leaderCtx, key, "leader", func() (interface{}, error) {
+			close(started)
+			<-leaderCtx.Done()
+			return nil, leaderCtx.Err()
+		})
+	}()
+
+	<-started
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	// The follower's context is live, so it runs the call itself
+	result, err := dedup.ExecuteShared(context.Background(), key, "follower", func() (interface{}, error) {
+		return "own", nil
+	})
+	wg.Wait()
+
+	if err != nil {
+		t.Fatalf("Expected follower to succeed, got %v", err)
+	}
+	if result.Value != "own" || result.Deduplicated || result.LeaderID != "follower" {
+		t.Errorf("Expected follower to run its own call, got %+v", result)
+	}
+}
+
+func TestDeduplicatorTotals(t *testing.T) {
+	dedup := NewDeduplicator()
+
+	// More keys than are kept per key
+	for i := 0; i < keyStatsLimit+10; i++ {
+		dedup.Execute(context.Background(), CacheKey(fmt.Sprintf("key-%d", i)), func() (core.ChatResponse, error) {
+			return core.ChatResponse{Text: "test"}, nil
+		})
+	}
+
+	if stats := dedup.Stats(); stats.Requests != keyStatsLimit+10 {
+		t.Errorf("Expected totals over every key, got %+v", stats)
+	}
+	if n := len(dedup.GetAllStats()); n != keyStatsLimit {
+		t.Errorf("Expected statistics for %d keys, got %d", keyStatsLimit, n)
+	}
+	if stats := dedup.GetStats("key-0"); stats.Requests != 0 {
+		t.Errorf("Expected the oldest key to be evicted, got %+v", stats)
+	}
+	if stats := dedup.GetStats(CacheKey(fmt.Sprintf("key-%d", keyStatsLimit+9))); stats.Requests != 1 {
+		t.Errorf("Expected the newest key to be kept, got %+v", stats)
+	}
+
+	dedup.ResetKey(CacheKey(fmt.Sprintf("key-%d", keyStatsLimit+9)))
+	if stats := dedup.Stats(); stats.Requests != keyStatsLimit+10 {
+		t.Errorf("Expected ResetKey to keep the totals, got %+v", stats)
+	}
+}
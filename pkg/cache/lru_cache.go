@@ -20,11 +20,22 @@ type LRUCache struct {
 
 // NewLRUCache creates a new LRU cache
 func NewLRUCache(config *CacheConfig) (*LRUCache, error) {
+	return NewLRUCacheWithEvict(config, nil)
+}
+
+// NewLRUCacheWithEvict creates a new LRU cache that calls onEvict with the
+// key of every entry that leaves it, whether evicted, expired, deleted or
+// cleared. onEvict runs with the cache locked and must not call back into it.
+func NewLRUCacheWithEvict(config *CacheConfig, onEvict func(CacheKey)) (*LRUCache, error) {
 	if config == nil {
 		config = DefaultCacheConfig()
 	}
 
-	cache, err := lru.New[CacheKey, *CacheEntry](config.MaxSize)
+	var evict func(CacheKey, *CacheEntry)
+	if onEvict != nil {
+		evict = func(key CacheKey, _ *CacheEntry) { onEvict(key) }
+	}
+	cache, err := lru.NewWithEvict[CacheKey, *CacheEntry](config.MaxSize, evict)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create LRU cache: %w", err)
 	}
@@ -44,8 +55,9 @@ func NewLRUCache(config *CacheConfig) (*LRUCache, error) {
 
 // Get retrieves a value from the cache
 func (c *LRUCache) Get(key CacheKey) (*CacheEntry, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	// Write lock: a lookup updates stats and may remove an expired entry
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	entry, exists := c.cache.Get(key)
 	if !exists {
@@ -67,8 +79,8 @@ func (c *LRUCache) Get(key CacheKey) (*CacheEntry, bool) {
 	return entry, true
 }
 
-// Set stores a value in the cache
-func (c *LRUCache) Set(key CacheKey, response core.ChatResponse, ttl time.Duration) {
+// Set stores a value in the cache; it never fails
+func (c *LRUCache) Set(key CacheKey, response core.ChatResponse, ttl time.Duration) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -98,6 +110,7 @@ func (c *LRUCache) Set(key CacheKey, response core.ChatResponse, ttl time.Durati
 
 	c.cache.Add(key, entry)
 	c.stats.Size = c.cache.Len()
+	return nil
 }
 
 // Delete removes a value from the cache
@@ -190,8 +203,8 @@ func (c *LRUCache) GetWithTTL(key CacheKey) (*CacheEntry, time.Duration, bool) {
 }
 
 // SetWithTTL stores a value with a specific TTL
-func (c *LRUCache) SetWithTTL(key CacheKey, response core.ChatResponse, ttl time.Duration) {
-	c.Set(key, response, ttl)
+func (c *LRUCache) SetWithTTL(key CacheKey, response core.ChatResponse, ttl time.Duration) error {
+	return c.Set(key, response, ttl)
 }
 
 // Keys returns all cache keys
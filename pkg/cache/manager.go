@@ -9,14 +9,20 @@ import (
 
 // CacheManager manages caching and deduplication
 type CacheManager struct {
-	cache        *LRUCache
+	cache        Cache
 	deduplicator *Deduplicator
+	semantic     *SemanticCache
 	config       *CacheConfig
 }
 
-// NewCacheManager creates a new cache manager
+// NewCacheManager creates a new cache manager over the backend selected by
+// config.Backend
 func NewCacheManager(config *CacheConfig) (*CacheManager, error) {
-	cache, err := NewLRUCache(config)
+	if config == nil {
+		config = DefaultCacheConfig()
+	}
+
+	cache, err := NewCache(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cache: %w", err)
 	}
@@ -55,6 +61,45 @@ func (cm *CacheManager) ExecuteWithCache(
 	return cm.deduplicator.ExecuteWithCache(ctx, key, cm.cache, ttl, fn)
 }
 
+// Deduplicate collapses identical in-flight requests sharing key into a single
+// call of fn. requestID identifies the caller as leader to the requests that
+// share its result.
+func (cm *CacheManager) Deduplicate(ctx context.Context, key CacheKey, requestID string, fn func() (interface{}, error)) (SharedResult, error) {
+	return cm.deduplicator.ExecuteShared(ctx, key, requestID, fn)
+}
+
+// EnableSemanticCache adds a semantic layer consulted after exact misses
+func (cm *CacheManager) EnableSemanticCache(semantic *SemanticCache) {
+	cm.semantic = semantic
+}
+
+// SemanticEnabled reports whether a semantic layer is configured
+func (cm *CacheManager) SemanticEnabled() bool {
+	return cm.semantic != nil
+}
+
+// GetSemantic finds a cached response for a similar prompt. A threshold below
+// the semantic cache's own, including zero, uses the semantic cache's.
+func (cm *CacheManager) GetSemantic(ctx context.Context, req CacheRequest, threshold float64) (*SemanticHit, bool, error) {
+	if !req.Cache || cm.semantic == nil {
+		return nil, false, nil
+	}
+
+	return cm.semantic.Lookup(ctx, req, threshold)
+}
+
+// SetSemantic stores a value in the semantic cache, if one is configured
+func (cm *CacheManager) SetSemantic(ctx context.Context, req CacheRequest, response core.ChatResponse) error {
+	if !req.Cache || cm.semantic == nil {
+		return nil
+	}
+
+	if req.TTL <= 0 {
+		req.TTL = cm.config.DefaultTTL
+	}
+	return cm.semantic.Store(ctx, req, response)
+}
+
 // Get retrieves a value from the cache
 func (cm *CacheManager) Get(req CacheRequest) (*CacheEntry, bool) {
 	if !req.Cache {
@@ -85,8 +130,7 @@ func (cm *CacheManager) Set(req CacheRequest, response core.ChatResponse) error
 		ttl = cm.config.DefaultTTL
 	}
 
-	cm.cache.Set(key, response, ttl)
-	return nil
+	return cm.cache.Set(key, response, ttl)
 }
 
 // Delete removes a value from the cache
@@ -108,50 +152,57 @@ func (cm *CacheManager) Delete(req CacheRequest) error {
 func (cm *CacheManager) Clear() {
 	cm.cache.Clear()
 	cm.deduplicator.Reset()
+	if cm.semantic != nil {
+		cm.semantic.Clear(context.Background())
+	}
 }
 
 // Stats returns comprehensive cache statistics
 func (cm *CacheManager) Stats() map[string]interface{} {
 	cacheStats := cm.cache.Stats()
-	dedupStats := cm.deduplicator.GetAllStats()
-
-	// Calculate total deduplication stats
-	var totalRequests, totalDeduplicated, totalCacheHits int64
-	for _, stats := range dedupStats {
-		totalRequests += stats.Requests
-		totalDeduplicated += stats.Deduplicated
-		totalCacheHits += stats.CacheHits
+	dedup := cm.DedupStats()
+
+	cache := map[string]interface{}{
+		"hits":        cacheStats.Hits,
+		"misses":      cacheStats.Misses,
+		"size":        cacheStats.Size,
+		"max_size":    cacheStats.MaxSize,
+		"hit_rate":    cacheStats.HitRate,
+		"evictions":   cacheStats.Evictions,
+		"expirations": cacheStats.Expirations,
+		"errors":      cacheStats.Errors,
 	}
-
-	var dedupRate, cacheHitRate float64
-	if totalRequests > 0 {
-		dedupRate = float64(totalDeduplicated) / float64(totalRequests)
-		cacheHitRate = float64(totalCacheHits) / float64(totalRequests)
+	if cacheStats.SizeUnknown {
+		// A misleading zero would read as an empty cache
+		delete(cache, "size")
+		cache["size_unknown"] = true
 	}
 
-	return map[string]interface{}{
-		"cache": map[string]interface{}{
-			"hits":        cacheStats.Hits,
-			"misses":      cacheStats.Misses,
-			"size":        cacheStats.Size,
-			"max_size":    cacheStats.MaxSize,
-			"hit_rate":    cacheStats.HitRate,
-			"evictions":   cacheStats.Evictions,
-			"expirations": cacheStats.Expirations,
-		},
+	stats := map[string]interface{}{
+		"cache": cache,
 		"deduplication": map[string]interface{}{
-			"total_requests":     totalRequests,
-			"total_deduplicated": totalDeduplicated,
-			"total_cache_hits":   totalCacheHits,
-			"dedup_rate":         dedupRate,
-			"cache_hit_rate":     cacheHitRate,
+			"total_requests":     dedup.Requests,
+			"total_deduplicated": dedup.Deduplicated,
+			"total_cache_hits":   dedup.CacheHits,
+			"dedup_rate":         dedup.DedupRate(),
+			"cache_hit_rate":     dedup.CacheHitRate(),
 		},
 		"config": map[string]interface{}{
+			"backend":          cm.Backend(),
 			"max_size":         cm.config.MaxSize,
 			"default_ttl":      cm.config.DefaultTTL.String(),
 			"cleanup_interval": cm.config.CleanupInterval.String(),
 		},
 	}
+	if cm.semantic != nil {
+		stats["semantic"] = cm.semantic.Stats()
+	}
+	return stats
+}
+
+// DedupStats returns deduplication statistics totalled over all keys
+func (cm *CacheManager) DedupStats() DedupStats {
+	return cm.deduplicator.Stats()
 }
 
 // GetKeyStats returns statistics for a specific cache key
@@ -177,8 +228,8 @@ func (cm *CacheManager) GetKeyStats(req CacheRequest) map[string]interface{} {
 			"requests":       dedupStats.Requests,
 			"deduplicated":   dedupStats.Deduplicated,
 			"cache_hits":     dedupStats.CacheHits,
-			"dedup_rate":     cm.deduplicator.GetDedupRate(key),
-			"cache_hit_rate": cm.deduplicator.GetCacheHitRate(key),
+			"dedup_rate":     dedupStats.DedupRate(),
+			"cache_hit_rate": dedupStats.CacheHitRate(),
 		},
 	}
 }
@@ -186,6 +237,9 @@ func (cm *CacheManager) GetKeyStats(req CacheRequest) map[string]interface{} {
 // Close closes the cache manager and cleans up resources
 func (cm *CacheManager) Close() {
 	cm.cache.Close()
+	if cm.semantic != nil {
+		cm.semantic.Close()
+	}
 }
 
 // IsCached checks if a request is cached
@@ -203,6 +257,14 @@ func (cm *CacheManager) IsCached(req CacheRequest) bool {
 	return exists
 }
 
+// Backend returns the name of the cache backend
+func (cm *CacheManager) Backend() string {
+	if cm.config.Backend == "" {
+		return BackendMemory
+	}
+	return cm.config.Backend
+}
+
 // GetCacheSize returns the current cache size
 func (cm *CacheManager) GetCacheSize() int {
 	return cm.cache.Len()
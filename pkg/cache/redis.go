@@ -0,0 +1,506 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snow-ghost/agent/pkg/router/core"
+)
+
+// RedisConfig holds Redis cache backend configuration
+type RedisConfig struct {
+	Addr        string        `json:"addr"`
+	Password    string        `json:"password,omitempty"`
+	DB          int           `json:"db"`
+	KeyPrefix   string        `json:"key_prefix"`   // Namespaces cache keys so replicas can share a database
+	DedicatedDB bool          `json:"dedicated_db"` // DB holds only cache keys, so its size is read with DBSIZE
+	PoolSize    int           `json:"pool_size"`    // Maximum idle connections kept open
+	DialTimeout time.Duration `json:"dial_timeout"` // Timeout for establishing a connection
+	IOTimeout   time.Duration `json:"io_timeout"`   // Timeout for a single command round trip
+}
+
+// DefaultRedisConfig returns a default Redis configuration
+func DefaultRedisConfig() RedisConfig {
+	return RedisConfig{
+		Addr:        "localhost:6379",
+		KeyPrefix:   "llmrouter:cache:",
+		PoolSize:    10,
+		DialTimeout: 2 * time.Second,
+		IOTimeout:   time.Second,
+	}
+}
+
+// scanBatch is the COUNT hint passed to SCAN and the DEL batch size
+const scanBatch = 100
+
+// RedisCache stores cache entries in Redis, or any server speaking the Redis
+// protocol, so that router replicas share one cache. Entries are JSON encoded
+// and expire through Redis TTLs.
+type RedisCache struct {
+	config *CacheConfig
+	redis  RedisConfig
+	pool   chan *respConn
+
+	mu     sync.Mutex
+	stats  CacheStats
+	closed bool
+}
+
+// NewRedisCache creates a Redis cache and checks the server is reachable
+func NewRedisCache(config *CacheConfig) (*RedisCache, error) {
+	if config == nil {
+		config = DefaultCacheConfig()
+	}
+
+	redis := config.Redis
+	defaults := DefaultRedisConfig()
+	if redis.Addr == "" {
+		redis.Addr = defaults.Addr
+	}
+	if redis.KeyPrefix == "" {
+		redis.KeyPrefix = defaults.KeyPrefix
+	}
+	if redis.PoolSize <= 0 {
+		redis.PoolSize = defaults.PoolSize
+	}
+	if redis.DialTimeout <= 0 {
+		redis.DialTimeout = defaults.DialTimeout
+	}
+	if redis.IOTimeout <= 0 {
+		redis.IOTimeout = defaults.IOTimeout
+	}
+
+	c := &RedisCache{
+		config: config,
+		redis:  redis,
+		pool:   make(chan *respConn, redis.PoolSize),
+		stats:  CacheStats{MaxSize: config.MaxSize},
+	}
+
+	if _, err := c.do("PING"); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", redis.Addr, err)
+	}
+
+	return c, nil
+}
+
+// Get retrieves a value from the cache
+func (c *RedisCache) Get(key CacheKey) (*CacheEntry, bool) {
+	reply, err := c.do("GET", c.redisKey(key))
+	if err != nil {
+		c.recordError("get", err)
+		c.count(func(s *CacheStats) { s.Misses++ })
+		return nil, false
+	}
+
+	data, ok := reply.([]byte)
+	if !ok {
+		c.count(func(s *CacheStats) { s.Misses++ })
+		return nil, false
+	}
+
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		c.recordError("decode", err)
+		c.count(func(s *CacheStats) { s.Misses++ })
+		return nil, false
+	}
+
+	// Redis expires keys itself; this guards against clock skew between replicas
+	if entry.IsExpired() {
+		c.count(func(s *CacheStats) { s.Expirations++; s.Misses++ })
+		return nil, false
+	}
+
+	entry.Touch()
+	c.count(func(s *CacheStats) { s.Hits++ })
+	return &entry, true
+}
+
+// Set stores a value in the cache
+func (c *RedisCache) Set(key CacheKey, response core.ChatResponse, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = c.config.DefaultTTL
+	}
+
+	now := time.Now()
+	data, err := json.Marshal(CacheEntry{
+		Response:     response,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(ttl),
+		LastAccessed: now,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+
+	if _, err := c.do("SET", c.redisKey(key), string(data), "PX", strconv.FormatInt(ms, 10)); err != nil {
+		c.recordError("set", err)
+		return fmt.Errorf("failed to store cache entry: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a value from the cache
+func (c *RedisCache) Delete(key CacheKey) {
+	if _, err := c.do("DEL", c.redisKey(key)); err != nil {
+		c.recordError("delete", err)
+	}
+}
+
+// Clear removes all values under the key prefix; other data in the
+// database is left alone
+func (c *RedisCache) Clear() {
+	keys, err := c.scan()
+	if err != nil {
+		c.recordError("clear", err)
+		return
+	}
+
+	for start := 0; start < len(keys); start += scanBatch {
+		end := start + scanBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		args := append([]string{"DEL"}, keys[start:end]...)
+		if _, err := c.do(args...); err != nil {
+			c.recordError("clear", err)
+			return
+		}
+	}
+}
+
+// Keys returns all cache keys, scanning the key prefix
+func (c *RedisCache) Keys() []CacheKey {
+	redisKeys, err := c.scan()
+	if err != nil {
+		c.recordError("keys", err)
+		return nil
+	}
+
+	keys := make([]CacheKey, 0, len(redisKeys))
+	for _, key := range redisKeys {
+		keys = append(keys, CacheKey(strings.TrimPrefix(key, c.redis.KeyPrefix)))
+	}
+	return keys
+}
+
+// Len returns the number of items in the cache. A dedicated database is
+// counted with DBSIZE; otherwise the key prefix is scanned.
+func (c *RedisCache) Len() int {
+	if c.redis.DedicatedDB {
+		return c.dbSize()
+	}
+
+	keys, err := c.scan()
+	if err != nil {
+		c.recordError("len", err)
+		return 0
+	}
+	return len(keys)
+}
+
+// Stats returns cache statistics. Hits and misses are counted by this
+// replica. Size is read from the shared server with DBSIZE when the database
+// is dedicated to the cache, and is otherwise reported as unknown rather
+// than scanning the keyspace on every call.
+func (c *RedisCache) Stats() CacheStats {
+	c.mu.Lock()
+	stats := c.stats
+	c.mu.Unlock()
+
+	if c.redis.DedicatedDB {
+		stats.Size = c.dbSize()
+	} else {
+		stats.SizeUnknown = true
+	}
+	stats.CalculateHitRate()
+	return stats
+}
+
+// Close closes all pooled connections
+func (c *RedisCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	for {
+		select {
+		case conn := <-c.pool:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// redisKey namespaces a cache key
+func (c *RedisCache) redisKey(key CacheKey) string {
+	return c.redis.KeyPrefix + string(key)
+}
+
+// dbSize returns the number of keys in the selected database
+func (c *RedisCache) dbSize() int {
+	reply, err := c.do("DBSIZE")
+	if err != nil {
+		c.recordError("dbsize", err)
+		return 0
+	}
+
+	size, ok := reply.(int64)
+	if !ok {
+		c.recordError("dbsize", fmt.Errorf("unexpected DBSIZE reply: %v", reply))
+		return 0
+	}
+	return int(size)
+}
+
+// scan lists every Redis key under the key prefix
+func (c *RedisCache) scan() ([]string, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := c.do("SCAN", cursor, "MATCH", c.redis.KeyPrefix+"*", "COUNT", strconv.Itoa(scanBatch))
+		if err != nil {
+			return nil, err
+		}
+
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply: %v", reply)
+		}
+		next, _ := parts[0].([]byte)
+		batch, _ := parts[1].([]interface{})
+		for _, key := range batch {
+			if b, ok := key.([]byte); ok {
+				keys = append(keys, string(b))
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return keys, nil
+		}
+	}
+}
+
+// recordError counts and logs a backend failure
+func (c *RedisCache) recordError(op string, err error) {
+	c.count(func(s *CacheStats) { s.Errors++ })
+	slog.Warn("redis cache operation failed", "op", op, "addr", c.redis.Addr, "error", err)
+}
+
+// count updates statistics under the lock
+func (c *RedisCache) count(update func(*CacheStats)) {
+	c.mu.Lock()
+	update(&c.stats)
+	c.mu.Unlock()
+}
+
+// do runs one command on a pooled connection. Connections that fail at the
+// network level are discarded; server error replies are returned as errors
+// and leave the connection usable.
+func (c *RedisCache) do(args ...string) (interface{}, error) {
+	conn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(c.redis.IOTimeout, args...)
+	var serverErr respError
+	if err != nil && !errors.As(err, &serverErr) {
+		conn.Close()
+		return nil, err
+	}
+
+	c.put(conn)
+	return reply, err
+}
+
+// get takes an idle connection from the pool or dials a new one
+func (c *RedisCache) get() (*respConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+	}
+
+	netConn, err := net.DialTimeout("tcp", c.redis.Addr, c.redis.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := newRESPConn(netConn)
+
+	if c.redis.Password != "" {
+		if _, err := conn.do(c.redis.IOTimeout, "AUTH", c.redis.Password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis AUTH failed: %w", err)
+		}
+	}
+	if c.redis.DB != 0 {
+		if _, err := conn.do(c.redis.IOTimeout, "SELECT", strconv.Itoa(c.redis.DB)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis SELECT failed: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// put returns a connection to the pool, closing it if the pool is full or
+// the cache is closed
+func (c *RedisCache) put(conn *respConn) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+
+	if closed {
+		conn.Close()
+		return
+	}
+
+	select {
+	case c.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// respError is an error reply from the server
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// respConn is a connection speaking RESP2, the Redis serialization protocol
+type respConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+func newRESPConn(conn net.Conn) *respConn {
+	return &respConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+	}
+}
+
+// do sends a command as an array of bulk strings and reads its reply
+func (rc *respConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if timeout > 0 {
+		rc.conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	if err := writeRESPCommand(rc.writer, args); err != nil {
+		return nil, err
+	}
+	if err := rc.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	return readRESP(rc.reader)
+}
+
+// Close closes the underlying connection
+func (rc *respConn) Close() error {
+	return rc.conn.Close()
+}
+
+// writeRESPCommand encodes a command as a RESP array of bulk strings
+func writeRESPCommand(w *bufio.Writer, args []string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n", len(arg))
+		w.WriteString(arg)
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRESP decodes one reply: simple strings as string, errors as respError,
+// integers as int64, bulk strings as []byte (nil when absent) and arrays as
+// []interface{}
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("empty RESP reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, respError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := readRESP(r)
+			var serverErr respError
+			if err != nil && !errors.As(err, &serverErr) {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unknown RESP type %q", line[0])
+	}
+}
+
+// readRESPLine reads a CRLF-terminated line without the terminator
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
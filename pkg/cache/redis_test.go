@@ -0,0 +1,438 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/snow-ghost/agent/pkg/router/core"
+)
+
+// respServer is an in-process stand-in for Redis implementing the commands
+// RedisCache uses
+type respServer struct {
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	data     map[string]string
+	expires  map[string]time.Time
+	commands map[string]int // calls per command
+	wg       sync.WaitGroup
+}
+
+func newRESPServer(t *testing.T, password string) *respServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &respServer{
+		listener: listener,
+		password: password,
+		data:     make(map[string]string),
+		expires:  make(map[string]time.Time),
+		commands: make(map[string]int),
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *respServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *respServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *respServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	authed := s.password == ""
+
+	for {
+		reply, err := readRESP(reader)
+		if err != nil {
+			return
+		}
+		parts, _ := reply.([]interface{})
+		args := make([]string, len(parts))
+		for i, part := range parts {
+			b, _ := part.([]byte)
+			args[i] = string(b)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		cmd := strings.ToUpper(args[0])
+		switch {
+		case cmd == "AUTH":
+			if len(args) == 2 && args[1] == s.password {
+				authed = true
+				writer.WriteString("+OK\r\n")
+			} else {
+				writer.WriteString("-WRONGPASS invalid password\r\n")
+			}
+		case !authed:
+			writer.WriteString("-NOAUTH Authentication required.\r\n")
+		default:
+			s.exec(writer, cmd, args[1:])
+		}
+		writer.Flush()
+	}
+}
+
+func (s *respServer) exec(w *bufio.Writer, cmd string, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commands[cmd]++
+	now := time.Now()
+	for key, expiresAt := range s.expires {
+		if now.After(expiresAt) {
+			delete(s.data, key)
+			delete(s.expires, key)
+		}
+	}
+
+	switch cmd {
+	case "PING":
+		w.WriteString("+PONG\r\n")
+	case "SELECT":
+		w.WriteString("+OK\r\n")
+	case "GET":
+		value, ok := s.data[args[0]]
+		if !ok {
+			w.WriteString("$-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(value), value)
+	case "SET":
+		s.data[args[0]] = args[1]
+		delete(s.expires, args[0])
+		if len(args) == 4 && strings.ToUpper(args[2]) == "PX" {
+			ms, _ := strconv.Atoi(args[3])
+			s.expires[args[0]] = now.Add(time.Duration(ms) * time.Millisecond)
+		}
+		w.WriteString("+OK\r\n")
+	case "DEL":
+		removed := 0
+		for _, key := range args {
+			if _, ok := s.data[key]; ok {
+				delete(s.data, key)
+				delete(s.expires, key)
+				removed++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", removed)
+	case "DBSIZE":
+		fmt.Fprintf(w, ":%d\r\n", len(s.data))
+	case "SCAN":
+		// The cursor is an offset into the sorted key space
+		cursor, _ := strconv.Atoi(args[0])
+		pattern, count := "*", 10
+		for i := 1; i+1 < len(args); i += 2 {
+			switch strings.ToUpper(args[i]) {
+			case "MATCH":
+				pattern = args[i+1]
+			case "COUNT":
+				count, _ = strconv.Atoi(args[i+1])
+			}
+		}
+
+		keys := make([]string, 0, len(s.data))
+		for key := range s.data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		end := cursor + count
+		next := strconv.Itoa(end)
+		if end >= len(keys) {
+			end = len(keys)
+			next = "0"
+		}
+
+		var matched []string
+		for _, key := range keys[cursor:end] {
+			if ok, _ := path.Match(pattern, key); ok {
+				matched = append(matched, key)
+			}
+		}
+
+		fmt.Fprintf(w, "*2\r\n$%d\r\n%s\r\n*%d\r\n", len(next), next, len(matched))
+		for _, key := range matched {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(key), key)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", cmd)
+	}
+}
+
+func newTestRedisCache(t *testing.T, server *respServer) *RedisCache {
+	t.Helper()
+
+	config := DefaultCacheConfig()
+	config.Backend = BackendRedis
+	config.Redis.Addr = server.addr()
+	config.Redis.Password = server.password
+
+	c, err := NewRedisCache(config)
+	if err != nil {
+		t.Fatalf("Failed to create redis cache: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestRedisCache(t *testing.T) {
+	server := newRESPServer(t, "secret")
+	c := newTestRedisCache(t, server)
+
+	key := CacheKey("test-key")
+	if _, exists := c.Get(key); exists {
+		t.Error("Expected miss on empty cache")
+	}
+
+	if err := c.Set(key, core.ChatResponse{Text: "test response"}, time.Minute); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	entry, exists := c.Get(key)
+	if !exists {
+		t.Fatal("Expected hit after Set")
+	}
+	if entry.Response.Text != "test response" {
+		t.Errorf("Expected 'test response', got %s", entry.Response.Text)
+	}
+
+	// Entries are namespaced under the key prefix
+	server.mu.Lock()
+	_, stored := server.data["llmrouter:cache:test-key"]
+	server.data["unrelated"] = "value"
+	server.mu.Unlock()
+	if !stored {
+		t.Error("Expected entry under the key prefix")
+	}
+
+	c.Delete(key)
+	if _, exists := c.Get(key); exists {
+		t.Error("Expected miss after Delete")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("Expected 1 hit and 2 misses, got %+v", stats)
+	}
+}
+
+func TestRedisCacheTTL(t *testing.T) {
+	c := newTestRedisCache(t, newRESPServer(t, ""))
+
+	key := CacheKey("ttl-key")
+	if err := c.Set(key, core.ChatResponse{Text: "short-lived"}, 20*time.Millisecond); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, exists := c.Get(key); !exists {
+		t.Fatal("Expected hit before expiry")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if _, exists := c.Get(key); exists {
+		t.Error("Expected miss after expiry")
+	}
+}
+
+func TestRedisCacheKeysAndClear(t *testing.T) {
+	server := newRESPServer(t, "")
+	c := newTestRedisCache(t, server)
+
+	// More keys than one SCAN batch
+	numKeys := scanBatch + 25
+	for i := 0; i < numKeys; i++ {
+		if err := c.Set(CacheKey(fmt.Sprintf("key-%03d", i)), core.ChatResponse{Text: "v"}, time.Minute); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+	server.mu.Lock()
+	server.data["other:key"] = "value"
+	server.mu.Unlock()
+
+	keys := c.Keys()
+	if len(keys) != numKeys {
+		t.Fatalf("Expected %d keys, got %d", numKeys, len(keys))
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(string(key), "key-") {
+			t.Errorf("Expected key without prefix, got %s", key)
+		}
+	}
+	if c.Len() != numKeys {
+		t.Errorf("Expected Len %d, got %d", numKeys, c.Len())
+	}
+
+	c.Clear()
+	if c.Len() != 0 {
+		t.Errorf("Expected empty cache after Clear, got %d", c.Len())
+	}
+
+	server.mu.Lock()
+	_, kept := server.data["other:key"]
+	server.mu.Unlock()
+	if !kept {
+		t.Error("Expected Clear to leave keys outside the prefix alone")
+	}
+}
+
+func TestRedisCacheStatsSize(t *testing.T) {
+	server := newRESPServer(t, "")
+	c := newTestRedisCache(t, server)
+	for _, key := range []CacheKey{"a", "b"} {
+		if err := c.Set(key, core.ChatResponse{Text: "v"}, time.Minute); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	// Stats never scans the keyspace; in a shared database the size is
+	// unknown
+	if stats := c.Stats(); !stats.SizeUnknown {
+		t.Errorf("Expected the size of a shared database to be unknown, got %+v", stats)
+	}
+
+	// A dedicated database is counted with DBSIZE
+	c.redis.DedicatedDB = true
+	if stats := c.Stats(); stats.Size != 2 || stats.SizeUnknown {
+		t.Errorf("Expected size 2, got %+v", stats)
+	}
+	if c.Len() != 2 {
+		t.Errorf("Expected Len 2, got %d", c.Len())
+	}
+
+	server.mu.Lock()
+	scans, dbsizes := server.commands["SCAN"], server.commands["DBSIZE"]
+	server.mu.Unlock()
+	if scans != 0 || dbsizes != 2 {
+		t.Errorf("Expected no SCAN and 2 DBSIZE calls, got %d and %d", scans, dbsizes)
+	}
+}
+
+func TestCacheManagerRedisSize(t *testing.T) {
+	server := newRESPServer(t, "")
+	t.Setenv("CACHE_BACKEND", BackendRedis)
+	t.Setenv("REDIS_ADDR", server.addr())
+
+	size := func() (interface{}, bool) {
+		manager, err := NewCacheManager(CacheConfigFromEnv(DefaultCacheConfig()))
+		if err != nil {
+			t.Fatalf("Failed to create cache manager: %v", err)
+		}
+		defer manager.Close()
+		size, known := manager.Stats()["cache"].(map[string]interface{})["size"]
+		return size, known
+	}
+
+	// A shared database has no size rather than a misleading zero
+	if size, known := size(); known {
+		t.Errorf("Expected no size for a shared database, got %v", size)
+	}
+
+	t.Setenv("REDIS_DEDICATED_DB", "true")
+	if size, known := size(); !known || size != 0 {
+		t.Errorf("Expected size 0 for an empty dedicated database, got %v", size)
+	}
+}
+
+func TestRedisCacheConnectionErrors(t *testing.T) {
+	server := newRESPServer(t, "secret")
+
+	config := DefaultCacheConfig()
+	config.Redis.Addr = server.addr()
+	config.Redis.Password = "wrong"
+	if _, err := NewRedisCache(config); err == nil {
+		t.Error("Expected bad password to fail")
+	}
+
+	c := newTestRedisCache(t, server)
+	server.listener.Close()
+	c.Close()
+
+	// A closed pool dials again and fails; lookups degrade to misses
+	if _, exists := c.Get("key"); exists {
+		t.Error("Expected miss when the server is unreachable")
+	}
+	if err := c.Set("key", core.ChatResponse{}, time.Minute); err == nil {
+		t.Error("Expected Set to fail when the server is unreachable")
+	}
+	if c.Stats().Errors == 0 {
+		t.Error("Expected backend errors to be counted")
+	}
+}
+
+func TestCacheManagerRedisBackend(t *testing.T) {
+	server := newRESPServer(t, "")
+
+	config := DefaultCacheConfig()
+	config.Backend = BackendRedis
+	config.Redis.Addr = server.addr()
+
+	// Two managers model two router replicas sharing one cache
+	replicaA, err := NewCacheManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache manager: %v", err)
+	}
+	defer replicaA.Close()
+	replicaB, err := NewCacheManager(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache manager: %v", err)
+	}
+	defer replicaB.Close()
+
+	requests := []CacheRequest{
+		{Model: "gpt-4o-mini", Messages: []core.Message{{Role: "user", Content: "hello"}}, Cache: true},
+		{Model: "gpt-4o-mini", Messages: []core.Message{{Role: "user", Content: "bye"}}, Cache: true},
+	}
+	responses := []core.ChatResponse{{Text: "hi"}, {Text: "goodbye"}}
+	if err := replicaA.Warmup(requests, responses); err != nil {
+		t.Fatalf("Warmup failed: %v", err)
+	}
+
+	entry, exists := replicaB.Get(requests[1])
+	if !exists || entry.Response.Text != "goodbye" {
+		t.Errorf("Expected warmed entry on the other replica, got %v", entry)
+	}
+	if len(replicaB.GetCacheKeys()) != 2 || replicaB.GetCacheSize() != 2 {
+		t.Errorf("Expected 2 shared keys, got %d", len(replicaB.GetCacheKeys()))
+	}
+	if backend := replicaB.Stats()["config"].(map[string]interface{})["backend"]; backend != BackendRedis {
+		t.Errorf("Expected redis backend in stats, got %v", backend)
+	}
+
+	if _, err := NewCacheManager(&CacheConfig{MaxSize: 10, Backend: "memcached"}); err == nil {
+		t.Error("Expected unknown backend to fail")
+	}
+}
@@ -0,0 +1,310 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/snow-ghost/agent/embeddings"
+	"github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/vectordb"
+)
+
+// SemanticCacheConfig holds semantic cache configuration
+type SemanticCacheConfig struct {
+	Threshold            float64       `json:"threshold"`             // Default minimum cosine similarity for a hit
+	TopK                 int           `json:"top_k"`                 // Neighbours examined per lookup
+	TemperatureTolerance float32       `json:"temperature_tolerance"` // Maximum temperature difference for a hit
+	MaxSize              int           `json:"max_size"`              // Maximum number of cached responses
+	DefaultTTL           time.Duration `json:"default_ttl"`           // Default TTL for entries
+}
+
+// DefaultSemanticCacheConfig returns a default semantic cache configuration
+func DefaultSemanticCacheConfig() *SemanticCacheConfig {
+	return &SemanticCacheConfig{
+		Threshold:            0.95,
+		TopK:                 5,
+		TemperatureTolerance: 0.05,
+		MaxSize:              1000,
+		DefaultTTL:           5 * time.Minute,
+	}
+}
+
+// SemanticHit is a cached response whose prompt is similar to the request
+type SemanticHit struct {
+	Key        CacheKey
+	Entry      *CacheEntry
+	Similarity float64
+}
+
+// SemanticCache serves cached responses for prompts that are similar rather
+// than identical. The last user message is embedded and looked up in a vector
+// store; a neighbour only counts as a hit if it was produced by the same model
+// at a compatible temperature, with the same conversation around that message
+// and the same tools and sampling limits.
+type SemanticCache struct {
+	embedder embeddings.Embedder
+	store    vectordb.VectorStore
+	entries  *LRUCache
+	config   *SemanticCacheConfig
+
+	// embedMu serializes embedding, as embedders such as the TF-IDF mock
+	// mutate their vocabulary
+	embedMu sync.Mutex
+
+	mu        sync.Mutex
+	stats     SemanticCacheStats
+	closeOnce sync.Once
+}
+
+// SemanticCacheStats represents semantic cache statistics
+type SemanticCacheStats struct {
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Rejected int64 `json:"rejected"` // Similar neighbours rejected for model, temperature or context
+	Errors   int64 `json:"errors"`
+}
+
+// NewSemanticCache creates a semantic cache. The vector store dimension must
+// match the embedder's.
+func NewSemanticCache(embedder embeddings.Embedder, store vectordb.VectorStore, config *SemanticCacheConfig) (*SemanticCache, error) {
+	if embedder == nil || store == nil {
+		return nil, fmt.Errorf("semantic cache requires an embedder and a vector store")
+	}
+	if config == nil {
+		config = DefaultSemanticCacheConfig()
+	}
+
+	sc := &SemanticCache{
+		embedder: embedder,
+		store:    store,
+		config:   config,
+	}
+
+	// A response's vector goes when the response is evicted or expires, so
+	// the store stays within MaxSize and searches only find live entries
+	entries, err := NewLRUCacheWithEvict(&CacheConfig{
+		MaxSize:         config.MaxSize,
+		DefaultTTL:      config.DefaultTTL,
+		CleanupInterval: time.Minute,
+	}, func(key CacheKey) {
+		store.Delete(context.Background(), string(key))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create semantic cache entries: %w", err)
+	}
+	sc.entries = entries
+
+	return sc, nil
+}
+
+// Lookup finds the most similar cached response at or above threshold. The
+// threshold can only raise the configured one: entries are shared between
+// callers, so a lower threshold would hand out answers to unrelated prompts.
+func (sc *SemanticCache) Lookup(ctx context.Context, req CacheRequest, threshold float64) (*SemanticHit, bool, error) {
+	threshold = math.Max(threshold, sc.config.Threshold)
+
+	prompt := lastUserMessage(req.Messages)
+	if prompt == "" {
+		sc.count(func(s *SemanticCacheStats) { s.Misses++ })
+		return nil, false, nil
+	}
+
+	reqContext, err := contextKey(req)
+	if err != nil {
+		sc.count(func(s *SemanticCacheStats) { s.Errors++ })
+		return nil, false, err
+	}
+
+	vec, err := sc.embed(ctx, prompt)
+	if err != nil {
+		sc.count(func(s *SemanticCacheStats) { s.Errors++ })
+		return nil, false, err
+	}
+
+	hits, err := sc.store.Search(ctx, vec, sc.config.TopK)
+	if err != nil {
+		sc.count(func(s *SemanticCacheStats) { s.Errors++ })
+		return nil, false, fmt.Errorf("failed to search semantic cache: %w", err)
+	}
+
+	// Hits are sorted by descending similarity
+	for _, hit := range hits {
+		if hit.Score < threshold {
+			break
+		}
+		if !sc.compatible(req, reqContext, hit.Meta) {
+			sc.count(func(s *SemanticCacheStats) { s.Rejected++ })
+			continue
+		}
+
+		key := CacheKey(hit.ID)
+		entry, exists := sc.entries.Get(key)
+		if !exists {
+			// Expired since the search, which drops its vector
+			continue
+		}
+
+		sc.count(func(s *SemanticCacheStats) { s.Hits++ })
+		return &SemanticHit{Key: key, Entry: entry, Similarity: hit.Score}, true, nil
+	}
+
+	sc.count(func(s *SemanticCacheStats) { s.Misses++ })
+	return nil, false, nil
+}
+
+// Store caches a response and indexes the request's last user message
+func (sc *SemanticCache) Store(ctx context.Context, req CacheRequest, response core.ChatResponse) error {
+	prompt := lastUserMessage(req.Messages)
+	if prompt == "" {
+		return nil
+	}
+
+	key, err := GenerateKey(req)
+	if err != nil {
+		return fmt.Errorf("failed to generate cache key: %w", err)
+	}
+	reqContext, err := contextKey(req)
+	if err != nil {
+		return err
+	}
+
+	vec, err := sc.embed(ctx, prompt)
+	if err != nil {
+		return err
+	}
+
+	// Index before caching, so that evicting the entry always finds its
+	// vector to delete
+	meta := map[string]string{
+		"model":       req.Model,
+		"temperature": strconv.FormatFloat(float64(req.Temperature), 'f', -1, 32),
+		"prompt":      prompt,
+		"context":     reqContext,
+	}
+	if err := sc.store.Upsert(ctx, string(key), vec, meta); err != nil {
+		return fmt.Errorf("failed to index semantic cache entry: %w", err)
+	}
+
+	ttl := req.TTL
+	if ttl <= 0 {
+		ttl = sc.config.DefaultTTL
+	}
+	sc.entries.Set(key, response, ttl)
+
+	return nil
+}
+
+// Clear removes all cached responses and their vectors
+func (sc *SemanticCache) Clear(ctx context.Context) error {
+	sc.entries.Clear()
+	return sc.store.Clear(ctx)
+}
+
+// Stats returns semantic cache statistics
+func (sc *SemanticCache) Stats() map[string]interface{} {
+	sc.mu.Lock()
+	stats := sc.stats
+	sc.mu.Unlock()
+
+	var hitRate float64
+	if total := stats.Hits + stats.Misses; total > 0 {
+		hitRate = float64(stats.Hits) / float64(total)
+	}
+
+	return map[string]interface{}{
+		"hits":      stats.Hits,
+		"misses":    stats.Misses,
+		"rejected":  stats.Rejected,
+		"errors":    stats.Errors,
+		"hit_rate":  hitRate,
+		"size":      sc.entries.Len(),
+		"threshold": sc.config.Threshold,
+	}
+}
+
+// Close stops the entry cleanup goroutine; it is safe to call more than once
+func (sc *SemanticCache) Close() {
+	sc.closeOnce.Do(sc.entries.Close)
+}
+
+// compatible reports whether a cached neighbour was produced by the requested
+// model, in the same context, at a temperature within tolerance
+func (sc *SemanticCache) compatible(req CacheRequest, reqContext string, meta map[string]string) bool {
+	if meta["model"] != req.Model || meta["context"] != reqContext {
+		return false
+	}
+
+	temperature, err := strconv.ParseFloat(meta["temperature"], 32)
+	if err != nil {
+		return false
+	}
+	diff := math.Abs(temperature - float64(req.Temperature))
+	return diff <= float64(sc.config.TemperatureTolerance)+1e-6
+}
+
+// embed converts a prompt to a vector
+func (sc *SemanticCache) embed(ctx context.Context, text string) ([]float32, error) {
+	sc.embedMu.Lock()
+	defer sc.embedMu.Unlock()
+
+	vec, err := sc.embedder.EmbedText(ctx, text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed prompt: %w", err)
+	}
+	return vec, nil
+}
+
+// count updates statistics under the lock
+func (sc *SemanticCache) count(update func(*SemanticCacheStats)) {
+	sc.mu.Lock()
+	update(&sc.stats)
+	sc.mu.Unlock()
+}
+
+// contextKey hashes what a request's answer depends on besides its model,
+// temperature and last user message: the other messages, such as the system
+// prompt and earlier turns, the tools, top_p and max_tokens
+func contextKey(req CacheRequest) (string, error) {
+	var messages []core.Message
+	last := -1
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			last = i
+			break
+		}
+	}
+	for i, message := range req.Messages {
+		if i == last {
+			// Stands in for the prompt, which is compared by similarity
+			message = core.Message{Role: message.Role}
+		}
+		messages = append(messages, message)
+	}
+
+	data, err := json.Marshal(struct {
+		Messages  []core.Message `json:"messages"`
+		Tools     []core.Tool    `json:"tools"`
+		TopP      float32        `json:"top_p"`
+		MaxTokens int            `json:"max_tokens"`
+	}{messages, req.Tools, req.TopP, req.MaxTokens})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request context: %w", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// lastUserMessage returns the content of the most recent user message
+func lastUserMessage(messages []core.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snow-ghost/agent/embeddings"
+	"github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/vectordb"
+)
+
+func newTestSemanticCache(t *testing.T) *SemanticCache {
+	t.Helper()
+
+	embedder := embeddings.NewMockEmbedder(&embeddings.EmbeddingConfig{Dimension: 64})
+	store := vectordb.NewMemoryVectorStore(&vectordb.VectorStoreConfig{Dimension: 64, Distance: "cosine"})
+
+	sc, err := NewSemanticCache(embedder, store, DefaultSemanticCacheConfig())
+	if err != nil {
+		t.Fatalf("Failed to create semantic cache: %v", err)
+	}
+	t.Cleanup(sc.Close)
+	return sc
+}
+
+func semanticRequest(model, prompt string, temperature float32) CacheRequest {
+	return CacheRequest{
+		Model: model,
+		Messages: []core.Message{
+			{Role: "system", Content: "You are a helpful assistant."},
+			{Role: "user", Content: prompt},
+		},
+		Temperature: temperature,
+		Cache:       true,
+	}
+}
+
+func TestSemanticCacheLookup(t *testing.T) {
+	ctx := context.Background()
+	sc := newTestSemanticCache(t)
+
+	stored := semanticRequest("gpt-4o-mini", "please sort this list of numbers in ascending order", 0.2)
+	if err := sc.Store(ctx, stored, core.ChatResponse{Text: "sorted"}); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+
+	// Identical prompt hits at the default threshold
+	hit, found, err := sc.Lookup(ctx, stored, 0)
+	if err != nil || !found {
+		t.Fatalf("Expected hit for identical prompt, got found=%v err=%v", found, err)
+	}
+	if hit.Entry.Response.Text != "sorted" {
+		t.Errorf("Expected cached response 'sorted', got %q", hit.Entry.Response.Text)
+	}
+	if hit.Similarity < 0.999 {
+		t.Errorf("Expected similarity ~1, got %f", hit.Similarity)
+	}
+
+	// A paraphrase (similarity ~0.949) misses at the default 0.95 threshold
+	similar := semanticRequest("gpt-4o-mini", "please sort this list of numbers in ascending order now", 0.2)
+	if _, found, _ := sc.Lookup(ctx, similar, 0); found {
+		t.Error("Expected miss for paraphrase at default threshold")
+	}
+
+	// and a lower per-request threshold cannot widen the match
+	if _, found, _ := sc.Lookup(ctx, similar, 0.0001); found {
+		t.Error("Expected miss for paraphrase at a threshold below the default")
+	}
+
+	// The paraphrase hits where the configured threshold is lower
+	sc.config.Threshold = 0.9
+	hit, found, err = sc.Lookup(ctx, similar, 0)
+	if err != nil || !found {
+		t.Fatalf("Expected hit for paraphrase at threshold 0.9, got found=%v err=%v", found, err)
+	}
+	if hit.Similarity < 0.9 || hit.Similarity >= 0.95 {
+		t.Errorf("Expected similarity in [0.9, 0.95), got %f", hit.Similarity)
+	}
+}
+
+func TestSemanticCacheCompatibility(t *testing.T) {
+	ctx := context.Background()
+	sc := newTestSemanticCache(t)
+
+	prompt := "explain binary search trees"
+	if err := sc.Store(ctx, semanticRequest("gpt-4o-mini", prompt, 0.2), core.ChatResponse{Text: "bst"}); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		req   CacheRequest
+		found bool
+	}{
+		{"same model and temperature", semanticRequest("gpt-4o-mini", prompt, 0.2), true},
+		{"temperature within tolerance", semanticRequest("gpt-4o-mini", prompt, 0.22), true},
+		{"different model", semanticRequest("gpt-4o", prompt, 0.2), false},
+		{"different temperature", semanticRequest("gpt-4o-mini", prompt, 0.9), false},
+		{"no user message", CacheRequest{Model: "gpt-4o-mini", Temperature: 0.2, Cache: true}, false},
+		{"different system prompt", withMessages(semanticRequest("gpt-4o-mini", prompt, 0.2), func(m []core.Message) []core.Message {
+			m[0].Content = "Answer in French."
+			return m
+		}), false},
+		{"earlier turns", withMessages(semanticRequest("gpt-4o-mini", prompt, 0.2), func(m []core.Message) []core.Message {
+			return append([]core.Message{m[0], {Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}}, m[1:]...)
+		}), false},
+		{"different max tokens", func() CacheRequest {
+			req := semanticRequest("gpt-4o-mini", prompt, 0.2)
+			req.MaxTokens = 16
+			return req
+		}(), false},
+		{"with tools", func() CacheRequest {
+			req := semanticRequest("gpt-4o-mini", prompt, 0.2)
+			req.Tools = []core.Tool{{Type: "function"}}
+			return req
+		}(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, found, err := sc.Lookup(ctx, tt.req, 0)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if found != tt.found {
+				t.Errorf("Expected found=%v, got %v", tt.found, found)
+			}
+		})
+	}
+
+	stats := sc.Stats()
+	if stats["rejected"].(int64) != 6 {
+		t.Errorf("Expected 6 rejected neighbours, got %v", stats["rejected"])
+	}
+}
+
+// withMessages returns req with its messages changed by edit
+func withMessages(req CacheRequest, edit func([]core.Message) []core.Message) CacheRequest {
+	req.Messages = edit(req.Messages)
+	return req
+}
+
+func TestSemanticCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	sc := newTestSemanticCache(t)
+
+	req := semanticRequest("gpt-4o-mini", "summarize this article", 0)
+	req.TTL = 10 * time.Millisecond
+	if err := sc.Store(ctx, req, core.ChatResponse{Text: "summary"}); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, found, _ := sc.Lookup(ctx, req, 0); found {
+		t.Error("Expected miss for expired entry")
+	}
+
+	// The expired entry's vector goes with it
+	if count, _ := sc.store.Count(ctx); count != 0 {
+		t.Errorf("Expected expired vector to be removed, got %d vectors", count)
+	}
+}
+
+func TestSemanticCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	embedder := embeddings.NewMockEmbedder(&embeddings.EmbeddingConfig{Dimension: 64})
+	store := vectordb.NewMemoryVectorStore(&vectordb.VectorStoreConfig{Dimension: 64, Distance: "cosine"})
+	config := DefaultSemanticCacheConfig()
+	config.MaxSize = 2
+	sc, err := NewSemanticCache(embedder, store, config)
+	if err != nil {
+		t.Fatalf("Failed to create semantic cache: %v", err)
+	}
+	defer sc.Close()
+
+	for _, prompt := range []string{"first question about go", "second question about rust", "third question about zig"} {
+		if err := sc.Store(ctx, semanticRequest("gpt-4o-mini", prompt, 0), core.ChatResponse{Text: prompt}); err != nil {
+			t.Fatalf("Failed to store: %v", err)
+		}
+	}
+
+	// Evicted entries take their vectors with them
+	if count, _ := store.Count(ctx); count != 2 {
+		t.Errorf("Expected 2 vectors after eviction, got %d", count)
+	}
+	if _, found, _ := sc.Lookup(ctx, semanticRequest("gpt-4o-mini", "first question about go", 0), 0); found {
+		t.Error("Expected miss for evicted entry")
+	}
+	if _, found, _ := sc.Lookup(ctx, semanticRequest("gpt-4o-mini", "third question about zig", 0), 0); !found {
+		t.Error("Expected hit for live entry")
+	}
+
+	if err := sc.Clear(ctx); err != nil {
+		t.Fatalf("Failed to clear: %v", err)
+	}
+	if count, _ := store.Count(ctx); count != 0 {
+		t.Errorf("Expected no vectors after clear, got %d", count)
+	}
+}
+
+func TestCacheManagerSemantic(t *testing.T) {
+	ctx := context.Background()
+	manager, err := NewCacheManager(DefaultCacheConfig())
+	if err != nil {
+		t.Fatalf("Failed to create cache manager: %v", err)
+	}
+	defer manager.Close()
+
+	req := semanticRequest("gpt-4o-mini", "translate hello to french", 0)
+
+	// Without a semantic layer lookups always miss
+	if _, found, _ := manager.GetSemantic(ctx, req, 0); found {
+		t.Error("Expected miss without semantic layer")
+	}
+
+	manager.EnableSemanticCache(newTestSemanticCache(t))
+	if err := manager.SetSemantic(ctx, req, core.ChatResponse{Text: "bonjour"}); err != nil {
+		t.Fatalf("Failed to set: %v", err)
+	}
+
+	hit, found, err := manager.GetSemantic(ctx, req, 0)
+	if err != nil || !found {
+		t.Fatalf("Expected semantic hit, got found=%v err=%v", found, err)
+	}
+	if hit.Entry.Response.Text != "bonjour" {
+		t.Errorf("Expected 'bonjour', got %q", hit.Entry.Response.Text)
+	}
+
+	if _, ok := manager.Stats()["semantic"]; !ok {
+		t.Error("Expected semantic stats")
+	}
+}
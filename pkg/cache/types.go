@@ -37,6 +37,8 @@ type CacheConfig struct {
 	MaxSize         int           `json:"max_size"`         // Maximum number of entries
 	DefaultTTL      time.Duration `json:"default_ttl"`      // Default TTL for entries
 	CleanupInterval time.Duration `json:"cleanup_interval"` // How often to clean expired entries
+	Backend         string        `json:"backend"`          // "memory" (default) or "redis"
+	Redis           RedisConfig   `json:"redis"`            // Used by the redis backend
 }
 
 // DefaultCacheConfig returns a default cache configuration
@@ -45,6 +47,8 @@ func DefaultCacheConfig() *CacheConfig {
 		MaxSize:         1000,
 		DefaultTTL:      5 * time.Minute,
 		CleanupInterval: 1 * time.Minute,
+		Backend:         BackendMemory,
+		Redis:           DefaultRedisConfig(),
 	}
 }
 
@@ -94,15 +98,31 @@ func GenerateKey(req CacheRequest) (CacheKey, error) {
 	return CacheKey(fmt.Sprintf("%x", hash)), nil
 }
 
+// GenerateEmbedKey generates a deduplication key for an embedding request
+func GenerateEmbedKey(model string, input []string) (CacheKey, error) {
+	data, err := json.Marshal(struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}{model, input})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	hash := sha256.Sum256(data)
+	return CacheKey(fmt.Sprintf("embed:%x", hash)), nil
+}
+
 // CacheStats represents cache statistics
 type CacheStats struct {
 	Hits        int64   `json:"hits"`
 	Misses      int64   `json:"misses"`
 	Size        int     `json:"size"`
+	SizeUnknown bool    `json:"size_unknown,omitempty"` // Size is not read, as counting would scan a shared database
 	MaxSize     int     `json:"max_size"`
 	HitRate     float64 `json:"hit_rate"`
 	Evictions   int64   `json:"evictions"`
 	Expirations int64   `json:"expirations"`
+	Errors      int64   `json:"errors,omitempty"` // Backend failures, counted as misses on lookup
 }
 
 // CalculateHitRate calculates the hit rate
@@ -2,14 +2,28 @@ package limiter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/snow-ghost/agent/pkg/registry"
+	"github.com/sony/gobreaker"
 )
 
+// ErrCircuitOpen is returned when a model's circuit breaker rejects a request
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// IsUnavailableError reports whether err means the model refused the request
+// before reaching the provider (open or saturated circuit breaker)
+func IsUnavailableError(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) ||
+		errors.Is(err, gobreaker.ErrOpenState) ||
+		errors.Is(err, gobreaker.ErrTooManyRequests)
+}
+
 // ProtectionManager integrates rate limiting, retries, and circuit breaker
 type ProtectionManager struct {
 	rateLimiter    *RateLimiter
+	tokenLimiter   *TokenLimiter
 	retryManager   *RetryManager
 	circuitBreaker *CircuitBreakerManager
 	registry       *registry.Registry
@@ -19,6 +33,7 @@ type ProtectionManager struct {
 func NewProtectionManager(registry *registry.Registry) *ProtectionManager {
 	return &ProtectionManager{
 		rateLimiter:    NewRateLimiter(),
+		tokenLimiter:   NewTokenLimiter(registry.RateLimits),
 		retryManager:   NewRetryManager(DefaultRetryConfig()),
 		circuitBreaker: NewCircuitBreakerManager(),
 		registry:       registry,
@@ -39,7 +54,7 @@ func (pm *ProtectionManager) ExecuteWithProtection(
 
 	// Check if circuit breaker is open
 	if pm.circuitBreaker.IsOpen(modelID, *modelConfig) {
-		return nil, fmt.Errorf("circuit breaker is open for model %s", modelID)
+		return nil, fmt.Errorf("%w for model %s", ErrCircuitOpen, modelID)
 	}
 
 	// Apply rate limiting
@@ -74,7 +89,7 @@ func (pm *ProtectionManager) ExecuteWithCustomRetry(
 
 	// Check if circuit breaker is open
 	if pm.circuitBreaker.IsOpen(modelID, *modelConfig) {
-		return nil, fmt.Errorf("circuit breaker is open for model %s", modelID)
+		return nil, fmt.Errorf("%w for model %s", ErrCircuitOpen, modelID)
 	}
 
 	// Apply rate limiting
@@ -97,6 +112,29 @@ func (pm *ProtectionManager) ExecuteWithCustomRetry(
 	return result, nil
 }
 
+// ReserveTokens reserves n estimated tokens against the model's and the
+// caller's tokens-per-minute limits, and one request against the caller's
+// requests-per-minute limit. The reservation must be reconciled with the
+// actual usage, or cancelled if the call fails.
+func (pm *ProtectionManager) ReserveTokens(ctx context.Context, modelID, caller string, n int) (*TokenReservation, error) {
+	modelConfig := pm.registry.FindModel(modelID)
+	if modelConfig == nil {
+		return nil, fmt.Errorf("model %s not found in registry", modelID)
+	}
+
+	return pm.tokenLimiter.Reserve(ctx, *modelConfig, caller, n)
+}
+
+// TokenLimiter returns the limiter enforcing tokens per minute
+func (pm *ProtectionManager) TokenLimiter() *TokenLimiter {
+	return pm.tokenLimiter
+}
+
+// CircuitBreakers returns the circuit breakers guarding each model
+func (pm *ProtectionManager) CircuitBreakers() *CircuitBreakerManager {
+	return pm.circuitBreaker
+}
+
 // GetStats returns comprehensive statistics for all protection mechanisms
 func (pm *ProtectionManager) GetStats(modelID string) map[string]interface{} {
 	modelConfig := pm.registry.FindModel(modelID)
@@ -107,11 +145,13 @@ func (pm *ProtectionManager) GetStats(modelID string) map[string]interface{} {
 	}
 
 	rateLimiterStats := pm.rateLimiter.GetStats(modelID, *modelConfig)
+	tokenLimiterStats := pm.tokenLimiter.GetStats(*modelConfig)
 	circuitBreakerStats := pm.circuitBreaker.GetStats(modelID, *modelConfig)
 
 	return map[string]interface{}{
 		"model_id":        modelID,
 		"rate_limiter":    rateLimiterStats,
+		"token_limiter":   tokenLimiterStats,
 		"circuit_breaker": circuitBreakerStats,
 		"retry_config": map[string]interface{}{
 			"max_retries":      pm.retryManager.config.MaxRetries,
@@ -138,16 +178,19 @@ func (pm *ProtectionManager) GetAllStats() map[string]interface{} {
 // ResetModel resets all protection mechanisms for a specific model
 func (pm *ProtectionManager) ResetModel(modelID string) {
 	pm.rateLimiter.Reset(modelID)
+	pm.tokenLimiter.Reset(modelID)
 	pm.circuitBreaker.Reset(modelID)
 }
 
 // ResetAll resets all protection mechanisms
 func (pm *ProtectionManager) ResetAll() {
 	pm.rateLimiter.ResetAll()
+	pm.tokenLimiter.ResetAll()
 	pm.circuitBreaker.ResetAll()
 }
 
-// IsModelAvailable checks if a model is available (not rate limited or circuit broken)
+// IsModelAvailable checks if a model is available (not rate limited or circuit broken).
+// It does not consume rate limiter capacity.
 func (pm *ProtectionManager) IsModelAvailable(modelID string) bool {
 	modelConfig := pm.registry.FindModel(modelID)
 	if modelConfig == nil {
@@ -159,8 +202,9 @@ func (pm *ProtectionManager) IsModelAvailable(modelID string) bool {
 		return false
 	}
 
-	// Check if rate limiter allows the request
-	return pm.rateLimiter.Allow(modelID, *modelConfig)
+	// Check if rate limiters have capacity for the request
+	return pm.rateLimiter.HasCapacity(modelID, *modelConfig) &&
+		pm.tokenLimiter.HasCapacity(*modelConfig, 1)
 }
 
 // GetAvailableModels returns a list of available models
@@ -33,34 +33,20 @@ func (rl *RateLimiter) GetLimiter(modelID string, config registry.ModelConfig) *
 		return limiter
 	}
 
-	// Create new limiter based on model configuration
-	// Use the more restrictive limit between RPM and TPM
-	rpm := float64(config.MaxRPM)
-	tpm := float64(config.MaxTPM)
-
-	// Convert TPM to requests per minute (assuming average 100 tokens per request)
-	avgTokensPerRequest := 100.0
-	tpmAsRPM := tpm / avgTokensPerRequest
-
-	// Use the more restrictive limit
-	var limit float64
-	if rpm > 0 && tpmAsRPM > 0 {
-		if rpm < tpmAsRPM {
-			limit = rpm
-		} else {
-			limit = tpmAsRPM
-		}
-	} else if rpm > 0 {
-		limit = rpm
-	} else if tpmAsRPM > 0 {
-		limit = tpmAsRPM
-	} else {
+	// Requests per minute only; tokens per minute are enforced by the
+	// TokenLimiter from estimated and actual token counts
+	limit := float64(config.MaxRPM)
+	if limit <= 0 {
 		// Default limit if not specified
 		limit = 1000.0
 	}
 
-	// Create rate limiter (per second)
-	limiter := rate.NewLimiter(rate.Limit(limit/60.0), int(limit/10.0)) // Burst = 1/10 of limit
+	// Create rate limiter (per second), burst = 1/10 of limit
+	burst := int(limit / 10.0)
+	if burst < 1 {
+		burst = 1
+	}
+	limiter := rate.NewLimiter(rate.Limit(limit/60.0), burst)
 	rl.limiters[modelID] = limiter
 
 	return limiter
@@ -84,6 +70,13 @@ func (rl *RateLimiter) Allow(modelID string, config registry.ModelConfig) bool {
 	return limiter.Allow()
 }
 
+// HasCapacity reports whether a request would currently be allowed without
+// consuming a token
+func (rl *RateLimiter) HasCapacity(modelID string, config registry.ModelConfig) bool {
+	limiter := rl.GetLimiter(modelID, config)
+	return limiter.Tokens() >= 1
+}
+
 // WaitN waits for N tokens from the rate limiter
 func (rl *RateLimiter) WaitN(ctx context.Context, modelID string, config registry.ModelConfig, n int) error {
 	limiter := rl.GetLimiter(modelID, config)
@@ -2,6 +2,7 @@ package limiter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
@@ -88,8 +89,9 @@ func (rm *RetryManager) Execute(ctx context.Context, fn RetryableFunc) (interfac
 
 // isRetryableError checks if an error is retryable
 func (rm *RetryManager) isRetryableError(err error) bool {
-	// Check if it's an HTTP error
-	if httpErr, ok := err.(*HTTPError); ok {
+	// Check if it's an HTTP error, possibly wrapped by the caller
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
 		for _, retryableCode := range rm.config.RetryableErrors {
 			if httpErr.StatusCode == retryableCode {
 				return true
@@ -98,7 +100,7 @@ func (rm *RetryManager) isRetryableError(err error) bool {
 	}
 
 	// Check for context cancellation
-	if err == context.Canceled || err == context.DeadlineExceeded {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 		return false
 	}
 
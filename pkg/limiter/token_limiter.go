@@ -0,0 +1,393 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/snow-ghost/agent/pkg/registry"
+)
+
+// ErrRateLimited is wrapped by every TokenLimitError
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Rate limit scopes
+const (
+	ScopeModel  = "model"
+	ScopeCaller = "caller"
+)
+
+// TokenLimitError is returned when a request cannot be admitted within the
+// limiter's maximum wait
+type TokenLimitError struct {
+	Scope      string // model|caller
+	Key        string // model ID or caller name
+	Unit       string // tokens|requests
+	Requested  int
+	Limit      int // per minute
+	RetryAfter time.Duration
+}
+
+func (e *TokenLimitError) Error() string {
+	if e.Requested > e.Limit {
+		return fmt.Sprintf("%s %s: request needs %d %s but the limit is %d per minute",
+			e.Scope, e.Key, e.Requested, e.Unit, e.Limit)
+	}
+	return fmt.Sprintf("%s %s: %d %s per minute exceeded, retry after %s",
+		e.Scope, e.Key, e.Limit, e.Unit, e.RetryAfter.Round(time.Millisecond))
+}
+
+func (e *TokenLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// tokenBucket refills continuously at limit per minute up to limit. Its level
+// may go negative when a response uses more tokens than were reserved; the
+// debt is paid back by refill before new requests are admitted.
+type tokenBucket struct {
+	mu     sync.Mutex
+	limit  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit int, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: float64(limit), tokens: float64(limit), last: now}
+}
+
+// refill adds tokens for the time elapsed since the last update
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.limit, b.tokens+elapsed*b.limit/60)
+		b.last = now
+	}
+}
+
+// take removes n tokens if available, otherwise reports how long until they
+// will be
+func (b *tokenBucket) take(n int, now time.Time) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		return 0, true
+	}
+
+	missing := float64(n) - b.tokens
+	return time.Duration(missing / (b.limit / 60) * float64(time.Second)), false
+}
+
+// adjust returns (negative delta) or charges (positive delta) tokens
+func (b *tokenBucket) adjust(delta int, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	b.tokens = math.Min(b.limit, b.tokens-float64(delta))
+}
+
+// idle reports whether the bucket has gone unused for longer than ttl and
+// has refilled to its limit, so that a new bucket would behave the same
+func (b *tokenBucket) idle(now time.Time, ttl time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Sub(b.last) <= ttl {
+		return false
+	}
+	b.refill(now)
+	return b.tokens >= b.limit
+}
+
+// available returns the current bucket level
+func (b *tokenBucket) available(now time.Time) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	return b.tokens
+}
+
+// bucketClaim is a request against one bucket
+type bucketClaim struct {
+	bucket *tokenBucket
+	scope  string
+	key    string
+	unit   string
+	n      int
+
+	// metered claims are reconciled against actual token usage
+	metered bool
+}
+
+// TokenReservation holds tokens taken ahead of a provider call. Exactly one of
+// Reconcile or Cancel should be called once the call finishes.
+type TokenReservation struct {
+	claims   []bucketClaim
+	reserved int
+	once     sync.Once
+}
+
+// Reserved returns the number of tokens reserved
+func (r *TokenReservation) Reserved() int {
+	if r == nil {
+		return 0
+	}
+	return r.reserved
+}
+
+// Reconcile charges or refunds the difference between the reserved tokens
+// and the tokens the call actually used
+func (r *TokenReservation) Reconcile(actual int) {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		now := time.Now()
+		for _, claim := range r.claims {
+			if claim.metered {
+				claim.bucket.adjust(actual-claim.n, now)
+			}
+		}
+	})
+}
+
+// Cancel refunds the reservation, for calls that failed before using tokens.
+// Request counts are not refunded.
+func (r *TokenReservation) Cancel() {
+	r.Reconcile(0)
+}
+
+// TokenLimiter enforces tokens-per-minute limits per model and tokens- and
+// requests-per-minute limits per caller. Tokens are reserved before a call
+// from an estimate and reconciled with actual usage afterwards.
+type TokenLimiter struct {
+	callers registry.RateLimitSettings
+	maxWait time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// idleBucketTTL is how long a full bucket may go unused before it is
+// dropped. Callers such as the worker's, one per task, would otherwise grow
+// the buckets without bound.
+const idleBucketTTL = time.Minute
+
+// DefaultMaxWait is how long a request may wait for capacity before it is
+// rejected
+const DefaultMaxWait = 30 * time.Second
+
+// NewTokenLimiter creates a token limiter with caller limits from settings
+func NewTokenLimiter(settings registry.RateLimitSettings) *TokenLimiter {
+	maxWait := DefaultMaxWait
+	if settings.MaxWaitMS > 0 {
+		maxWait = time.Duration(settings.MaxWaitMS) * time.Millisecond
+	}
+
+	return &TokenLimiter{
+		callers:   settings,
+		maxWait:   maxWait,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Reserve takes n tokens from the model's TPM bucket and the caller's TPM
+// bucket, and one request from the caller's RPM bucket, waiting for capacity
+// up to the maximum wait or the context deadline. Unlimited buckets are
+// skipped. It returns a *TokenLimitError if the request cannot be admitted.
+func (tl *TokenLimiter) Reserve(ctx context.Context, mc registry.ModelConfig, caller string, n int) (*TokenReservation, error) {
+	claims := tl.claims(mc, caller, n)
+	reservation := &TokenReservation{reserved: n}
+	if len(claims) == 0 {
+		return reservation, nil
+	}
+
+	// A request larger than a whole minute of capacity can never be admitted
+	for _, claim := range claims {
+		if float64(claim.n) > claim.bucket.limit {
+			return nil, claim.limitError(0)
+		}
+	}
+
+	deadline := time.Now().Add(tl.maxWait)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		wait, blocking, ok := tl.tryTake(claims)
+		if ok {
+			reservation.claims = claims
+			return reservation, nil
+		}
+
+		if time.Now().Add(wait).After(deadline) {
+			return nil, blocking.limitError(wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// tryTake takes every claim or none. On failure it returns the wait for the
+// first claim that could not be satisfied.
+func (tl *TokenLimiter) tryTake(claims []bucketClaim) (time.Duration, *bucketClaim, bool) {
+	now := time.Now()
+	for i := range claims {
+		wait, ok := claims[i].bucket.take(claims[i].n, now)
+		if ok {
+			continue
+		}
+
+		// Roll back the claims already taken
+		for j := 0; j < i; j++ {
+			claims[j].bucket.adjust(-claims[j].n, now)
+		}
+		return wait, &claims[i], false
+	}
+	return 0, nil, true
+}
+
+// claims lists the buckets a request draws from
+func (tl *TokenLimiter) claims(mc registry.ModelConfig, caller string, n int) []bucketClaim {
+	var claims []bucketClaim
+
+	if mc.MaxTPM > 0 {
+		claims = append(claims, bucketClaim{
+			bucket: tl.bucket("model-tpm:"+mc.ID, mc.MaxTPM),
+			scope:  ScopeModel, key: mc.ID, unit: "tokens", n: n, metered: true,
+		})
+	}
+
+	limit := tl.callerLimit(caller)
+	if limit.MaxTPM > 0 {
+		claims = append(claims, bucketClaim{
+			bucket: tl.bucket("caller-tpm:"+caller, limit.MaxTPM),
+			scope:  ScopeCaller, key: caller, unit: "tokens", n: n, metered: true,
+		})
+	}
+	if limit.MaxRPM > 0 {
+		claims = append(claims, bucketClaim{
+			bucket: tl.bucket("caller-rpm:"+caller, limit.MaxRPM),
+			scope:  ScopeCaller, key: caller, unit: "requests", n: 1,
+		})
+	}
+
+	return claims
+}
+
+// callerLimit returns the caller's configured limit or the default
+func (tl *TokenLimiter) callerLimit(caller string) registry.CallerLimit {
+	if limit, ok := tl.callers.Callers[caller]; ok {
+		return limit
+	}
+	return tl.callers.DefaultCaller
+}
+
+// bucket returns or creates the bucket for key, dropping idle buckets at
+// most once per idleBucketTTL
+func (tl *TokenLimiter) bucket(key string, limit int) *tokenBucket {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(tl.lastSweep) > idleBucketTTL {
+		for k, bucket := range tl.buckets {
+			if bucket.idle(now, idleBucketTTL) {
+				delete(tl.buckets, k)
+			}
+		}
+		tl.lastSweep = now
+	}
+
+	bucket, exists := tl.buckets[key]
+	if !exists || bucket.limit != float64(limit) {
+		bucket = newTokenBucket(limit, now)
+		tl.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// HasCapacity reports whether the model's TPM bucket could admit n tokens now
+func (tl *TokenLimiter) HasCapacity(mc registry.ModelConfig, n int) bool {
+	if mc.MaxTPM <= 0 {
+		return true
+	}
+	return tl.bucket("model-tpm:"+mc.ID, mc.MaxTPM).available(time.Now()) >= float64(n)
+}
+
+// GetStats returns the remaining capacity of the model's TPM bucket
+func (tl *TokenLimiter) GetStats(mc registry.ModelConfig) map[string]interface{} {
+	stats := map[string]interface{}{
+		"max_tpm": mc.MaxTPM,
+	}
+	if mc.MaxTPM > 0 {
+		stats["tokens_available"] = tl.bucket("model-tpm:"+mc.ID, mc.MaxTPM).available(time.Now())
+	}
+	return stats
+}
+
+// GetCallerStats returns the caller's limits and remaining capacity
+func (tl *TokenLimiter) GetCallerStats(caller string) map[string]interface{} {
+	limit := tl.callerLimit(caller)
+	stats := map[string]interface{}{
+		"caller":  caller,
+		"max_tpm": limit.MaxTPM,
+		"max_rpm": limit.MaxRPM,
+	}
+	now := time.Now()
+	if limit.MaxTPM > 0 {
+		stats["tokens_available"] = tl.bucket("caller-tpm:"+caller, limit.MaxTPM).available(now)
+	}
+	if limit.MaxRPM > 0 {
+		stats["requests_available"] = tl.bucket("caller-rpm:"+caller, limit.MaxRPM).available(now)
+	}
+	return stats
+}
+
+// Reset refills the model's tokens-per-minute bucket
+func (tl *TokenLimiter) Reset(modelID string) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	delete(tl.buckets, "model-tpm:"+modelID)
+}
+
+// ResetAll refills every bucket
+func (tl *TokenLimiter) ResetAll() {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tl.buckets = make(map[string]*tokenBucket)
+}
+
+// limitError describes why the claim could not be admitted
+func (c *bucketClaim) limitError(retryAfter time.Duration) *TokenLimitError {
+	return &TokenLimitError{
+		Scope:      c.scope,
+		Key:        c.key,
+		Unit:       c.unit,
+		Requested:  c.n,
+		Limit:      int(c.bucket.limit),
+		RetryAfter: retryAfter,
+	}
+}
@@ -0,0 +1,162 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/snow-ghost/agent/pkg/registry"
+)
+
+func TestTokenLimiterReconcile(t *testing.T) {
+	tl := NewTokenLimiter(registry.RateLimitSettings{MaxWaitMS: 10})
+	config := registry.ModelConfig{ID: "tpm-model", MaxTPM: 1000}
+	ctx := context.Background()
+
+	reservation, err := tl.Reserve(ctx, config, "", 600)
+	if err != nil {
+		t.Fatalf("Expected first reservation to succeed, got %v", err)
+	}
+
+	// 400 tokens left; a second estimate of 600 does not fit
+	if _, err := tl.Reserve(ctx, config, "", 600); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Expected rate limit error, got %v", err)
+	}
+
+	// The call used fewer tokens than estimated; the difference is refunded
+	reservation.Reconcile(100)
+	reservation.Reconcile(0) // only the first settlement counts
+	second, err := tl.Reserve(ctx, config, "", 600)
+	if err != nil {
+		t.Fatalf("Expected reservation to fit after refund, got %v", err)
+	}
+
+	// Usage beyond the estimate is charged, leaving the bucket in debt
+	second.Reconcile(1500)
+	if tl.HasCapacity(config, 1) {
+		t.Error("Expected no capacity while the bucket is in debt")
+	}
+
+	stats := tl.GetStats(config)
+	if available := stats["tokens_available"].(float64); available >= 0 {
+		t.Errorf("Expected negative balance, got %v", available)
+	}
+}
+
+func TestTokenLimiterCallerLimits(t *testing.T) {
+	tl := NewTokenLimiter(registry.RateLimitSettings{
+		DefaultCaller: registry.CallerLimit{MaxRPM: 2},
+		Callers:       map[string]registry.CallerLimit{"batch": {MaxTPM: 500}},
+		MaxWaitMS:     10,
+	})
+	config := registry.ModelConfig{ID: "shared-model", MaxTPM: 10000}
+	ctx := context.Background()
+
+	if _, err := tl.Reserve(ctx, config, "batch", 400); err != nil {
+		t.Fatalf("Expected batch reservation to succeed, got %v", err)
+	}
+	_, err := tl.Reserve(ctx, config, "batch", 400)
+	var limitErr *TokenLimitError
+	if !errors.As(err, &limitErr) || limitErr.Scope != ScopeCaller || limitErr.Key != "batch" || limitErr.Unit != "tokens" {
+		t.Fatalf("Expected caller token limit error, got %v", err)
+	}
+	if limitErr.RetryAfter <= 0 {
+		t.Error("Expected a retry delay")
+	}
+
+	// The failed caller claim must not keep the model tokens it took
+	if available := tl.GetStats(config)["tokens_available"].(float64); available < 9599 {
+		t.Errorf("Expected model tokens to be rolled back, got %v", available)
+	}
+
+	// Unlisted callers get the default request limit
+	for i := 0; i < 2; i++ {
+		reservation, err := tl.Reserve(ctx, config, "web", 10)
+		if err != nil {
+			t.Fatalf("Request %d: expected reservation to succeed, got %v", i, err)
+		}
+		reservation.Cancel()
+	}
+	_, err = tl.Reserve(ctx, config, "web", 10)
+	if !errors.As(err, &limitErr) || limitErr.Unit != "requests" {
+		t.Fatalf("Expected caller request limit error, got %v", err)
+	}
+}
+
+func TestTokenLimiterWaitsForRefill(t *testing.T) {
+	// 6000 TPM refills 100 tokens per second
+	tl := NewTokenLimiter(registry.RateLimitSettings{MaxWaitMS: 500})
+	config := registry.ModelConfig{ID: "refill-model", MaxTPM: 6000}
+	ctx := context.Background()
+
+	if _, err := tl.Reserve(ctx, config, "", 6000); err != nil {
+		t.Fatalf("Expected full reservation to succeed, got %v", err)
+	}
+
+	start := time.Now()
+	if _, err := tl.Reserve(ctx, config, "", 20); err != nil {
+		t.Fatalf("Expected reservation to succeed after waiting, got %v", err)
+	}
+	if waited := time.Since(start); waited < 150*time.Millisecond {
+		t.Errorf("Expected to wait for refill, waited %v", waited)
+	}
+
+	// Larger than a minute of capacity can never be admitted
+	_, err := tl.Reserve(ctx, config, "", 7000)
+	var limitErr *TokenLimitError
+	if !errors.As(err, &limitErr) || limitErr.Scope != ScopeModel || limitErr.RetryAfter != 0 {
+		t.Errorf("Expected oversized request to be rejected outright, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := tl.Reserve(cancelled, config, "", 100); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context error, got %v", err)
+	}
+}
+
+func TestTokenLimiterEvictsIdleBuckets(t *testing.T) {
+	tl := NewTokenLimiter(registry.RateLimitSettings{
+		DefaultCaller: registry.CallerLimit{MaxRPM: 10, MaxTPM: 1000},
+	})
+	config := registry.ModelConfig{ID: "shared-model", MaxTPM: 10000}
+	ctx := context.Background()
+
+	for _, caller := range []string{"worker/text/task-1", "worker/text/task-2", "web"} {
+		reservation, err := tl.Reserve(ctx, config, caller, 100)
+		if err != nil {
+			t.Fatalf("Expected reservation for %s to succeed, got %v", caller, err)
+		}
+		reservation.Reconcile(100)
+	}
+
+	// Age every bucket past the idle TTL; the web caller's tokens stay spent
+	past := time.Now().Add(-2 * idleBucketTTL)
+	tl.mu.Lock()
+	for key, bucket := range tl.buckets {
+		bucket.last = past
+		if key == "caller-tpm:web" {
+			bucket.tokens = -1e6
+		}
+	}
+	tl.lastSweep = past
+	tl.mu.Unlock()
+
+	if _, err := tl.Reserve(ctx, config, "worker/text/task-3", 100); err != nil {
+		t.Fatalf("Expected reservation to succeed, got %v", err)
+	}
+
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	for _, key := range []string{"caller-tpm:worker/text/task-1", "caller-rpm:worker/text/task-1", "caller-rpm:worker/text/task-2", "caller-rpm:web"} {
+		if _, exists := tl.buckets[key]; exists {
+			t.Errorf("Expected idle bucket %s to be evicted", key)
+		}
+	}
+	for _, key := range []string{"caller-tpm:web", "caller-tpm:worker/text/task-3", "caller-rpm:worker/text/task-3"} {
+		if _, exists := tl.buckets[key]; !exists {
+			t.Errorf("Expected bucket %s to be kept", key)
+		}
+	}
+}
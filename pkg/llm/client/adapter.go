@@ -3,18 +3,58 @@ package client
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wat"
 	routercore "github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/testkit"
 )
 
+// moduleRules describes the module the worker can run
+const moduleRules = `You are an expert WebAssembly developer. Write a WebAssembly text format (WAT) module that solves the given task.
+
+The module must:
+- export its memory as "memory" and a function "solve" with type (param i32 i32) (result i32 i32)
+- read its input as UTF-8 JSON of the form {"input": <task input>, "spec": <task spec>}; the host writes it at the pointer and length passed to solve, in pages it grows past the module's initial memory
+- write its output as a UTF-8 JSON object to memory and return its pointer and length
+- import nothing; tables, SIMD and reference types are not available
+`
+
+// watSystemPrompt asks for a module on its own
+const watSystemPrompt = moduleRules + `
+Reply with the complete module in a single wat code block.`
+
+// proposalSystemPrompt asks for a module together with tests and criteria;
+// the list of checks follows it
+const proposalSystemPrompt = moduleRules + `
+Also write test cases for the task. Each test input must have the same shape as the example input; give the expected output as the oracle when you know it.
+
+Reply with a single JSON object, and nothing else, that follows this schema:
+` + proposalSchema + `
+
+Criteria are either short descriptions, which hold when every test passes, or conditions over test metrics such as cases_failed == 0 or p95_latency_ms < 50. Prefix a description that contains an operator with desc:, as in desc: handles arrays with > 1000 elements.
+
+Test checks are written name(arguments), where arguments are given in order or as name=value and paths are JSONPath expressions such as $.sorted. The available checks are:
+`
+
+// checkList describes the checks tests may use, one per line
+func checkList() string {
+	var b strings.Builder
+	for _, c := range testkit.DefaultChecks.Checks() {
+		fmt.Fprintf(&b, "- %s: %s\n", c.Signature(), c.Doc)
+	}
+	return b.String()
+}
+
 // Adapter adapts the HTTP client to the core.LLMClient interface
 type Adapter struct {
-	client *Client
+	client LLMClient
 }
 
 // NewAdapter creates a new adapter
-func NewAdapter(client *Client) *Adapter {
+func NewAdapter(client LLMClient) *Adapter {
 	return &Adapter{
 		client: client,
 	}
@@ -136,22 +176,20 @@ func (a *Adapter) Propose(ctx context.Context, task core.Task) (string, []core.T
 	return a.ProposeWithCaller(ctx, task, caller)
 }
 
-// ProposeWithCaller implements core.LLMClient.ProposeWithCaller
+// ProposeWithCaller implements core.LLMClient.ProposeWithCaller. The model
+// replies with a structured proposal; the returned algorithm is WAT source
+// for the worker to assemble. Proposals that don't fit the task are rejected.
 func (a *Adapter) ProposeWithCaller(ctx context.Context, task core.Task, caller string) (string, []core.TestCase, []string, error) {
-	// Create a chat request for algorithm generation
-	prompt := fmt.Sprintf("Generate a WASM algorithm for the following task:\n\nDescription: %s\nDomain: %s\nSpec: %s",
-		task.Description, task.Domain, task.Spec)
-
 	req := ChatRequest{
 		Caller: caller,
 		Messages: []routercore.Message{
 			{
 				Role:    "system",
-				Content: "You are an expert algorithm developer. Generate WASM bytecode for the given task. Return only the algorithm description, not the actual bytecode.",
+				Content: proposalSystemPrompt + checkList(),
 			},
 			{
 				Role:    "user",
-				Content: prompt,
+				Content: taskPrompt(task),
 			},
 		},
 	}
@@ -162,21 +200,192 @@ func (a *Adapter) ProposeWithCaller(ctx context.Context, task core.Task, caller
 		return "", nil, nil, fmt.Errorf("LLM client error: %w", err)
 	}
 
-	// For now, return a simple algorithm description
-	// In a real implementation, this would parse the response and generate proper tests/criteria
-	algo := resp.Text
-	tests := []core.TestCase{
-		{
-			Name:   "basic_test",
-			Input:  []byte(`{"input": "test"}`),
-			Oracle: []byte(`{"output": "test"}`),
-			Checks: []string{"basic_check"},
-			Weight: 1.0,
+	proposal, err := ParseProposal(resp.Text)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("LLM proposal: %w", err)
+	}
+	if err := proposal.Validate(task); err != nil {
+		return "", nil, nil, fmt.Errorf("LLM proposal rejected: %w", err)
+	}
+
+	criteria := proposal.Criteria
+	if len(criteria) == 0 {
+		criteria = task.Spec.SuccessCriteria
+	}
+
+	return wat.Extract(proposal.Algorithm), proposal.TestCases(), criteria, nil
+}
+
+// RepairWithCaller implements core.SourceRepairer. The failing source and its
+// build error are sent back so that the model can correct its own output.
+func (a *Adapter) RepairWithCaller(ctx context.Context, task core.Task, source string, buildErr string, caller string) (string, error) {
+	req := ChatRequest{
+		Caller: caller,
+		Messages: []routercore.Message{
+			{
+				Role:    "system",
+				Content: watSystemPrompt,
+			},
+			{
+				Role:    "user",
+				Content: taskPrompt(task),
+			},
+			{
+				Role:    "assistant",
+				Content: "```wat\n" + source + "\n```",
+			},
+			{
+				Role:    "user",
+				Content: fmt.Sprintf("The module failed to build:\n\n%s\n\nFix the error and reply with the complete corrected module.", buildErr),
+			},
+		},
+	}
+
+	resp, err := a.client.Chat(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("LLM client error: %w", err)
+	}
+
+	return wat.Extract(resp.Text), nil
+}
+
+// maxFeedbackCases bounds how many failing tests are quoted in a mutation
+// request
+const maxFeedbackCases = 5
+
+// MutateWithCaller implements core.SourceEvolver. The model is shown the
+// module with its failing tests and metrics and asked for a targeted change.
+func (a *Adapter) MutateWithCaller(ctx context.Context, task core.Task, source string, feedback core.Feedback, caller string) (string, error) {
+	req := ChatRequest{
+		Caller: caller,
+		Messages: []routercore.Message{
+			{
+				Role:    "system",
+				Content: watSystemPrompt,
+			},
+			{
+				Role:    "user",
+				Content: taskPrompt(task),
+			},
+			{
+				Role:    "assistant",
+				Content: "```wat\n" + source + "\n```",
+			},
+			{
+				Role:    "user",
+				Content: feedbackPrompt(feedback),
+			},
+		},
+	}
+
+	resp, err := a.client.Chat(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("LLM client error: %w", err)
+	}
+
+	return wat.Extract(resp.Text), nil
+}
+
+// CrossoverWithCaller implements core.SourceEvolver. The model is asked to
+// merge two working modules into one.
+func (a *Adapter) CrossoverWithCaller(ctx context.Context, task core.Task, parentA, parentB string, caller string) (string, error) {
+	req := ChatRequest{
+		Caller: caller,
+		Messages: []routercore.Message{
+			{
+				Role:    "system",
+				Content: watSystemPrompt,
+			},
+			{
+				Role:    "user",
+				Content: taskPrompt(task),
+			},
+			{
+				Role: "user",
+				Content: fmt.Sprintf("Here are two candidate modules for this task.\n\nModule A:\n```wat\n%s\n```\n\nModule B:\n```wat\n%s\n```\n\n"+
+					"Combine the strengths of both into a single module and reply with the complete module.", parentA, parentB),
+			},
 		},
 	}
-	criteria := []string{"basic_check", "handles_input"}
 
-	return algo, tests, criteria, nil
+	resp, err := a.client.Chat(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("LLM client error: %w", err)
+	}
+
+	return wat.Extract(resp.Text), nil
+}
+
+// feedbackPrompt describes how a module fared against its tests
+func feedbackPrompt(feedback core.Feedback) string {
+	var b strings.Builder
+	if len(feedback.Failing) == 0 {
+		b.WriteString("The module passes its tests.")
+	} else {
+		b.WriteString("The module fails these tests:\n")
+		for i, tc := range feedback.Failing {
+			if i == maxFeedbackCases {
+				fmt.Fprintf(&b, "- and %d more\n", len(feedback.Failing)-i)
+				break
+			}
+			fmt.Fprintf(&b, "- %s: input %s", tc.Name, tc.Input)
+			if len(tc.Oracle) > 0 {
+				fmt.Fprintf(&b, ", expected %s", tc.Oracle)
+			}
+			if len(tc.Checks) > 0 {
+				fmt.Fprintf(&b, ", checks %s", strings.Join(tc.Checks, ", "))
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	if len(feedback.Metrics) > 0 {
+		keys := make([]string, 0, len(feedback.Metrics))
+		for key := range feedback.Metrics {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b.WriteString("\nMetrics:")
+		for _, key := range keys {
+			fmt.Fprintf(&b, " %s=%g", key, feedback.Metrics[key])
+		}
+		b.WriteString("\n")
+	}
+
+	if len(feedback.Failing) == 0 {
+		b.WriteString("\nMake a small change that improves these metrics without breaking the tests, and reply with the complete module.")
+	} else {
+		b.WriteString("\nMake a targeted change so that the module passes these tests, and reply with the complete corrected module.")
+	}
+	return b.String()
+}
+
+// taskPrompt describes a task for code generation
+func taskPrompt(task core.Task) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Description: %s\nDomain: %s\n", task.Description, task.Domain)
+
+	if len(task.Spec.SuccessCriteria) > 0 {
+		fmt.Fprintf(&b, "Success criteria: %s\n", strings.Join(task.Spec.SuccessCriteria, ", "))
+	}
+	if len(task.Spec.Props) > 0 {
+		keys := make([]string, 0, len(task.Spec.Props))
+		for key := range task.Spec.Props {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b.WriteString("Properties:\n")
+		for _, key := range keys {
+			fmt.Fprintf(&b, "- %s: %s\n", key, task.Spec.Props[key])
+		}
+	}
+	if len(task.Input) > 0 {
+		fmt.Fprintf(&b, "Example input: %s\n", task.Input)
+	}
+
+	return b.String()
 }
 
 // Embed implements core.LLMClient.Embed
@@ -194,5 +403,10 @@ func (a *Adapter) Health(ctx context.Context) error {
 	return a.client.Health(ctx)
 }
 
-// Ensure Adapter implements core.LLMClient interface
-var _ core.LLMClient = (*Adapter)(nil)
+// Ensure Adapter implements the core.LLMClient, core.SourceRepairer and
+// core.SourceEvolver interfaces
+var (
+	_ core.LLMClient      = (*Adapter)(nil)
+	_ core.SourceRepairer = (*Adapter)(nil)
+	_ core.SourceEvolver  = (*Adapter)(nil)
+)
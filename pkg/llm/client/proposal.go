@@ -0,0 +1,350 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wat"
+	"github.com/snow-ghost/agent/testkit"
+)
+
+// proposalSchema is the JSON schema proposals must follow
+const proposalSchema = `{
+  "type": "object",
+  "required": ["algorithm", "tests", "criteria"],
+  "properties": {
+    "algorithm": {"type": "string", "description": "complete WAT module"},
+    "tests": {
+      "type": "array",
+      "minItems": 1,
+      "items": {
+        "type": "object",
+        "required": ["name", "input"],
+        "properties": {
+          "name": {"type": "string"},
+          "input": {"description": "task input, same shape as the example input"},
+          "oracle": {"description": "expected output object, omit if unknown"},
+          "checks": {"type": "array", "items": {"type": "string"}, "description": "property checks from the list below"},
+          "weight": {"type": "number", "minimum": 0}
+        }
+      }
+    },
+    "criteria": {"type": "array", "items": {"type": "string"}}
+  }
+}`
+
+// Proposal is the structured reply to a proposal request
+type Proposal struct {
+	Algorithm string         `json:"algorithm"`
+	Tests     []ProposedTest `json:"tests"`
+	Criteria  []string       `json:"criteria"`
+}
+
+// ProposedTest is a test case as written by the model
+type ProposedTest struct {
+	Name   string          `json:"name"`
+	Input  json.RawMessage `json:"input"`
+	Oracle json.RawMessage `json:"oracle,omitempty"`
+	Checks []string        `json:"checks,omitempty"`
+	Weight float64         `json:"weight,omitempty"`
+}
+
+var fencePattern = regexp.MustCompile("(?s)```([A-Za-z]*)[ \t]*\r?\n(.*?)(?:```|$)")
+
+// ParseProposal extracts a proposal from a model reply. The JSON may be
+// fenced, surrounded by prose or cut off mid-way; a truncated object is
+// closed after its last complete member. When the algorithm field is empty
+// the module is taken from a separate wat block in the reply.
+func ParseProposal(reply string) (*Proposal, error) {
+	var lastErr error
+	for _, candidate := range jsonCandidates(reply) {
+		var p Proposal
+		if err := json.Unmarshal([]byte(candidate), &p); err != nil {
+			lastErr = err
+			continue
+		}
+		if p.Algorithm == "" && len(p.Tests) == 0 && len(p.Criteria) == 0 {
+			// Some other object, such as a data segment in a WAT block
+			continue
+		}
+
+		if p.Algorithm == "" && strings.Contains(reply, "(module") {
+			p.Algorithm = wat.Extract(reply)
+		}
+		return &p, nil
+	}
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("invalid proposal JSON: %w", lastErr)
+	}
+	return nil, fmt.Errorf("no JSON object found in reply")
+}
+
+// jsonCandidates returns the JSON objects a reply may contain, most likely
+// first: json-fenced blocks, then objects found in the raw text
+func jsonCandidates(reply string) []string {
+	var candidates []string
+	for _, m := range fencePattern.FindAllStringSubmatch(reply, -1) {
+		lang, body := strings.ToLower(m[1]), strings.TrimSpace(m[2])
+		if lang == "json" || (lang == "" && strings.HasPrefix(body, "{")) {
+			if obj, ok := balancedObject(body); ok {
+				candidates = append(candidates, obj)
+			}
+		}
+	}
+
+	for i := 0; i < len(reply); i++ {
+		if reply[i] != '{' {
+			continue
+		}
+		obj, ok := balancedObject(reply[i:])
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, obj)
+		if len(obj) > 0 {
+			i += len(obj) - 1
+		}
+	}
+	return candidates
+}
+
+// balancedObject returns the JSON object starting at the beginning of s. A
+// truncated object is cut at its last complete member and closed.
+func balancedObject(s string) (string, bool) {
+	if !strings.HasPrefix(s, "{") {
+		return "", false
+	}
+
+	var (
+		stack    []byte
+		inString bool
+		escaped  bool
+		cut      = -1
+		cutStack []byte
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			stack = append(stack, '}')
+		case '[':
+			stack = append(stack, ']')
+		case '}', ']':
+			if len(stack) == 0 || stack[len(stack)-1] != c {
+				return "", false
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return s[:i+1], true
+			}
+			cut, cutStack = i+1, append(cutStack[:0], stack...)
+		case ',':
+			cut, cutStack = i, append(cutStack[:0], stack...)
+		}
+	}
+
+	if cut < 0 {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteString(s[:cut])
+	for i := len(cutStack) - 1; i >= 0; i-- {
+		b.WriteByte(cutStack[i])
+	}
+	return b.String(), true
+}
+
+// Validate checks the proposal against the task: it needs a module, at least
+// one test and criteria unless the task has its own, criteria must be
+// well-formed, and every test input must have the shape of the task's input.
+func (p *Proposal) Validate(task core.Task) error {
+	if strings.TrimSpace(p.Algorithm) == "" {
+		return fmt.Errorf("proposal has no algorithm")
+	}
+	if len(p.Tests) == 0 {
+		return fmt.Errorf("proposal has no tests")
+	}
+	if len(p.Criteria) == 0 && len(task.Spec.SuccessCriteria) == 0 {
+		return fmt.Errorf("proposal has no criteria")
+	}
+	for _, criterion := range p.Criteria {
+		if _, err := core.ParseCriterion(criterion); err != nil {
+			return fmt.Errorf("criterion %q: %w", criterion, err)
+		}
+	}
+
+	var want any
+	if len(task.Input) > 0 {
+		if err := json.Unmarshal(task.Input, &want); err != nil {
+			return fmt.Errorf("task input is not valid JSON: %w", err)
+		}
+	}
+
+	names := make(map[string]bool, len(p.Tests))
+	for i, test := range p.Tests {
+		label := fmt.Sprintf("test %d", i+1)
+		if test.Name != "" {
+			label = fmt.Sprintf("test %q", test.Name)
+			if names[test.Name] {
+				return fmt.Errorf("%s: duplicate name", label)
+			}
+			names[test.Name] = true
+		}
+
+		if len(bytes.TrimSpace(test.Input)) == 0 {
+			return fmt.Errorf("%s: missing input", label)
+		}
+		if test.Weight < 0 {
+			return fmt.Errorf("%s: negative weight %g", label, test.Weight)
+		}
+		for _, check := range test.Checks {
+			if _, err := testkit.ParseCheck(check); err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
+		}
+
+		if want == nil {
+			continue
+		}
+		var got any
+		if err := json.Unmarshal(test.Input, &got); err != nil {
+			return fmt.Errorf("%s: invalid input: %w", label, err)
+		}
+		if err := matchShape(want, got, "input"); err != nil {
+			return fmt.Errorf("%s: %w", label, err)
+		}
+	}
+
+	return nil
+}
+
+// TestCases converts the proposed tests, naming unnamed ones and giving
+// unweighted ones a weight of 1
+func (p *Proposal) TestCases() []core.TestCase {
+	cases := make([]core.TestCase, len(p.Tests))
+	for i, test := range p.Tests {
+		name := test.Name
+		if name == "" {
+			name = fmt.Sprintf("case_%d", i+1)
+		}
+		weight := test.Weight
+		if weight == 0 {
+			weight = 1.0
+		}
+
+		var oracle []byte
+		if trimmed := bytes.TrimSpace(test.Oracle); len(trimmed) > 0 && !bytes.Equal(trimmed, []byte("null")) {
+			oracle = trimmed
+		}
+
+		cases[i] = core.TestCase{
+			Name:   name,
+			Input:  bytes.TrimSpace(test.Input),
+			Oracle: oracle,
+			Checks: test.Checks,
+			Weight: weight,
+		}
+	}
+	return cases
+}
+
+// matchShape reports where got differs in structure from want. Objects need
+// the same fields, arrays are matched element-wise against want's first
+// element, and null in want matches anything.
+func matchShape(want, got any, path string) error {
+	if want == nil {
+		return nil
+	}
+
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %s", path, jsonKind(got))
+		}
+		for _, key := range sortedKeys(w) {
+			value, ok := g[key]
+			if !ok {
+				return fmt.Errorf("%s: missing field %q", path, key)
+			}
+			if err := matchShape(w[key], value, path+"."+key); err != nil {
+				return err
+			}
+		}
+		for _, key := range sortedKeys(g) {
+			if _, ok := w[key]; !ok {
+				return fmt.Errorf("%s: unexpected field %q", path, key)
+			}
+		}
+		return nil
+
+	case []any:
+		g, ok := got.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %s", path, jsonKind(got))
+		}
+		if len(w) == 0 {
+			return nil
+		}
+		for i, elem := range g {
+			if err := matchShape(w[0], elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		if jsonKind(want) != jsonKind(got) {
+			return fmt.Errorf("%s: expected %s, got %s", path, jsonKind(want), jsonKind(got))
+		}
+		return nil
+	}
+}
+
+func jsonKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
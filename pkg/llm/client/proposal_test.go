@@ -0,0 +1,120 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sortModule = `(module (memory (export "memory") 2) (func (export "solve") (param i32 i32) (result i32 i32) (local.get 0) (local.get 1)))`
+
+func sortTask() core.Task {
+	return core.Task{
+		ID:     "sort-1",
+		Domain: "algorithms",
+		Input:  json.RawMessage(`{"numbers": [3, 1, 2], "order": "asc"}`),
+	}
+}
+
+func TestParseProposal(t *testing.T) {
+	algorithm, _ := json.Marshal(sortModule)
+	body := `{
+  "algorithm": ` + string(algorithm) + `,
+  "tests": [
+    {"name": "small", "input": {"numbers": [2, 1], "order": "asc"}, "oracle": {"sorted": [1, 2]}, "checks": ["sorted_non_decreasing"], "weight": 2},
+    {"input": {"numbers": [], "order": "desc"}}
+  ],
+  "criteria": ["sorted_non_decreasing", "permutes"]
+}`
+
+	cases := []struct {
+		name  string
+		reply string
+	}{
+		{"bare", body},
+		{"fenced", "Here is my proposal:\n```json\n" + body + "\n```\nGood luck!"},
+		{"prose", "Sure. " + body + " Let me know if you need more."},
+		{"separate module", "```wat\n" + sortModule + "\n```\n```json\n" +
+			`{"algorithm": "", "tests": [{"name": "small", "input": {"numbers": [2, 1], "order": "asc"}, "oracle": {"sorted": [1, 2]}, "checks": ["sorted_non_decreasing"], "weight": 2}, {"input": {"numbers": [], "order": "desc"}}], "criteria": ["sorted_non_decreasing", "permutes"]}` +
+			"\n```"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ParseProposal(tc.reply)
+			require.NoError(t, err)
+			require.NoError(t, p.Validate(sortTask()))
+
+			assert.Equal(t, sortModule, p.Algorithm)
+			assert.Equal(t, []string{"sorted_non_decreasing", "permutes"}, p.Criteria)
+
+			tests := p.TestCases()
+			require.Len(t, tests, 2)
+			assert.Equal(t, "small", tests[0].Name)
+			assert.JSONEq(t, `{"sorted": [1, 2]}`, string(tests[0].Oracle))
+			assert.Equal(t, 2.0, tests[0].Weight)
+			assert.Equal(t, "case_2", tests[1].Name)
+			assert.JSONEq(t, `{"numbers": [], "order": "desc"}`, string(tests[1].Input))
+			assert.Nil(t, tests[1].Oracle)
+			assert.Equal(t, 1.0, tests[1].Weight)
+		})
+	}
+}
+
+func TestParseTruncatedProposal(t *testing.T) {
+	algorithm, _ := json.Marshal(sortModule)
+	reply := "```json\n{\"algorithm\": " + string(algorithm) + `, "tests": [{"input": {"numbers": [1], "order": "asc"}}, {"input": {"numb`
+
+	p, err := ParseProposal(reply)
+	require.NoError(t, err)
+	assert.Equal(t, sortModule, p.Algorithm)
+	require.Len(t, p.Tests, 1)
+	assert.Empty(t, p.Criteria)
+
+	_, err = ParseProposal(`{"algorithm": "(module`)
+	assert.Error(t, err)
+
+	_, err = ParseProposal("I could not come up with anything.")
+	assert.EqualError(t, err, "no JSON object found in reply")
+}
+
+func TestValidateProposal(t *testing.T) {
+	cases := []struct {
+		name  string
+		tests string
+		want  string
+	}{
+		{"missing field", `[{"input": {"numbers": [1]}}]`, `input: missing field "order"`},
+		{"extra field", `[{"input": {"numbers": [1], "order": "asc", "limit": 3}}]`, `input: unexpected field "limit"`},
+		{"wrong element", `[{"name": "strings", "input": {"numbers": ["b", "a"], "order": "asc"}}]`, `test "strings": input.numbers[0]: expected number, got string`},
+		{"wrong type", `[{"input": [3, 1, 2]}]`, "input: expected object, got array"},
+		{"no input", `[{"name": "empty"}]`, `test "empty": missing input`},
+		{"duplicate", `[{"name": "a", "input": {"numbers": [], "order": "asc"}}, {"name": "a", "input": {"numbers": [], "order": "asc"}}]`, `test "a": duplicate name`},
+		{"no tests", `[]`, "proposal has no tests"},
+		{"unknown check", `[{"name": "c", "input": {"numbers": [], "order": "asc"}, "checks": ["is_fast"]}]`, `test "c": unknown check "is_fast"`},
+		{"bad check argument", `[{"input": {"numbers": [], "order": "asc"}, "checks": ["monotone(order=up)"]}]`, `order: "up" is not one of asc, desc`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ParseProposal(`{"algorithm": "(module)", "tests": ` + tc.tests + `, "criteria": ["ok"]}`)
+			require.NoError(t, err)
+			assert.ErrorContains(t, p.Validate(sortTask()), tc.want)
+		})
+	}
+
+	p := &Proposal{Algorithm: "(module)", Tests: []ProposedTest{{Input: json.RawMessage(`{"numbers": [], "order": "asc"}`)}}}
+	assert.EqualError(t, p.Validate(sortTask()), "proposal has no criteria")
+
+	task := sortTask()
+	task.Spec.SuccessCriteria = []string{"sorted_non_decreasing"}
+	assert.NoError(t, p.Validate(task))
+
+	p.Criteria = []string{"permutes", "cases_failed == 0 && fuel_used < 1e6", "desc: handles arrays with > 1000 elements"}
+	assert.NoError(t, p.Validate(task))
+	p.Criteria = []string{"cases_failed == true"}
+	assert.EqualError(t, p.Validate(task), `criterion "cases_failed == true": cannot compare a number with a bool`)
+}
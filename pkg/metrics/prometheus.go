@@ -24,6 +24,9 @@ type PrometheusMetrics struct {
 	CacheHitsTotal   prometheus.Counter
 	CacheMissesTotal prometheus.Counter
 
+	// Deduplication metrics
+	DedupRequestsTotal *prometheus.CounterVec
+
 	// Retry metrics
 	RetriesTotal *prometheus.CounterVec
 
@@ -95,6 +98,15 @@ func NewPrometheusMetrics() *PrometheusMetrics {
 			},
 		),
 
+		// Deduplication metrics
+		DedupRequestsTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "llm_dedup_requests_total",
+				Help: "Total number of deduplicable requests by whether they shared an in-flight result",
+			},
+			[]string{"endpoint", "outcome"},
+		),
+
 		// Retry metrics
 		RetriesTotal: promauto.NewCounterVec(
 			prometheus.CounterOpts{
@@ -166,6 +178,11 @@ func (m *PrometheusMetrics) RecordCacheMiss() {
 	m.CacheMissesTotal.Inc()
 }
 
+// RecordDedup records a deduplicable request; outcome is "leader" or "deduplicated"
+func (m *PrometheusMetrics) RecordDedup(endpoint, outcome string) {
+	m.DedupRequestsTotal.WithLabelValues(endpoint, outcome).Inc()
+}
+
 // RecordRetry records a retry
 func (m *PrometheusMetrics) RecordRetry(provider, model, reason string) {
 	m.RetriesTotal.WithLabelValues(provider, model, reason).Inc()
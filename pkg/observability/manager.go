@@ -120,6 +120,15 @@ func (m *Manager) RecordCacheMetrics(hit bool) {
 	}
 }
 
+// RecordDedupMetrics records whether a request shared an identical in-flight request's result
+func (m *Manager) RecordDedupMetrics(endpoint string, deduplicated bool) {
+	if deduplicated {
+		m.metrics.RecordDedup(endpoint, "deduplicated")
+	} else {
+		m.metrics.RecordDedup(endpoint, "leader")
+	}
+}
+
 // RecordRetryMetrics records retry metrics
 func (m *Manager) RecordRetryMetrics(provider, model, reason string) {
 	m.metrics.RecordRetry(provider, model, reason)
@@ -1,16 +1,21 @@
 package providers
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/snow-ghost/agent/pkg/limiter"
 	"github.com/snow-ghost/agent/pkg/registry"
 	"github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/pkg/streaming"
 )
 
 // AnthropicProvider implements the Provider interface for Anthropic Claude API
@@ -31,9 +36,11 @@ type AnthropicMessage struct {
 type AnthropicRequest struct {
 	Model       string             `json:"model"`
 	MaxTokens   int                `json:"max_tokens"`
+	System      string             `json:"system,omitempty"`
 	Messages    []AnthropicMessage `json:"messages"`
 	Temperature float32            `json:"temperature,omitempty"`
 	TopP        float32            `json:"top_p,omitempty"`
+	Stream      bool               `json:"stream,omitempty"`
 }
 
 // AnthropicResponse represents the response format from Anthropic API
@@ -49,6 +56,30 @@ type AnthropicResponse struct {
 	StopReason string `json:"stop_reason"`
 }
 
+// AnthropicStreamEvent represents a server-sent event from the streaming
+// Messages API (message_start, content_block_delta, message_delta, ...)
+type AnthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
 // NewAnthropicProvider creates a new Anthropic provider
 func NewAnthropicProvider(baseURL, apiKey string) *AnthropicProvider {
 	// Create a default registry for cost calculation
@@ -66,44 +97,11 @@ func NewAnthropicProvider(baseURL, apiKey string) *AnthropicProvider {
 
 // Chat performs chat completion using Anthropic API
 func (p *AnthropicProvider) Chat(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest) (core.ChatResponse, error) {
-	// Convert messages (Anthropic uses different format)
-	messages := make([]AnthropicMessage, 0, len(req.Messages))
-	for _, msg := range req.Messages {
-		// Skip system messages as Anthropic handles them differently
-		if msg.Role == "system" {
-			continue
-		}
-		messages = append(messages, AnthropicMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		})
-	}
-
-	// Build request
-	anthropicReq := AnthropicRequest{
-		Model:       mc.ID,
-		MaxTokens:   req.MaxTokens,
-		Messages:    messages,
-		Temperature: req.Temperature,
-		TopP:        req.TopP,
-	}
-
-	// Marshal request
-	reqBody, err := json.Marshal(anthropicReq)
-	if err != nil {
-		return core.ChatResponse{}, fmt.Errorf("failed to marshal anthropic request: %w", err)
-	}
-
-	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/v1/messages", bytes.NewReader(reqBody))
+	httpReq, err := p.newMessagesRequest(ctx, mc, req, false)
 	if err != nil {
-		return core.ChatResponse{}, fmt.Errorf("failed to create HTTP request: %w", err)
+		return core.ChatResponse{}, err
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-api-key", p.apiKey)
-	httpReq.Header.Set("anthropic-version", "2023-06-01")
-
 	// Make request
 	resp, err := p.client.Do(httpReq)
 	if err != nil {
@@ -112,7 +110,9 @@ func (p *AnthropicProvider) Chat(ctx context.Context, mc registry.ModelConfig, r
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return core.ChatResponse{}, fmt.Errorf("anthropic API returned status %d", resp.StatusCode)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return core.ChatResponse{}, fmt.Errorf("anthropic API request failed: %w",
+			limiter.NewHTTPError(resp.StatusCode, http.StatusText(resp.StatusCode), string(body)))
 	}
 
 	// Parse response
@@ -145,6 +145,135 @@ func (p *AnthropicProvider) Chat(ctx context.Context, mc registry.ModelConfig, r
 	return chatResp, nil
 }
 
+// ChatStream performs streaming chat completion using the Anthropic Messages API
+func (p *AnthropicProvider) ChatStream(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) (core.Usage, error) {
+	httpReq, err := p.newMessagesRequest(ctx, mc, req, true)
+	if err != nil {
+		return core.Usage{}, err
+	}
+
+	// The client timeout would cut off long streams; rely on ctx instead
+	client := *p.client
+	client.Timeout = 0
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return core.Usage{}, fmt.Errorf("anthropic API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return core.Usage{}, fmt.Errorf("anthropic API request failed: %w",
+			limiter.NewHTTPError(resp.StatusCode, http.StatusText(resp.StatusCode), string(body)))
+	}
+
+	handler := NewStreamHandler(writer)
+	if err := handler.WriteStart(mc.ID, mc.Provider); err != nil {
+		return core.Usage{}, err
+	}
+
+	var usage core.Usage
+	finishReason := "stop"
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+
+		var event AnthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			return core.Usage{}, fmt.Errorf("failed to decode anthropic stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			usage.PromptTokens = event.Message.Usage.InputTokens
+			usage.CompletionTokens = event.Message.Usage.OutputTokens
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				if err := handler.HandleChunk(event.Delta.Text); err != nil {
+					return core.Usage{}, err
+				}
+			}
+		case "message_delta":
+			// Output token counts in message_delta are cumulative
+			usage.CompletionTokens = event.Usage.OutputTokens
+			if event.Delta.StopReason != "" {
+				finishReason = event.Delta.StopReason
+			}
+		case "error":
+			return core.Usage{}, fmt.Errorf("anthropic stream error: %s: %s", event.Error.Type, event.Error.Message)
+		}
+
+		if event.Type == "message_stop" {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return core.Usage{}, fmt.Errorf("failed to read anthropic stream: %w", err)
+	}
+
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	handler.HandleUsage(usage)
+
+	cost, currency := p.calculateCost(mc, usage)
+	return usage, handler.HandleDone(mc.ID, mc.Provider, finishReason, cost, currency)
+}
+
+// newMessagesRequest builds an HTTP request for the Anthropic Messages API
+func (p *AnthropicProvider) newMessagesRequest(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, stream bool) (*http.Request, error) {
+	// Convert messages (Anthropic uses different format)
+	messages := make([]AnthropicMessage, 0, len(req.Messages))
+	var system string
+	for _, msg := range req.Messages {
+		// System messages go into the top-level system field
+		if msg.Role == "system" {
+			if system != "" {
+				system += "\n\n"
+			}
+			system += msg.Content
+			continue
+		}
+		messages = append(messages, AnthropicMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		})
+	}
+
+	// Build request
+	anthropicReq := AnthropicRequest{
+		Model:       UpstreamModelName(mc),
+		MaxTokens:   req.MaxTokens,
+		System:      system,
+		Messages:    messages,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stream:      stream,
+	}
+
+	// Marshal request
+	reqBody, err := json.Marshal(anthropicReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal anthropic request: %w", err)
+	}
+
+	// Create HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/v1/messages", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", p.apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+
+	return httpReq, nil
+}
+
 // Embed generates embeddings (Anthropic doesn't have embeddings API, return error)
 func (p *AnthropicProvider) Embed(ctx context.Context, mc registry.ModelConfig, input []string) ([][]float32, core.Usage, error) {
 	return nil, core.Usage{}, fmt.Errorf("anthropic does not support embeddings")
@@ -2,8 +2,12 @@ package providers
 
 import (
 	"context"
+	"errors"
+	"strings"
 
+	"github.com/sashabaranov/go-openai"
 	"github.com/snow-ghost/agent/pkg/cost"
+	"github.com/snow-ghost/agent/pkg/limiter"
 	"github.com/snow-ghost/agent/pkg/registry"
 	"github.com/snow-ghost/agent/pkg/router/core"
 	"github.com/snow-ghost/agent/pkg/tokens"
@@ -72,6 +76,20 @@ func (b *BaseProvider) GetCostCalculator() *cost.Calculator {
 	return b.costCalculator
 }
 
+// calculateCost calculates the cost of the usage for a model
+func (b *BaseProvider) calculateCost(mc registry.ModelConfig, usage core.Usage) (float64, string) {
+	if b.costCalculator != nil {
+		if result, err := b.costCalculator.CalcCostForModel(mc.ID, usage); err == nil {
+			return result.TotalCost, result.Currency
+		}
+	}
+	currency := mc.Pricing.Currency
+	if currency == "" {
+		currency = "USD"
+	}
+	return 0.0, currency
+}
+
 // EstimateUsage estimates token usage when not provided by the provider
 func (b *BaseProvider) EstimateUsage(messages []string, responseText string) core.Usage {
 	var totalInputTokens int
@@ -102,3 +120,29 @@ func (b *BaseProvider) EstimateUsage(messages []string, responseText string) cor
 		TotalTokens:      totalInputTokens + completionTokens,
 	}
 }
+
+// UpstreamModelName returns the model name expected by the provider API.
+// Registry IDs are namespaced as "provider:model"; the prefix is stripped
+// when it matches the configured provider.
+func UpstreamModelName(mc registry.ModelConfig) string {
+	if name, ok := strings.CutPrefix(mc.ID, mc.Provider+":"); ok {
+		return name
+	}
+	return mc.ID
+}
+
+// asHTTPError converts OpenAI client errors into limiter.HTTPError so that
+// retry and circuit breaker logic can inspect the upstream status code
+func asHTTPError(err error) error {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode != 0 {
+		return limiter.NewHTTPError(apiErr.HTTPStatusCode, apiErr.Message, "")
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode != 0 {
+		return limiter.NewHTTPError(reqErr.HTTPStatusCode, reqErr.Error(), string(reqErr.Body))
+	}
+
+	return err
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/sashabaranov/go-openai"
 	"github.com/snow-ghost/agent/pkg/registry"
 	"github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/pkg/streaming"
 )
 
 // LMStudioProvider implements the Provider interface for LM Studio (OpenAI-compatible)
@@ -64,7 +65,7 @@ func (p *LMStudioProvider) Chat(ctx context.Context, mc registry.ModelConfig, re
 
 	// Build request
 	request := openai.ChatCompletionRequest{
-		Model:       mc.ID,
+		Model:       UpstreamModelName(mc),
 		Messages:    messages,
 		Temperature: req.Temperature,
 		TopP:        req.TopP,
@@ -79,7 +80,11 @@ func (p *LMStudioProvider) Chat(ctx context.Context, mc registry.ModelConfig, re
 	// Make API call
 	response, err := p.client.CreateChatCompletion(ctx, request)
 	if err != nil {
-		return core.ChatResponse{}, fmt.Errorf("lmstudio chat completion failed: %w", err)
+		return core.ChatResponse{}, fmt.Errorf("lmstudio chat completion failed: %w", asHTTPError(err))
+	}
+
+	if len(response.Choices) == 0 {
+		return core.ChatResponse{}, fmt.Errorf("lmstudio chat completion returned no choices")
 	}
 
 	// Convert response
@@ -115,16 +120,21 @@ func (p *LMStudioProvider) Chat(ctx context.Context, mc registry.ModelConfig, re
 	return chatResp, nil
 }
 
+// ChatStream performs streaming chat completion using LM Studio (OpenAI-compatible API)
+func (p *LMStudioProvider) ChatStream(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) (core.Usage, error) {
+	return streamOpenAICompatible(ctx, p.client, p.BaseProvider, mc, req, writer)
+}
+
 // Embed generates embeddings using LM Studio (OpenAI-compatible API)
 func (p *LMStudioProvider) Embed(ctx context.Context, mc registry.ModelConfig, input []string) ([][]float32, core.Usage, error) {
 	request := openai.EmbeddingRequest{
 		Input: input,
-		Model: openai.EmbeddingModel(mc.ID),
+		Model: openai.EmbeddingModel(UpstreamModelName(mc)),
 	}
 
 	response, err := p.client.CreateEmbeddings(ctx, request)
 	if err != nil {
-		return nil, core.Usage{}, fmt.Errorf("lmstudio embeddings failed: %w", err)
+		return nil, core.Usage{}, fmt.Errorf("lmstudio embeddings failed: %w", asHTTPError(err))
 	}
 
 	// Convert embeddings
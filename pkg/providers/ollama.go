@@ -1,15 +1,20 @@
 package providers
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/snow-ghost/agent/pkg/limiter"
 	"github.com/snow-ghost/agent/pkg/registry"
 	"github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/pkg/streaming"
 )
 
 // OllamaProvider implements the Provider interface for Ollama API
@@ -33,12 +38,17 @@ type OllamaRequest struct {
 	Options  map[string]interface{} `json:"options,omitempty"`
 }
 
-// OllamaResponse represents the response format from Ollama API
+// OllamaResponse represents the response format from Ollama API. When
+// streaming, each NDJSON line has this shape and the final one carries
+// done=true with the token counts.
 type OllamaResponse struct {
-	Model     string        `json:"model"`
-	Message   OllamaMessage `json:"message"`
-	Done      bool          `json:"done"`
-	CreatedAt string        `json:"created_at"`
+	Model           string        `json:"model"`
+	Message         OllamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason,omitempty"`
+	CreatedAt       string        `json:"created_at"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+	EvalCount       int           `json:"eval_count,omitempty"`
 }
 
 // OllamaEmbedRequest represents the request format for Ollama embeddings
@@ -68,6 +78,111 @@ func NewOllamaProvider(baseURL string) *OllamaProvider {
 
 // Chat performs chat completion using Ollama API
 func (p *OllamaProvider) Chat(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest) (core.ChatResponse, error) {
+	httpReq, err := p.newChatRequest(ctx, mc, req, false)
+	if err != nil {
+		return core.ChatResponse{}, err
+	}
+
+	// Make request
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return core.ChatResponse{}, fmt.Errorf("ollama API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return core.ChatResponse{}, fmt.Errorf("ollama API request failed: %w",
+			limiter.NewHTTPError(resp.StatusCode, http.StatusText(resp.StatusCode), string(body)))
+	}
+
+	// Parse response
+	var ollamaResp OllamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return core.ChatResponse{}, fmt.Errorf("failed to decode ollama response: %w", err)
+	}
+
+	// Convert response
+	chatResp := core.ChatResponse{
+		Text:         ollamaResp.Message.Content,
+		Usage:        ollamaUsage(req, ollamaResp, ollamaResp.Message.Content),
+		Model:        mc.ID,
+		Provider:     mc.Provider,
+		FinishReason: ollamaFinishReason(ollamaResp),
+	}
+
+	return chatResp, nil
+}
+
+// ChatStream performs streaming chat completion using Ollama's NDJSON stream
+func (p *OllamaProvider) ChatStream(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) (core.Usage, error) {
+	httpReq, err := p.newChatRequest(ctx, mc, req, true)
+	if err != nil {
+		return core.Usage{}, err
+	}
+
+	// The client timeout would cut off long streams; rely on ctx instead
+	client := *p.client
+	client.Timeout = 0
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return core.Usage{}, fmt.Errorf("ollama API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return core.Usage{}, fmt.Errorf("ollama API request failed: %w",
+			limiter.NewHTTPError(resp.StatusCode, http.StatusText(resp.StatusCode), string(body)))
+	}
+
+	handler := NewStreamHandler(writer)
+	if err := handler.WriteStart(mc.ID, mc.Provider); err != nil {
+		return core.Usage{}, err
+	}
+
+	var text strings.Builder
+	var final OllamaResponse
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk OllamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return core.Usage{}, fmt.Errorf("failed to decode ollama stream chunk: %w", err)
+		}
+
+		if chunk.Message.Content != "" {
+			text.WriteString(chunk.Message.Content)
+			if err := handler.HandleChunk(chunk.Message.Content); err != nil {
+				return core.Usage{}, err
+			}
+		}
+
+		if chunk.Done {
+			final = chunk
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return core.Usage{}, fmt.Errorf("failed to read ollama stream: %w", err)
+	}
+
+	usage := ollamaUsage(req, final, text.String())
+	handler.HandleUsage(usage)
+
+	cost, currency := p.calculateCost(mc, usage)
+	return usage, handler.HandleDone(mc.ID, mc.Provider, ollamaFinishReason(final), cost, currency)
+}
+
+// newChatRequest builds an HTTP request for the Ollama chat API
+func (p *OllamaProvider) newChatRequest(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, stream bool) (*http.Request, error) {
 	// Convert messages
 	messages := make([]OllamaMessage, len(req.Messages))
 	for i, msg := range req.Messages {
@@ -79,9 +194,9 @@ func (p *OllamaProvider) Chat(ctx context.Context, mc registry.ModelConfig, req
 
 	// Build request
 	ollamaReq := OllamaRequest{
-		Model:    mc.ID,
+		Model:    UpstreamModelName(mc),
 		Messages: messages,
-		Stream:   false, // We'll handle streaming separately
+		Stream:   stream,
 		Options: map[string]interface{}{
 			"temperature": req.Temperature,
 			"top_p":       req.TopP,
@@ -92,57 +207,49 @@ func (p *OllamaProvider) Chat(ctx context.Context, mc registry.ModelConfig, req
 	// Marshal request
 	reqBody, err := json.Marshal(ollamaReq)
 	if err != nil {
-		return core.ChatResponse{}, fmt.Errorf("failed to marshal ollama request: %w", err)
+		return nil, fmt.Errorf("failed to marshal ollama request: %w", err)
 	}
 
 	// Create HTTP request
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.baseURL+"/api/chat", bytes.NewReader(reqBody))
 	if err != nil {
-		return core.ChatResponse{}, fmt.Errorf("failed to create HTTP request: %w", err)
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
+	return httpReq, nil
+}
 
-	// Make request
-	resp, err := p.client.Do(httpReq)
-	if err != nil {
-		return core.ChatResponse{}, fmt.Errorf("ollama API request failed: %w", err)
-	}
-	defer resp.Body.Close()
+// ollamaUsage returns the token counts reported by Ollama, estimating them
+// when the server leaves them out
+func ollamaUsage(req core.ChatRequest, resp OllamaResponse, text string) core.Usage {
+	promptTokens := resp.PromptEvalCount
+	completionTokens := resp.EvalCount
 
-	if resp.StatusCode != http.StatusOK {
-		return core.ChatResponse{}, fmt.Errorf("ollama API returned status %d", resp.StatusCode)
+	estimator := &MockUsageEstimator{}
+	if promptTokens == 0 {
+		for _, msg := range req.Messages {
+			tokens, _ := estimator.EstimateTokens(msg.Content)
+			promptTokens += tokens
+		}
 	}
-
-	// Parse response
-	var ollamaResp OllamaResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return core.ChatResponse{}, fmt.Errorf("failed to decode ollama response: %w", err)
+	if completionTokens == 0 {
+		completionTokens = estimator.EstimateCompletionTokens(text)
 	}
 
-	// Estimate usage (Ollama doesn't provide token counts)
-	estimator := &MockUsageEstimator{}
-	promptTokens := 0
-	for _, msg := range req.Messages {
-		tokens, _ := estimator.EstimateTokens(msg.Content)
-		promptTokens += tokens
+	return core.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
 	}
-	completionTokens := estimator.EstimateCompletionTokens(ollamaResp.Message.Content)
+}
 
-	// Convert response
-	chatResp := core.ChatResponse{
-		Text: ollamaResp.Message.Content,
-		Usage: core.Usage{
-			PromptTokens:     promptTokens,
-			CompletionTokens: completionTokens,
-			TotalTokens:      promptTokens + completionTokens,
-		},
-		Model:        mc.ID,
-		Provider:     mc.Provider,
-		FinishReason: "stop", // Ollama doesn't provide detailed finish reasons
+// ollamaFinishReason maps Ollama's done_reason to a finish reason
+func ollamaFinishReason(resp OllamaResponse) string {
+	if resp.DoneReason != "" {
+		return resp.DoneReason
 	}
-
-	return chatResp, nil
+	return "stop"
 }
 
 // Embed generates embeddings using Ollama API
@@ -153,7 +260,7 @@ func (p *OllamaProvider) Embed(ctx context.Context, mc registry.ModelConfig, inp
 	for i, text := range input {
 		// Create request for this input
 		ollamaReq := OllamaEmbedRequest{
-			Model:  mc.ID,
+			Model:  UpstreamModelName(mc),
 			Prompt: text,
 		}
 
@@ -179,7 +286,9 @@ func (p *OllamaProvider) Embed(ctx context.Context, mc registry.ModelConfig, inp
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			return nil, core.Usage{}, fmt.Errorf("ollama embed API returned status %d", resp.StatusCode)
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			return nil, core.Usage{}, fmt.Errorf("ollama embed API request failed: %w",
+				limiter.NewHTTPError(resp.StatusCode, http.StatusText(resp.StatusCode), string(body)))
 		}
 
 		// Parse response
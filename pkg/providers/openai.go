@@ -8,6 +8,7 @@ import (
 	"github.com/sashabaranov/go-openai"
 	"github.com/snow-ghost/agent/pkg/registry"
 	"github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/pkg/streaming"
 )
 
 // OpenAIProvider implements the Provider interface for OpenAI-compatible APIs
@@ -68,7 +69,7 @@ func (p *OpenAIProvider) Chat(ctx context.Context, mc registry.ModelConfig, req
 
 	// Build request
 	request := openai.ChatCompletionRequest{
-		Model:       mc.ID,
+		Model:       UpstreamModelName(mc),
 		Messages:    messages,
 		Temperature: req.Temperature,
 		TopP:        req.TopP,
@@ -83,7 +84,11 @@ func (p *OpenAIProvider) Chat(ctx context.Context, mc registry.ModelConfig, req
 	// Make API call
 	response, err := client.CreateChatCompletion(ctx, request)
 	if err != nil {
-		return core.ChatResponse{}, fmt.Errorf("openai chat completion failed: %w", err)
+		return core.ChatResponse{}, fmt.Errorf("openai chat completion failed: %w", asHTTPError(err))
+	}
+
+	if len(response.Choices) == 0 {
+		return core.ChatResponse{}, fmt.Errorf("openai chat completion returned no choices")
 	}
 
 	// Convert response
@@ -119,6 +124,16 @@ func (p *OpenAIProvider) Chat(ctx context.Context, mc registry.ModelConfig, req
 	return chatResp, nil
 }
 
+// ChatStream performs streaming chat completion using OpenAI API
+func (p *OpenAIProvider) ChatStream(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) (core.Usage, error) {
+	// Create a new client with the model config's base URL
+	config := openai.DefaultConfig(p.apiKey)
+	config.BaseURL = mc.BaseURL
+	client := openai.NewClientWithConfig(config)
+
+	return streamOpenAICompatible(ctx, client, p.BaseProvider, mc, req, writer)
+}
+
 // Embed generates embeddings using OpenAI API
 func (p *OpenAIProvider) Embed(ctx context.Context, mc registry.ModelConfig, input []string) ([][]float32, core.Usage, error) {
 	// Create a new client with the model config's base URL
@@ -127,12 +142,12 @@ func (p *OpenAIProvider) Embed(ctx context.Context, mc registry.ModelConfig, inp
 	client := openai.NewClientWithConfig(config)
 	request := openai.EmbeddingRequest{
 		Input: input,
-		Model: openai.EmbeddingModel(mc.ID),
+		Model: openai.EmbeddingModel(UpstreamModelName(mc)),
 	}
 
 	response, err := client.CreateEmbeddings(ctx, request)
 	if err != nil {
-		return nil, core.Usage{}, fmt.Errorf("openai embeddings failed: %w", err)
+		return nil, core.Usage{}, fmt.Errorf("openai embeddings failed: %w", asHTTPError(err))
 	}
 
 	// Convert embeddings
@@ -8,6 +8,7 @@ import (
 	"github.com/sashabaranov/go-openai"
 	"github.com/snow-ghost/agent/pkg/registry"
 	"github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/pkg/streaming"
 )
 
 // OpenRouterProvider implements the Provider interface for OpenRouter (OpenAI-compatible)
@@ -64,7 +65,7 @@ func (p *OpenRouterProvider) Chat(ctx context.Context, mc registry.ModelConfig,
 
 	// Build request
 	request := openai.ChatCompletionRequest{
-		Model:       mc.ID,
+		Model:       UpstreamModelName(mc),
 		Messages:    messages,
 		Temperature: req.Temperature,
 		TopP:        req.TopP,
@@ -79,7 +80,11 @@ func (p *OpenRouterProvider) Chat(ctx context.Context, mc registry.ModelConfig,
 	// Make API call
 	response, err := p.client.CreateChatCompletion(ctx, request)
 	if err != nil {
-		return core.ChatResponse{}, fmt.Errorf("openrouter chat completion failed: %w", err)
+		return core.ChatResponse{}, fmt.Errorf("openrouter chat completion failed: %w", asHTTPError(err))
+	}
+
+	if len(response.Choices) == 0 {
+		return core.ChatResponse{}, fmt.Errorf("openrouter chat completion returned no choices")
 	}
 
 	// Convert response
@@ -115,16 +120,21 @@ func (p *OpenRouterProvider) Chat(ctx context.Context, mc registry.ModelConfig,
 	return chatResp, nil
 }
 
+// ChatStream performs streaming chat completion using OpenRouter (OpenAI-compatible API)
+func (p *OpenRouterProvider) ChatStream(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) (core.Usage, error) {
+	return streamOpenAICompatible(ctx, p.client, p.BaseProvider, mc, req, writer)
+}
+
 // Embed generates embeddings using OpenRouter (OpenAI-compatible API)
 func (p *OpenRouterProvider) Embed(ctx context.Context, mc registry.ModelConfig, input []string) ([][]float32, core.Usage, error) {
 	request := openai.EmbeddingRequest{
 		Input: input,
-		Model: openai.EmbeddingModel(mc.ID),
+		Model: openai.EmbeddingModel(UpstreamModelName(mc)),
 	}
 
 	response, err := p.client.CreateEmbeddings(ctx, request)
 	if err != nil {
-		return nil, core.Usage{}, fmt.Errorf("openrouter embeddings failed: %w", err)
+		return nil, core.Usage{}, fmt.Errorf("openrouter embeddings failed: %w", asHTTPError(err))
 	}
 
 	// Convert embeddings
@@ -2,8 +2,12 @@ package providers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"time"
 
+	"github.com/sashabaranov/go-openai"
 	"github.com/snow-ghost/agent/pkg/registry"
 	"github.com/snow-ghost/agent/pkg/router/core"
 	"github.com/snow-ghost/agent/pkg/streaming"
@@ -13,8 +17,10 @@ import (
 type StreamingProvider interface {
 	Provider
 
-	// ChatStream performs streaming chat completion
-	ChatStream(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) error
+	// ChatStream performs streaming chat completion, forwarding chunks to the
+	// writer and finishing with a done event. It returns the final usage so
+	// callers can account for the request.
+	ChatStream(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) (core.Usage, error)
 }
 
 // StreamHandler handles streaming responses
@@ -107,10 +113,10 @@ func (p *MockStreamingProvider) Embed(ctx context.Context, mc registry.ModelConf
 }
 
 // ChatStream performs streaming chat completion
-func (p *MockStreamingProvider) ChatStream(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) error {
+func (p *MockStreamingProvider) ChatStream(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) (core.Usage, error) {
 	// Write start event
 	if err := writer.WriteStart(mc.ID, mc.Provider); err != nil {
-		return err
+		return core.Usage{}, err
 	}
 
 	// Simulate streaming response
@@ -129,7 +135,7 @@ func (p *MockStreamingProvider) ChatStream(ctx context.Context, mc registry.Mode
 	for _, chunk := range chunks {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return core.Usage{}, ctx.Err()
 		default:
 		}
 
@@ -138,7 +144,7 @@ func (p *MockStreamingProvider) ChatStream(ctx context.Context, mc registry.Mode
 
 		// Write chunk
 		if err := handler.HandleChunk(chunk); err != nil {
-			return err
+			return core.Usage{}, err
 		}
 	}
 
@@ -154,15 +160,114 @@ func (p *MockStreamingProvider) ChatStream(ctx context.Context, mc registry.Mode
 	cost, currency := p.calculateCost(mc, usage)
 
 	// Write done event
-	return handler.HandleDone(mc.ID, mc.Provider, "stop", cost, currency)
+	return usage, handler.HandleDone(mc.ID, mc.Provider, "stop", cost, currency)
 }
 
-// calculateCost calculates the cost for the usage
-func (p *MockStreamingProvider) calculateCost(mc registry.ModelConfig, usage core.Usage) (float64, string) {
-	if p.costCalculator != nil {
-		if result, err := p.costCalculator.CalcCostForModel(mc.ID, usage); err == nil {
-			return result.TotalCost, result.Currency
+// buildOpenAIChatRequest converts a router chat request into the
+// OpenAI-compatible wire format
+func buildOpenAIChatRequest(mc registry.ModelConfig, req core.ChatRequest) openai.ChatCompletionRequest {
+	messages := make([]openai.ChatCompletionMessage, len(req.Messages))
+	for i, msg := range req.Messages {
+		messages[i] = openai.ChatCompletionMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+			Name:    msg.Name,
 		}
 	}
-	return 0.0, "USD"
+
+	request := openai.ChatCompletionRequest{
+		Model:       UpstreamModelName(mc),
+		Messages:    messages,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxTokens,
+	}
+
+	for _, tool := range req.Tools {
+		openaiTool := openai.Tool{
+			Type: openai.ToolType(tool.Type),
+		}
+		if tool.Function != nil {
+			openaiTool.Function = &openai.FunctionDefinition{
+				Name:        tool.Function.Name,
+				Description: tool.Function.Description,
+				Parameters:  tool.Function.Parameters,
+			}
+		}
+		request.Tools = append(request.Tools, openaiTool)
+	}
+
+	return request
+}
+
+// streamOpenAICompatible streams a chat completion from any OpenAI-compatible
+// API (OpenAI, vLLM, LM Studio, OpenRouter) through the SSE writer
+func streamOpenAICompatible(ctx context.Context, client *openai.Client, base *BaseProvider, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) (core.Usage, error) {
+	request := buildOpenAIChatRequest(mc, req)
+	request.Stream = true
+	request.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+
+	stream, err := client.CreateChatCompletionStream(ctx, request)
+	if err != nil {
+		return core.Usage{}, fmt.Errorf("%s chat stream failed: %w", mc.Provider, asHTTPError(err))
+	}
+	defer stream.Close()
+
+	handler := NewStreamHandler(writer)
+	if err := handler.WriteStart(mc.ID, mc.Provider); err != nil {
+		return core.Usage{}, err
+	}
+
+	var text string
+	var usage *core.Usage
+	finishReason := "stop"
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return core.Usage{}, fmt.Errorf("%s chat stream failed: %w", mc.Provider, asHTTPError(err))
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				text += choice.Delta.Content
+				if err := handler.HandleChunk(choice.Delta.Content); err != nil {
+					return core.Usage{}, err
+				}
+			}
+			if choice.FinishReason != "" {
+				finishReason = string(choice.FinishReason)
+			}
+		}
+
+		if chunk.Usage != nil {
+			usage = &core.Usage{
+				PromptTokens:     chunk.Usage.PromptTokens,
+				CompletionTokens: chunk.Usage.CompletionTokens,
+				TotalTokens:      chunk.Usage.TotalTokens,
+			}
+		}
+	}
+
+	// Not every OpenAI-compatible server honours include_usage
+	if usage == nil {
+		estimated := base.EstimateUsage(messageContents(req.Messages), text)
+		usage = &estimated
+	}
+
+	handler.HandleUsage(*usage)
+	cost, currency := base.calculateCost(mc, *usage)
+	return *usage, handler.HandleDone(mc.ID, mc.Provider, finishReason, cost, currency)
+}
+
+// messageContents returns the content of each message
+func messageContents(messages []core.Message) []string {
+	contents := make([]string, len(messages))
+	for i, msg := range messages {
+		contents[i] = msg.Content
+	}
+	return contents
 }
@@ -0,0 +1,262 @@
+package providers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snow-ghost/agent/pkg/registry"
+	"github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/pkg/streaming"
+)
+
+// sseEvent is a parsed event written by streaming.SSEWriter
+type sseEvent struct {
+	Event string
+	Data  map[string]interface{}
+}
+
+// parseSSE parses the events written to a recorder
+func parseSSE(t *testing.T, body string) []sseEvent {
+	t.Helper()
+
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &current.Data); err != nil {
+				t.Fatalf("invalid SSE data %q: %v", line, err)
+			}
+		case line == "" && current.Event != "":
+			events = append(events, current)
+			current = sseEvent{}
+		}
+	}
+	return events
+}
+
+// streamedText concatenates chunk events
+func streamedText(events []sseEvent) string {
+	var text strings.Builder
+	for _, e := range events {
+		if e.Event == "chunk" {
+			text.WriteString(e.Data["text"].(string))
+		}
+	}
+	return text.String()
+}
+
+// doneUsage returns the usage carried by the done event
+func doneUsage(t *testing.T, events []sseEvent) (map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+
+	last := events[len(events)-1]
+	if last.Event != "done" {
+		t.Fatalf("Expected stream to end with done event, got %s", last.Event)
+	}
+	return last.Data["usage"].(map[string]interface{}), last.Data["cost"].(map[string]interface{})
+}
+
+// pricedRegistry returns a registry holding mc so cost calculation works
+func pricedRegistry(mc registry.ModelConfig) *registry.Registry {
+	return &registry.Registry{Models: []registry.ModelConfig{mc}}
+}
+
+func TestOpenAICompatibleChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["stream"] != true {
+			http.Error(w, "expected stream", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, text := range []string{"Hel", "lo", "!"} {
+			fmt.Fprintf(w, "data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", text)
+		}
+		fmt.Fprint(w, "data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: {\"id\":\"1\",\"choices\":[],\"usage\":{\"prompt_tokens\":1000,\"completion_tokens\":2000,\"total_tokens\":3000}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	mc := registry.ModelConfig{
+		ID:       "vllm:test-model",
+		Provider: "vllm",
+		BaseURL:  server.URL,
+		Pricing:  registry.Pricing{Currency: "USD", InputPer1K: 0.001, OutputPer1K: 0.001},
+	}
+	provider, err := CreateVLLMProviderFromConfig(mc, pricedRegistry(mc))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	writer, _ := streaming.NewSSEWriter(rec)
+
+	usage, err := provider.ChatStream(context.Background(), mc, core.ChatRequest{
+		Messages: []core.Message{{Role: "user", Content: "Hi"}},
+	}, writer)
+	if err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+
+	if usage.TotalTokens != 3000 {
+		t.Errorf("Expected 3000 total tokens, got %d", usage.TotalTokens)
+	}
+
+	events := parseSSE(t, rec.Body.String())
+	if events[0].Event != "start" {
+		t.Errorf("Expected start event first, got %s", events[0].Event)
+	}
+	if got := streamedText(events); got != "Hello!" {
+		t.Errorf("Expected streamed text Hello!, got %q", got)
+	}
+
+	doneUsageData, costData := doneUsage(t, events)
+	if doneUsageData["completion_tokens"] != float64(2000) {
+		t.Errorf("Expected 2000 completion tokens in done event, got %v", doneUsageData["completion_tokens"])
+	}
+	if costData["total"] != 0.003 {
+		t.Errorf("Expected cost 0.003 in done event, got %v", costData["total"])
+	}
+}
+
+func TestAnthropicChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`{"type":"message_start","message":{"usage":{"input_tokens":25,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"ping"}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" world"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":15}}`,
+			`{"type":"message_stop"}`,
+		}
+		for _, e := range events {
+			var typed struct{ Type string }
+			json.Unmarshal([]byte(e), &typed)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typed.Type, e)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewAnthropicProvider(server.URL, "test-key")
+	mc := registry.ModelConfig{ID: "claude-test", Provider: "anthropic", BaseURL: server.URL}
+
+	rec := httptest.NewRecorder()
+	writer, _ := streaming.NewSSEWriter(rec)
+
+	usage, err := provider.ChatStream(context.Background(), mc, core.ChatRequest{
+		Messages:  []core.Message{{Role: "user", Content: "Hi"}},
+		MaxTokens: 100,
+	}, writer)
+	if err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+
+	if usage.PromptTokens != 25 || usage.CompletionTokens != 15 || usage.TotalTokens != 40 {
+		t.Errorf("Unexpected usage: %+v", usage)
+	}
+
+	events := parseSSE(t, rec.Body.String())
+	if got := streamedText(events); got != "Hello world" {
+		t.Errorf("Expected streamed text 'Hello world', got %q", got)
+	}
+	doneUsageData, _ := doneUsage(t, events)
+	if doneUsageData["total_tokens"] != float64(40) {
+		t.Errorf("Expected 40 total tokens in done event, got %v", doneUsageData["total_tokens"])
+	}
+}
+
+func TestOllamaChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, `{"model":"llama3.2","message":{"role":"assistant","content":"Why"},"done":false}`)
+		fmt.Fprintln(w, `{"model":"llama3.2","message":{"role":"assistant","content":" not?"},"done":false}`)
+		fmt.Fprintln(w, `{"model":"llama3.2","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":26,"eval_count":7}`)
+	}))
+	defer server.Close()
+
+	provider := NewOllamaProvider(server.URL)
+	mc := registry.ModelConfig{ID: "ollama:llama3.2", Provider: "ollama", BaseURL: server.URL}
+
+	rec := httptest.NewRecorder()
+	writer, _ := streaming.NewSSEWriter(rec)
+
+	usage, err := provider.ChatStream(context.Background(), mc, core.ChatRequest{
+		Messages: []core.Message{{Role: "user", Content: "Hi"}},
+	}, writer)
+	if err != nil {
+		t.Fatalf("ChatStream failed: %v", err)
+	}
+
+	if usage.PromptTokens != 26 || usage.CompletionTokens != 7 {
+		t.Errorf("Expected reported Ollama token counts, got %+v", usage)
+	}
+
+	events := parseSSE(t, rec.Body.String())
+	if got := streamedText(events); got != "Why not?" {
+		t.Errorf("Expected streamed text 'Why not?', got %q", got)
+	}
+}
+
+func TestChatStreamCancelsUpstream(t *testing.T) {
+	upstreamDone := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"partial"},"done":false}`)
+		w.(http.Flusher).Flush()
+
+		// Hold the stream open until the client goes away
+		<-r.Context().Done()
+		close(upstreamDone)
+	}))
+	defer server.Close()
+
+	provider := NewOllamaProvider(server.URL)
+	mc := registry.ModelConfig{ID: "ollama:llama3.2", Provider: "ollama", BaseURL: server.URL}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rec := httptest.NewRecorder()
+	writer, _ := streaming.NewSSEWriter(rec)
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := provider.ChatStream(ctx, mc, core.ChatRequest{
+			Messages: []core.Message{{Role: "user", Content: "Hi"}},
+		}, writer)
+		errCh <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("Expected error after cancellation")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ChatStream did not return after cancellation")
+	}
+
+	select {
+	case <-upstreamDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Upstream request was not cancelled")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/sashabaranov/go-openai"
 	"github.com/snow-ghost/agent/pkg/registry"
 	"github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/pkg/streaming"
 )
 
 // VLLMProvider implements the Provider interface for vLLM (OpenAI-compatible)
@@ -64,7 +65,7 @@ func (p *VLLMProvider) Chat(ctx context.Context, mc registry.ModelConfig, req co
 
 	// Build request
 	request := openai.ChatCompletionRequest{
-		Model:       mc.ID,
+		Model:       UpstreamModelName(mc),
 		Messages:    messages,
 		Temperature: req.Temperature,
 		TopP:        req.TopP,
@@ -79,7 +80,11 @@ func (p *VLLMProvider) Chat(ctx context.Context, mc registry.ModelConfig, req co
 	// Make API call
 	response, err := p.client.CreateChatCompletion(ctx, request)
 	if err != nil {
-		return core.ChatResponse{}, fmt.Errorf("vllm chat completion failed: %w", err)
+		return core.ChatResponse{}, fmt.Errorf("vllm chat completion failed: %w", asHTTPError(err))
+	}
+
+	if len(response.Choices) == 0 {
+		return core.ChatResponse{}, fmt.Errorf("vllm chat completion returned no choices")
 	}
 
 	// Convert response
@@ -115,16 +120,21 @@ func (p *VLLMProvider) Chat(ctx context.Context, mc registry.ModelConfig, req co
 	return chatResp, nil
 }
 
+// ChatStream performs streaming chat completion using vLLM (OpenAI-compatible API)
+func (p *VLLMProvider) ChatStream(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) (core.Usage, error) {
+	return streamOpenAICompatible(ctx, p.client, p.BaseProvider, mc, req, writer)
+}
+
 // Embed generates embeddings using vLLM (OpenAI-compatible API)
 func (p *VLLMProvider) Embed(ctx context.Context, mc registry.ModelConfig, input []string) ([][]float32, core.Usage, error) {
 	request := openai.EmbeddingRequest{
 		Input: input,
-		Model: openai.EmbeddingModel(mc.ID),
+		Model: openai.EmbeddingModel(UpstreamModelName(mc)),
 	}
 
 	response, err := p.client.CreateEmbeddings(ctx, request)
 	if err != nil {
-		return nil, core.Usage{}, fmt.Errorf("vllm embeddings failed: %w", err)
+		return nil, core.Usage{}, fmt.Errorf("vllm embeddings failed: %w", asHTTPError(err))
 	}
 
 	// Convert embeddings
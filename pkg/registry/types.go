@@ -16,14 +16,37 @@ type ModelConfig struct {
 	Kind          string                 `json:"kind" yaml:"kind"` // chat|complete|embed
 	Pricing       Pricing                `json:"pricing" yaml:"pricing"`
 	DefaultParams map[string]interface{} `json:"default_params" yaml:"default_params"`
-	MaxRPM        int                    `json:"max_rpm,omitempty" yaml:"max_rpm,omitempty"` // requests per minute
-	MaxTPM        int                    `json:"max_tpm,omitempty" yaml:"max_tpm,omitempty"` // tokens per minute
-	Tags          []string               `json:"tags,omitempty" yaml:"tags,omitempty"`       // routing hints: general, code, embed
+	MaxRPM        int                    `json:"max_rpm,omitempty" yaml:"max_rpm,omitempty"`     // requests per minute
+	MaxTPM        int                    `json:"max_tpm,omitempty" yaml:"max_tpm,omitempty"`     // tokens per minute
+	Tags          []string               `json:"tags,omitempty" yaml:"tags,omitempty"`           // routing hints: general, code, embed
+	Fallbacks     []string               `json:"fallbacks,omitempty" yaml:"fallbacks,omitempty"` // model IDs tried in order when this model fails
+}
+
+// AccountingSettings selects where cost records are kept
+type AccountingSettings struct {
+	Backend       string `json:"backend,omitempty" yaml:"backend,omitempty"`               // memory|sqlite
+	DBPath        string `json:"db_path,omitempty" yaml:"db_path,omitempty"`               // SQLite database file
+	RetentionDays int    `json:"retention_days,omitempty" yaml:"retention_days,omitempty"` // raw records kept before daily compaction; 0 keeps all
+}
+
+// CallerLimit caps the traffic of one caller across all models
+type CallerLimit struct {
+	MaxRPM int `json:"max_rpm,omitempty" yaml:"max_rpm,omitempty"` // requests per minute
+	MaxTPM int `json:"max_tpm,omitempty" yaml:"max_tpm,omitempty"` // tokens per minute
+}
+
+// RateLimitSettings configures per-caller rate limits
+type RateLimitSettings struct {
+	DefaultCaller CallerLimit            `json:"default_caller,omitempty" yaml:"default_caller,omitempty"` // applies to callers not listed
+	Callers       map[string]CallerLimit `json:"callers,omitempty" yaml:"callers,omitempty"`
+	MaxWaitMS     int                    `json:"max_wait_ms,omitempty" yaml:"max_wait_ms,omitempty"` // how long a request may queue for capacity
 }
 
 // Registry represents the model registry
 type Registry struct {
-	Models []ModelConfig `json:"models" yaml:"models"`
+	Models     []ModelConfig      `json:"models" yaml:"models"`
+	Accounting AccountingSettings `json:"accounting,omitempty" yaml:"accounting,omitempty"`
+	RateLimits RateLimitSettings  `json:"rate_limits,omitempty" yaml:"rate_limits,omitempty"`
 }
 
 // GetModelByID returns a model configuration by ID
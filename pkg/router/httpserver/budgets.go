@@ -0,0 +1,185 @@
+package httpserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/snow-ghost/agent/pkg/accounting"
+)
+
+// enforceBudgets checks the caller's configured budgets and the per-request
+// X-Budget-Amount header before a provider is called. It writes a 402
+// response and returns false if an exhausted hard budget rejects the request;
+// soft budgets and alert thresholds only add response headers.
+func (s *Server) enforceBudgets(w http.ResponseWriter, r *http.Request, caller, requestID string) bool {
+	if s.accounting == nil {
+		return true
+	}
+
+	if budgetHeader := r.Header.Get("X-Budget-Amount"); budgetHeader != "" {
+		budgetInfo, err := s.accounting.CheckBudget(caller, budgetHeader)
+		if err != nil {
+			s.logger.Warn("failed to check budget", "error", err, "request_id", requestID)
+		} else if budgetInfo.Exceeded {
+			s.writeError(w, "Budget exceeded", "BUDGET_EXCEEDED", http.StatusPaymentRequired)
+			return false
+		}
+	}
+
+	if caller == "" {
+		return true
+	}
+
+	statuses, err := s.accounting.GetBudgetStatuses(caller)
+	if err != nil {
+		// Fail open: an accounting outage should not take down serving
+		s.logger.Warn("failed to check caller budgets", "error", err, "caller", caller, "request_id", requestID)
+		return true
+	}
+
+	var alerts, exceeded []string
+	for _, status := range statuses {
+		if status.Blocks() {
+			s.logger.Warn("budget exhausted, rejecting request",
+				"caller", caller, "window", status.Window, "used", status.Used, "amount", status.Amount, "request_id", requestID)
+			w.Header().Set("X-Budget-Exceeded", string(status.Window))
+			s.writeError(w,
+				fmt.Sprintf("%s budget of %.2f %s exhausted", status.Window, status.Amount, status.Currency),
+				"BUDGET_EXCEEDED", http.StatusPaymentRequired)
+			return false
+		}
+
+		if status.Exceeded {
+			exceeded = append(exceeded, string(status.Window))
+		}
+		if status.Alert {
+			s.logger.Warn("budget alert threshold reached",
+				"caller", caller, "window", status.Window, "used", status.Used, "amount", status.Amount, "request_id", requestID)
+			alerts = append(alerts, fmt.Sprintf("%s;used=%.6f;amount=%.6f;currency=%s",
+				status.Window, status.Used, status.Amount, status.Currency))
+		}
+	}
+
+	if len(alerts) > 0 {
+		w.Header().Set("X-Budget-Alert", strings.Join(alerts, ", "))
+	}
+	if len(exceeded) > 0 {
+		w.Header().Set("X-Budget-Exceeded", strings.Join(exceeded, ", "))
+	}
+
+	return true
+}
+
+// requireAdmin only lets requests with the admin token as a bearer token
+// through. Without a configured token the endpoint is disabled, since callers
+// are otherwise identified only by the unauthenticated X-Caller header.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			s.writeError(w, "Admin endpoints are disabled", "ADMIN_DISABLED", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.writeError(w, "Admin token required", "UNAUTHORIZED", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleBudgets lists (GET), sets (PUT/POST) and deletes (DELETE) caller budgets
+func (s *Server) handleBudgets(w http.ResponseWriter, r *http.Request) {
+	if s.accounting == nil {
+		s.writeError(w, "Accounting not available", "ACCOUNTING_UNAVAILABLE", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		statuses, err := s.accounting.GetBudgetStatuses(r.URL.Query().Get("caller"))
+		if err != nil {
+			s.writeError(w, "Failed to get budgets", "BUDGETS_FAILED", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"budgets": statuses,
+		})
+
+	case http.MethodPut, http.MethodPost:
+		var budget accounting.Budget
+		if err := json.NewDecoder(r.Body).Decode(&budget); err != nil {
+			s.writeError(w, "Invalid JSON", "INVALID_JSON", http.StatusBadRequest)
+			return
+		}
+
+		stored, err := s.accounting.SetBudget(budget)
+		if err != nil {
+			s.writeError(w, err.Error(), "INVALID_BUDGET", http.StatusBadRequest)
+			return
+		}
+
+		s.logger.Info("budget set", "caller", stored.Caller, "window", stored.Window, "amount", stored.Amount, "enforcement", stored.Enforcement)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stored)
+
+	case http.MethodDelete:
+		caller := r.URL.Query().Get("caller")
+		window := accounting.BudgetWindow(r.URL.Query().Get("window"))
+		if caller == "" || window == "" {
+			s.writeError(w, "caller and window are required", "INVALID_REQUEST", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.accounting.DeleteBudget(caller, window); err != nil {
+			s.writeError(w, "Failed to delete budget", "BUDGETS_FAILED", http.StatusInternalServerError)
+			return
+		}
+
+		s.logger.Info("budget deleted", "caller", caller, "window", window)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleBudgetReset clears the spend counted against a budget in its current window
+func (s *Server) handleBudgetReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.accounting == nil {
+		s.writeError(w, "Accounting not available", "ACCOUNTING_UNAVAILABLE", http.StatusServiceUnavailable)
+		return
+	}
+
+	caller := r.URL.Query().Get("caller")
+	window := accounting.BudgetWindow(r.URL.Query().Get("window"))
+	if caller == "" || window == "" {
+		s.writeError(w, "caller and window are required", "INVALID_REQUEST", http.StatusBadRequest)
+		return
+	}
+
+	budget, err := s.accounting.ResetBudget(caller, window)
+	if errors.Is(err, accounting.ErrBudgetNotFound) {
+		s.writeError(w, err.Error(), "BUDGET_NOT_FOUND", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.writeError(w, "Failed to reset budget", "BUDGETS_FAILED", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info("budget reset", "caller", caller, "window", window)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(budget)
+}
@@ -0,0 +1,558 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/snow-ghost/agent/pkg/accounting"
+	"github.com/snow-ghost/agent/pkg/cache"
+	"github.com/snow-ghost/agent/pkg/cost"
+	"github.com/snow-ghost/agent/pkg/limiter"
+	"github.com/snow-ghost/agent/pkg/observability"
+	"github.com/snow-ghost/agent/pkg/providers"
+	"github.com/snow-ghost/agent/pkg/registry"
+	"github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/pkg/streaming"
+)
+
+// attempt records one provider call made while serving a request
+type attempt struct {
+	model    registry.ModelConfig
+	err      error
+	duration time.Duration
+}
+
+// chatOutcome is the result of a chat dispatch, shared with identical
+// requests that were deduplicated into it
+type chatOutcome struct {
+	served   registry.ModelConfig
+	attempts []attempt
+	response core.ChatResponse
+}
+
+// Token estimation for rate limiting: chat formats add a few tokens per
+// message, and requests without max_tokens are assumed to produce a short
+// completion until the provider reports actual usage
+const (
+	tokensPerMessage          = 4
+	defaultCompletionEstimate = 256
+)
+
+// errProviderUnavailable marks failures to construct a provider client,
+// typically a missing API key for the selected model
+var errProviderUnavailable = errors.New("provider unavailable")
+
+// selectModel resolves the model for a request and reports how it was chosen.
+// An explicitly requested model that exists in the registry wins ("explicit");
+// otherwise the routing strategy decides.
+func (s *Server) selectModel(ctx context.Context, strategy, model string, metadata map[string]string) (*registry.ModelConfig, string, error) {
+	if model != "" {
+		if mc := s.registry.FindModel(model); mc != nil {
+			return mc, "explicit", nil
+		}
+	}
+	mc, err := s.modelRouter.SelectModel(ctx, strategy, metadata)
+	return mc, strategy, err
+}
+
+// providerFor returns the provider backing a model, creating it on first use
+func (s *Server) providerFor(mc registry.ModelConfig) (providers.Provider, error) {
+	s.providersMu.Lock()
+	defer s.providersMu.Unlock()
+
+	if provider, exists := s.providers[mc.ID]; exists {
+		return provider, nil
+	}
+
+	provider, err := s.providerFactory.CreateProviderFromConfig(mc, s.registry)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errProviderUnavailable, mc.ID, err)
+	}
+
+	s.providers[mc.ID] = provider
+	return provider, nil
+}
+
+// estimateTokens estimates the tokens a chat request will use: the prompt
+// counted with the model's encoder plus the completion allowance
+func (s *Server) estimateTokens(mc registry.ModelConfig, req core.ChatRequest) int {
+	completion := req.MaxTokens
+	if completion <= 0 {
+		completion = defaultCompletionEstimate
+	}
+	return s.countPromptTokens(mc, req) + completion
+}
+
+// countPromptTokens counts a chat request's prompt with the model's encoder
+func (s *Server) countPromptTokens(mc registry.ModelConfig, req core.ChatRequest) int {
+	contents := make([]string, len(req.Messages))
+	for i, message := range req.Messages {
+		contents[i] = message.Content
+	}
+	return s.countTokens(mc, contents) + tokensPerMessage*len(req.Messages)
+}
+
+// countTokens counts texts with the model's encoder
+func (s *Server) countTokens(mc registry.ModelConfig, texts []string) int {
+	count, err := s.encoders.CountTokensInMessages(providers.UpstreamModelName(mc), texts)
+	if err != nil {
+		// Fall back to the usual four characters per token
+		count = 0
+		for _, text := range texts {
+			count += len(text) / 4
+		}
+	}
+	return count
+}
+
+// partialStreamUsage estimates the usage of a stream cut off before the
+// provider reported it: the whole prompt and the text streamed so far
+func (s *Server) partialStreamUsage(mc registry.ModelConfig, req core.ChatRequest, streamed string) core.Usage {
+	usage := core.Usage{PromptTokens: s.countPromptTokens(mc, req)}
+	if streamed != "" {
+		usage.CompletionTokens = s.countTokens(mc, []string{streamed})
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+// reserveTokens reserves the request's estimated tokens against the model's
+// and the caller's tokens-per-minute limits
+func (s *Server) reserveTokens(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest) (*limiter.TokenReservation, error) {
+	caller := observability.GetCallerFromContext(ctx)
+	return s.protectionManager.ReserveTokens(ctx, mc.ID, caller, s.estimateTokens(mc, req))
+}
+
+// reconcileTokens settles a reservation with the tokens actually used. Usage
+// that the provider did not report is assumed to match the estimate.
+func reconcileTokens(reservation *limiter.TokenReservation, usage core.Usage) {
+	if usage.TotalTokens == 0 {
+		reservation.Reconcile(reservation.Reserved())
+		return
+	}
+	reservation.Reconcile(usage.TotalTokens)
+}
+
+// executeChat sends a chat request to the model's provider under rate
+// limiting, retries and circuit breaker protection
+func (s *Server) executeChat(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest) (core.ChatResponse, error) {
+	provider, err := s.providerFor(mc)
+	if err != nil {
+		return core.ChatResponse{}, err
+	}
+
+	req = applyDefaultParams(mc, req)
+
+	reservation, err := s.reserveTokens(ctx, mc, req)
+	if err != nil {
+		return core.ChatResponse{}, err
+	}
+
+	result, err := s.protectionManager.ExecuteWithProtection(ctx, mc.ID, func(ctx context.Context) (interface{}, error) {
+		return provider.Chat(ctx, mc, req)
+	})
+	if err != nil {
+		reservation.Cancel()
+		return core.ChatResponse{}, err
+	}
+
+	response, ok := result.(core.ChatResponse)
+	if !ok {
+		reservation.Cancel()
+		return core.ChatResponse{}, fmt.Errorf("unexpected provider result type %T", result)
+	}
+
+	// Some providers omit the total; keep it consistent for billing
+	if response.Usage.TotalTokens == 0 {
+		response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
+	}
+	reconcileTokens(reservation, response.Usage)
+
+	return response, nil
+}
+
+// executeChatStream streams a chat request from the model's provider. Once
+// chunks have been written the request cannot be replayed, so it runs under
+// rate limiting and circuit breaking but without retries.
+func (s *Server) executeChatStream(ctx context.Context, mc registry.ModelConfig, req core.ChatRequest, writer *streaming.SSEWriter) (core.Usage, error) {
+	provider, err := s.providerFor(mc)
+	if err != nil {
+		return core.Usage{}, err
+	}
+
+	streamer, ok := provider.(providers.StreamingProvider)
+	if !ok {
+		return core.Usage{}, fmt.Errorf("%w: %s does not support streaming", errProviderUnavailable, mc.Provider)
+	}
+
+	req = applyDefaultParams(mc, req)
+	req.Stream = true
+
+	reservation, err := s.reserveTokens(ctx, mc, req)
+	if err != nil {
+		return core.Usage{}, err
+	}
+
+	noRetry := &limiter.RetryConfig{MaxRetries: 0}
+	result, err := s.protectionManager.ExecuteWithCustomRetry(ctx, mc.ID, noRetry, func(ctx context.Context) (interface{}, error) {
+		return streamer.ChatStream(ctx, mc, req, writer)
+	})
+	if err != nil && ctx.Err() != nil && writer.HasWritten() {
+		// The client went away mid-stream; what it received is still used
+		usage := s.partialStreamUsage(mc, req, writer.Text())
+		reconcileTokens(reservation, usage)
+		return usage, err
+	}
+	if err != nil {
+		reservation.Cancel()
+		return core.Usage{}, err
+	}
+
+	usage, ok := result.(core.Usage)
+	if !ok {
+		reservation.Cancel()
+		return core.Usage{}, fmt.Errorf("unexpected provider result type %T", result)
+	}
+	reconcileTokens(reservation, usage)
+	return usage, nil
+}
+
+// runWithFallback calls the primary model and, while calls fail with errors
+// another model could recover from, each of its configured fallbacks in turn.
+// Candidates that are circuit-broken or out of rate limit capacity are skipped
+// unless they are the last resort. canFallback reports whether the request can
+// still be replayed (e.g. nothing has been streamed to the client yet).
+func (s *Server) runWithFallback(
+	ctx context.Context,
+	primary registry.ModelConfig,
+	call func(mc registry.ModelConfig) error,
+	canFallback func() bool,
+) (registry.ModelConfig, []attempt, error) {
+	chain := s.modelRouter.FallbackChain(primary)
+
+	var attempts []attempt
+	var lastErr error
+	for i, mc := range chain {
+		if i < len(chain)-1 && !s.protectionManager.IsModelAvailable(mc.ID) {
+			s.logger.Warn("skipping unavailable model", "model", mc.ID, "primary", primary.ID)
+			continue
+		}
+
+		start := time.Now()
+		err := call(mc)
+		attempts = append(attempts, attempt{model: mc, err: err, duration: time.Since(start)})
+		if err == nil {
+			return mc, attempts, nil
+		}
+
+		lastErr = err
+		if !shouldFallback(ctx, err) || (canFallback != nil && !canFallback()) {
+			break
+		}
+		if i < len(chain)-1 {
+			s.logger.Warn("model failed, trying fallback", "model", mc.ID, "error", err)
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("%w for model %s", limiter.ErrCircuitOpen, primary.ID)
+	}
+	return registry.ModelConfig{}, attempts, lastErr
+}
+
+// shouldFallback reports whether a failed call may succeed on another model.
+// Client disconnects and malformed requests are not retried elsewhere.
+func shouldFallback(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	// A caller over its own limit is over it on every model
+	var limitErr *limiter.TokenLimitError
+	if errors.As(err, &limitErr) && limitErr.Scope == limiter.ScopeCaller {
+		return false
+	}
+
+	var httpErr *limiter.HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+			return false
+		}
+	}
+
+	return true
+}
+
+// setRoutingHeaders records which model served the request and how many
+// provider calls it took
+func setRoutingHeaders(w http.ResponseWriter, primary registry.ModelConfig, served *registry.ModelConfig, attempts []attempt) {
+	w.Header().Set("X-Attempts", strconv.Itoa(len(attempts)))
+	if served == nil {
+		return
+	}
+
+	w.Header().Set("X-Model", served.ID)
+	w.Header().Set("X-Provider", served.Provider)
+	if served.ID != primary.ID {
+		w.Header().Set("X-Fallback-From", primary.ID)
+	}
+}
+
+// recordFailedAttempts records metrics and zero-cost accounting entries for
+// every failed provider call
+func (s *Server) recordFailedAttempts(ctx context.Context, caller, requestID, strategy string, attempts []attempt) {
+	for i, a := range attempts {
+		if a.err == nil {
+			continue
+		}
+
+		s.modelRouter.RecordOutcome(a.model.ID, a.duration, false)
+		if s.observability != nil {
+			s.observability.RecordRequestMetrics(
+				a.model.Provider, a.model.ID, "error", a.duration,
+				0, 0, 0, a.model.Pricing.Currency,
+			)
+			s.observability.LogRequestCompletion(
+				ctx, a.model.Provider, a.model.ID, "error", a.duration, 0, 0, requestID,
+			)
+		}
+
+		if s.accounting != nil {
+			err := s.accounting.RecordCost(accounting.CostRecord{
+				Timestamp: time.Now(),
+				Caller:    caller,
+				Provider:  a.model.Provider,
+				Model:     a.model.ID,
+				Currency:  a.model.Pricing.Currency,
+				RequestID: requestID,
+				Status:    "error",
+				Attempt:   i + 1,
+				Error:     a.err.Error(),
+				LatencyMS: a.duration.Milliseconds(),
+				Strategy:  strategy,
+			})
+			if err != nil {
+				s.logger.Warn("failed to record attempt", "error", err, "request_id", requestID)
+			}
+		}
+	}
+}
+
+// recordServedAttempt records metrics and the billed accounting entry for
+// the attempt that served the request, which is always the last one
+func (s *Server) recordServedAttempt(ctx context.Context, caller, requestID, strategy string, served registry.ModelConfig, attempts []attempt, usage core.Usage) {
+	s.modelRouter.RecordOutcome(served.ID, attempts[len(attempts)-1].duration, true)
+	s.recordBilledAttempt(ctx, caller, requestID, strategy, "success", served, attempts, usage)
+}
+
+// recordCancelledAttempts records the attempts of a request whose client
+// went away. Earlier attempts failed; the last one, cut off by the
+// disconnect, is billed for the usage the client received and does not
+// count against the model.
+func (s *Server) recordCancelledAttempts(ctx context.Context, caller, requestID, strategy string, attempts []attempt, usage core.Usage) {
+	if len(attempts) == 0 {
+		return
+	}
+	s.recordFailedAttempts(ctx, caller, requestID, strategy, attempts[:len(attempts)-1])
+	s.recordBilledAttempt(ctx, caller, requestID, strategy, "cancelled", attempts[len(attempts)-1].model, attempts, usage)
+}
+
+// recordBilledAttempt records metrics and the billed accounting entry for
+// the last attempt with the given status
+func (s *Server) recordBilledAttempt(ctx context.Context, caller, requestID, strategy, status string, served registry.ModelConfig, attempts []attempt, usage core.Usage) {
+	duration := attempts[len(attempts)-1].duration
+
+	costResult, err := s.costCalculator.CalcCostForModel(served.ID, usage)
+	if err != nil {
+		s.logger.Warn("failed to calculate cost", "error", err, "request_id", requestID)
+		costResult = &cost.CostResult{TotalCost: 0, Currency: "USD"}
+	}
+
+	if s.observability != nil {
+		s.observability.RecordRequestMetrics(
+			served.Provider, served.ID, status, duration,
+			usage.PromptTokens, usage.CompletionTokens,
+			costResult.TotalCost, costResult.Currency,
+		)
+		s.observability.LogRequestCompletion(
+			ctx, served.Provider, served.ID, status,
+			duration, usage.TotalTokens, costResult.TotalCost, requestID,
+		)
+	}
+
+	if s.accounting != nil {
+		err := s.accounting.RecordCost(accounting.CostRecord{
+			Timestamp:        time.Now(),
+			Caller:           caller,
+			Provider:         served.Provider,
+			Model:            served.ID,
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			Currency:         costResult.Currency,
+			CostInput:        costResult.InputCost,
+			CostOutput:       costResult.OutputCost,
+			CostTotal:        costResult.TotalCost,
+			RequestID:        requestID,
+			Status:           status,
+			Attempt:          len(attempts),
+			LatencyMS:        duration.Milliseconds(),
+			Strategy:         strategy,
+		})
+		if err != nil {
+			s.logger.Warn("failed to record cost", "error", err, "request_id", requestID)
+		}
+	}
+}
+
+// deduplicate runs fn once for identical requests in flight under the same
+// key; the others share its result. Without a cache manager or key fn runs
+// directly.
+func (s *Server) deduplicate(ctx context.Context, endpoint string, key cache.CacheKey, requestID string, fn func() (interface{}, error)) (cache.SharedResult, error) {
+	if s.cacheManager == nil || key == "" {
+		value, err := fn()
+		return cache.SharedResult{Value: value, LeaderID: requestID}, err
+	}
+
+	result, err := s.cacheManager.Deduplicate(ctx, key, requestID, fn)
+	if s.observability != nil {
+		s.observability.RecordDedupMetrics(endpoint, result.Deduplicated)
+	}
+	if result.Deduplicated {
+		s.logger.Info("request deduplicated", "endpoint", endpoint, "deduplicated_from", result.LeaderID, "request_id", requestID)
+	}
+	return result, err
+}
+
+// recordDeduplicated records a zero-cost accounting entry for a request that
+// shared another request's in-flight result; the leader carries the cost
+func (s *Server) recordDeduplicated(caller, requestID, strategy, leaderID string, model registry.ModelConfig, dispatchErr error) {
+	if s.accounting == nil {
+		return
+	}
+
+	record := accounting.CostRecord{
+		Timestamp:        time.Now(),
+		Caller:           caller,
+		Provider:         model.Provider,
+		Model:            model.ID,
+		Currency:         model.Pricing.Currency,
+		RequestID:        requestID,
+		Status:           "success",
+		Attempt:          1,
+		Strategy:         strategy,
+		DeduplicatedFrom: leaderID,
+	}
+	if record.Currency == "" {
+		record.Currency = "USD"
+	}
+	if dispatchErr != nil {
+		record.Status = "error"
+		record.Error = dispatchErr.Error()
+	}
+
+	if err := s.accounting.RecordCost(record); err != nil {
+		s.logger.Warn("failed to record deduplicated request", "error", err, "request_id", requestID)
+	}
+}
+
+// recordCacheHit records a zero-cost accounting entry for a response served
+// from cache, so hit rates can be reported per caller and model
+func (s *Server) recordCacheHit(caller, requestID string, response core.ChatResponse) {
+	if s.accounting == nil {
+		return
+	}
+
+	currency := "USD"
+	if mc := s.registry.FindModel(response.Model); mc != nil && mc.Pricing.Currency != "" {
+		currency = mc.Pricing.Currency
+	}
+
+	err := s.accounting.RecordCost(accounting.CostRecord{
+		Timestamp: time.Now(),
+		Caller:    caller,
+		Provider:  response.Provider,
+		Model:     response.Model,
+		Currency:  currency,
+		RequestID: requestID,
+		Status:    "success",
+		Attempt:   1,
+		CacheHit:  true,
+		Strategy:  "cache",
+	})
+	if err != nil {
+		s.logger.Warn("failed to record cache hit", "error", err, "request_id", requestID)
+	}
+}
+
+// applyDefaultParams fills unset sampling parameters from the model's default_params
+func applyDefaultParams(mc registry.ModelConfig, req core.ChatRequest) core.ChatRequest {
+	if req.Temperature == 0 {
+		if v, ok := toFloat(mc.DefaultParams["temperature"]); ok {
+			req.Temperature = float32(v)
+		}
+	}
+	if req.TopP == 0 {
+		if v, ok := toFloat(mc.DefaultParams["top_p"]); ok {
+			req.TopP = float32(v)
+		}
+	}
+	if req.MaxTokens == 0 {
+		if v, ok := toFloat(mc.DefaultParams["max_tokens"]); ok {
+			req.MaxTokens = int(v)
+		}
+	}
+	return req
+}
+
+// toFloat converts numeric values decoded from YAML or JSON to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// setRetryAfter advertises when a request rejected by the token limiter may
+// be retried
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var limitErr *limiter.TokenLimitError
+	if !errors.As(err, &limitErr) || limitErr.RetryAfter <= 0 {
+		return
+	}
+	seconds := int(math.Ceil(limitErr.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// providerErrorStatus maps a dispatch error to an HTTP status and error code
+func providerErrorStatus(err error) (int, string) {
+	var httpErr *limiter.HTTPError
+
+	switch {
+	case errors.Is(err, errProviderUnavailable):
+		return http.StatusServiceUnavailable, "PROVIDER_UNAVAILABLE"
+	case limiter.IsUnavailableError(err):
+		return http.StatusServiceUnavailable, "MODEL_UNAVAILABLE"
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "PROVIDER_TIMEOUT"
+	case errors.Is(err, limiter.ErrRateLimited):
+		return http.StatusTooManyRequests, "RATE_LIMITED"
+	case errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests:
+		return http.StatusTooManyRequests, "RATE_LIMITED"
+	default:
+		return http.StatusBadGateway, "PROVIDER_ERROR"
+	}
+}
@@ -0,0 +1,62 @@
+package httpserver
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/snow-ghost/agent/embeddings"
+	"github.com/snow-ghost/agent/pkg/cache"
+	"github.com/snow-ghost/agent/vectordb"
+)
+
+// newSemanticCacheFromEnv builds the semantic cache layer selected by
+// SEMANTIC_CACHE ("mock" or "openai"; empty or "off" disables it).
+// SEMANTIC_CACHE_THRESHOLD overrides the default similarity threshold.
+func newSemanticCacheFromEnv(logger *slog.Logger) (*cache.SemanticCache, error) {
+	mode := os.Getenv("SEMANTIC_CACHE")
+
+	var embedder embeddings.Embedder
+	dimension := embeddings.DefaultConfig().Dimension
+	switch mode {
+	case "", "off", "false":
+		return nil, nil
+	case "mock":
+		embedder = embeddings.NewMockEmbedderFactory(nil).CreateEmbedderWithCorpus()
+	case "openai":
+		openaiEmbedder, err := embeddings.NewOpenAIEmbedderFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		embedder = openaiEmbedder
+		dimension = openaiEmbedder.GetConfig().Dimension
+	default:
+		return nil, fmt.Errorf("unknown semantic cache mode: %s", mode)
+	}
+
+	storeConfig := vectordb.DefaultConfig()
+	storeConfig.Collection = "semantic_cache"
+	storeConfig.Dimension = dimension
+
+	config := cache.DefaultSemanticCacheConfig()
+	if value := os.Getenv("SEMANTIC_CACHE_THRESHOLD"); value != "" {
+		threshold, err := parseSimilarityThreshold(value)
+		if err != nil {
+			return nil, err
+		}
+		config.Threshold = threshold
+	}
+
+	logger.Info("semantic cache enabled", "mode", mode, "threshold", config.Threshold, "dimension", dimension)
+	return cache.NewSemanticCache(embedder, vectordb.NewMemoryVectorStore(storeConfig), config)
+}
+
+// parseSimilarityThreshold parses a cosine similarity threshold in (0, 1]
+func parseSimilarityThreshold(value string) (float64, error) {
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil || threshold <= 0 || threshold > 1 {
+		return 0, fmt.Errorf("similarity threshold must be a number in (0, 1], got %q", value)
+	}
+	return threshold, nil
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/snow-ghost/agent/pkg/accounting"
@@ -18,6 +20,7 @@ import (
 	"github.com/snow-ghost/agent/pkg/router/core"
 	"github.com/snow-ghost/agent/pkg/routing"
 	"github.com/snow-ghost/agent/pkg/streaming"
+	"github.com/snow-ghost/agent/pkg/tokens"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -34,6 +37,13 @@ type Server struct {
 	cacheManager      *cache.CacheManager
 	observability     *observability.Manager
 	accounting        *accounting.Manager
+	encoders          *tokens.EncoderRegistry
+	providerFactory   *providers.DefaultProviderFactory
+	providers         map[string]providers.Provider
+	providersMu       sync.Mutex
+
+	// adminToken guards the /v1/admin endpoints; they are disabled without it
+	adminToken string
 }
 
 // NewServer creates a new HTTP server
@@ -46,12 +56,20 @@ func NewServer(port string, logger *slog.Logger) *Server {
 		reg = registry.GetDefaultRegistry()
 	}
 
-	// Create cache manager
-	cacheConfig := cache.DefaultCacheConfig()
+	// Create cache manager; CACHE_BACKEND=redis shares the cache between replicas
+	cacheConfig := cache.CacheConfigFromEnv(cache.DefaultCacheConfig())
 	cacheManager, err := cache.NewCacheManager(cacheConfig)
 	if err != nil {
-		logger.Warn("failed to create cache manager, caching disabled", "error", err)
+		logger.Warn("failed to create cache manager, caching disabled", "error", err, "backend", cacheConfig.Backend)
 		cacheManager = nil
+	} else {
+		logger.Info("cache enabled", "backend", cacheManager.Backend())
+
+		if semanticCache, err := newSemanticCacheFromEnv(logger); err != nil {
+			logger.Warn("failed to create semantic cache, semantic caching disabled", "error", err)
+		} else if semanticCache != nil {
+			cacheManager.EnableSemanticCache(semanticCache)
+		}
 	}
 
 	// Create observability manager
@@ -70,18 +88,36 @@ func NewServer(port string, logger *slog.Logger) *Server {
 		obsManager = nil
 	}
 
-	// Create accounting manager
-	accountingConfig := accounting.Config{
-		UseSQLite: false, // Use in-memory for now
-		DBPath:    "costs.db",
-	}
+	// Create accounting manager; environment variables override router.yaml
+	accountingConfig := accounting.ConfigFromEnv(accounting.Config{
+		UseSQLite:     reg.Accounting.Backend == "sqlite",
+		DBPath:        reg.Accounting.DBPath,
+		RetentionDays: reg.Accounting.RetentionDays,
+	})
 
 	accountingManager, err := accounting.NewManager(accountingConfig)
 	if err != nil {
 		logger.Warn("failed to create accounting manager, cost tracking disabled", "error", err)
 		accountingManager = nil
+	} else {
+		logger.Info("accounting enabled", "sqlite", accountingConfig.UseSQLite, "db_path", accountingConfig.DBPath, "retention_days", accountingConfig.RetentionDays)
 	}
 
+	s := newServer(port, logger, reg)
+	s.encoders = tokens.GetDefaultRegistry()
+	s.cacheManager = cacheManager
+	s.observability = obsManager
+	s.accounting = accountingManager
+	s.adminToken = os.Getenv("LLMROUTER_ADMIN_TOKEN")
+	if s.adminToken == "" {
+		logger.Info("admin endpoints disabled; set LLMROUTER_ADMIN_TOKEN to enable them")
+	}
+	return s
+}
+
+// newServer creates a server around a registry with routing, protection and
+// provider dispatch; cache, observability and accounting are left unset
+func newServer(port string, logger *slog.Logger, reg *registry.Registry) *Server {
 	s := &Server{
 		port:              port,
 		logger:            logger,
@@ -90,10 +126,11 @@ func NewServer(port string, logger *slog.Logger) *Server {
 		costCalculator:    cost.NewCalculator(reg),
 		modelRouter:       routing.NewModelRouter(reg),
 		protectionManager: limiter.NewProtectionManager(reg),
-		cacheManager:      cacheManager,
-		observability:     obsManager,
-		accounting:        accountingManager,
+		encoders:          tokens.NewEncoderRegistry(),
+		providerFactory:   providers.NewProviderFactory(),
+		providers:         make(map[string]providers.Provider),
 	}
+	s.modelRouter.UseCircuitBreakers(s.protectionManager.CircuitBreakers())
 	s.setupRoutes()
 	return s
 }
@@ -115,8 +152,11 @@ func (s *Server) setupRoutes() {
 	v1.HandleFunc("/strategies", s.handleStrategies)
 	v1.HandleFunc("/protection", s.handleProtection)
 	v1.HandleFunc("/cache", s.handleCache)
+	v1.HandleFunc("/admin/budgets", s.requireAdmin(s.handleBudgets))
+	v1.HandleFunc("/admin/budgets/reset", s.requireAdmin(s.handleBudgetReset))
 
-	s.router.Handle("/v1/", http.StripPrefix("/v1", v1))
+	// API requests carry a request ID and caller in their context
+	s.router.Handle("/v1/", http.StripPrefix("/v1", s.observabilityMiddleware(v1.ServeHTTP)))
 }
 
 // observabilityMiddleware adds observability to HTTP requests
@@ -176,6 +216,13 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Flush lets streaming handlers flush through the wrapper
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // generateRequestID generates a unique request ID
 func generateRequestID() string {
 	return fmt.Sprintf("req_%d", time.Now().UnixNano())
@@ -268,6 +315,24 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "llm_cache_misses_total 0\n")
 	fmt.Fprintf(w, "\n")
 
+	if s.cacheManager != nil {
+		dedup := s.cacheManager.DedupStats()
+		fmt.Fprintf(w, "# HELP llm_dedup_requests_total Total number of deduplicable requests\n")
+		fmt.Fprintf(w, "# TYPE llm_dedup_requests_total counter\n")
+		fmt.Fprintf(w, "llm_dedup_requests_total %d\n", dedup.Requests)
+		fmt.Fprintf(w, "\n")
+
+		fmt.Fprintf(w, "# HELP llm_dedup_collapsed_total Total number of requests that shared an in-flight result\n")
+		fmt.Fprintf(w, "# TYPE llm_dedup_collapsed_total counter\n")
+		fmt.Fprintf(w, "llm_dedup_collapsed_total %d\n", dedup.Deduplicated)
+		fmt.Fprintf(w, "\n")
+
+		fmt.Fprintf(w, "# HELP llm_dedup_rate Fraction of deduplicable requests that shared an in-flight result\n")
+		fmt.Fprintf(w, "# TYPE llm_dedup_rate gauge\n")
+		fmt.Fprintf(w, "llm_dedup_rate %g\n", dedup.DedupRate())
+		fmt.Fprintf(w, "\n")
+	}
+
 	fmt.Fprintf(w, "# HELP llm_retries_total Total number of retries\n")
 	fmt.Fprintf(w, "# TYPE llm_retries_total counter\n")
 	fmt.Fprintf(w, "llm_retries_total{provider=\"mock\",model=\"mock\",reason=\"429\"} 0\n")
@@ -295,18 +360,9 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 	requestID := observability.GetRequestIDFromContext(ctx)
 	caller := observability.GetCallerFromContext(ctx)
 
-	// Check budget if specified
-	if s.accounting != nil {
-		budgetHeader := r.Header.Get("X-Budget-Amount")
-		if budgetHeader != "" {
-			budgetInfo, err := s.accounting.CheckBudget(caller, budgetHeader)
-			if err != nil {
-				s.logger.Warn("failed to check budget", "error", err, "request_id", requestID)
-			} else if budgetInfo.Exceeded {
-				s.writeError(w, "Budget exceeded", "BUDGET_EXCEEDED", http.StatusPaymentRequired)
-				return
-			}
-		}
+	// Check budgets before any provider call
+	if !s.enforceBudgets(w, r, caller, requestID) {
+		return
 	}
 
 	// Check if caching is enabled and not streaming
@@ -331,12 +387,45 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 				s.observability.LogCacheOperation(ctx, "get", true, requestID)
 			}
 			s.logger.Info("cache hit", "model", req.Model, "request_id", requestID)
+			s.recordCacheHit(caller, requestID, entry.Response)
 			s.addCostHeaders(w, entry.Response.Model, entry.Response.Usage)
 			w.Header().Set("Content-Type", "application/json")
 			w.Header().Set("X-Cache", "HIT")
 			json.NewEncoder(w).Encode(entry.Response)
 			return
 		}
+
+		// Fall back to a cached response for a similar prompt; a request may
+		// raise the similarity threshold but not lower it
+		if s.cacheManager.SemanticEnabled() && req.Metadata["semantic_cache"] != "false" {
+			var threshold float64
+			if value := req.Metadata["semantic_threshold"]; value != "" {
+				parsed, err := parseSimilarityThreshold(value)
+				if err != nil {
+					s.writeError(w, err.Error(), "INVALID_REQUEST", http.StatusBadRequest)
+					return
+				}
+				threshold = parsed
+			}
+
+			hit, found, err := s.cacheManager.GetSemantic(ctx, cacheReq, threshold)
+			if err != nil {
+				s.logger.Warn("semantic cache lookup failed", "error", err, "request_id", requestID)
+			} else if found {
+				if s.observability != nil {
+					s.observability.RecordCacheMetrics(true)
+					s.observability.LogCacheOperation(ctx, "semantic_get", true, requestID)
+				}
+				s.logger.Info("semantic cache hit", "model", req.Model, "similarity", hit.Similarity, "request_id", requestID)
+				s.recordCacheHit(caller, requestID, hit.Entry.Response)
+				s.addCostHeaders(w, hit.Entry.Response.Model, hit.Entry.Response.Usage)
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("X-Cache", "SEMANTIC-HIT")
+				w.Header().Set("X-Cache-Similarity", strconv.FormatFloat(hit.Similarity, 'f', 4, 64))
+				json.NewEncoder(w).Encode(hit.Entry.Response)
+				return
+			}
+		}
 	}
 
 	// Select model using routing strategy
@@ -345,7 +434,7 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 		strategy = "tag-based" // Default strategy
 	}
 
-	selectedModel, err := s.modelRouter.SelectModel(ctx, strategy, req.Metadata)
+	selectedModel, strategy, err := s.selectModel(ctx, strategy, req.Model, req.Metadata)
 	if err != nil {
 		s.logger.Error("model selection failed", "error", err, "strategy", strategy, "request_id", requestID)
 		s.writeError(w, "Model selection failed", "MODEL_SELECTION_FAILED", http.StatusInternalServerError)
@@ -361,59 +450,68 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 
 	s.logger.Info("model selected", "model", selectedModel.ID, "strategy", strategy, "domain", req.Metadata["task_domain"], "request_id", requestID)
 
-	// For now, return a mock response with selected model info
-	response := core.ChatResponse{
-		Text: fmt.Sprintf("Hello! This is a mock response from the LLM router using model %s (strategy: %s).", selectedModel.ID, strategy),
-		Usage: core.Usage{
-			PromptTokens:     10,
-			CompletionTokens: 15,
-			TotalTokens:      25,
-		},
-		Model:        selectedModel.ID,
-		Provider:     selectedModel.Provider,
-		FinishReason: "stop",
+	// Identical cacheable requests in flight share a single dispatch
+	var dedupKey cache.CacheKey
+	if cacheEnabled && s.cacheManager != nil {
+		if dedupKey, err = cache.GenerateKey(cacheReq); err != nil {
+			s.logger.Warn("failed to generate deduplication key", "error", err, "request_id", requestID)
+		}
 	}
 
-	// Calculate cost
-	costResult, err := s.costCalculator.CalcCostForModel(selectedModel.ID, response.Usage)
+	// Dispatch to the provider, falling back along the model's chain
+	result, err := s.deduplicate(ctx, "chat", dedupKey, requestID, func() (interface{}, error) {
+		var outcome chatOutcome
+		var err error
+		outcome.served, outcome.attempts, err = s.runWithFallback(ctx, *selectedModel, func(mc registry.ModelConfig) error {
+			var err error
+			outcome.response, err = s.executeChat(ctx, mc, req)
+			return err
+		}, nil)
+		return outcome, err
+	})
+	outcome, _ := result.Value.(chatOutcome)
+	served, attempts, response := outcome.served, outcome.attempts, outcome.response
+
+	if result.Deduplicated {
+		// The leader has already recorded the provider calls and their cost
+		model := served
+		if err != nil {
+			model = *selectedModel
+		}
+		s.recordDeduplicated(caller, requestID, strategy, result.LeaderID, model, err)
+		w.Header().Set("X-Deduplicated-From", result.LeaderID)
+	} else {
+		s.recordFailedAttempts(ctx, caller, requestID, strategy, attempts)
+	}
 	if err != nil {
-		s.logger.Warn("failed to calculate cost", "error", err, "request_id", requestID)
-		costResult = &cost.CostResult{TotalCost: 0, Currency: "USD"}
+		statusCode, code := providerErrorStatus(err)
+		s.logger.Error("chat request failed", "error", err, "model", selectedModel.ID, "attempts", len(attempts), "request_id", requestID)
+		setRoutingHeaders(w, *selectedModel, nil, attempts)
+		setRetryAfter(w, err)
+		s.writeError(w, err.Error(), code, statusCode)
+		return
 	}
 
-	// Record metrics and logs
-	if s.observability != nil {
-		s.observability.RecordRequestMetrics(
-			selectedModel.Provider, selectedModel.ID, "success",
-			time.Since(time.Now()), // Mock duration
-			response.Usage.PromptTokens, response.Usage.CompletionTokens,
-			costResult.TotalCost, costResult.Currency,
-		)
-		s.observability.LogRequestCompletion(
-			ctx, selectedModel.Provider, selectedModel.ID, "success",
-			time.Since(time.Now()), response.Usage.TotalTokens, costResult.TotalCost, requestID,
-		)
-	}
-
-	// Record cost in accounting
-	if s.accounting != nil {
-		err := s.accounting.RecordLLMCost(
-			caller, selectedModel.Provider, selectedModel.ID, requestID,
-			response.Usage.PromptTokens, response.Usage.CompletionTokens,
-			costResult.InputCost, costResult.OutputCost, costResult.TotalCost,
-			costResult.Currency,
-		)
-		if err != nil {
-			s.logger.Warn("failed to record cost", "error", err, "request_id", requestID)
-		}
+	setRoutingHeaders(w, *selectedModel, &served, attempts)
+	if result.Deduplicated {
+		w.Header().Set("X-Cache", "DEDUPLICATED")
+		s.addCostHeaders(w, served.ID, core.Usage{})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
 	}
 
+	s.recordServedAttempt(ctx, caller, requestID, strategy, served, attempts, response.Usage)
+
 	// Cache the response if enabled
 	if cacheEnabled && s.cacheManager != nil {
 		if err := s.cacheManager.Set(cacheReq, response); err != nil {
 			s.logger.Warn("failed to cache response", "error", err, "request_id", requestID)
 		} else {
-			s.logger.Info("response cached", "model", selectedModel.ID, "request_id", requestID)
+			s.logger.Info("response cached", "model", served.ID, "request_id", requestID)
+		}
+		if err := s.cacheManager.SetSemantic(ctx, cacheReq, response); err != nil {
+			s.logger.Warn("failed to index response in semantic cache", "error", err, "request_id", requestID)
 		}
 		w.Header().Set("X-Cache", "MISS")
 	} else {
@@ -421,7 +519,7 @@ func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Add cost headers
-	s.addCostHeaders(w, selectedModel.ID, response.Usage)
+	s.addCostHeaders(w, served.ID, response.Usage)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -440,6 +538,15 @@ func (s *Server) handleChatStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ctx := r.Context()
+	requestID := observability.GetRequestIDFromContext(ctx)
+	caller := observability.GetCallerFromContext(ctx)
+
+	// Check budgets while a plain JSON error can still be returned
+	if !s.enforceBudgets(w, r, caller, requestID) {
+		return
+	}
+
 	// Create SSE writer
 	sseWriter, err := streaming.NewSSEWriter(w)
 	if err != nil {
@@ -454,7 +561,7 @@ func (s *Server) handleChatStream(w http.ResponseWriter, r *http.Request) {
 		strategy = "tag-based" // Default strategy
 	}
 
-	selectedModel, err := s.modelRouter.SelectModel(r.Context(), strategy, req.Metadata)
+	selectedModel, strategy, err := s.selectModel(ctx, strategy, req.Model, req.Metadata)
 	if err != nil {
 		s.logger.Error("model selection failed", "error", err, "strategy", strategy)
 		sseWriter.WriteError(fmt.Errorf("model selection failed: %w", err))
@@ -463,16 +570,30 @@ func (s *Server) handleChatStream(w http.ResponseWriter, r *http.Request) {
 
 	s.logger.Info("streaming model selected", "model", selectedModel.ID, "strategy", strategy, "domain", req.Metadata["task_domain"])
 
-	// Create mock streaming provider for now
-	provider := providers.NewMockStreamingProvider()
-
-	// Perform streaming chat
-	if err := provider.ChatStream(r.Context(), *selectedModel, req, sseWriter); err != nil {
-		s.logger.Error("streaming chat failed", "error", err)
+	// Stream from the provider; the request context is cancelled when the
+	// client disconnects, which aborts the upstream request as well. Fallback
+	// is only possible until the first event reaches the client.
+	var usage core.Usage
+	served, attempts, err := s.runWithFallback(ctx, *selectedModel, func(mc registry.ModelConfig) error {
+		var err error
+		usage, err = s.executeChatStream(ctx, mc, req, sseWriter)
+		return err
+	}, func() bool { return !sseWriter.HasWritten() })
+	if err != nil && ctx.Err() != nil {
+		s.logger.Info("client disconnected during stream", "model", selectedModel.ID, "request_id", requestID,
+			"prompt_tokens", usage.PromptTokens, "completion_tokens", usage.CompletionTokens)
+		s.recordCancelledAttempts(ctx, caller, requestID, strategy, attempts, usage)
+		return
+	}
+	s.recordFailedAttempts(ctx, caller, requestID, strategy, attempts)
+	if err != nil {
+		s.logger.Error("streaming chat failed", "error", err, "model", selectedModel.ID, "attempts", len(attempts), "request_id", requestID)
 		sseWriter.WriteError(err)
 		return
 	}
 
+	s.recordServedAttempt(ctx, caller, requestID, strategy, served, attempts, usage)
+
 	// Close the stream
 	sseWriter.Close()
 }
@@ -490,6 +611,12 @@ func (s *Server) handleComplete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check budgets before any provider call
+	ctx := r.Context()
+	if !s.enforceBudgets(w, r, observability.GetCallerFromContext(ctx), observability.GetRequestIDFromContext(ctx)) {
+		return
+	}
+
 	// For now, return a mock response
 	response := core.CompleteResponse{
 		Text: "This is a mock completion response.",
@@ -520,25 +647,50 @@ func (s *Server) handleEmbed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// For now, return a mock response
-	embeddings := make([]core.Embedding, len(req.Input))
-	for i := range req.Input {
-		// Generate mock embedding (1536 dimensions)
-		embedding := make([]float32, 1536)
-		for j := range embedding {
-			embedding[j] = float32(i+j) / 1000.0 // Mock values
-		}
-		embeddings[i] = core.Embedding{
-			Index:     i,
-			Embedding: embedding,
+	// Check budgets before any provider call
+	ctx := r.Context()
+	requestID := observability.GetRequestIDFromContext(ctx)
+	if !s.enforceBudgets(w, r, observability.GetCallerFromContext(ctx), requestID) {
+		return
+	}
+
+	// Identical embedding requests in flight share a single call
+	dedupKey, err := cache.GenerateEmbedKey(req.Model, req.Input)
+	if err != nil {
+		s.logger.Warn("failed to generate deduplication key", "error", err, "request_id", requestID)
+	}
+
+	result, err := s.deduplicate(ctx, "embed", dedupKey, requestID, func() (interface{}, error) {
+		// For now, return a mock response
+		embeddings := make([]core.Embedding, len(req.Input))
+		for i := range req.Input {
+			// Generate mock embedding (1536 dimensions)
+			embedding := make([]float32, 1536)
+			for j := range embedding {
+				embedding[j] = float32(i+j) / 1000.0 // Mock values
+			}
+			embeddings[i] = core.Embedding{
+				Index:     i,
+				Embedding: embedding,
+			}
 		}
+
+		return core.EmbedResponse{
+			Data:     embeddings,
+			Usage:    core.Usage{TotalTokens: len(req.Input) * 10},
+			Model:    req.Model,
+			Provider: "mock",
+		}, nil
+	})
+	if err != nil {
+		statusCode, code := providerErrorStatus(err)
+		s.writeError(w, err.Error(), code, statusCode)
+		return
 	}
 
-	response := core.EmbedResponse{
-		Data:     embeddings,
-		Usage:    core.Usage{TotalTokens: len(req.Input) * 10},
-		Model:    req.Model,
-		Provider: "mock",
+	response := result.Value.(core.EmbedResponse)
+	if result.Deduplicated {
+		w.Header().Set("X-Deduplicated-From", result.LeaderID)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -595,9 +747,18 @@ func (s *Server) handleStrategies(w http.ResponseWriter, r *http.Request) {
 
 	strategies := s.modelRouter.GetAvailableStrategies()
 
+	// Score against the same constraints a request would carry in its metadata
+	metadata := make(map[string]string)
+	for _, key := range []string{"max_latency_ms", "max_cost_per_1k"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			metadata[key] = value
+		}
+	}
+
 	response := map[string]interface{}{
-		"strategies": strategies,
-		"default":    "tag-based",
+		"strategies":      strategies,
+		"default":         "tag-based",
+		"adaptive_scores": s.modelRouter.AdaptiveScores(metadata),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -639,13 +800,17 @@ func (s *Server) handleCache(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get cache statistics
+	// Get cache statistics; the size is read once, as a shared backend
+	// answers it with a round trip, and left out when the backend cannot
+	// count its entries cheaply
 	stats := s.cacheManager.Stats()
 
 	// Add cache status
 	stats["status"] = "enabled"
-	stats["size"] = s.cacheManager.GetCacheSize()
-	stats["keys"] = len(s.cacheManager.GetCacheKeys())
+	if size, known := stats["cache"].(map[string]interface{})["size"]; known {
+		stats["size"] = size
+		stats["keys"] = size
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
@@ -0,0 +1,900 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/snow-ghost/agent/pkg/accounting"
+	"github.com/snow-ghost/agent/pkg/cache"
+	"github.com/snow-ghost/agent/pkg/registry"
+	"github.com/snow-ghost/agent/pkg/router/core"
+	"github.com/snow-ghost/agent/pkg/routing"
+)
+
+// testLogger discards server logs during tests
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// newTestServer creates a server over a single-model registry with in-memory accounting
+func newTestServer(t *testing.T, models ...registry.ModelConfig) *Server {
+	t.Helper()
+
+	s := newServer("0", testLogger(), &registry.Registry{Models: models})
+
+	acct, err := accounting.NewManager(accounting.Config{})
+	if err != nil {
+		t.Fatalf("failed to create accounting manager: %v", err)
+	}
+	s.accounting = acct
+
+	return s
+}
+
+// postChat sends a chat request through the server's router
+func postChat(t *testing.T, s *Server, req core.ChatRequest) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to marshal request: %v", err)
+	}
+
+	httpReq := httptest.NewRequest(http.MethodPost, "/v1/chat", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httpReq)
+	return rec
+}
+
+func TestHandleChatOpenAICompatible(t *testing.T) {
+	var gotModel string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotModel, _ = body["model"].(string)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":     "chatcmpl-test",
+			"object": "chat.completion",
+			"model":  gotModel,
+			"choices": []map[string]interface{}{
+				{
+					"index":         0,
+					"message":       map[string]interface{}{"role": "assistant", "content": "Hi from upstream"},
+					"finish_reason": "stop",
+				},
+			},
+			"usage": map[string]interface{}{
+				"prompt_tokens":     1000,
+				"completion_tokens": 500,
+				"total_tokens":      1500,
+			},
+		})
+	}))
+	defer upstream.Close()
+
+	t.Setenv("TEST_OPENAI_KEY", "test-key")
+	s := newTestServer(t, registry.ModelConfig{
+		ID:        "openai:gpt-test",
+		Provider:  "openai",
+		BaseURL:   upstream.URL,
+		APIKeyEnv: "TEST_OPENAI_KEY",
+		Kind:      "chat",
+		Pricing:   registry.Pricing{Currency: "USD", InputPer1K: 0.001, OutputPer1K: 0.002},
+	})
+
+	rec := postChat(t, s, core.ChatRequest{
+		Messages: []core.Message{{Role: "user", Content: "Hello"}},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp core.ChatResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if resp.Text != "Hi from upstream" {
+		t.Errorf("Expected upstream text, got %q", resp.Text)
+	}
+	if resp.Usage.PromptTokens != 1000 || resp.Usage.CompletionTokens != 500 {
+		t.Errorf("Expected upstream usage, got %+v", resp.Usage)
+	}
+	if resp.Model != "openai:gpt-test" {
+		t.Errorf("Expected model openai:gpt-test, got %s", resp.Model)
+	}
+	if gotModel != "gpt-test" {
+		t.Errorf("Expected provider prefix to be stripped, upstream saw %q", gotModel)
+	}
+	if got := rec.Header().Get("X-Cost-Total"); got != "0.002000;currency=USD" {
+		t.Errorf("Expected X-Cost-Total 0.002000;currency=USD, got %q", got)
+	}
+
+	summary, err := s.accounting.GetCostSummary(accounting.CostFilter{})
+	if err != nil {
+		t.Fatalf("GetCostSummary failed: %v", err)
+	}
+	if summary.TotalRecords != 1 || summary.TotalCost != 0.002 {
+		t.Errorf("Expected one recorded cost of 0.002, got %+v", summary)
+	}
+}
+
+func TestHandleChatAnthropic(t *testing.T) {
+	var gotReq map[string]interface{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "test-key" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&gotReq)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"content":     []map[string]interface{}{{"type": "text", "text": "Hi from Claude"}},
+			"usage":       map[string]interface{}{"input_tokens": 12, "output_tokens": 8},
+			"stop_reason": "end_turn",
+		})
+	}))
+	defer upstream.Close()
+
+	t.Setenv("TEST_ANTHROPIC_KEY", "test-key")
+	s := newTestServer(t, registry.ModelConfig{
+		ID:            "anthropic:claude-test",
+		Provider:      "anthropic",
+		BaseURL:       upstream.URL,
+		APIKeyEnv:     "TEST_ANTHROPIC_KEY",
+		Kind:          "chat",
+		Pricing:       registry.Pricing{Currency: "USD"},
+		DefaultParams: map[string]interface{}{"max_tokens": 256},
+	})
+
+	rec := postChat(t, s, core.ChatRequest{
+		Messages: []core.Message{
+			{Role: "system", Content: "Be brief"},
+			{Role: "user", Content: "Hello"},
+		},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp core.ChatResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	if resp.Text != "Hi from Claude" || resp.Usage.TotalTokens != 20 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+	if gotReq["system"] != "Be brief" {
+		t.Errorf("Expected system prompt to be forwarded, got %v", gotReq["system"])
+	}
+	if gotReq["max_tokens"] != float64(256) {
+		t.Errorf("Expected max_tokens from default params, got %v", gotReq["max_tokens"])
+	}
+}
+
+func TestHandleChatOllama(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"model":   "llama-test",
+			"message": map[string]interface{}{"role": "assistant", "content": "Hi from Ollama"},
+			"done":    true,
+		})
+	}))
+	defer upstream.Close()
+
+	s := newTestServer(t, registry.ModelConfig{
+		ID:       "ollama:llama-test",
+		Provider: "ollama",
+		BaseURL:  upstream.URL,
+		Kind:     "chat",
+		Pricing:  registry.Pricing{Currency: "USD"},
+	})
+
+	rec := postChat(t, s, core.ChatRequest{
+		Messages: []core.Message{{Role: "user", Content: "Hello there"}},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp core.ChatResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+
+	if resp.Text != "Hi from Ollama" || resp.Provider != "ollama" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+	if resp.Usage.TotalTokens == 0 {
+		t.Error("Expected estimated usage for Ollama response")
+	}
+}
+
+func TestHandleChatProviderErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"bad request"}`, http.StatusBadRequest)
+	}))
+	defer upstream.Close()
+
+	t.Run("upstream error", func(t *testing.T) {
+		s := newTestServer(t, registry.ModelConfig{
+			ID:       "ollama:broken",
+			Provider: "ollama",
+			BaseURL:  upstream.URL,
+			Kind:     "chat",
+		})
+
+		rec := postChat(t, s, core.ChatRequest{Messages: []core.Message{{Role: "user", Content: "Hi"}}})
+		if rec.Code != http.StatusBadGateway {
+			t.Errorf("Expected status 502, got %d", rec.Code)
+		}
+
+		summary, _ := s.accounting.GetCostSummary(accounting.CostFilter{})
+		if summary.TotalRecords != 1 || summary.TotalCost != 0 {
+			t.Errorf("Expected one zero-cost record for the failed attempt, got %+v", summary)
+		}
+	})
+
+	t.Run("missing api key", func(t *testing.T) {
+		s := newTestServer(t, registry.ModelConfig{
+			ID:        "openai:no-key",
+			Provider:  "openai",
+			BaseURL:   upstream.URL,
+			APIKeyEnv: "TEST_UNSET_KEY",
+			Kind:      "chat",
+		})
+
+		rec := postChat(t, s, core.ChatRequest{Messages: []core.Message{{Role: "user", Content: "Hi"}}})
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503, got %d", rec.Code)
+		}
+
+		var errResp core.ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&errResp)
+		if errResp.Code != "PROVIDER_UNAVAILABLE" {
+			t.Errorf("Expected PROVIDER_UNAVAILABLE, got %s", errResp.Code)
+		}
+	})
+}
+
+func TestHandleChatStream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hello"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":" there"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":1000,"eval_count":1000}`)
+	}))
+	defer upstream.Close()
+
+	s := newTestServer(t, registry.ModelConfig{
+		ID:       "ollama:llama-test",
+		Provider: "ollama",
+		BaseURL:  upstream.URL,
+		Kind:     "chat",
+		Pricing:  registry.Pricing{Currency: "USD", InputPer1K: 0.001, OutputPer1K: 0.001},
+	})
+
+	body, _ := json.Marshal(core.ChatRequest{Messages: []core.Message{{Role: "user", Content: "Hi"}}})
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/stream", bytes.NewReader(body)))
+
+	out := rec.Body.String()
+	for _, want := range []string{"event: start", `"text":"Hello"`, `"text":" there"`, "event: done", `"total":0.002`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected stream to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "event: error") {
+		t.Errorf("Unexpected error event:\n%s", out)
+	}
+
+	summary, _ := s.accounting.GetCostSummary(accounting.CostFilter{})
+	if summary.TotalRecords != 1 || summary.TotalPromptTokens != 1000 {
+		t.Errorf("Expected streamed usage to be recorded, got %+v", summary)
+	}
+}
+
+// cancelOnChunk cancels the request once the first chunk reaches the client
+type cancelOnChunk struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (w cancelOnChunk) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), `"type":"chunk"`) {
+		w.cancel()
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestHandleChatStreamClientDisconnect(t *testing.T) {
+	finished := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hello there, this is the start of a long answer"},"done":false}`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-finished:
+		}
+	}))
+	defer upstream.Close()
+	defer close(finished)
+
+	s := newTestServer(t, registry.ModelConfig{
+		ID:       "ollama:llama-test",
+		Provider: "ollama",
+		BaseURL:  upstream.URL,
+		Kind:     "chat",
+		Pricing:  registry.Pricing{Currency: "USD", InputPer1K: 1, OutputPer1K: 1},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	body, _ := json.Marshal(core.ChatRequest{Messages: []core.Message{{Role: "user", Content: "Write a long answer"}}})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/stream", bytes.NewReader(body)).WithContext(ctx)
+	req.Header.Set("X-Caller", "team-a")
+	s.router.ServeHTTP(cancelOnChunk{httptest.NewRecorder(), cancel}, req)
+
+	// What was streamed before the disconnect is billed to the caller
+	records, err := s.accounting.GetCosts(accounting.CostFilter{})
+	if err != nil {
+		t.Fatalf("Failed to get costs: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Expected one record for the cancelled stream, got %+v", records)
+	}
+	record := records[0]
+	if record.Status != "cancelled" || record.Caller != "team-a" {
+		t.Errorf("Expected a cancelled record for team-a, got %+v", record)
+	}
+	if record.PromptTokens == 0 || record.CompletionTokens == 0 || record.CostTotal == 0 {
+		t.Errorf("Expected partial usage to be billed, got %+v", record)
+	}
+}
+
+func TestHandleChatFallback(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+	}))
+	defer failing.Close()
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"bad request"}`, http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hi from fallback"},"done":true,"prompt_eval_count":10,"eval_count":5}`)
+	}))
+	defer healthy.Close()
+
+	models := func(primaryURL string) []registry.ModelConfig {
+		return []registry.ModelConfig{
+			{
+				ID:        "ollama:primary",
+				Provider:  "ollama",
+				BaseURL:   primaryURL,
+				Kind:      "chat",
+				Fallbacks: []string{"ollama:missing", "ollama:backup"},
+			},
+			{ID: "ollama:backup", Provider: "ollama", BaseURL: healthy.URL, Kind: "chat"},
+		}
+	}
+
+	t.Run("falls back on provider failure", func(t *testing.T) {
+		s := newTestServer(t, models(failing.URL)...)
+
+		rec := postChat(t, s, core.ChatRequest{
+			Model:    "ollama:primary",
+			Messages: []core.Message{{Role: "user", Content: "Hi"}},
+		})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		var resp core.ChatResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if resp.Text != "Hi from fallback" || resp.Model != "ollama:backup" {
+			t.Errorf("Expected response from ollama:backup, got %+v", resp)
+		}
+
+		headers := map[string]string{
+			"X-Model":         "ollama:backup",
+			"X-Provider":      "ollama",
+			"X-Fallback-From": "ollama:primary",
+			"X-Attempts":      "2",
+		}
+		for name, want := range headers {
+			if got := rec.Header().Get(name); got != want {
+				t.Errorf("Expected %s %q, got %q", name, want, got)
+			}
+		}
+
+		records, err := s.accounting.GetCosts(accounting.CostFilter{})
+		if err != nil {
+			t.Fatalf("GetCostRecords failed: %v", err)
+		}
+		if len(records) != 2 {
+			t.Fatalf("Expected a cost record per attempt, got %d", len(records))
+		}
+
+		byAttempt := map[int]accounting.CostRecord{}
+		for _, record := range records {
+			byAttempt[record.Attempt] = record
+		}
+		if r := byAttempt[1]; r.Model != "ollama:primary" || r.Status != "error" || r.Error == "" {
+			t.Errorf("Expected failed first attempt on ollama:primary, got %+v", r)
+		}
+		if r := byAttempt[2]; r.Model != "ollama:backup" || r.Status != "success" || r.PromptTokens != 10 || r.Strategy != "explicit" {
+			t.Errorf("Expected successful second attempt on ollama:backup, got %+v", r)
+		}
+	})
+
+	t.Run("does not fall back on invalid request", func(t *testing.T) {
+		s := newTestServer(t, models(rejecting.URL)...)
+
+		rec := postChat(t, s, core.ChatRequest{
+			Model:    "ollama:primary",
+			Messages: []core.Message{{Role: "user", Content: "Hi"}},
+		})
+		if rec.Code != http.StatusBadGateway {
+			t.Errorf("Expected status 502, got %d", rec.Code)
+		}
+		if got := rec.Header().Get("X-Attempts"); got != "1" {
+			t.Errorf("Expected a single attempt, got %q", got)
+		}
+	})
+
+	t.Run("falls back before streaming starts", func(t *testing.T) {
+		s := newTestServer(t, models(failing.URL)...)
+
+		body, _ := json.Marshal(core.ChatRequest{
+			Model:    "ollama:primary",
+			Messages: []core.Message{{Role: "user", Content: "Hi"}},
+		})
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/stream", bytes.NewReader(body)))
+
+		out := rec.Body.String()
+		for _, want := range []string{`"model":"ollama:backup"`, `"text":"Hi from fallback"`, "event: done"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("Expected stream to contain %q, got:\n%s", want, out)
+			}
+		}
+		if strings.Contains(out, "event: error") {
+			t.Errorf("Unexpected error event:\n%s", out)
+		}
+	})
+}
+
+func TestHandleStrategiesExposesAdaptiveScores(t *testing.T) {
+	s := newTestServer(t,
+		registry.ModelConfig{ID: "ollama:fast", Provider: "ollama", Kind: "chat"},
+		registry.ModelConfig{ID: "ollama:slow", Provider: "ollama", Kind: "chat"},
+	)
+	s.modelRouter.RecordOutcome("ollama:fast", 50*time.Millisecond, true)
+	s.modelRouter.RecordOutcome("ollama:slow", 900*time.Millisecond, true)
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/strategies?max_latency_ms=500", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var resp struct {
+		Strategies     []string             `json:"strategies"`
+		AdaptiveScores []routing.ModelScore `json:"adaptive_scores"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if len(resp.AdaptiveScores) != 2 {
+		t.Fatalf("Expected a score per model, got %+v", resp.AdaptiveScores)
+	}
+	if best := resp.AdaptiveScores[0]; best.ModelID != "ollama:fast" || !best.Eligible {
+		t.Errorf("Expected ollama:fast to rank first, got %+v", best)
+	}
+	if slow := resp.AdaptiveScores[1]; slow.Eligible || len(slow.Reasons) == 0 {
+		t.Errorf("Expected ollama:slow to be ineligible under max_latency_ms, got %+v", slow)
+	}
+}
+
+func TestBudgetEnforcement(t *testing.T) {
+	var upstreamCalls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"ok"},"done":true,"prompt_eval_count":1000,"eval_count":0}`)
+	}))
+	defer upstream.Close()
+
+	s := newTestServer(t, registry.ModelConfig{
+		ID:       "ollama:llama-test",
+		Provider: "ollama",
+		BaseURL:  upstream.URL,
+		Kind:     "chat",
+		Pricing:  registry.Pricing{Currency: "USD", InputPer1K: 0.9},
+	})
+
+	s.adminToken = "secret"
+	admin := func(method, target string, body interface{}) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != nil {
+			data, _ := json.Marshal(body)
+			reader = bytes.NewReader(data)
+		}
+		req := httptest.NewRequest(method, target, reader)
+		req.Header.Set("Authorization", "Bearer "+s.adminToken)
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	chat := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(core.ChatRequest{Messages: []core.Message{{Role: "user", Content: "Hi"}}})
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat", bytes.NewReader(body))
+		req.Header.Set("X-Caller", "team-a")
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := admin(http.MethodPut, "/v1/admin/budgets", accounting.Budget{Caller: "team-a", Window: accounting.BudgetWindowDaily, Amount: 1.0}); rec.Code != http.StatusOK {
+		t.Fatalf("Expected budget to be set, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := admin(http.MethodPut, "/v1/admin/budgets", accounting.Budget{Caller: "team-a", Window: "weekly", Amount: 1.0}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid budget to be rejected, got %d", rec.Code)
+	}
+
+	// First request spends 0.9 of the 1.0 budget
+	rec := chat()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected first request to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Second request is allowed but past the 80% alert threshold
+	rec = chat()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected second request to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(rec.Header().Get("X-Budget-Alert"), "daily;") {
+		t.Errorf("Expected daily budget alert header, got %q", rec.Header().Get("X-Budget-Alert"))
+	}
+
+	// Budget is now exhausted; the provider must not be called
+	calls := upstreamCalls
+	rec = chat()
+	if rec.Code != http.StatusPaymentRequired {
+		t.Fatalf("Expected 402 once the budget is exhausted, got %d", rec.Code)
+	}
+	if upstreamCalls != calls {
+		t.Error("Expected no provider call for a rejected request")
+	}
+
+	rec = admin(http.MethodGet, "/v1/admin/budgets?caller=team-a", nil)
+	var listed struct {
+		Budgets []accounting.BudgetStatus `json:"budgets"`
+	}
+	json.NewDecoder(rec.Body).Decode(&listed)
+	if len(listed.Budgets) != 1 || !listed.Budgets[0].Exceeded {
+		t.Errorf("Expected one exceeded budget, got %+v", listed.Budgets)
+	}
+
+	if rec := admin(http.MethodPost, "/v1/admin/budgets/reset?caller=team-a&window=daily", nil); rec.Code != http.StatusOK {
+		t.Fatalf("Expected budget reset, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := chat(); rec.Code != http.StatusOK {
+		t.Errorf("Expected request to succeed after reset, got %d", rec.Code)
+	}
+
+	if rec := admin(http.MethodDelete, "/v1/admin/budgets?caller=team-a&window=daily", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected budget deletion, got %d", rec.Code)
+	}
+	if rec := admin(http.MethodPost, "/v1/admin/budgets/reset?caller=team-a&window=daily", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 resetting a deleted budget, got %d", rec.Code)
+	}
+}
+
+func TestAdminEndpointsRequireToken(t *testing.T) {
+	s := newTestServer(t)
+
+	get := func(authorization string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/admin/budgets?caller=team-a", nil)
+		req.Header.Set("X-Caller", "team-a")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Disabled without a configured token
+	if code := get("Bearer "); code != http.StatusForbidden {
+		t.Errorf("Expected 403 without an admin token, got %d", code)
+	}
+
+	s.adminToken = "secret"
+	for _, authorization := range []string{"", "Bearer wrong", "secret", "Basic c2VjcmV0"} {
+		if code := get(authorization); code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected 401, got %d", authorization, code)
+		}
+	}
+	if code := get("Bearer secret"); code == http.StatusUnauthorized || code == http.StatusForbidden {
+		t.Errorf("Expected the admin token to be accepted, got %d", code)
+	}
+}
+
+func TestHandleChatSemanticCache(t *testing.T) {
+	var upstreamCalls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"1, 2, 3"},"done":true,"prompt_eval_count":10,"eval_count":5}`)
+	}))
+	defer upstream.Close()
+
+	s := newTestServer(t, registry.ModelConfig{ID: "ollama:llama-test", Provider: "ollama", BaseURL: upstream.URL, Kind: "chat"})
+
+	t.Setenv("SEMANTIC_CACHE", "mock")
+	t.Setenv("SEMANTIC_CACHE_THRESHOLD", "0.9")
+	semanticCache, err := newSemanticCacheFromEnv(testLogger())
+	if err != nil {
+		t.Fatalf("failed to create semantic cache: %v", err)
+	}
+	s.cacheManager, err = cache.NewCacheManager(cache.DefaultCacheConfig())
+	if err != nil {
+		t.Fatalf("failed to create cache manager: %v", err)
+	}
+	s.cacheManager.EnableSemanticCache(semanticCache)
+	defer s.cacheManager.Close()
+
+	chat := func(prompt string, metadata map[string]string) *httptest.ResponseRecorder {
+		metadata["cache"] = "true"
+		return postChat(t, s, core.ChatRequest{
+			Model:    "ollama:llama-test",
+			Messages: []core.Message{{Role: "user", Content: prompt}},
+			Metadata: metadata,
+		})
+	}
+
+	rec := chat("please sort this list of numbers in ascending order", map[string]string{})
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("Expected cache miss, got %d X-Cache=%q", rec.Code, rec.Header().Get("X-Cache"))
+	}
+
+	// A paraphrase can opt out of the semantic cache
+	paraphrase := "please sort this list of numbers in ascending order now"
+	rec = chat(paraphrase, map[string]string{"semantic_cache": "false"})
+	if rec.Header().Get("X-Cache") != "MISS" || upstreamCalls != 2 {
+		t.Fatalf("Expected opted-out request to reach the provider, got X-Cache=%q calls=%d", rec.Header().Get("X-Cache"), upstreamCalls)
+	}
+
+	// or raise the threshold above its similarity
+	rec = chat("please sort these numbers in ascending order", map[string]string{"semantic_threshold": "0.999"})
+	if rec.Header().Get("X-Cache") != "MISS" || upstreamCalls != 3 {
+		t.Fatalf("Expected a raised threshold to miss, got X-Cache=%q calls=%d", rec.Header().Get("X-Cache"), upstreamCalls)
+	}
+
+	// but cannot lower it to match an unrelated prompt
+	rec = chat("please list some numbers", map[string]string{"semantic_threshold": "0.0001"})
+	if rec.Header().Get("X-Cache") != "MISS" || upstreamCalls != 4 {
+		t.Fatalf("Expected a lowered threshold to miss, got X-Cache=%q calls=%d", rec.Header().Get("X-Cache"), upstreamCalls)
+	}
+
+	// Otherwise it is served from the semantic cache at the configured threshold
+	rec = chat(paraphrase+" please", map[string]string{})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Cache") != "SEMANTIC-HIT" {
+		t.Fatalf("Expected semantic hit, got X-Cache=%q", rec.Header().Get("X-Cache"))
+	}
+	if similarity, err := strconv.ParseFloat(rec.Header().Get("X-Cache-Similarity"), 64); err != nil || similarity < 0.9 {
+		t.Errorf("Expected similarity header >= 0.9, got %q", rec.Header().Get("X-Cache-Similarity"))
+	}
+	if upstreamCalls != 4 {
+		t.Errorf("Expected no provider call for a semantic hit, got %d calls", upstreamCalls)
+	}
+
+	var resp core.ChatResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Text != "1, 2, 3" {
+		t.Errorf("Expected cached text, got %q", resp.Text)
+	}
+
+	rec = chat("an uncached prompt", map[string]string{"semantic_threshold": "1.5"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid threshold to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestHandleChatDeduplication(t *testing.T) {
+	var mu sync.Mutex
+	var upstreamCalls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		upstreamCalls++
+		mu.Unlock()
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"shared"},"done":true,"prompt_eval_count":1000,"eval_count":0}`)
+	}))
+	defer upstream.Close()
+
+	s := newTestServer(t, registry.ModelConfig{
+		ID:       "ollama:llama-test",
+		Provider: "ollama",
+		BaseURL:  upstream.URL,
+		Kind:     "chat",
+		Pricing:  registry.Pricing{Currency: "USD", InputPer1K: 0.5},
+	})
+	var err error
+	s.cacheManager, err = cache.NewCacheManager(cache.DefaultCacheConfig())
+	if err != nil {
+		t.Fatalf("failed to create cache manager: %v", err)
+	}
+	defer s.cacheManager.Close()
+
+	body, _ := json.Marshal(core.ChatRequest{
+		Model:    "ollama:llama-test",
+		Messages: []core.Message{{Role: "user", Content: "Hi"}},
+		Metadata: map[string]string{"cache": "true"},
+	})
+
+	numRequests := 3
+	recs := make([]*httptest.ResponseRecorder, numRequests)
+	var wg sync.WaitGroup
+	for i := 0; i < numRequests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat", bytes.NewReader(body))
+			req.Header.Set("X-Request-ID", fmt.Sprintf("req-%d", i))
+			recs[i] = httptest.NewRecorder()
+			s.router.ServeHTTP(recs[i], req)
+		}(i)
+	}
+	wg.Wait()
+
+	if upstreamCalls != 1 {
+		t.Fatalf("Expected one provider call for identical in-flight requests, got %d", upstreamCalls)
+	}
+
+	var leader string
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d: %s", i, rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("X-Deduplicated-From") == "" {
+			leader = fmt.Sprintf("req-%d", i)
+		}
+	}
+	if leader == "" {
+		t.Fatal("Expected one request to lead")
+	}
+
+	records, err := s.accounting.GetCosts(accounting.CostFilter{})
+	if err != nil {
+		t.Fatalf("GetCosts failed: %v", err)
+	}
+	if len(records) != numRequests {
+		t.Fatalf("Expected %d cost records, got %d", numRequests, len(records))
+	}
+	for _, r := range records {
+		if r.RequestID == leader {
+			if r.CostTotal != 0.5 || r.DeduplicatedFrom != "" {
+				t.Errorf("Expected leader to carry the cost, got %+v", r)
+			}
+			continue
+		}
+		if r.CostTotal != 0 || r.DeduplicatedFrom != leader {
+			t.Errorf("Expected zero-cost record deduplicated from %s, got %+v", leader, r)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/cache", nil))
+	var stats struct {
+		Deduplication struct {
+			TotalRequests     int64   `json:"total_requests"`
+			TotalDeduplicated int64   `json:"total_deduplicated"`
+			DedupRate         float64 `json:"dedup_rate"`
+		} `json:"deduplication"`
+	}
+	json.NewDecoder(rec.Body).Decode(&stats)
+	if stats.Deduplication.TotalRequests != 3 || stats.Deduplication.TotalDeduplicated != 2 {
+		t.Errorf("Expected 3 requests and 2 deduplicated on /v1/cache, got %+v", stats.Deduplication)
+	}
+
+	rec = httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "llm_dedup_collapsed_total 2\n") {
+		t.Error("Expected collapsed request count in metrics")
+	}
+}
+
+func TestHandleChatTokenRateLimits(t *testing.T) {
+	var mu sync.Mutex
+	var upstreamCalls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		upstreamCalls++
+		mu.Unlock()
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"ok"},"done":true,"prompt_eval_count":100,"eval_count":50}`)
+	}))
+	defer upstream.Close()
+
+	reg := &registry.Registry{
+		Models: []registry.ModelConfig{{
+			ID:       "ollama:llama-test",
+			Provider: "ollama",
+			BaseURL:  upstream.URL,
+			Kind:     "chat",
+		}},
+		RateLimits: registry.RateLimitSettings{
+			Callers:   map[string]registry.CallerLimit{"alice": {MaxTPM: 1000}},
+			MaxWaitMS: 50,
+		},
+	}
+	s := newServer("0", testLogger(), reg)
+
+	chat := func(caller string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(core.ChatRequest{
+			Messages:  []core.Message{{Role: "user", Content: "Hi"}},
+			MaxTokens: 500,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat", bytes.NewReader(body))
+		req.Header.Set("X-Caller", caller)
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Each request reserves about 505 tokens but uses 150; without the refund
+	// the second request would already exceed the 1000 TPM limit
+	for i := 0; i < 4; i++ {
+		if rec := chat("alice"); rec.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d: %s", i, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := chat("alice")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 once the caller's tokens are used up, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var errResp core.ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&errResp)
+	if errResp.Code != "RATE_LIMITED" {
+		t.Errorf("Expected RATE_LIMITED, got %q", errResp.Code)
+	}
+	if retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retryAfter <= 0 {
+		t.Errorf("Expected a Retry-After header, got %q", rec.Header().Get("Retry-After"))
+	}
+	if upstreamCalls != 4 {
+		t.Errorf("Expected the rejected request not to reach the provider, got %d calls", upstreamCalls)
+	}
+
+	// Other callers are not affected
+	if rec := chat("bob"); rec.Code != http.StatusOK {
+		t.Errorf("Expected another caller to be served, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
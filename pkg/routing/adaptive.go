@@ -0,0 +1,265 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/snow-ghost/agent/pkg/limiter"
+	"github.com/snow-ghost/agent/pkg/registry"
+	"github.com/sony/gobreaker"
+)
+
+const (
+	// defaultEWMAAlpha weights the newest observation in latency and error averages
+	defaultEWMAAlpha = 0.2
+
+	// Score weights for health, latency and cost; they sum to 1
+	healthWeight  = 0.5
+	latencyWeight = 0.3
+	costWeight    = 0.2
+
+	// halfOpenPenalty scales the score of models whose circuit is probing
+	halfOpenPenalty = 0.5
+)
+
+// ModelScore explains how the adaptive strategy rated a model
+type ModelScore struct {
+	ModelID      string   `json:"model_id"`
+	Score        float64  `json:"score"`
+	Eligible     bool     `json:"eligible"`
+	LatencyMS    float64  `json:"latency_ms"`
+	ErrorRate    float64  `json:"error_rate"`
+	Requests     int64    `json:"requests"`
+	CircuitState string   `json:"circuit_state"`
+	CostPer1K    float64  `json:"cost_per_1k"`
+	Reasons      []string `json:"reasons,omitempty"`
+}
+
+// modelStats holds exponentially weighted averages for one model
+type modelStats struct {
+	latencyMS float64
+	errorRate float64
+	requests  int64
+}
+
+// AdaptiveStrategy selects the model with the best observed latency, error
+// rate and circuit state, subject to the request's max_latency_ms and
+// max_cost_per_1k metadata. Only chat models are candidates, narrowed to
+// those tagged for the request's task_domain when any are. Models without
+// observations are scored optimistically so they get traffic and build up
+// history.
+type AdaptiveStrategy struct {
+	alpha    float64
+	circuits *limiter.CircuitBreakerManager
+	stats    map[string]*modelStats
+	mu       sync.RWMutex
+}
+
+// NewAdaptiveStrategy creates a new adaptive strategy. circuits may be nil, in
+// which case every circuit is treated as closed.
+func NewAdaptiveStrategy(circuits *limiter.CircuitBreakerManager) *AdaptiveStrategy {
+	return &AdaptiveStrategy{
+		alpha:    defaultEWMAAlpha,
+		circuits: circuits,
+		stats:    make(map[string]*modelStats),
+	}
+}
+
+// SetCircuitBreakers sets the circuit breakers consulted for model state
+func (a *AdaptiveStrategy) SetCircuitBreakers(circuits *limiter.CircuitBreakerManager) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.circuits = circuits
+}
+
+// Observe records the outcome of a request served by a model
+func (a *AdaptiveStrategy) Observe(modelID string, latency time.Duration, success bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	failure := 0.0
+	if !success {
+		failure = 1.0
+	}
+	latencyMS := float64(latency) / float64(time.Millisecond)
+
+	stats, exists := a.stats[modelID]
+	if !exists {
+		a.stats[modelID] = &modelStats{latencyMS: latencyMS, errorRate: failure, requests: 1}
+		return
+	}
+
+	// Failed calls often return early, so they only move the error rate
+	if success {
+		stats.latencyMS = a.alpha*latencyMS + (1-a.alpha)*stats.latencyMS
+	}
+	stats.errorRate = a.alpha*failure + (1-a.alpha)*stats.errorRate
+	stats.requests++
+}
+
+// SelectModel selects the highest scoring eligible model. If no model meets
+// the request's constraints, the highest scoring model with a usable circuit
+// is returned instead.
+func (a *AdaptiveStrategy) SelectModel(ctx context.Context, models []registry.ModelConfig, metadata map[string]string) (*registry.ModelConfig, error) {
+	if len(models) == 0 {
+		return nil, fmt.Errorf("no models available")
+	}
+
+	models = chatCandidates(models, metadata["task_domain"])
+	if len(models) == 0 {
+		return nil, fmt.Errorf("no chat models available")
+	}
+
+	scores := a.Scores(models, metadata)
+
+	best, relaxed := -1, -1
+	for i, score := range scores {
+		if score.CircuitState == gobreaker.StateOpen.String() {
+			continue
+		}
+		if relaxed == -1 || score.Score > scores[relaxed].Score {
+			relaxed = i
+		}
+		if score.Eligible && (best == -1 || score.Score > scores[best].Score) {
+			best = i
+		}
+	}
+
+	if best == -1 {
+		best = relaxed
+	}
+	if best == -1 {
+		return nil, fmt.Errorf("no models available: all circuits are open")
+	}
+
+	return &models[best], nil
+}
+
+// Scores rates every model for a request, in the order given
+func (a *AdaptiveStrategy) Scores(models []registry.ModelConfig, metadata map[string]string) []ModelScore {
+	maxLatency, _ := strconv.ParseFloat(metadata["max_latency_ms"], 64)
+	maxCost, _ := strconv.ParseFloat(metadata["max_cost_per_1k"], 64)
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	scores := make([]ModelScore, len(models))
+	for i, model := range models {
+		scores[i] = a.score(model, maxLatency, maxCost)
+	}
+	return scores
+}
+
+// score rates a single model. Callers must hold the read lock.
+func (a *AdaptiveStrategy) score(model registry.ModelConfig, maxLatency, maxCost float64) ModelScore {
+	score := ModelScore{
+		ModelID:      model.ID,
+		Eligible:     true,
+		CircuitState: gobreaker.StateClosed.String(),
+		CostPer1K:    modelCostPer1K(model),
+	}
+
+	if stats, exists := a.stats[model.ID]; exists {
+		score.LatencyMS = stats.latencyMS
+		score.ErrorRate = stats.errorRate
+		score.Requests = stats.requests
+	}
+
+	if a.circuits != nil {
+		score.CircuitState = a.circuits.GetState(model.ID, model).String()
+	}
+
+	if !chatCapable(model) {
+		score.Eligible = false
+		score.Reasons = append(score.Reasons, fmt.Sprintf("%s model cannot serve chat", model.Kind))
+		return score
+	}
+
+	health := 1 - score.ErrorRate
+
+	// Unobserved models get the benefit of the doubt
+	latency := 1.0
+	if score.Requests > 0 {
+		reference := maxLatency
+		if reference <= 0 {
+			reference = 1000
+		}
+		latency = reference / (reference + score.LatencyMS)
+		if maxLatency > 0 && score.LatencyMS > maxLatency {
+			score.Eligible = false
+			score.Reasons = append(score.Reasons, fmt.Sprintf("latency %.0fms exceeds max_latency_ms %.0f", score.LatencyMS, maxLatency))
+		}
+	}
+
+	cost := 1.0
+	if score.CostPer1K > 0 {
+		reference := maxCost
+		if reference <= 0 {
+			reference = 0.01
+		}
+		cost = reference / (reference + score.CostPer1K)
+	}
+	if maxCost > 0 && score.CostPer1K > maxCost {
+		score.Eligible = false
+		score.Reasons = append(score.Reasons, fmt.Sprintf("cost %.5f per 1k exceeds max_cost_per_1k %.5f", score.CostPer1K, maxCost))
+	}
+
+	score.Score = healthWeight*health + latencyWeight*latency + costWeight*cost
+
+	switch score.CircuitState {
+	case gobreaker.StateOpen.String():
+		score.Score = 0
+		score.Eligible = false
+		score.Reasons = append(score.Reasons, "circuit open")
+	case gobreaker.StateHalfOpen.String():
+		score.Score *= halfOpenPenalty
+		score.Reasons = append(score.Reasons, "circuit half-open")
+	}
+
+	score.Score = math.Round(score.Score*1e4) / 1e4
+	return score
+}
+
+// RankedScores returns the scores of all models, best first
+func (a *AdaptiveStrategy) RankedScores(models []registry.ModelConfig, metadata map[string]string) []ModelScore {
+	scores := a.Scores(models, metadata)
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+	return scores
+}
+
+// chatCapable reports whether a model serves chat requests. Models without a
+// kind are taken to be chat models.
+func chatCapable(model registry.ModelConfig) bool {
+	return model.Kind == "" || model.Kind == "chat"
+}
+
+// chatCandidates returns the chat models, narrowed to those matching domain
+// when it is set and any match
+func chatCandidates(models []registry.ModelConfig, domain string) []registry.ModelConfig {
+	var chat, tagged []registry.ModelConfig
+	for _, model := range models {
+		if !chatCapable(model) {
+			continue
+		}
+		chat = append(chat, model)
+		if domain != "" && modelMatchesDomain(model, domain) {
+			tagged = append(tagged, model)
+		}
+	}
+	if len(tagged) > 0 {
+		return tagged
+	}
+	return chat
+}
+
+// modelCostPer1K returns the blended price of 1K tokens, averaging input and output
+func modelCostPer1K(model registry.ModelConfig) float64 {
+	return (model.Pricing.InputPer1K + model.Pricing.OutputPer1K) / 2
+}
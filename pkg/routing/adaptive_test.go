@@ -0,0 +1,166 @@
+package routing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snow-ghost/agent/pkg/limiter"
+	"github.com/snow-ghost/agent/pkg/registry"
+)
+
+func TestAdaptiveStrategyPrefersHealthyFastModels(t *testing.T) {
+	models := []registry.ModelConfig{
+		{ID: "slow", Provider: "test", Kind: "chat"},
+		{ID: "flaky", Provider: "test", Kind: "chat"},
+		{ID: "fast", Provider: "test", Kind: "chat"},
+	}
+
+	strategy := NewAdaptiveStrategy(nil)
+	for i := 0; i < 10; i++ {
+		strategy.Observe("slow", 2*time.Second, true)
+		strategy.Observe("flaky", 100*time.Millisecond, i%2 == 0)
+		strategy.Observe("fast", 100*time.Millisecond, true)
+	}
+
+	selected, err := strategy.SelectModel(context.Background(), models, map[string]string{})
+	if err != nil {
+		t.Fatalf("SelectModel failed: %v", err)
+	}
+	if selected.ID != "fast" {
+		t.Errorf("Expected fast, got %s", selected.ID)
+	}
+
+	scores := strategy.Scores(models, map[string]string{})
+	if scores[1].ErrorRate == 0 {
+		t.Error("Expected flaky model to have a non-zero error rate")
+	}
+	if scores[0].LatencyMS < 1000 {
+		t.Errorf("Expected slow model latency to be tracked, got %.0fms", scores[0].LatencyMS)
+	}
+}
+
+func TestAdaptiveStrategyConstraints(t *testing.T) {
+	models := []registry.ModelConfig{
+		{ID: "cheap-slow", Provider: "test", Kind: "chat", Pricing: registry.Pricing{InputPer1K: 0.0001, OutputPer1K: 0.0001}},
+		{ID: "pricey-fast", Provider: "test", Kind: "chat", Pricing: registry.Pricing{InputPer1K: 0.01, OutputPer1K: 0.03}},
+	}
+
+	strategy := NewAdaptiveStrategy(nil)
+	for i := 0; i < 5; i++ {
+		strategy.Observe("cheap-slow", 800*time.Millisecond, true)
+		strategy.Observe("pricey-fast", 50*time.Millisecond, true)
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		expected string
+	}{
+		{"latency bound", map[string]string{"max_latency_ms": "200"}, "pricey-fast"},
+		{"cost bound", map[string]string{"max_cost_per_1k": "0.001"}, "cheap-slow"},
+		{"unsatisfiable bounds fall back to best score", map[string]string{"max_latency_ms": "10", "max_cost_per_1k": "0.00001"}, "pricey-fast"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := strategy.SelectModel(context.Background(), models, tt.metadata)
+			if err != nil {
+				t.Fatalf("SelectModel failed: %v", err)
+			}
+			if selected.ID != tt.expected {
+				t.Errorf("Expected %s, got %s (scores: %+v)", tt.expected, selected.ID, strategy.Scores(models, tt.metadata))
+			}
+		})
+	}
+
+	scores := strategy.Scores(models, map[string]string{"max_cost_per_1k": "0.001"})
+	if scores[1].Eligible || len(scores[1].Reasons) == 0 {
+		t.Errorf("Expected pricey model to be ineligible with a reason, got %+v", scores[1])
+	}
+}
+
+func TestAdaptiveStrategySkipsOpenCircuits(t *testing.T) {
+	models := []registry.ModelConfig{
+		{ID: "broken", Provider: "test", Kind: "chat"},
+		{ID: "healthy", Provider: "test", Kind: "chat"},
+	}
+
+	circuits := limiter.NewCircuitBreakerManager()
+	for i := 0; i < 5; i++ {
+		circuits.Execute(context.Background(), "broken", models[0], func() (interface{}, error) {
+			return nil, limiter.NewHTTPError(500, "Internal server error", "")
+		})
+	}
+	if !circuits.IsOpen("broken", models[0]) {
+		t.Fatal("Expected circuit to be open")
+	}
+
+	strategy := NewAdaptiveStrategy(circuits)
+	// Give the broken model a better history so only the circuit rules it out
+	strategy.Observe("broken", 10*time.Millisecond, true)
+	strategy.Observe("healthy", 500*time.Millisecond, true)
+
+	selected, err := strategy.SelectModel(context.Background(), models, map[string]string{})
+	if err != nil {
+		t.Fatalf("SelectModel failed: %v", err)
+	}
+	if selected.ID != "healthy" {
+		t.Errorf("Expected healthy, got %s", selected.ID)
+	}
+
+	scores := strategy.Scores(models, map[string]string{})
+	if scores[0].CircuitState != "open" || scores[0].Score != 0 {
+		t.Errorf("Expected open circuit to zero the score, got %+v", scores[0])
+	}
+
+	if _, err := strategy.SelectModel(context.Background(), models[:1], map[string]string{}); err == nil {
+		t.Error("Expected error when every circuit is open")
+	}
+}
+
+func TestAdaptiveStrategyCandidates(t *testing.T) {
+	models := []registry.ModelConfig{
+		{ID: "embedder", Provider: "test", Kind: "embed", Tags: []string{"embed", "code"}},
+		{ID: "general", Provider: "test", Kind: "chat", Tags: []string{"general"}, Pricing: registry.Pricing{InputPer1K: 0.001, OutputPer1K: 0.002}},
+		{ID: "coder", Provider: "test", Kind: "chat", Tags: []string{"code"}, Pricing: registry.Pricing{InputPer1K: 0.003, OutputPer1K: 0.015}},
+	}
+
+	strategy := NewAdaptiveStrategy(nil)
+	for i := 0; i < 5; i++ {
+		strategy.Observe("embedder", 5*time.Millisecond, true)
+		strategy.Observe("general", 300*time.Millisecond, true)
+		strategy.Observe("coder", 300*time.Millisecond, true)
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		expected string
+	}{
+		{"embed models are not chat candidates", map[string]string{}, "general"},
+		{"task domain narrows by tags", map[string]string{"task_domain": "code"}, "coder"},
+		{"unmatched task domain keeps every chat model", map[string]string{"task_domain": "legal"}, "general"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := strategy.SelectModel(context.Background(), models, tt.metadata)
+			if err != nil {
+				t.Fatalf("SelectModel failed: %v", err)
+			}
+			if selected.ID != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, selected.ID)
+			}
+		})
+	}
+
+	scores := strategy.Scores(models, map[string]string{})
+	if scores[0].Eligible || scores[0].Score != 0 || len(scores[0].Reasons) == 0 {
+		t.Errorf("Expected embed model to be ineligible with a reason, got %+v", scores[0])
+	}
+
+	if _, err := strategy.SelectModel(context.Background(), models[:1], map[string]string{}); err == nil {
+		t.Error("Expected error when no chat model is available")
+	}
+}
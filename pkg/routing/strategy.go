@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/snow-ghost/agent/pkg/limiter"
 	"github.com/snow-ghost/agent/pkg/registry"
 )
 
@@ -115,7 +116,7 @@ func (t *TagBasedStrategy) SelectModel(ctx context.Context, models []registry.Mo
 	var candidateModels []registry.ModelConfig
 
 	for _, model := range models {
-		if t.modelMatchesDomain(model, taskDomain) {
+		if modelMatchesDomain(model, taskDomain) {
 			candidateModels = append(candidateModels, model)
 		}
 	}
@@ -130,7 +131,7 @@ func (t *TagBasedStrategy) SelectModel(ctx context.Context, models []registry.Mo
 }
 
 // modelMatchesDomain checks if a model matches the given task domain
-func (t *TagBasedStrategy) modelMatchesDomain(model registry.ModelConfig, domain string) bool {
+func modelMatchesDomain(model registry.ModelConfig, domain string) bool {
 	domain = strings.ToLower(domain)
 
 	// Check if any of the model's tags match the domain
@@ -171,6 +172,7 @@ func containsTag(tags []string, target string) bool {
 type ModelRouter struct {
 	registry        *registry.Registry
 	strategies      map[string]ModelSelector
+	adaptive        *AdaptiveStrategy
 	defaultStrategy string
 	defaultModel    string
 }
@@ -181,6 +183,7 @@ func NewModelRouter(registry *registry.Registry) *ModelRouter {
 	roundRobin := NewRoundRobinStrategy()
 	weighted := NewWeightedStrategy()
 	tagBased := NewTagBasedStrategy(weighted)
+	adaptive := NewAdaptiveStrategy(nil)
 
 	router := &ModelRouter{
 		registry: registry,
@@ -188,7 +191,9 @@ func NewModelRouter(registry *registry.Registry) *ModelRouter {
 			"round-robin": roundRobin,
 			"weighted":    weighted,
 			"tag-based":   tagBased,
+			"adaptive":    adaptive,
 		},
+		adaptive:        adaptive,
 		defaultStrategy: "tag-based",
 		defaultModel:    os.Getenv("DEFAULT_MODEL"),
 	}
@@ -232,6 +237,44 @@ func (r *ModelRouter) SelectModel(ctx context.Context, strategy string, metadata
 	return selectedModel, nil
 }
 
+// FallbackChain returns the primary model followed by its configured
+// fallbacks, in order. Unknown and duplicate IDs are skipped.
+func (r *ModelRouter) FallbackChain(primary registry.ModelConfig) []registry.ModelConfig {
+	chain := []registry.ModelConfig{primary}
+	seen := map[string]bool{primary.ID: true}
+
+	for _, id := range primary.Fallbacks {
+		if seen[id] {
+			continue
+		}
+		fallback := r.registry.FindModel(id)
+		if fallback == nil {
+			continue
+		}
+		seen[id] = true
+		chain = append(chain, *fallback)
+	}
+
+	return chain
+}
+
+// UseCircuitBreakers makes the adaptive strategy consult circuit breaker state
+func (r *ModelRouter) UseCircuitBreakers(circuits *limiter.CircuitBreakerManager) {
+	r.adaptive.SetCircuitBreakers(circuits)
+}
+
+// RecordOutcome feeds the latency and result of a provider call to the
+// adaptive strategy
+func (r *ModelRouter) RecordOutcome(modelID string, latency time.Duration, success bool) {
+	r.adaptive.Observe(modelID, latency, success)
+}
+
+// AdaptiveScores returns the adaptive strategy's current rating of every
+// registry model for the given request metadata, best first
+func (r *ModelRouter) AdaptiveScores(metadata map[string]string) []ModelScore {
+	return r.adaptive.RankedScores(r.registry.Models, metadata)
+}
+
 // GetAvailableStrategies returns the list of available strategies
 func (r *ModelRouter) GetAvailableStrategies() []string {
 	strategies := make([]string, 0, len(r.strategies))
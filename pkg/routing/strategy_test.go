@@ -3,6 +3,7 @@ package routing
 import (
 	"context"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/snow-ghost/agent/pkg/registry"
@@ -139,7 +140,7 @@ func TestModelRouter(t *testing.T) {
 
 	// Test available strategies
 	strategies := router.GetAvailableStrategies()
-	expectedStrategies := []string{"round-robin", "weighted", "tag-based"}
+	expectedStrategies := []string{"round-robin", "weighted", "tag-based", "adaptive"}
 
 	if len(strategies) != len(expectedStrategies) {
 		t.Errorf("Expected %d strategies, got %d", len(expectedStrategies), len(strategies))
@@ -205,3 +206,30 @@ func TestModelRouterEmptyRegistry(t *testing.T) {
 		t.Error("Expected error for empty registry")
 	}
 }
+
+func TestModelRouterFallbackChain(t *testing.T) {
+	registry := &registry.Registry{
+		Models: []registry.ModelConfig{
+			{ID: "primary", Provider: "test", Kind: "chat", Fallbacks: []string{"second", "missing", "primary", "third", "second"}},
+			{ID: "second", Provider: "test", Kind: "chat"},
+			{ID: "third", Provider: "test", Kind: "chat"},
+		},
+	}
+
+	router := NewModelRouter(registry)
+
+	chain := router.FallbackChain(registry.Models[0])
+	var ids []string
+	for _, mc := range chain {
+		ids = append(ids, mc.ID)
+	}
+
+	expected := []string{"primary", "second", "third"}
+	if strings.Join(ids, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected chain %v, got %v", expected, ids)
+	}
+
+	if chain := router.FallbackChain(registry.Models[1]); len(chain) != 1 {
+		t.Errorf("Expected a model without fallbacks to have a chain of 1, got %d", len(chain))
+	}
+}
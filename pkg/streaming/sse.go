@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -16,6 +17,8 @@ import (
 type SSEWriter struct {
 	w       http.ResponseWriter
 	flusher http.Flusher
+	written bool
+	text    strings.Builder // chunks written so far
 }
 
 // NewSSEWriter creates a new SSE writer
@@ -40,6 +43,8 @@ func NewSSEWriter(w http.ResponseWriter) (*SSEWriter, error) {
 
 // WriteEvent writes an SSE event
 func (s *SSEWriter) WriteEvent(event string, data interface{}) error {
+	s.written = true
+
 	// Write event type
 	if event != "" {
 		if _, err := fmt.Fprintf(s.w, "event: %s\n", event); err != nil {
@@ -71,6 +76,11 @@ func (s *SSEWriter) WriteEvent(event string, data interface{}) error {
 	return nil
 }
 
+// HasWritten reports whether any event has been written to the client
+func (s *SSEWriter) HasWritten() bool {
+	return s.written
+}
+
 // WriteError writes an error event
 func (s *SSEWriter) WriteError(err error) error {
 	errorData := map[string]interface{}{
@@ -93,8 +103,14 @@ func (s *SSEWriter) WriteDone(usage core.Usage, cost float64, currency string) e
 	return s.WriteEvent("done", doneData)
 }
 
+// Text returns the text of the chunks written so far
+func (s *SSEWriter) Text() string {
+	return s.text.String()
+}
+
 // WriteChunk writes a text chunk
 func (s *SSEWriter) WriteChunk(text string) error {
+	s.text.WriteString(text)
 	chunkData := map[string]interface{}{
 		"text": text,
 		"type": "chunk",
@@ -241,8 +257,6 @@ func ParseSSEStream(ctx context.Context, reader *bufio.Reader, handler *StreamHa
 
 		// Ignore other lines
 	}
-
-	return nil
 }
 
 // processEvent processes a single SSE event
@@ -268,9 +282,9 @@ func processEvent(eventType, data string, handler *StreamHandler) error {
 			return fmt.Errorf("failed to unmarshal error: %w", err)
 		}
 		if errorMsg, ok := errorData["error"].(string); ok {
-			return handler.HandleError(fmt.Errorf(errorMsg))
+			return handler.HandleError(errors.New(errorMsg))
 		}
-		return handler.HandleError(fmt.Errorf("unknown error"))
+		return handler.HandleError(errors.New("unknown error"))
 
 	default:
 		// Ignore unknown event types
@@ -10,9 +10,10 @@ import (
 )
 
 // Guard is a simple implementation of core.PolicyGuard
-// - Wrap: enforces timeout from Budget (Timeout or CPUMillis), and approximates CPU ticks by wall time
+// - Wrap: enforces the wall-clock timeout from Budget
 // - AllowTool: hostname/name allowlist
-// Note: Memory limits are advisory in this layer; actual enforcement occurs in WASM runtime config.
+// Note: CPU and memory limits are advisory in this layer; the WASM sandbox
+// enforces them with fuel metering and per-execution memory limits.
 type Guard struct {
 	allow map[string]bool
 }
@@ -26,15 +27,11 @@ func NewGuard(allowlist []string) *Guard {
 }
 
 // Wrap applies a timeout based on Budget and runs the function.
-// Order of precedence: Task.Timeout > CPUMillis > default 30s.
+// Order of precedence: Task.Timeout > default 30s. CPUMillis measures CPU,
+// not elapsed time, so it does not shorten the timeout.
 func (g *Guard) Wrap(ctx context.Context, b core.Budget, run func(ctx context.Context) error) error {
-	var timeout time.Duration
-	switch {
-	case b.Timeout > 0:
-		timeout = b.Timeout
-	case b.CPUMillis > 0:
-		timeout = time.Duration(b.CPUMillis) * time.Millisecond
-	default:
+	timeout := b.Timeout
+	if timeout <= 0 {
 		timeout = 30 * time.Second
 	}
 
@@ -0,0 +1,671 @@
+package testkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ParamKind is the type of a check parameter
+type ParamKind int
+
+const (
+	ParamPath   ParamKind = iota // a JSONPath, see ParsePath
+	ParamNumber                  // a float64
+	ParamBool                    // true or false
+	ParamString                  // a bare or JSON-quoted string
+	ParamSchema                  // an inline JSON schema, see ParseSchema
+)
+
+func (k ParamKind) String() string {
+	switch k {
+	case ParamPath:
+		return "path"
+	case ParamNumber:
+		return "number"
+	case ParamBool:
+		return "bool"
+	case ParamString:
+		return "string"
+	case ParamSchema:
+		return "schema"
+	default:
+		return fmt.Sprintf("ParamKind(%d)", int(k))
+	}
+}
+
+// Param declares a parameter of a check. Parameters without a default are
+// required.
+type Param struct {
+	Name    string
+	Kind    ParamKind
+	Default string
+	// Enum, if set, lists the values a string parameter may take
+	Enum []string
+}
+
+// CheckInput is what a check sees of one test case. Input, Output and
+// Oracle are decoded JSON; Oracle is nil when the case has none.
+type CheckInput struct {
+	Input  any
+	Output any
+	Oracle any
+	// Rerun executes the hypothesis on another input and returns its
+	// decoded output
+	Rerun func(input any) (any, error)
+}
+
+// Check is a named property of a hypothesis's output. Fn returns nil when
+// the property holds and otherwise explains why it does not.
+type Check struct {
+	Name   string
+	Doc    string
+	Params []Param
+	// ComparesOracle marks checks that compare the output with the oracle
+	// themselves, so cases using them skip the exact oracle comparison
+	ComparesOracle bool
+	Fn             func(in CheckInput, args Args) error
+}
+
+// Signature describes a check's call syntax, e.g. monotone(path=$.sorted)
+func (c Check) Signature() string {
+	params := make([]string, len(c.Params))
+	for i, p := range c.Params {
+		switch {
+		case p.Default != "":
+			params[i] = p.Name + "=" + p.Default
+		case len(p.Enum) > 0:
+			params[i] = p.Name + ":" + strings.Join(p.Enum, "|")
+		default:
+			params[i] = p.Name + ":" + p.Kind.String()
+		}
+	}
+	return fmt.Sprintf("%s(%s)", c.Name, strings.Join(params, ", "))
+}
+
+// Args are the typed arguments of a bound check
+type Args struct {
+	values map[string]any
+}
+
+// Path returns a path argument
+func (a Args) Path(name string) *Path { return a.values[name].(*Path) }
+
+// Number returns a number argument
+func (a Args) Number(name string) float64 { return a.values[name].(float64) }
+
+// Bool returns a bool argument
+func (a Args) Bool(name string) bool { return a.values[name].(bool) }
+
+// String returns a string argument
+func (a Args) String(name string) string { return a.values[name].(string) }
+
+// Schema returns a schema argument
+func (a Args) Schema(name string) *Schema { return a.values[name].(*Schema) }
+
+// BoundCheck is a check with its arguments, parsed from a TestCase.Checks
+// entry
+type BoundCheck struct {
+	Check
+	Spec string
+	args Args
+}
+
+// Eval runs the check on a test case
+func (b *BoundCheck) Eval(in CheckInput) error {
+	return b.Fn(in, b.args)
+}
+
+// Registry holds checks by name. It is safe for concurrent use.
+type Registry struct {
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{checks: make(map[string]Check)}
+}
+
+// DefaultChecks holds the built-in checks; runners use it unless given
+// another registry
+var DefaultChecks = newBuiltinRegistry()
+
+// Register adds a check to DefaultChecks
+func Register(c Check) error { return DefaultChecks.Register(c) }
+
+// ParseCheck binds a check from DefaultChecks
+func ParseCheck(spec string) (*BoundCheck, error) { return DefaultChecks.Parse(spec) }
+
+// Register adds a check, rejecting duplicate names and invalid defaults
+func (r *Registry) Register(c Check) error {
+	if !checkName.MatchString(c.Name) {
+		return fmt.Errorf("invalid check name %q", c.Name)
+	}
+	if c.Fn == nil {
+		return fmt.Errorf("check %s has no function", c.Name)
+	}
+	seen := map[string]bool{}
+	for _, p := range c.Params {
+		if seen[p.Name] {
+			return fmt.Errorf("check %s declares parameter %s twice", c.Name, p.Name)
+		}
+		seen[p.Name] = true
+		if p.Default == "" {
+			continue
+		}
+		if _, err := p.convert(p.Default); err != nil {
+			return fmt.Errorf("check %s: default of %s: %w", c.Name, p.Name, err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.checks[c.Name]; exists {
+		return fmt.Errorf("check %s is already registered", c.Name)
+	}
+	r.checks[c.Name] = c
+	return nil
+}
+
+// Lookup returns a registered check
+func (r *Registry) Lookup(name string) (Check, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.checks[name]
+	return c, ok
+}
+
+// Checks returns the registered checks sorted by name
+func (r *Registry) Checks() []Check {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	checks := make([]Check, 0, len(r.checks))
+	for _, c := range r.checks {
+		checks = append(checks, c)
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Name < checks[j].Name })
+	return checks
+}
+
+// checkName is the syntax of check and parameter names
+var checkName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// keyedArg matches arguments given as name=value
+var keyedArg = regexp.MustCompile(`^([a-z][a-z0-9_]*)\s*=(.*)$`)
+
+// Parse binds a check from its spec: a name, optionally followed by
+// arguments in parentheses, given in order or as name=value, e.g.
+//
+//	monotone($.sorted, order=desc)
+//	schema_valid(schema={"type": "object", "required": ["sorted"]})
+//
+// Unknown checks and parameters, and arguments of the wrong type, are
+// errors.
+func (r *Registry) Parse(spec string) (*BoundCheck, error) {
+	spec = strings.TrimSpace(spec)
+	name, argList := spec, ""
+	if open := strings.IndexByte(spec, '('); open >= 0 {
+		if !strings.HasSuffix(spec, ")") {
+			return nil, fmt.Errorf("check %q: missing closing parenthesis", spec)
+		}
+		name, argList = strings.TrimSpace(spec[:open]), spec[open+1:len(spec)-1]
+	}
+
+	c, ok := r.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown check %q", name)
+	}
+
+	raw, err := splitArgs(argList)
+	if err != nil {
+		return nil, fmt.Errorf("check %q: %w", spec, err)
+	}
+	given := map[string]string{}
+	keyed := false
+	for i, arg := range raw {
+		var pname, value string
+		if m := keyedArg.FindStringSubmatch(arg); m != nil {
+			pname, value, keyed = m[1], strings.TrimSpace(m[2]), true
+		} else if keyed {
+			return nil, fmt.Errorf("check %q: positional argument %q after a named one", spec, arg)
+		} else if i < len(c.Params) {
+			pname, value = c.Params[i].Name, arg
+		} else {
+			return nil, fmt.Errorf("check %q: too many arguments", spec)
+		}
+		if _, dup := given[pname]; dup {
+			return nil, fmt.Errorf("check %q: %s given twice", spec, pname)
+		}
+		given[pname] = value
+	}
+
+	args := Args{values: make(map[string]any, len(c.Params))}
+	for _, p := range c.Params {
+		value, ok := given[p.Name]
+		delete(given, p.Name)
+		if !ok {
+			if p.Default == "" {
+				return nil, fmt.Errorf("check %q: missing %s", spec, p.Name)
+			}
+			value = p.Default
+		}
+		v, err := p.convert(value)
+		if err != nil {
+			return nil, fmt.Errorf("check %q: %s: %w", spec, p.Name, err)
+		}
+		args.values[p.Name] = v
+	}
+	for pname := range given {
+		return nil, fmt.Errorf("check %q: %s has no parameter %s", spec, name, pname)
+	}
+
+	return &BoundCheck{Check: c, Spec: spec, args: args}, nil
+}
+
+// ParseAll binds every check of a test case
+func (r *Registry) ParseAll(specs []string) ([]*BoundCheck, error) {
+	checks := make([]*BoundCheck, len(specs))
+	for i, spec := range specs {
+		c, err := r.Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		checks[i] = c
+	}
+	return checks, nil
+}
+
+// convert parses an argument according to the parameter's kind
+func (p Param) convert(value string) (any, error) {
+	switch p.Kind {
+	case ParamPath:
+		return ParsePath(value)
+	case ParamNumber:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		return n, nil
+	case ParamBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a bool", value)
+		}
+		return b, nil
+	case ParamString:
+		s := value
+		if strings.HasPrefix(value, `"`) {
+			if err := json.Unmarshal([]byte(value), &s); err != nil {
+				return nil, fmt.Errorf("%s is not a valid string", value)
+			}
+		}
+		if len(p.Enum) > 0 && !slices.Contains(p.Enum, s) {
+			return nil, fmt.Errorf("%q is not one of %s", s, strings.Join(p.Enum, ", "))
+		}
+		return s, nil
+	case ParamSchema:
+		return ParseSchema([]byte(value))
+	default:
+		return nil, fmt.Errorf("unknown parameter kind %v", p.Kind)
+	}
+}
+
+// splitArgs splits an argument list at top-level commas, leaving commas in
+// strings, brackets and braces alone
+func splitArgs(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	var args []string
+	depth, start := 0, 0
+	inString, escaped := false, false
+	for i, r := range list {
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\':
+				escaped = true
+			case r == '"':
+				inString = false
+			}
+		case r == '"':
+			inString = true
+		case r == '[' || r == '{' || r == '(':
+			depth++
+		case r == ']' || r == '}' || r == ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced %q", r)
+			}
+		case r == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+	if inString || depth != 0 {
+		return nil, fmt.Errorf("unterminated argument %q", strings.TrimSpace(list[start:]))
+	}
+	return append(args, strings.TrimSpace(list[start:])), nil
+}
+
+// newBuiltinRegistry registers the checks every runner knows
+func newBuiltinRegistry() *Registry {
+	r := NewRegistry()
+	for _, c := range slices.Concat(builtinChecks, metamorphicChecks) {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+var builtinChecks = []Check{
+	{
+		Name: "length_preserved",
+		Doc:  "the output array or string at out has as many elements as the input's at in",
+		Params: []Param{
+			{Name: "in", Kind: ParamPath, Default: "$.numbers"},
+			{Name: "out", Kind: ParamPath, Default: "$.sorted"},
+		},
+		Fn: checkLengthPreserved,
+	},
+	{
+		Name: "idempotent",
+		Doc:  "running the hypothesis again with its output at out placed at in leaves out unchanged",
+		Params: []Param{
+			{Name: "in", Kind: ParamPath, Default: "$.numbers"},
+			{Name: "out", Kind: ParamPath, Default: "$.sorted"},
+		},
+		Fn: checkIdempotent,
+	},
+	{
+		Name: "monotone",
+		Doc:  "the numbers or strings at path are ordered",
+		Params: []Param{
+			{Name: "path", Kind: ParamPath, Default: "$.sorted"},
+			{Name: "order", Kind: ParamString, Default: "asc", Enum: []string{"asc", "desc"}},
+			{Name: "strict", Kind: ParamBool, Default: "false"},
+		},
+		Fn: checkMonotone,
+	},
+	{
+		Name: "schema_valid",
+		Doc:  "the output at path follows schema",
+		Params: []Param{
+			{Name: "schema", Kind: ParamSchema},
+			{Name: "path", Kind: ParamPath, Default: "$"},
+		},
+		Fn: checkSchemaValid,
+	},
+	{
+		Name: "within_tolerance",
+		Doc:  "the numbers at path are within tol of the oracle's, relative to its magnitude if relative is set; other values must be equal",
+		Params: []Param{
+			{Name: "path", Kind: ParamPath, Default: "$"},
+			{Name: "tol", Kind: ParamNumber, Default: "1e-9"},
+			{Name: "relative", Kind: ParamBool, Default: "false"},
+		},
+		ComparesOracle: true,
+		Fn:             checkWithinTolerance,
+	},
+	{
+		Name: "permutes",
+		Doc:  "the output array at out is a rearrangement of the input's at in",
+		Params: []Param{
+			{Name: "in", Kind: ParamPath, Default: "$.numbers"},
+			{Name: "out", Kind: ParamPath, Default: "$.sorted"},
+		},
+		Fn: checkPermutes,
+	},
+	{
+		Name: "sorted_non_decreasing",
+		Doc:  "the numbers at path never decrease; monotone with its defaults",
+		Params: []Param{
+			{Name: "path", Kind: ParamPath, Default: "$.sorted"},
+		},
+		Fn: func(in CheckInput, args Args) error {
+			return ordered(in.Output, args.Path("path"), "asc", false)
+		},
+	},
+}
+
+func checkLengthPreserved(in CheckInput, args Args) error {
+	want, err := lengthAt(in.Input, args.Path("in"))
+	if err != nil {
+		return fmt.Errorf("input %w", err)
+	}
+	got, err := lengthAt(in.Output, args.Path("out"))
+	if err != nil {
+		return fmt.Errorf("output %w", err)
+	}
+	if got != want {
+		return fmt.Errorf("output %s has length %d, input %s has %d", args.Path("out"), got, args.Path("in"), want)
+	}
+	return nil
+}
+
+// lengthAt measures the array, string or object at a path
+func lengthAt(doc any, path *Path) (int, error) {
+	v, err := path.Get(doc)
+	if err != nil {
+		return 0, err
+	}
+	switch v := v.(type) {
+	case []any:
+		return len(v), nil
+	case string:
+		return utf8.RuneCountInString(v), nil
+	case map[string]any:
+		return len(v), nil
+	default:
+		return 0, fmt.Errorf("%s is a %s, which has no length", path, jsonType(v))
+	}
+}
+
+func checkIdempotent(in CheckInput, args Args) error {
+	first, err := args.Path("out").Get(in.Output)
+	if err != nil {
+		return fmt.Errorf("output %w", err)
+	}
+	input, err := args.Path("in").Set(in.Input, first)
+	if err != nil {
+		return fmt.Errorf("input %w", err)
+	}
+
+	second, err := rerunAt(in, input, args.Path("out"))
+	if err != nil {
+		return err
+	}
+	if !deepEqualJSON(first, second) {
+		return fmt.Errorf("second run changed %s from %s to %s", args.Path("out"), compactJSON(first), compactJSON(second))
+	}
+	return nil
+}
+
+func checkMonotone(in CheckInput, args Args) error {
+	return ordered(in.Output, args.Path("path"), args.String("order"), args.Bool("strict"))
+}
+
+// ordered checks that the numbers or strings at a path are in order
+func ordered(doc any, path *Path, order string, strict bool) error {
+	v, err := path.Get(doc)
+	if err != nil {
+		return fmt.Errorf("output %w", err)
+	}
+	items, ok := v.([]any)
+	if !ok {
+		return fmt.Errorf("output %s is a %s, not an array", path, jsonType(v))
+	}
+
+	for i := 1; i < len(items); i++ {
+		c, err := compareScalars(items[i-1], items[i])
+		if err != nil {
+			return fmt.Errorf("output %s[%d]: %w", path, i, err)
+		}
+		if order == "desc" {
+			c = -c
+		}
+		if c > 0 || (strict && c == 0) {
+			return fmt.Errorf("output %s is not %s at index %d: %s then %s",
+				path, orderName(order, strict), i, compactJSON(items[i-1]), compactJSON(items[i]))
+		}
+	}
+	return nil
+}
+
+func orderName(order string, strict bool) string {
+	switch {
+	case order == "desc" && strict:
+		return "strictly decreasing"
+	case order == "desc":
+		return "non-increasing"
+	case strict:
+		return "strictly increasing"
+	default:
+		return "non-decreasing"
+	}
+}
+
+// compareScalars orders two numbers or two strings
+func compareScalars(a, b any) (int, error) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, nil
+			case a > b:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot order a %s and a %s", jsonType(a), jsonType(b))
+}
+
+func checkSchemaValid(in CheckInput, args Args) error {
+	v, err := args.Path("path").Get(in.Output)
+	if err != nil {
+		return fmt.Errorf("output %w", err)
+	}
+	return args.Schema("schema").Validate(v)
+}
+
+func checkWithinTolerance(in CheckInput, args Args) error {
+	if in.Oracle == nil {
+		return fmt.Errorf("the case has no oracle to compare with")
+	}
+	path := args.Path("path")
+	want, err := path.Get(in.Oracle)
+	if err != nil {
+		return fmt.Errorf("oracle %w", err)
+	}
+	got, err := path.Get(in.Output)
+	if err != nil {
+		return fmt.Errorf("output %w", err)
+	}
+	return withinTolerance(want, got, path.String(), args.Number("tol"), args.Bool("relative"))
+}
+
+func withinTolerance(want, got any, at string, tol float64, relative bool) error {
+	switch w := want.(type) {
+	case float64:
+		g, ok := got.(float64)
+		if !ok {
+			return fmt.Errorf("%s: want a number, got a %s", at, jsonType(got))
+		}
+		limit := tol
+		if relative {
+			limit = tol * math.Abs(w)
+		}
+		if math.Abs(g-w) > limit {
+			return fmt.Errorf("%s: %g differs from %g by more than %g", at, g, w, limit)
+		}
+		return nil
+	case []any:
+		g, ok := got.([]any)
+		if !ok || len(g) != len(w) {
+			return fmt.Errorf("%s: want %d elements, got %s", at, len(w), compactJSON(got))
+		}
+		for i := range w {
+			if err := withinTolerance(w[i], g[i], fmt.Sprintf("%s[%d]", at, i), tol, relative); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok || len(g) != len(w) {
+			return fmt.Errorf("%s: want %s, got %s", at, compactJSON(want), compactJSON(got))
+		}
+		for _, k := range sortedKeys(w) {
+			gv, ok := g[k]
+			if !ok {
+				return fmt.Errorf("%s: missing field %q", at, k)
+			}
+			if err := withinTolerance(w[k], gv, at+"."+k, tol, relative); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		if !deepEqualJSON(want, got) {
+			return fmt.Errorf("%s: want %s, got %s", at, compactJSON(want), compactJSON(got))
+		}
+		return nil
+	}
+}
+
+func checkPermutes(in CheckInput, args Args) error {
+	inPath, outPath := args.Path("in"), args.Path("out")
+	want, err := inPath.Get(in.Input)
+	if err != nil {
+		return fmt.Errorf("input %w", err)
+	}
+	got, err := outPath.Get(in.Output)
+	if err != nil {
+		return fmt.Errorf("output %w", err)
+	}
+	w, ok1 := want.([]any)
+	g, ok2 := got.([]any)
+	if !ok1 || !ok2 {
+		return fmt.Errorf("input %s and output %s must both be arrays", inPath, outPath)
+	}
+	if len(w) != len(g) {
+		return fmt.Errorf("output %s has %d elements, input %s has %d", outPath, len(g), inPath, len(w))
+	}
+
+	count := map[string]int{}
+	for _, v := range w {
+		count[compactKey(v)]++
+	}
+	for _, v := range g {
+		key := compactKey(v)
+		if count[key] == 0 {
+			return fmt.Errorf("output %s has %s more often than the input", outPath, compactJSON(v))
+		}
+		count[key]--
+	}
+	return nil
+}
+
+// compactKey identifies a decoded value for counting
+func compactKey(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
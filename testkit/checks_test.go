@@ -0,0 +1,222 @@
+package testkit
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	require.NoError(t, json.Unmarshal([]byte(s), &v))
+	return v
+}
+
+func TestPath(t *testing.T) {
+	doc := decode(t, `{"a": {"b": [10, 20, 30]}, "c d": true}`)
+
+	cases := []struct {
+		expr string
+		want any
+	}{
+		{"$", doc},
+		{"$.a.b[1]", 20.0},
+		{"$.a.b[-1]", 30.0},
+		{"$['c d']", true},
+		{`$.a["b"][0]`, 10.0},
+		{"$.a.b[*]", []any{10.0, 20.0, 30.0}},
+		{"$.*", []any{decode(t, `{"b": [10, 20, 30]}`), true}},
+	}
+	for _, tc := range cases {
+		got, err := MustParsePath(tc.expr).Get(doc)
+		require.NoError(t, err, tc.expr)
+		assert.Equal(t, tc.want, got, tc.expr)
+	}
+
+	_, err := MustParsePath("$.a.x").Get(doc)
+	assert.ErrorContains(t, err, `$.a.x: no field "x"`)
+	_, err = MustParsePath("$.a.b[3]").Get(doc)
+	assert.ErrorContains(t, err, "index 3 is out of range")
+	for _, bad := range []string{"a.b", "$.", "$[x]", "$[0"} {
+		_, err := ParsePath(bad)
+		assert.Error(t, err, bad)
+	}
+
+	// Set copies along the path and leaves the original alone
+	set, err := MustParsePath("$.a.b[0]").Set(doc, 99.0)
+	require.NoError(t, err)
+	assert.Equal(t, 99.0, decodePath(t, set, "$.a.b[0]"))
+	assert.Equal(t, 10.0, decodePath(t, doc, "$.a.b[0]"))
+	_, err = MustParsePath("$.a.b[*]").Set(doc, 1.0)
+	assert.Error(t, err)
+}
+
+func decodePath(t *testing.T, doc any, expr string) any {
+	t.Helper()
+	v, err := MustParsePath(expr).Get(doc)
+	require.NoError(t, err)
+	return v
+}
+
+func TestSchema(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{
+	  "type": "object",
+	  "required": ["sorted"],
+	  "additionalProperties": false,
+	  "properties": {
+	    "sorted": {"type": "array", "maxItems": 3, "items": {"type": "integer", "minimum": 0}},
+	    "order": {"enum": ["asc", "desc"]}
+	  }
+	}`))
+	require.NoError(t, err)
+
+	assert.NoError(t, schema.Validate(decode(t, `{"sorted": [1, 2], "order": "asc"}`)))
+	for doc, want := range map[string]string{
+		`[]`:                            "$: want object, got array",
+		`{}`:                            `$: missing required field "sorted"`,
+		`{"sorted": [1.5]}`:             "$.sorted[0]: want integer, got number",
+		`{"sorted": [-1]}`:              "$.sorted[0]: -1 is below the minimum 0",
+		`{"sorted": [1, 2, 3, 4]}`:      "$.sorted: 4 items, want at most 3",
+		`{"sorted": [], "order": "up"}`: `$.order: "up" is not one of the allowed values`,
+		`{"sorted": [], "extra": 1}`:    `$: unexpected field "extra"`,
+	} {
+		assert.EqualError(t, schema.Validate(decode(t, doc)), want, doc)
+	}
+
+	_, err = ParseSchema([]byte(`{"type": "list"}`))
+	assert.ErrorContains(t, err, `unknown type "list"`)
+}
+
+func TestRegistry_Parse(t *testing.T) {
+	c, err := ParseCheck("monotone")
+	require.NoError(t, err)
+	assert.Equal(t, "$.sorted", c.args.Path("path").String())
+	assert.Equal(t, "asc", c.args.String("order"))
+
+	c, err = ParseCheck(`monotone($.out[*], order="desc", strict=true)`)
+	require.NoError(t, err)
+	assert.Equal(t, "$.out[*]", c.args.Path("path").String())
+	assert.Equal(t, "desc", c.args.String("order"))
+	assert.True(t, c.args.Bool("strict"))
+
+	c, err = ParseCheck(`schema_valid(schema={"type": "object", "required": ["a", "b"]}, path=$)`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, c.args.Schema("schema").Required)
+
+	for spec, want := range map[string]string{
+		"is_fast":                         `unknown check "is_fast"`,
+		"monotone(order=up)":              `"up" is not one of asc, desc`,
+		"monotone(strict=maybe)":          `"maybe" is not a bool`,
+		"within_tolerance(tol=small)":     `"small" is not a number`,
+		"monotone(sorted)":                "must start with $",
+		"monotone(depth=2)":               "monotone has no parameter depth",
+		"monotone(order=asc, $.x)":        "positional argument",
+		"monotone($.a, $.b, asc, 1, 2)":   "too many arguments",
+		"monotone($.a, path=$.b)":         "path given twice",
+		"schema_valid":                    "missing schema",
+		"schema_valid(schema={\"type\":)": "unterminated argument",
+		"monotone($.a":                    "missing closing parenthesis",
+	} {
+		_, err := ParseCheck(spec)
+		assert.ErrorContains(t, err, want, spec)
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry()
+	always := func(CheckInput, Args) error { return nil }
+
+	require.NoError(t, r.Register(Check{Name: "always", Fn: always}))
+	assert.ErrorContains(t, r.Register(Check{Name: "always", Fn: always}), "already registered")
+	assert.ErrorContains(t, r.Register(Check{Name: "Bad Name", Fn: always}), "invalid check name")
+	assert.ErrorContains(t, r.Register(Check{
+		Name:   "limited",
+		Params: []Param{{Name: "n", Kind: ParamNumber, Default: "lots"}},
+		Fn:     always,
+	}), "default of n")
+
+	_, err := r.Parse("monotone")
+	assert.ErrorContains(t, err, "unknown check", "registries are independent")
+}
+
+func TestBuiltinChecks(t *testing.T) {
+	echo := func(input any) (any, error) {
+		return map[string]any{"sorted": decodePath(t, input, "$.numbers")}, nil
+	}
+	sortNumbers := func(input any) (any, error) {
+		numbers := slices.Clone(decodePath(t, input, "$.numbers").([]any))
+		slices.SortFunc(numbers, func(a, b any) int { return cmp.Compare(a.(float64), b.(float64)) })
+		return map[string]any{"sorted": numbers}, nil
+	}
+	reverse := func(s string) string {
+		runes := []rune(s)
+		slices.Reverse(runes)
+		return string(runes)
+	}
+
+	cases := []struct {
+		spec   string
+		input  string
+		output string
+		oracle string
+		rerun  func(any) (any, error)
+		want   string // error substring, empty if the check holds
+	}{
+		{"length_preserved", `{"numbers": [3, 1]}`, `{"sorted": [1, 3]}`, "", nil, ""},
+		{"length_preserved", `{"numbers": [3, 1]}`, `{"sorted": [1]}`, "", nil, "has length 1, input $.numbers has 2"},
+		{"length_preserved($.text, $.reversed)", `{"text": "héllo"}`, `{"reversed": "olléh"}`, "", nil, ""},
+		{"idempotent", `{"numbers": [3, 1]}`, `{"sorted": [1, 3]}`, "", echo, ""},
+		{"idempotent", `{"numbers": [3, 1]}`, `{"sorted": [3, 1]}`, "", func(any) (any, error) {
+			return decode(t, `{"sorted": [1, 3]}`), nil
+		}, "second run changed $.sorted from [3,1] to [1,3]"},
+		{"idempotent", `{"numbers": []}`, `{"sorted": []}`, "", func(any) (any, error) {
+			return nil, errors.New("trap")
+		}, "second run failed: trap"},
+		{"monotone", `{}`, `{"sorted": [1, 1, 2]}`, "", nil, ""},
+		{"monotone(strict=true)", `{}`, `{"sorted": [1, 1, 2]}`, "", nil, "not strictly increasing at index 1"},
+		{"monotone($.words, order=desc)", `{}`, `{"words": ["c", "b", "a"]}`, "", nil, ""},
+		{"monotone", `{}`, `{"sorted": [1, "a"]}`, "", nil, "cannot order a number and a string"},
+		{`schema_valid(schema={"type": "array"}, path=$.sorted)`, `{}`, `{"sorted": []}`, "", nil, ""},
+		{`schema_valid(schema={"required": ["count"]})`, `{}`, `{"sorted": []}`, "", nil, `missing required field "count"`},
+		{"within_tolerance(tol=0.01)", `{}`, `{"pi": 3.141, "name": "pi"}`, `{"pi": 3.14159, "name": "pi"}`, nil, ""},
+		{"within_tolerance($.pi, tol=0.0001)", `{}`, `{"pi": 3.141}`, `{"pi": 3.14159}`, nil, "$.pi: 3.141 differs from 3.14159"},
+		{"within_tolerance(tol=0.1, relative=true)", `{}`, `[100, 1000]`, `[95, 1050]`, nil, ""},
+		{"within_tolerance", `{}`, `{"x": 1}`, "", nil, "no oracle"},
+		{"permutes", `{"numbers": [2, 1, 1]}`, `{"sorted": [1, 1, 2]}`, "", nil, ""},
+		{"permutes", `{"numbers": [2, 1, 1]}`, `{"sorted": [1, 2, 2]}`, "", nil, "has 2 more often than the input"},
+		{"sorted_non_decreasing", `{}`, `{"sorted": [2, 1]}`, "", nil, "not non-decreasing at index 1: 2 then 1"},
+		{"shuffle_invariant", `{"numbers": [3, 1, 2]}`, `{"sorted": [1, 2, 3]}`, "", sortNumbers, ""},
+		{"shuffle_invariant", `{"numbers": [3, 1, 2]}`, `{"sorted": [3, 1, 2]}`, "", echo, "changed $.sorted from [3,1,2] to"},
+		{"shuffle_invariant($.text)", `{"text": "abc"}`, `{"sorted": []}`, "", echo, "input $.text is a string, not an array"},
+		{"involution", `{"text": "abc"}`, `{"reversed": "cba"}`, "", func(input any) (any, error) {
+			return map[string]any{"reversed": reverse(decodePath(t, input, "$.text").(string))}, nil
+		}, ""},
+		{"involution", `{"text": "abc"}`, `{"reversed": "cb"}`, "", func(any) (any, error) {
+			return decode(t, `{"reversed": "b"}`), nil
+		}, `running on the output "cb" gave "b", not the input "abc"`},
+		{"involution", `{"text": "abc"}`, `{"reversed": "cba"}`, "", nil, "cannot be run again"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.spec, func(t *testing.T) {
+			check, err := ParseCheck(tc.spec)
+			require.NoError(t, err)
+
+			in := CheckInput{Input: decode(t, tc.input), Output: decode(t, tc.output), Rerun: tc.rerun}
+			if tc.oracle != "" {
+				in.Oracle = decode(t, tc.oracle)
+			}
+			err = check.Eval(in)
+			if tc.want == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package testkit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"slices"
+
+	"github.com/snow-ghost/agent/core"
+)
+
+// DifferentialConfig controls a comparison with a reference skill
+type DifferentialConfig struct {
+	// Path selects the part of each output that is compared; "$", the
+	// default, compares whole outputs
+	Path string
+	// Runs is how many inputs are generated from the task's input schema,
+	// if it has one, besides the inputs of the test cases
+	Runs int
+	// MaxSize is the size of the last inputs generated
+	MaxSize int
+	// Seed seeds input generation
+	Seed int64
+}
+
+// withDefaults fills zero fields
+func (c DifferentialConfig) withDefaults() DifferentialConfig {
+	if c.Path == "" {
+		c.Path = "$"
+	}
+	if c.Runs <= 0 {
+		c.Runs = DefaultPropertyRuns
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = DefaultPropertySize
+	}
+	if c.Seed == 0 {
+		c.Seed = DefaultPropertySeed
+	}
+	return c
+}
+
+// Disagreement is an input on which a hypothesis and the reference skill
+// give different outputs
+type Disagreement struct {
+	// Case is the input as a test case that fails unless the hypothesis
+	// agrees with the reference
+	Case      core.TestCase
+	Output    json.RawMessage // the hypothesis's output, nil if it failed
+	Reference json.RawMessage // the reference skill's output
+	Reason    string
+}
+
+// Differential compares hypotheses with a trusted skill for the same
+// domain, such as one from the knowledge base. It implements
+// core.ReferenceTester, so evolution can add the inputs they disagree on to
+// a task's tests.
+type Differential struct {
+	reference core.Skill
+	path      *Path
+	config    DifferentialConfig
+}
+
+// NewDifferential creates a comparison with reference. It panics if
+// config.Path is not a valid JSONPath.
+func NewDifferential(reference core.Skill, config DifferentialConfig) *Differential {
+	config = config.withDefaults()
+	return &Differential{reference: reference, path: MustParsePath(config.Path), config: config}
+}
+
+// Compare runs the hypothesis and the reference on the inputs of cases, of
+// the reference's own tests, and of inputs generated from the task's input
+// schema, and returns the inputs they disagree on. Inputs the reference
+// cannot handle are skipped. Metrics are differential_inputs,
+// differential_agreed, differential_disagreed and differential_skipped.
+func (d *Differential) Compare(ctx context.Context, h core.Hypothesis, task core.Task, cases []core.TestCase, exec core.Interpreter) ([]Disagreement, map[string]float64, error) {
+	metrics := map[string]float64{
+		"differential_inputs":    0,
+		"differential_agreed":    0,
+		"differential_disagreed": 0,
+		"differential_skipped":   0,
+	}
+	if domain := d.reference.Domain(); domain != task.Domain {
+		return nil, metrics, fmt.Errorf("reference %s is for domain %s, not %s", d.reference.Name(), domain, task.Domain)
+	}
+
+	inputs, err := d.inputs(task, cases)
+	if err != nil {
+		return nil, metrics, err
+	}
+
+	scope, release := evaluationScope(task, exec)
+	defer release()
+
+	var disagreements []Disagreement
+	for _, input := range inputs {
+		if err := ctx.Err(); err != nil {
+			return disagreements, metrics, err
+		}
+		metrics["differential_inputs"]++
+
+		run := task
+		run.ID = scope + "/differential"
+		run.Input = input
+
+		want, err := d.reference.Execute(ctx, run)
+		if err != nil || !want.Success {
+			metrics["differential_skipped"]++
+			continue
+		}
+		got, err := exec.Execute(ctx, h, run)
+		if err != nil && ctx.Err() != nil {
+			return disagreements, metrics, ctx.Err()
+		}
+
+		reason := ""
+		if err != nil {
+			reason = "hypothesis failed: " + err.Error()
+		} else if cmp := d.compare(want.Output, got.Output); cmp != nil {
+			reason = cmp.Error()
+		}
+		if reason == "" {
+			metrics["differential_agreed"]++
+			continue
+		}
+		metrics["differential_disagreed"]++
+		disagreements = append(disagreements, Disagreement{
+			Case:      d.testCase(input, want.Output),
+			Output:    got.Output,
+			Reference: want.Output,
+			Reason:    reason,
+		})
+	}
+	return disagreements, metrics, nil
+}
+
+// FindDisagreement implements core.ReferenceTester. The first disagreement
+// Compare finds is returned as a counterexample whose case has the
+// reference's output as oracle.
+func (d *Differential) FindDisagreement(ctx context.Context, h core.Hypothesis, task core.Task, cases []core.TestCase, exec core.Interpreter) (*core.Counterexample, map[string]float64, error) {
+	disagreements, metrics, err := d.Compare(ctx, h, task, cases, exec)
+	if len(disagreements) == 0 {
+		return nil, metrics, err
+	}
+	first := disagreements[0]
+	return &core.Counterexample{
+		Case:   first.Case,
+		Output: first.Output,
+		Reason: fmt.Sprintf("differs from reference %s: %s", d.reference.Name(), first.Reason),
+	}, metrics, err
+}
+
+// inputs collects distinct inputs to compare on
+func (d *Differential) inputs(task core.Task, cases []core.TestCase) ([]json.RawMessage, error) {
+	var inputs []json.RawMessage
+	seen := map[string]bool{}
+	add := func(input any) {
+		data, err := json.Marshal(input)
+		if err != nil || seen[string(data)] {
+			return
+		}
+		seen[string(data)] = true
+		inputs = append(inputs, data)
+	}
+
+	for _, tc := range slices.Concat(cases, d.reference.Tests()) {
+		var input any
+		if err := json.Unmarshal(tc.Input, &input); err != nil {
+			return nil, fmt.Errorf("test case %s: input is not JSON: %w", tc.Name, err)
+		}
+		add(input)
+	}
+
+	if len(task.Spec.InputSchema) > 0 {
+		schema, err := ParseSchema(task.Spec.InputSchema)
+		if err != nil {
+			return nil, fmt.Errorf("input schema: %w", err)
+		}
+		rng := rand.New(rand.NewSource(d.config.Seed))
+		for run := 0; run < d.config.Runs; run++ {
+			add(schema.Generate(rng, 1+run*d.config.MaxSize/d.config.Runs))
+		}
+	}
+	return inputs, nil
+}
+
+// compare reports how the hypothesis's output differs from the reference's
+// at the configured path
+func (d *Differential) compare(want, got json.RawMessage) error {
+	var w, g any
+	if err := json.Unmarshal(want, &w); err != nil {
+		return fmt.Errorf("reference output is not JSON: %w", err)
+	}
+	if err := json.Unmarshal(got, &g); err != nil {
+		return fmt.Errorf("output is not JSON: %w", err)
+	}
+	wv, err := d.path.Get(w)
+	if err != nil {
+		return fmt.Errorf("reference output %w", err)
+	}
+	gv, err := d.path.Get(g)
+	if err != nil {
+		return fmt.Errorf("output %w", err)
+	}
+	return withinTolerance(wv, gv, d.path.String(), 0, false)
+}
+
+// testCase turns a disagreement's input into a test case with the
+// reference's output as oracle. When only part of the output is compared,
+// within_tolerance compares that part instead of the whole.
+func (d *Differential) testCase(input, reference json.RawMessage) core.TestCase {
+	tc := core.TestCase{
+		Name:   "differential:" + d.reference.Name(),
+		Input:  input,
+		Oracle: reference,
+		Weight: 1.0,
+	}
+	if d.config.Path != "$" {
+		tc.Checks = []string{fmt.Sprintf("within_tolerance(%s, tol=0)", d.path)}
+	}
+	return tc
+}
@@ -0,0 +1,92 @@
+package testkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/kb/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDifferential_Compare(t *testing.T) {
+	ctx := context.Background()
+	diff := NewDifferential(&memory.SortSkill{}, DifferentialConfig{Path: "$.sorted", Runs: 20})
+	task := sortTask()
+	task.Domain = "algorithms"
+	cases := []core.TestCase{
+		{Name: "dupes", Input: []byte(`{"numbers": [2, 1, 2]}`)},
+		{Name: "not_numbers", Input: []byte(`{"words": ["b", "a"]}`)},
+	}
+
+	// The reference adds a count, which is not compared
+	found, metrics, err := diff.Compare(ctx, core.Hypothesis{}, task, cases, funcInterp(sorted))
+	require.NoError(t, err)
+	assert.Empty(t, found)
+	assert.Equal(t, 1.0, metrics["differential_skipped"], "the reference cannot sort words")
+	assert.Equal(t, metrics["differential_inputs"]-1, metrics["differential_agreed"])
+
+	found, metrics, err = diff.Compare(ctx, core.Hypothesis{}, task, cases, funcInterp(dedupe))
+	require.NoError(t, err)
+	require.NotEmpty(t, found)
+	assert.Equal(t, float64(len(found)), metrics["differential_disagreed"])
+
+	first := found[0]
+	assert.JSONEq(t, `{"numbers": [2, 1, 2]}`, string(first.Case.Input))
+	assert.JSONEq(t, `{"sorted": [1, 2]}`, string(first.Output))
+	assert.JSONEq(t, `{"sorted": [1, 2, 2], "count": 3}`, string(first.Reference))
+	assert.Equal(t, "$.sorted: want 3 elements, got [1,2]", first.Reason)
+	assert.Equal(t, "differential:algorithms/sort.v1", first.Case.Name)
+
+	// Disagreements fail as test cases until the hypothesis agrees
+	runner := NewRunner()
+	_, pass, err := runner.Run(ctx, core.Hypothesis{}, []core.TestCase{first.Case}, funcInterp(dedupe))
+	require.NoError(t, err)
+	assert.False(t, pass)
+	_, pass, err = runner.Run(ctx, core.Hypothesis{}, []core.TestCase{first.Case}, funcInterp(sorted))
+	require.NoError(t, err)
+	assert.True(t, pass)
+}
+
+func TestDifferential_FindDisagreement(t *testing.T) {
+	ctx := context.Background()
+	diff := NewDifferential(&memory.SortSkill{}, DifferentialConfig{Path: "$.sorted"})
+	task := core.Task{Domain: "algorithms"}
+	cases := []core.TestCase{{Name: "dupes", Input: []byte(`{"numbers": [2, 1, 2]}`)}}
+
+	found, metrics, err := diff.FindDisagreement(ctx, core.Hypothesis{}, task, cases, funcInterp(sorted))
+	require.NoError(t, err)
+	assert.Nil(t, found)
+	assert.Zero(t, metrics["differential_disagreed"])
+
+	found, _, err = diff.FindDisagreement(ctx, core.Hypothesis{}, task, cases, funcInterp(dedupe))
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.JSONEq(t, `{"numbers": [2, 1, 2]}`, string(found.Case.Input))
+	assert.Equal(t, "differs from reference algorithms/sort.v1: $.sorted: want 3 elements, got [1,2]", found.Reason)
+}
+
+func TestDifferential_Errors(t *testing.T) {
+	ctx := context.Background()
+	diff := NewDifferential(&memory.SortSkill{}, DifferentialConfig{})
+	cases := []core.TestCase{{Name: "one", Input: []byte(`{"numbers": [1]}`)}}
+
+	_, _, err := diff.Compare(ctx, core.Hypothesis{}, core.Task{Domain: "text"}, cases, funcInterp(sorted))
+	assert.ErrorContains(t, err, "reference algorithms/sort.v1 is for domain algorithms, not text")
+
+	// Crashes disagree with any answer, and the whole output is compared
+	crashy := funcInterp(func([]float64) ([]float64, error) { return nil, errors.New("trap") })
+	found, _, err := diff.Compare(ctx, core.Hypothesis{}, core.Task{Domain: "algorithms"}, cases, crashy)
+	require.NoError(t, err)
+	require.Len(t, found, 2, "the case and the reference's own test")
+	assert.Equal(t, "hypothesis failed: trap", found[0].Reason)
+	assert.Nil(t, found[0].Output)
+	assert.Empty(t, found[0].Case.Checks)
+
+	var oracle map[string]any
+	require.NoError(t, json.Unmarshal(found[0].Case.Oracle, &oracle))
+	assert.Equal(t, 1.0, oracle["count"])
+}
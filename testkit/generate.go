@@ -0,0 +1,263 @@
+package testkit
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+)
+
+// defaultRange bounds numbers whose schema sets no minimum or maximum, at
+// the given size
+func defaultRange(size int) float64 { return float64(10 * size) }
+
+// Generate returns a random value that follows the schema. Size bounds how
+// large it gets: arrays and strings have at most size elements beyond their
+// minimum, and unbounded numbers lie within ±10·size. Optional object fields
+// are included half of the time.
+func (s *Schema) Generate(rng *rand.Rand, size int) any {
+	if len(s.Enum) > 0 {
+		return s.Enum[rng.Intn(len(s.Enum))]
+	}
+
+	switch s.typeOf() {
+	case "object":
+		obj := make(map[string]any, len(s.Properties))
+		for _, name := range sortedSchemaKeys(s.Properties) {
+			if s.isRequired(name) || rng.Intn(2) == 0 {
+				obj[name] = s.Properties[name].Generate(rng, size)
+			}
+		}
+		return obj
+	case "array":
+		lo, hi := s.lengthBounds(s.MinItems, s.MaxItems, size)
+		n := lo + rng.Intn(hi-lo+1)
+		items := s.Items
+		if items == nil {
+			items = &Schema{Type: "integer"}
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			arr[i] = items.Generate(rng, size)
+		}
+		return arr
+	case "string":
+		lo, hi := s.lengthBounds(s.MinLength, s.MaxLength, size)
+		b := make([]byte, lo+rng.Intn(hi-lo+1))
+		for i := range b {
+			b[i] = byte('a' + rng.Intn(26))
+		}
+		return string(b)
+	case "integer":
+		lo, hi := s.numberBounds(size)
+		lo, hi = math.Ceil(lo), math.Floor(hi)
+		if hi < lo {
+			return lo
+		}
+		return lo + float64(rng.Int63n(int64(hi-lo)+1))
+	case "number":
+		lo, hi := s.numberBounds(size)
+		// Two decimal places keep values readable in counterexamples
+		v := math.Round((lo+rng.Float64()*(hi-lo))*100) / 100
+		return math.Min(math.Max(v, lo), hi)
+	case "boolean":
+		return rng.Intn(2) == 0
+	default:
+		return nil
+	}
+}
+
+// Shrink returns values smaller than v that still follow the schema,
+// simplest first. Arrays and strings lose elements, numbers move towards
+// zero, optional fields are dropped, and every element and field is
+// shrunk in turn, equal array elements also together.
+func (s *Schema) Shrink(v any) []any {
+	var candidates []any
+	add := func(c any) {
+		if s.Validate(c) == nil && !deepEqualJSON(c, v) {
+			candidates = append(candidates, c)
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		for _, e := range s.Enum {
+			if deepEqualJSON(e, v) {
+				break
+			}
+			add(e)
+		}
+		return candidates
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range sortedKeys(v) {
+			if !s.isRequired(name) {
+				add(without(v, name))
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			field := s.Properties[name]
+			if field == nil {
+				continue
+			}
+			for _, c := range field.Shrink(v[name]) {
+				add(with(v, name, c))
+			}
+		}
+	case []any:
+		n := len(v)
+		if n > 0 {
+			add([]any{})
+		}
+		if n > 1 {
+			add(append([]any(nil), v[:n/2]...))
+			add(append([]any(nil), v[n/2:]...))
+		}
+		for i := range v {
+			add(append(append([]any(nil), v[:i]...), v[i+1:]...))
+		}
+		items := s.Items
+		if items == nil {
+			items = &Schema{Type: "integer"}
+		}
+		// Equal elements are first shrunk together, since failures often
+		// depend on them staying equal
+		done := map[string]bool{}
+		for i := range v {
+			key := compactKey(v[i])
+			if done[key] {
+				continue
+			}
+			done[key] = true
+			for _, c := range items.Shrink(v[i]) {
+				all := make([]any, n)
+				for j := range v {
+					all[j] = v[j]
+					if compactKey(v[j]) == key {
+						all[j] = c
+					}
+				}
+				add(all)
+			}
+		}
+		for i := range v {
+			for _, c := range items.Shrink(v[i]) {
+				add(append(append(append([]any(nil), v[:i]...), c), v[i+1:]...))
+			}
+		}
+	case string:
+		runes := []rune(v)
+		n := len(runes)
+		if n > 0 {
+			add("")
+		}
+		if n > 1 {
+			add(string(runes[:n/2]))
+			add(string(runes[n/2:]))
+		}
+		for i := range runes {
+			add(string(runes[:i]) + string(runes[i+1:]))
+		}
+		for i, r := range runes {
+			if r != 'a' {
+				add(string(runes[:i]) + "a" + string(runes[i+1:]))
+			}
+		}
+	case float64:
+		target := 0.0
+		if s.Minimum != nil && target < *s.Minimum {
+			target = *s.Minimum
+		}
+		if s.Maximum != nil && target > *s.Maximum {
+			target = *s.Maximum
+		}
+		add(target)
+		add(target + math.Trunc((v-target)/2))
+		if v != math.Trunc(v) {
+			add(math.Trunc(v))
+		}
+		if v > target {
+			add(v - 1)
+		} else if v < target {
+			add(v + 1)
+		}
+	case bool:
+		if v {
+			add(false)
+		}
+	}
+	return candidates
+}
+
+// typeOf is the schema's type, inferred from its keywords when unset
+func (s *Schema) typeOf() string {
+	switch {
+	case s.Type != "":
+		return s.Type
+	case s.Properties != nil:
+		return "object"
+	case s.Items != nil || s.MinItems != nil || s.MaxItems != nil:
+		return "array"
+	case s.MinLength != nil || s.MaxLength != nil:
+		return "string"
+	default:
+		return "integer"
+	}
+}
+
+func (s *Schema) isRequired(name string) bool {
+	return slices.Contains(s.Required, name)
+}
+
+// lengthBounds are the lengths an array or string may have at a size
+func (s *Schema) lengthBounds(minLen, maxLen *int, size int) (int, int) {
+	lo := 0
+	if minLen != nil {
+		lo = *minLen
+	}
+	hi := lo + size
+	if maxLen != nil && *maxLen < hi {
+		hi = *maxLen
+	}
+	return lo, max(lo, hi)
+}
+
+// numberBounds is the range numbers are drawn from at a size
+func (s *Schema) numberBounds(size int) (float64, float64) {
+	lo, hi := -defaultRange(size), defaultRange(size)
+	switch {
+	case s.Minimum != nil && s.Maximum != nil:
+		return *s.Minimum, *s.Maximum
+	case s.Minimum != nil:
+		return *s.Minimum, *s.Minimum + 2*defaultRange(size)
+	case s.Maximum != nil:
+		return *s.Maximum - 2*defaultRange(size), *s.Maximum
+	}
+	return lo, hi
+}
+
+func sortedSchemaKeys(m map[string]*Schema) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func without(m map[string]any, name string) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		if k != name {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func with(m map[string]any, name string, value any) map[string]any {
+	out := without(m, name)
+	out[name] = value
+	return out
+}
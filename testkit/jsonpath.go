@@ -0,0 +1,237 @@
+package testkit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a compiled JSONPath expression. The supported subset is the root
+// $, fields as .name or ['name'], array indexes as [n] (negative counts from
+// the end) and wildcards as .* or [*].
+type Path struct {
+	expr  string
+	steps []pathStep
+}
+
+// pathStep selects a field, an index, or with wildcard every child
+type pathStep struct {
+	field    string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// ParsePath compiles a JSONPath expression
+func ParsePath(expr string) (*Path, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("path %q must start with $", expr)
+	}
+
+	p := &Path{expr: expr}
+	rest := expr[1:]
+	for rest != "" {
+		var step pathStep
+		switch {
+		case strings.HasPrefix(rest, ".*"):
+			step.wildcard = true
+			rest = rest[2:]
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			step.field = rest[1 : end+1]
+			if step.field == "" {
+				return nil, fmt.Errorf("path %q has an empty field name", expr)
+			}
+			rest = rest[end+1:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q has an unclosed [", expr)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			switch {
+			case inner == "*":
+				step.wildcard = true
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				step.field = inner[1 : len(inner)-1]
+			default:
+				n, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("path %q: %q is not an index, quoted field or *", expr, inner)
+				}
+				step.index, step.isIndex = n, true
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("path %q: unexpected %q", expr, rest[0])
+		}
+		p.steps = append(p.steps, step)
+	}
+	return p, nil
+}
+
+// MustParsePath is like ParsePath but panics on error
+func MustParsePath(expr string) *Path {
+	p, err := ParsePath(expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the expression the path was compiled from
+func (p *Path) String() string { return p.expr }
+
+// Get selects the value at the path in a decoded JSON document. Paths with a
+// wildcard select a []any of every match.
+func (p *Path) Get(doc any) (any, error) {
+	values := []any{doc}
+	wildcard := false
+	for _, step := range p.steps {
+		var next []any
+		for _, v := range values {
+			children, err := step.apply(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", p.expr, err)
+			}
+			next = append(next, children...)
+		}
+		values = next
+		wildcard = wildcard || step.wildcard
+	}
+
+	if wildcard {
+		if values == nil {
+			values = []any{}
+		}
+		return values, nil
+	}
+	return values[0], nil
+}
+
+// Set returns a copy of doc with the value at the path replaced. Only the
+// objects and arrays along the path are copied. Paths with a wildcard cannot
+// be set.
+func (p *Path) Set(doc, value any) (any, error) {
+	for _, step := range p.steps {
+		if step.wildcard {
+			return nil, fmt.Errorf("%s: cannot set a wildcard path", p.expr)
+		}
+	}
+	out, err := set(doc, p.steps, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", p.expr, err)
+	}
+	return out, nil
+}
+
+func set(doc any, steps []pathStep, value any) (any, error) {
+	if len(steps) == 0 {
+		return value, nil
+	}
+	step := steps[0]
+
+	switch v := doc.(type) {
+	case map[string]any:
+		if step.isIndex {
+			return nil, fmt.Errorf("cannot index an object with [%d]", step.index)
+		}
+		child, err := set(v[step.field], steps[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		out := make(map[string]any, len(v)+1)
+		for k, x := range v {
+			out[k] = x
+		}
+		out[step.field] = child
+		return out, nil
+	case []any:
+		i, err := step.resolve(len(v))
+		if err != nil {
+			return nil, err
+		}
+		child, err := set(v[i], steps[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		out := append([]any(nil), v...)
+		out[i] = child
+		return out, nil
+	case nil:
+		if step.isIndex {
+			return nil, fmt.Errorf("index %d is out of range", step.index)
+		}
+		child, err := set(nil, steps[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{step.field: child}, nil
+	default:
+		return nil, fmt.Errorf("cannot select %s in a %s", step, jsonType(doc))
+	}
+}
+
+// apply selects the step's children of v
+func (s pathStep) apply(v any) ([]any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		if s.wildcard {
+			keys := sortedKeys(v)
+			out := make([]any, len(keys))
+			for i, k := range keys {
+				out[i] = v[k]
+			}
+			return out, nil
+		}
+		if s.isIndex {
+			return nil, fmt.Errorf("cannot index an object with [%d]", s.index)
+		}
+		child, ok := v[s.field]
+		if !ok {
+			return nil, fmt.Errorf("no field %q", s.field)
+		}
+		return []any{child}, nil
+	case []any:
+		if s.wildcard {
+			return v, nil
+		}
+		i, err := s.resolve(len(v))
+		if err != nil {
+			return nil, err
+		}
+		return []any{v[i]}, nil
+	default:
+		return nil, fmt.Errorf("cannot select %s in a %s", s, jsonType(v))
+	}
+}
+
+// resolve turns the step's index into a position in an array of length n
+func (s pathStep) resolve(n int) (int, error) {
+	if !s.isIndex {
+		return 0, fmt.Errorf("cannot select field %q in an array", s.field)
+	}
+	i := s.index
+	if i < 0 {
+		i += n
+	}
+	if i < 0 || i >= n {
+		return 0, fmt.Errorf("index %d is out of range for %d elements", s.index, n)
+	}
+	return i, nil
+}
+
+func (s pathStep) String() string {
+	switch {
+	case s.wildcard:
+		return "[*]"
+	case s.isIndex:
+		return fmt.Sprintf("[%d]", s.index)
+	default:
+		return fmt.Sprintf("field %q", s.field)
+	}
+}
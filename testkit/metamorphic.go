@@ -0,0 +1,117 @@
+package testkit
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// metamorphicChecks relate the output of a test case to the output of a
+// second run on an input derived from it, so they hold without an oracle
+var metamorphicChecks = []Check{
+	{
+		Name: "shuffle_invariant",
+		Doc:  "running the hypothesis again with the array at in shuffled leaves out unchanged",
+		Params: []Param{
+			{Name: "in", Kind: ParamPath, Default: "$.numbers"},
+			{Name: "out", Kind: ParamPath, Default: "$.sorted"},
+		},
+		Fn: checkShuffleInvariant,
+	},
+	{
+		Name: "involution",
+		Doc:  "running the hypothesis again with its output at out placed at in gives back the input at in",
+		Params: []Param{
+			{Name: "in", Kind: ParamPath, Default: "$.text"},
+			{Name: "out", Kind: ParamPath, Default: "$.reversed"},
+		},
+		Fn: checkInvolution,
+	},
+}
+
+func checkShuffleInvariant(in CheckInput, args Args) error {
+	inPath, outPath := args.Path("in"), args.Path("out")
+	v, err := inPath.Get(in.Input)
+	if err != nil {
+		return fmt.Errorf("input %w", err)
+	}
+	items, ok := v.([]any)
+	if !ok {
+		return fmt.Errorf("input %s is a %s, not an array", inPath, jsonType(v))
+	}
+	first, err := outPath.Get(in.Output)
+	if err != nil {
+		return fmt.Errorf("output %w", err)
+	}
+
+	shuffled := shuffle(items)
+	input, err := inPath.Set(in.Input, shuffled)
+	if err != nil {
+		return fmt.Errorf("input %w", err)
+	}
+	second, err := rerunAt(in, input, outPath)
+	if err != nil {
+		return err
+	}
+	if !deepEqualJSON(first, second) {
+		return fmt.Errorf("shuffling %s to %s changed %s from %s to %s",
+			inPath, compactJSON(shuffled), outPath, compactJSON(first), compactJSON(second))
+	}
+	return nil
+}
+
+// shuffle returns the items in another order. The order depends only on
+// the number of items, so that failures can be reproduced.
+func shuffle(items []any) []any {
+	out := make([]any, len(items))
+	perm := rand.New(rand.NewSource(int64(len(items)))).Perm(len(items))
+	for i, j := range perm {
+		out[i] = items[j]
+	}
+	// Make sure the order changes
+	if len(out) > 1 && deepEqualJSON(out, items) {
+		out[0], out[1] = out[1], out[0]
+	}
+	return out
+}
+
+func checkInvolution(in CheckInput, args Args) error {
+	inPath, outPath := args.Path("in"), args.Path("out")
+	original, err := inPath.Get(in.Input)
+	if err != nil {
+		return fmt.Errorf("input %w", err)
+	}
+	first, err := outPath.Get(in.Output)
+	if err != nil {
+		return fmt.Errorf("output %w", err)
+	}
+
+	input, err := inPath.Set(in.Input, first)
+	if err != nil {
+		return fmt.Errorf("input %w", err)
+	}
+	second, err := rerunAt(in, input, outPath)
+	if err != nil {
+		return err
+	}
+	if !deepEqualJSON(original, second) {
+		return fmt.Errorf("running on the output %s gave %s, not the input %s",
+			compactJSON(first), compactJSON(second), compactJSON(original))
+	}
+	return nil
+}
+
+// rerunAt runs the hypothesis again and returns its output at a path
+func rerunAt(in CheckInput, input any, out *Path) (any, error) {
+	if in.Rerun == nil {
+		return nil, fmt.Errorf("the hypothesis cannot be run again")
+	}
+	output, err := in.Rerun(input)
+	if err != nil {
+		return nil, fmt.Errorf("second run failed: %w", err)
+	}
+	v, err := out.Get(output)
+	if err != nil {
+		return nil, fmt.Errorf("second output %w", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,225 @@
+package testkit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+
+	"github.com/snow-ghost/agent/core"
+)
+
+// Defaults for PropertyConfig fields left at zero
+const (
+	DefaultPropertyRuns  = 100
+	DefaultPropertySize  = 20
+	DefaultMaxShrinkRuns = 200
+	DefaultPropertySeed  = 1
+)
+
+// PropertyConfig controls the search for counterexamples
+type PropertyConfig struct {
+	// Runs is how many random inputs are tried
+	Runs int
+	// MaxSize is the size of the last inputs tried; sizes grow from 1 so
+	// that small counterexamples are found first
+	MaxSize int
+	// MaxShrinkRuns caps the executions spent shrinking a counterexample
+	MaxShrinkRuns int
+	// Seed seeds input generation. Every search starts from it, so the same
+	// hypothesis always meets the same inputs.
+	Seed int64
+}
+
+// withDefaults fills zero fields
+func (c PropertyConfig) withDefaults() PropertyConfig {
+	if c.Runs <= 0 {
+		c.Runs = DefaultPropertyRuns
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = DefaultPropertySize
+	}
+	if c.MaxShrinkRuns <= 0 {
+		c.MaxShrinkRuns = DefaultMaxShrinkRuns
+	}
+	if c.Seed == 0 {
+		c.Seed = DefaultPropertySeed
+	}
+	return c
+}
+
+// PropertyTester implements core.PropertyTester. It runs a hypothesis on
+// inputs generated from the task's Spec.InputSchema, applies the checks in
+// Spec.Properties to each output, and shrinks the first input that fails.
+type PropertyTester struct {
+	config PropertyConfig
+	checks *Registry
+}
+
+// NewPropertyTester creates a property tester using DefaultChecks
+func NewPropertyTester(config PropertyConfig) *PropertyTester {
+	return &PropertyTester{config: config.withDefaults(), checks: DefaultChecks}
+}
+
+// SetChecks sets the registry properties are looked up in
+func (p *PropertyTester) SetChecks(checks *Registry) {
+	p.checks = checks
+}
+
+// FindCounterexample implements core.PropertyTester. Tasks without an input
+// schema or properties have nothing to test. Properties that are not
+// registered, or that need an oracle, are errors. Metrics are property_runs,
+// property_failures, property_shrink_runs and counterexample_shrinks.
+func (p *PropertyTester) FindCounterexample(ctx context.Context, h core.Hypothesis, task core.Task, exec core.Interpreter) (*core.Counterexample, map[string]float64, error) {
+	metrics := map[string]float64{
+		"property_runs":          0,
+		"property_failures":      0,
+		"property_shrink_runs":   0,
+		"counterexample_shrinks": 0,
+	}
+	if len(task.Spec.InputSchema) == 0 || len(task.Spec.Properties) == 0 {
+		return nil, metrics, nil
+	}
+
+	schema, err := ParseSchema(task.Spec.InputSchema)
+	if err != nil {
+		return nil, metrics, fmt.Errorf("input schema: %w", err)
+	}
+	checks, err := p.checks.ParseAll(task.Spec.Properties)
+	if err != nil {
+		return nil, metrics, err
+	}
+	for _, c := range checks {
+		if c.ComparesOracle {
+			return nil, metrics, fmt.Errorf("property %s needs an oracle, which generated inputs do not have", c.Spec)
+		}
+	}
+
+	scope, release := evaluationScope(task, exec)
+	defer release()
+
+	t := propertyTrial{ctx: ctx, h: h, task: task, scope: scope, exec: exec, checks: checks}
+	rng := rand.New(rand.NewSource(p.config.Seed))
+
+	var failure *trialFailure
+	var input any
+	for run := 0; run < p.config.Runs && failure == nil; run++ {
+		if err := ctx.Err(); err != nil {
+			return nil, metrics, err
+		}
+		size := 1 + run*p.config.MaxSize/p.config.Runs
+		input = schema.Generate(rng, size)
+		metrics["property_runs"]++
+		failure = t.try(input)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, metrics, err
+	}
+	if failure == nil {
+		return nil, metrics, nil
+	}
+	metrics["property_failures"] = 1
+
+	// Shrink greedily: take the first smaller input that still fails, until
+	// none does or the budget is spent
+	shrinks, runs := 0, 0
+	for shrunk := true; shrunk && runs < p.config.MaxShrinkRuns; {
+		shrunk = false
+		for _, candidate := range schema.Shrink(input) {
+			if runs >= p.config.MaxShrinkRuns || ctx.Err() != nil {
+				break
+			}
+			runs++
+			if f := t.try(candidate); f != nil {
+				input, failure, shrunk = candidate, f, true
+				shrinks++
+				break
+			}
+		}
+	}
+	metrics["property_shrink_runs"] = float64(runs)
+	metrics["counterexample_shrinks"] = float64(shrinks)
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, metrics, err
+	}
+	return &core.Counterexample{
+		Case: core.TestCase{
+			Name:   "counterexample:" + failure.property,
+			Input:  data,
+			Checks: task.Spec.Properties,
+			Weight: 1.0,
+		},
+		Output:  failure.output,
+		Reason:  failure.reason,
+		Shrinks: shrinks,
+	}, metrics, nil
+}
+
+// propertyTrial runs a hypothesis on inputs and checks its outputs
+type propertyTrial struct {
+	ctx    context.Context
+	h      core.Hypothesis
+	task   core.Task
+	scope  string // the task ID executions run under
+	exec   core.Interpreter
+	checks []*BoundCheck
+}
+
+// trialFailure is how an input broke a property
+type trialFailure struct {
+	property string // the failing check, or "error" if the execution failed
+	reason   string
+	output   json.RawMessage
+}
+
+// try runs one input, returning nil if every property holds
+func (t propertyTrial) try(input any) *trialFailure {
+	run := func(input any) (json.RawMessage, any, error) {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return nil, nil, err
+		}
+		task := t.task
+		task.ID = t.scope + "/property"
+		task.Input = data
+		res, err := t.exec.Execute(t.ctx, t.h, task)
+		if err != nil {
+			return nil, nil, err
+		}
+		var out any
+		if err := json.Unmarshal(res.Output, &out); err != nil {
+			return res.Output, nil, fmt.Errorf("output is not JSON: %w", err)
+		}
+		return res.Output, out, nil
+	}
+
+	raw, output, err := run(input)
+	if err != nil && t.ctx.Err() != nil {
+		// Cancelled, which says nothing about the input
+		return nil
+	}
+	if err != nil {
+		return &trialFailure{property: "error", reason: err.Error(), output: raw}
+	}
+
+	in := CheckInput{
+		Input:  input,
+		Output: output,
+		Rerun: func(input any) (any, error) {
+			_, out, err := run(input)
+			return out, err
+		},
+	}
+	for _, c := range t.checks {
+		if err := c.Eval(in); err != nil {
+			return &trialFailure{
+				property: c.Name,
+				reason:   fmt.Sprintf("%s: %v", c.Spec, err),
+				output:   raw,
+			}
+		}
+	}
+	return nil
+}
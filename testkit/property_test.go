@@ -0,0 +1,154 @@
+package testkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sortSchema = `{
+  "type": "object",
+  "required": ["numbers"],
+  "properties": {
+    "numbers": {"type": "array", "items": {"type": "integer", "minimum": -50, "maximum": 50}},
+    "label": {"type": "string", "maxLength": 4}
+  }
+}`
+
+// funcInterp runs a Go function over a task's numbers and answers with
+// {"sorted": ...}
+type funcInterp func(numbers []float64) ([]float64, error)
+
+func (f funcInterp) Execute(ctx context.Context, h core.Hypothesis, task core.Task) (core.Result, error) {
+	var in struct{ Numbers []float64 }
+	if err := json.Unmarshal(task.Input, &in); err != nil {
+		return core.Result{}, err
+	}
+	out, err := f(in.Numbers)
+	if err != nil {
+		return core.Result{}, err
+	}
+	data, _ := json.Marshal(map[string]any{"sorted": out})
+	return core.Result{Success: true, Output: data}, nil
+}
+
+func sorted(numbers []float64) ([]float64, error) {
+	out := append([]float64{}, numbers...)
+	sort.Float64s(out)
+	return out, nil
+}
+
+// dedupe sorts but drops repeated numbers
+func dedupe(numbers []float64) ([]float64, error) {
+	out, _ := sorted(numbers)
+	unique := []float64{}
+	for i, n := range out {
+		if i == 0 || n != out[i-1] {
+			unique = append(unique, n)
+		}
+	}
+	return unique, nil
+}
+
+func sortTask() core.Task {
+	return core.Task{
+		ID: "sort",
+		Spec: core.Spec{
+			InputSchema: json.RawMessage(sortSchema),
+			Properties:  []string{"monotone", "length_preserved"},
+		},
+	}
+}
+
+func TestSchema_GenerateAndShrink(t *testing.T) {
+	schema, err := ParseSchema([]byte(sortSchema))
+	require.NoError(t, err)
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		v := schema.Generate(rng, 1+i/10)
+		require.NoError(t, schema.Validate(v), "generated %s", compactJSON(v))
+
+		for _, c := range schema.Shrink(v) {
+			require.NoError(t, schema.Validate(c), "shrunk %s to %s", compactJSON(v), compactJSON(c))
+			assert.NotEqual(t, compactKey(v), compactKey(c))
+		}
+	}
+
+	// Numbers shrink towards zero, or the nearest bound
+	bounded := &Schema{Type: "integer", Minimum: ptr(3.0)}
+	assert.Equal(t, []any{3.0, 6.0, 9.0}, bounded.Shrink(10.0))
+	assert.Empty(t, bounded.Shrink(3.0))
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestPropertyTester_FindsAndShrinks(t *testing.T) {
+	tester := NewPropertyTester(PropertyConfig{Seed: 7})
+
+	found, metrics, err := tester.FindCounterexample(context.Background(), core.Hypothesis{ID: "dedupe"}, sortTask(), funcInterp(dedupe))
+	require.NoError(t, err)
+	require.NotNil(t, found)
+
+	// The smallest input with a repeated number
+	assert.JSONEq(t, `{"numbers": [0, 0]}`, string(found.Case.Input))
+	assert.JSONEq(t, `{"sorted": [0]}`, string(found.Output))
+	assert.Equal(t, "counterexample:length_preserved", found.Case.Name)
+	assert.Equal(t, []string{"monotone", "length_preserved"}, found.Case.Checks)
+	assert.Contains(t, found.Reason, "length_preserved: output $.sorted has length 1, input $.numbers has 2")
+	assert.Greater(t, found.Shrinks, 0)
+	assert.Equal(t, 1.0, metrics["property_failures"])
+	assert.Equal(t, float64(found.Shrinks), metrics["counterexample_shrinks"])
+
+	// The counterexample fails as a test case too
+	_, pass, err := NewRunner().Run(context.Background(), core.Hypothesis{ID: "dedupe"}, []core.TestCase{found.Case}, funcInterp(dedupe))
+	require.NoError(t, err)
+	assert.False(t, pass)
+}
+
+func TestPropertyTester_Errors(t *testing.T) {
+	tester := NewPropertyTester(PropertyConfig{Runs: 50})
+	ctx := context.Background()
+
+	// A correct sort has no counterexample
+	found, metrics, err := tester.FindCounterexample(ctx, core.Hypothesis{}, sortTask(), funcInterp(sorted))
+	require.NoError(t, err)
+	assert.Nil(t, found)
+	assert.Equal(t, 50.0, metrics["property_runs"])
+
+	// Crashes are counterexamples
+	crashy := funcInterp(func(numbers []float64) ([]float64, error) {
+		if len(numbers) > 2 {
+			return nil, errors.New("trap")
+		}
+		return sorted(numbers)
+	})
+	found, _, err = tester.FindCounterexample(ctx, core.Hypothesis{}, sortTask(), crashy)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.JSONEq(t, `{"numbers": [0, 0, 0]}`, string(found.Case.Input))
+	assert.Equal(t, "trap", found.Reason)
+
+	// Tasks without a schema have nothing to test
+	task := sortTask()
+	task.Spec.InputSchema = nil
+	found, _, err = tester.FindCounterexample(ctx, core.Hypothesis{}, task, funcInterp(dedupe))
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+
+	task = sortTask()
+	task.Spec.Properties = []string{"is_fast"}
+	_, _, err = tester.FindCounterexample(ctx, core.Hypothesis{}, task, funcInterp(sorted))
+	assert.ErrorContains(t, err, `unknown check "is_fast"`)
+
+	task.Spec.Properties = []string{"within_tolerance"}
+	_, _, err = tester.FindCounterexample(ctx, core.Hypothesis{}, task, funcInterp(sorted))
+	assert.ErrorContains(t, err, "needs an oracle")
+}
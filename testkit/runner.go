@@ -3,6 +3,13 @@ package testkit
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/snow-ghost/agent/core"
@@ -31,149 +38,300 @@ func GenerateSortCasesFixed() []core.TestCase {
 	return cases
 }
 
-// Runner implements core.TestRunner
-type Runner struct{}
+// Runner implements core.TaskTestRunner. Test cases run concurrently on up to
+// workers goroutines; metrics are aggregated in case order.
+type Runner struct {
+	workers int
+	checks  *Registry
+}
 
-func NewRunner() *Runner { return &Runner{} }
+// NewRunner creates a runner with one worker per CPU
+func NewRunner() *Runner { return NewRunnerWithWorkers(runtime.GOMAXPROCS(0)) }
 
-// Run executes each test case via the provided interpreter and aggregates metrics.
-func (r *Runner) Run(ctx context.Context, h core.Hypothesis, cases []core.TestCase, exec core.Interpreter) (map[string]float64, bool, error) {
-	metrics := map[string]float64{
-		"cases_total":       0,
-		"cases_passed":      0,
-		"cases_failed":      0,
-		"duration_ms_total": 0,
+// NewRunnerWithWorkers creates a runner that executes up to workers test
+// cases at once
+func NewRunnerWithWorkers(workers int) *Runner {
+	if workers < 1 {
+		workers = 1
 	}
+	return &Runner{workers: workers, checks: DefaultChecks}
+}
 
-	allPassed := true
+// SetChecks sets the registry test case checks are looked up in
+func (r *Runner) SetChecks(checks *Registry) {
+	r.checks = checks
+}
+
+// Failure classes of a test case, reported as cases_<class>
+const (
+	failureTimeout = "timeout" // the execution ran out of time or CPU budget
+	failureTrap    = "trap"    // the execution failed
+	failureWrong   = "wrong"   // the output was wrong
+)
 
-	for _, tc := range cases {
-		start := time.Now()
+// latencyPercentiles are reported as p<n>_latency_ms
+var latencyPercentiles = []int{50, 90, 95, 99}
+
+// caseResult is the outcome of one test case
+type caseResult struct {
+	passed  bool
+	failure string // why the case failed, one of the failure classes
+	durMs   float64
+	fuel    float64 // instructions metered by the interpreter, if it reports them
+	peak    float64 // peak memory in bytes, if reported
+	checks  []bool  // whether each of the case's checks passed
+}
 
-		task := core.Task{
-			ID:     "case:" + tc.Name,
-			Domain: "algorithms",
-			Spec:   core.Spec{SuccessCriteria: tc.Checks},
-			Input:  json.RawMessage(tc.Input),
+// Run executes each test case via the provided interpreter and aggregates
+// metrics. Cases run with no domain or budget; see RunTask.
+func (r *Runner) Run(ctx context.Context, h core.Hypothesis, cases []core.TestCase, exec core.Interpreter) (map[string]float64, bool, error) {
+	return r.RunTask(ctx, core.Task{}, h, cases, exec)
+}
+
+// RunTask implements core.TaskTestRunner. Each case executes with the
+// task's domain and budget, and times out after Budget.Timeout. Besides the
+// case counts, metrics include:
+//
+//   - cases_timeout, cases_trap and cases_wrong, which split cases_failed
+//     into executions stopped by their budget, executions that failed, and
+//     wrong outputs
+//   - weight_total, weight_passed and pass_rate_weighted, the passed share
+//     of the total weight; cases without a weight count as 1
+//   - p50_latency_ms, p90_latency_ms, p95_latency_ms, p99_latency_ms and
+//     max_latency_ms over the cases' execution times
+//   - check_<name>_passed and check_<name>_failed for each check used
+//
+// A check that is not registered fails the whole run.
+func (r *Runner) RunTask(ctx context.Context, task core.Task, h core.Hypothesis, cases []core.TestCase, exec core.Interpreter) (map[string]float64, bool, error) {
+	bound := make([][]*BoundCheck, len(cases))
+	for i, tc := range cases {
+		checks, err := r.checks.ParseAll(tc.Checks)
+		if err != nil {
+			return nil, false, fmt.Errorf("test case %s: %w", tc.Name, err)
 		}
+		bound[i] = checks
+	}
+
+	scope, release := evaluationScope(task, exec)
+	defer release()
+
+	results := make([]caseResult, len(cases))
+	parallel(len(cases), r.workers, func(i int) {
+		results[i] = runCase(ctx, task, scope, h, cases[i], bound[i], exec)
+	})
+
+	metrics := map[string]float64{
+		"cases_total":        0,
+		"cases_passed":       0,
+		"cases_failed":       0,
+		"cases_timeout":      0,
+		"cases_trap":         0,
+		"cases_wrong":        0,
+		"weight_total":       0,
+		"weight_passed":      0,
+		"pass_rate_weighted": 0,
+		"duration_ms_total":  0,
+		"fuel_used":          0,
+		"peak_memory_bytes":  0,
+	}
 
-		res, err := exec.Execute(ctx, h, task)
-		durMs := float64(time.Since(start).Milliseconds())
-		metrics["duration_ms_total"] += durMs
+	allPassed := true
+	latencies := make([]float64, len(results))
+	for i, res := range results {
+		latencies[i] = res.durMs
+		metrics["duration_ms_total"] += res.durMs
+		metrics["fuel_used"] += res.fuel
+		metrics["peak_memory_bytes"] = max(metrics["peak_memory_bytes"], res.peak)
 		metrics["cases_total"] += 1
 
-		passed := false
-		if err == nil {
-			passed = evaluateCase(tc, task, res)
+		weight := cases[i].Weight
+		if weight <= 0 {
+			weight = 1
 		}
+		metrics["weight_total"] += weight
 
-		if passed {
+		if res.passed {
 			metrics["cases_passed"] += 1
+			metrics["weight_passed"] += weight
 		} else {
 			metrics["cases_failed"] += 1
+			metrics["cases_"+res.failure] += 1
 			allPassed = false
 		}
+
+		// Both counts are reported for every check used, even when zero
+		for j, check := range bound[i] {
+			passed, failed := "check_"+check.Name+"_passed", "check_"+check.Name+"_failed"
+			metrics[passed] += 0
+			metrics[failed] += 0
+			if res.checks[j] {
+				metrics[passed]++
+			} else {
+				metrics[failed]++
+			}
+		}
 	}
 
+	if metrics["weight_total"] > 0 {
+		metrics["pass_rate_weighted"] = metrics["weight_passed"] / metrics["weight_total"]
+	}
+	sort.Float64s(latencies)
+	for _, p := range latencyPercentiles {
+		metrics[fmt.Sprintf("p%d_latency_ms", p)] = percentile(latencies, p)
+	}
+	metrics["max_latency_ms"] = percentile(latencies, 100)
+
 	return metrics, allPassed, nil
 }
 
-// evaluateCase validates the output against the oracle and checks.
-func evaluateCase(tc core.TestCase, task core.Task, res core.Result) bool {
-	// If Oracle is provided, require exact JSON equality (semantic)
-	if len(tc.Oracle) > 0 {
-		var want, got any
-		if err := json.Unmarshal(tc.Oracle, &want); err != nil {
-			return false
-		}
-		if err := json.Unmarshal(res.Output, &got); err != nil {
-			return false
-		}
-		if !deepEqualJSON(want, got) {
-			return false
-		}
+// percentile is the nearest-rank percentile of sorted values, 0 if there
+// are none
+func percentile(sorted []float64, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
 	}
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
 
-	// Property checks
-	for _, check := range tc.Checks {
-		switch check {
-		case "sorted_non_decreasing":
-			if !checkSorted(res.Output) {
-				return false
-			}
-		case "permutes":
-			if !checkPermutes(tc.Input, res.Output) {
-				return false
-			}
+// evaluations numbers evaluations of hypotheses
+var evaluations atomic.Int64
+
+// evaluationScope returns the task ID an evaluation of a hypothesis runs its
+// executions under, derived from the task's, and a function that releases
+// the state they kept. Evaluations, even of the same hypothesis and task,
+// never see each other's state, such as the WASM key-value store.
+func evaluationScope(task core.Task, exec core.Interpreter) (string, func()) {
+	scope := fmt.Sprintf("%s/eval-%d", task.ID, evaluations.Add(1))
+	return scope, func() {
+		if releaser, ok := exec.(core.StateReleaser); ok {
+			releaser.ReleaseState(scope)
 		}
 	}
-	return true
 }
 
-func deepEqualJSON(a, b any) bool {
-	ab, _ := json.Marshal(a)
-	bb, _ := json.Marshal(b)
-	return string(ab) == string(bb)
-}
+// runCase executes a single test case on behalf of a task, under the
+// evaluation's scope
+func runCase(ctx context.Context, parent core.Task, scope string, h core.Hypothesis, tc core.TestCase, checks []*BoundCheck, exec core.Interpreter) caseResult {
+	if parent.Budget.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, parent.Budget.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+
+	task := core.Task{
+		ID:     scope + "/case:" + tc.Name,
+		Domain: parent.Domain,
+		Spec:   core.Spec{SuccessCriteria: tc.Checks},
+		Input:  json.RawMessage(tc.Input),
+		Budget: parent.Budget,
+	}
+
+	res, err := exec.Execute(ctx, h, task)
+	durMs := float64(time.Since(start).Microseconds()) / 1000
 
-func extractNumbers(data []byte, field string) ([]float64, bool) {
-	var obj map[string]any
-	if err := json.Unmarshal(data, &obj); err != nil {
-		return nil, false
-	}
-	v, ok := obj[field]
-	if !ok {
-		return nil, false
-	}
-	switch arr := v.(type) {
-	case []any:
-		nums := make([]float64, 0, len(arr))
-		for _, it := range arr {
-			switch n := it.(type) {
-			case float64:
-				nums = append(nums, n)
-			case int:
-				nums = append(nums, float64(n))
-			default:
-				return nil, false
+	result := caseResult{
+		durMs:  durMs,
+		fuel:   res.Metrics["fuel_used"],
+		peak:   res.Metrics["peak_memory_bytes"],
+		checks: make([]bool, len(checks)),
+	}
+	switch {
+	case err != nil && (errors.Is(err, context.DeadlineExceeded) || errors.Is(err, core.ErrBudgetExceeded)):
+		result.failure = failureTimeout
+	case err != nil:
+		result.failure = failureTrap
+	default:
+		rerun := func(input any) (any, error) {
+			data, err := json.Marshal(input)
+			if err != nil {
+				return nil, err
+			}
+			rerunTask := task
+			rerunTask.Input = data
+			res, err := exec.Execute(ctx, h, rerunTask)
+			if err != nil {
+				return nil, err
 			}
+			var out any
+			if err := json.Unmarshal(res.Output, &out); err != nil {
+				return nil, fmt.Errorf("output is not JSON: %w", err)
+			}
+			return out, nil
+		}
+		result.passed = evaluateCase(tc, checks, res, rerun, result.checks)
+		if !result.passed {
+			result.failure = failureWrong
 		}
-		return nums, true
-	default:
-		return nil, false
 	}
+	return result
 }
 
-func checkSorted(output []byte) bool {
-	nums, ok := extractNumbers(output, "sorted")
-	if !ok {
-		return false
-	}
-	for i := 1; i < len(nums); i++ {
-		if nums[i] < nums[i-1] {
-			return false
+// parallel calls fn for 0..n-1 on up to workers goroutines and waits for
+// all calls to return
+func parallel(n, workers int, fn func(i int)) {
+	if workers <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
 		}
+		return
 	}
-	return true
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
 }
 
-func checkPermutes(input, output []byte) bool {
-	in, ok1 := extractNumbers(input, "numbers")
-	out, ok2 := extractNumbers(output, "sorted")
-	if !ok1 || !ok2 || len(in) != len(out) {
+// evaluateCase validates the output against the oracle and checks,
+// recording in passed whether each check held. Every check runs even when
+// an earlier one fails, so that the metrics break failures down by check.
+func evaluateCase(tc core.TestCase, checks []*BoundCheck, res core.Result, rerun func(any) (any, error), passed []bool) bool {
+	in := CheckInput{Rerun: rerun}
+	if err := json.Unmarshal(res.Output, &in.Output); err != nil {
 		return false
 	}
-	count := map[float64]int{}
-	for _, n := range in {
-		count[n]++
-	}
-	for _, n := range out {
-		count[n]--
+	if err := json.Unmarshal(tc.Input, &in.Input); err != nil {
+		return false
 	}
-	for _, v := range count {
-		if v != 0 {
+	if len(tc.Oracle) > 0 {
+		if err := json.Unmarshal(tc.Oracle, &in.Oracle); err != nil {
 			return false
 		}
 	}
-	return true
+
+	// If Oracle is provided, require exact JSON equality (semantic), unless
+	// a check compares with it more loosely
+	ok := true
+	exact := in.Oracle != nil
+	for _, check := range checks {
+		if check.ComparesOracle {
+			exact = false
+		}
+	}
+	if exact && !deepEqualJSON(in.Oracle, in.Output) {
+		ok = false
+	}
+
+	// Property checks
+	for i, check := range checks {
+		passed[i] = check.Eval(in) == nil
+		ok = ok && passed[i]
+	}
+	return ok
+}
+
+func deepEqualJSON(a, b any) bool {
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return string(ab) == string(bb)
 }
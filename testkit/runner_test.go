@@ -3,7 +3,12 @@ package testkit
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/snow-ghost/agent/core"
 	"github.com/snow-ghost/agent/interp/wasm"
@@ -66,4 +71,224 @@ func TestRunner_TimingMetrics(t *testing.T) {
 	metrics, _, err := runner.Run(ctx, h, cases, interp)
 	require.NoError(t, err)
 	assert.GreaterOrEqual(t, metrics["duration_ms_total"], float64(0))
+	assert.Greater(t, metrics["fuel_used"], float64(0))
+	assert.Greater(t, metrics["peak_memory_bytes"], float64(0))
+}
+
+// sleepyInterp echoes its input after a delay and tracks concurrent calls
+type sleepyInterp struct {
+	delay   time.Duration
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (s *sleepyInterp) Execute(ctx context.Context, h core.Hypothesis, task core.Task) (core.Result, error) {
+	n := s.running.Add(1)
+	defer s.running.Add(-1)
+	for {
+		peak := s.peak.Load()
+		if n <= peak || s.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	time.Sleep(s.delay)
+	if string(task.Input) == `{"fail":true}` {
+		return core.Result{}, errors.New("trap")
+	}
+	return core.Result{Success: true, Output: task.Input}, nil
+}
+
+func TestRunner_ParallelCases(t *testing.T) {
+	var cases []core.TestCase
+	for i := 0; i < 8; i++ {
+		input := json.RawMessage(fmt.Sprintf(`{"n":%d}`, i))
+		cases = append(cases, core.TestCase{Name: fmt.Sprint(i), Input: input, Oracle: input})
+	}
+	cases[5].Input = json.RawMessage(`{"fail":true}`)
+
+	interp := &sleepyInterp{delay: 50 * time.Millisecond}
+	runner := NewRunnerWithWorkers(4)
+
+	start := time.Now()
+	metrics, pass, err := runner.Run(context.Background(), core.Hypothesis{ID: "echo"}, cases, interp)
+	require.NoError(t, err)
+
+	assert.False(t, pass)
+	assert.Equal(t, 8.0, metrics["cases_total"])
+	assert.Equal(t, 7.0, metrics["cases_passed"])
+	assert.Equal(t, 1.0, metrics["cases_failed"])
+	assert.Equal(t, int32(4), interp.peak.Load())
+	assert.Less(t, time.Since(start), 8*interp.delay)
+}
+
+func TestRunner_CheckMetrics(t *testing.T) {
+	interp := &sleepyInterp{}
+	runner := NewRunner()
+	h := core.Hypothesis{ID: "echo"}
+
+	cases := []core.TestCase{
+		{Name: "ordered", Input: json.RawMessage(`{"sorted": [1, 2]}`), Checks: []string{"monotone", "length_preserved($.sorted, $.sorted)"}},
+		{Name: "unordered", Input: json.RawMessage(`{"sorted": [2, 1]}`), Checks: []string{"monotone"}},
+		{Name: "close", Input: json.RawMessage(`{"x": 1.0001}`), Oracle: json.RawMessage(`{"x": 1}`), Checks: []string{"within_tolerance(tol=0.001)"}},
+	}
+	metrics, pass, err := runner.Run(context.Background(), h, cases, interp)
+	require.NoError(t, err)
+
+	assert.False(t, pass)
+	assert.Equal(t, 2.0, metrics["cases_passed"])
+	assert.Equal(t, 1.0, metrics["check_monotone_passed"])
+	assert.Equal(t, 1.0, metrics["check_monotone_failed"])
+	assert.Equal(t, 1.0, metrics["check_length_preserved_passed"])
+	assert.Equal(t, 0.0, metrics["check_length_preserved_failed"])
+	assert.Equal(t, 1.0, metrics["check_within_tolerance_passed"])
+
+	// Checks that are not registered fail the run instead of passing
+	cases[1].Checks = []string{"is_fast"}
+	_, pass, err = runner.Run(context.Background(), h, cases, interp)
+	assert.False(t, pass)
+	assert.EqualError(t, err, `test case unordered: unknown check "is_fast"`)
+}
+
+// execFunc adapts a function to core.Interpreter
+type execFunc func(ctx context.Context, task core.Task) (core.Result, error)
+
+func (f execFunc) Execute(ctx context.Context, h core.Hypothesis, task core.Task) (core.Result, error) {
+	return f(ctx, task)
+}
+
+func TestRunner_RunTask(t *testing.T) {
+	var mu sync.Mutex
+	var seen []core.Task
+	interp := execFunc(func(ctx context.Context, task core.Task) (core.Result, error) {
+		mu.Lock()
+		seen = append(seen, task)
+		mu.Unlock()
+
+		switch string(task.Input) {
+		case `"hang"`:
+			<-ctx.Done()
+			return core.Result{}, fmt.Errorf("interrupted: %w", ctx.Err())
+		case `"spin"`:
+			return core.Result{}, fmt.Errorf("%w: out of fuel", core.ErrBudgetExceeded)
+		case `"crash"`:
+			return core.Result{}, errors.New("unreachable executed")
+		}
+		return core.Result{Success: true, Output: task.Input}, nil
+	})
+
+	cases := []core.TestCase{
+		{Name: "right", Input: json.RawMessage(`1`), Oracle: json.RawMessage(`1`), Weight: 3},
+		{Name: "unweighted", Input: json.RawMessage(`2`), Oracle: json.RawMessage(`2`)},
+		{Name: "wrong", Input: json.RawMessage(`3`), Oracle: json.RawMessage(`4`), Weight: 2},
+		{Name: "hang", Input: json.RawMessage(`"hang"`), Weight: 1},
+		{Name: "spin", Input: json.RawMessage(`"spin"`), Weight: 1},
+		{Name: "crash", Input: json.RawMessage(`"crash"`), Weight: 2},
+	}
+	task := core.Task{ID: "parent", Domain: "numbers", Budget: core.Budget{CPUMillis: 7, Timeout: 20 * time.Millisecond}}
+
+	metrics, pass, err := NewRunner().RunTask(context.Background(), task, core.Hypothesis{}, cases, interp)
+	require.NoError(t, err)
+	assert.False(t, pass)
+
+	assert.Equal(t, 2.0, metrics["cases_passed"])
+	assert.Equal(t, 4.0, metrics["cases_failed"])
+	assert.Equal(t, 2.0, metrics["cases_timeout"])
+	assert.Equal(t, 1.0, metrics["cases_trap"])
+	assert.Equal(t, 1.0, metrics["cases_wrong"])
+
+	assert.Equal(t, 10.0, metrics["weight_total"])
+	assert.Equal(t, 4.0, metrics["weight_passed"])
+	assert.InDelta(t, 0.4, metrics["pass_rate_weighted"], 1e-9)
+
+	// Only the hanging case waits for its timeout
+	assert.GreaterOrEqual(t, metrics["max_latency_ms"], 20.0)
+	assert.Less(t, metrics["p50_latency_ms"], 20.0)
+	assert.LessOrEqual(t, metrics["p50_latency_ms"], metrics["p90_latency_ms"])
+	assert.LessOrEqual(t, metrics["p90_latency_ms"], metrics["p95_latency_ms"])
+	assert.LessOrEqual(t, metrics["p95_latency_ms"], metrics["p99_latency_ms"])
+
+	require.Len(t, seen, len(cases))
+	for _, got := range seen {
+		assert.Equal(t, "numbers", got.Domain)
+		assert.Equal(t, task.Budget, got.Budget)
+	}
+}
+
+// seenModule answers whether an earlier execution of its task stored "k"
+const seenModule = `(module
+  (import "agent" "kv_get" (func $get (param i32 i32 i32 i32) (result i32)))
+  (import "agent" "kv_set" (func $set (param i32 i32 i32 i32) (result i32)))
+  (memory (export "memory") 1)
+  (data (i32.const 0) "k")
+  (data (i32.const 16) "{\"seen\":true}")
+  (data (i32.const 32) "{\"seen\":false}")
+  (func (export "solve") (param i32 i32) (result i32 i32)
+    (local $n i32)
+    (local.set $n (call $get (i32.const 0) (i32.const 1) (i32.const 1024) (i32.const 64)))
+    (if (i32.lt_s (local.get $n) (i32.const 0))
+      (then
+        (drop (call $set (i32.const 0) (i32.const 1) (i32.const 16) (i32.const 13)))
+        (return (i32.const 32) (i32.const 14))))
+    (i32.const 1024) (local.get $n)))`
+
+func TestRunner_RunTaskIsolatesState(t *testing.T) {
+	ctx := context.Background()
+	interp := wasm.NewInterpreter()
+	defer interp.Close(ctx)
+
+	bin, err := wasm.Build(ctx, seenModule)
+	require.NoError(t, err)
+	h := core.Hypothesis{ID: "seen", Lang: "wasm", Bytes: bin, Meta: map[string]string{core.MetaCapabilities: core.CapabilityKV}}
+	cases := []core.TestCase{{Name: "echo", Input: []byte(`{}`), Oracle: []byte(`{"seen":false}`)}}
+
+	// Tasks with a case of the same name, and repeated evaluations of one
+	// task, each start with an empty store
+	for _, id := range []string{"task-a", "task-b", "task-a"} {
+		metrics, pass, err := NewRunner().RunTask(ctx, core.Task{ID: id}, h, cases, interp)
+		require.NoError(t, err)
+		assert.True(t, pass, "%s: %v", id, metrics)
+	}
+}
+
+// constModule answers {"ok":true} to any input
+const constModule = `(module
+  (memory (export "memory") 1)
+  (data (i32.const 0) "{\"ok\":true}")
+  (func (export "solve") (param i32 i32) (result i32 i32)
+    (i32.const 0)
+    (i32.const 11)))`
+
+func TestRunner_MetricsMeetCriteria(t *testing.T) {
+	ctx := context.Background()
+	interp := wasm.NewInterpreter()
+	defer interp.Close(ctx)
+
+	bin, err := wasm.Build(ctx, constModule)
+	require.NoError(t, err)
+	h := core.Hypothesis{ID: "const", Lang: "wasm", Bytes: bin}
+	cases := []core.TestCase{
+		{Name: "a", Input: json.RawMessage(`{}`), Oracle: json.RawMessage(`{"ok":true}`)},
+		{Name: "b", Input: json.RawMessage(`[1]`), Oracle: json.RawMessage(`{"ok":true}`)},
+	}
+	task := core.Task{ID: "criteria", Spec: core.Spec{SuccessCriteria: []string{
+		"cases_failed == 0", "p95_latency_ms < 50", "fuel_used < 1e6",
+	}}}
+
+	// The criteria documented for proposals name metrics the runner reports
+	metrics, pass, err := NewRunner().RunTask(ctx, task, h, cases, interp)
+	require.NoError(t, err)
+	require.True(t, pass)
+	ok, reason := core.NewRuleCritic().Accept(task, metrics)
+	assert.True(t, ok, reason)
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(t, 5.0, percentile(values, 50))
+	assert.Equal(t, 9.0, percentile(values, 90))
+	assert.Equal(t, 10.0, percentile(values, 99))
+	assert.Equal(t, 10.0, percentile(values, 100))
+	assert.Equal(t, 7.0, percentile([]float64{7}, 50))
+	assert.Zero(t, percentile(nil, 50))
 }
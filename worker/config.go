@@ -2,9 +2,14 @@ package worker
 
 import (
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/snow-ghost/agent/interp/wasm"
+	"github.com/snow-ghost/agent/testkit"
+	"github.com/snow-ghost/agent/worker/evolve"
 )
 
 // Config holds configuration for the worker
@@ -23,6 +28,31 @@ type Config struct {
 	LLMRouterURL string
 	DefaultModel string
 	ModelTag     string
+
+	// LLMRepairRounds is how many times a generated module that fails to
+	// build is sent back to the LLM
+	LLMRepairRounds int
+
+	// LLMMutationsPerTask is how many LLM mutation and crossover requests
+	// one evolution run of a task may make
+	LLMMutationsPerTask int
+
+	// WASMCacheSize is how many compiled modules the sandbox keeps in memory
+	WASMCacheSize int
+
+	// WASMCacheDir, if set, persists compiled modules across restarts
+	WASMCacheDir string
+
+	// PropertyRuns is how many generated inputs each hypothesis is property
+	// tested on, for tasks that give an input schema
+	PropertyRuns int
+
+	// EvalWorkers is how many candidates, and test cases per candidate, are
+	// evaluated at once
+	EvalWorkers int
+
+	// Evolution configures the evolutionary search of the heavy worker
+	Evolution evolve.Config
 }
 
 // LoadConfig loads configuration from environment variables
@@ -33,6 +63,8 @@ func LoadConfig() *Config {
 		LLMMode:          getEnv("LLM_MODE", "mock"),
 		PolicyAllowTools: parseCommaSeparated(getEnv("POLICY_ALLOW_TOOLS", "example.com,api.example.com")),
 		SandboxMemMB:     getEnvInt("SANDBOX_MEM_MB", 4),
+		WASMCacheSize:    getEnvInt("WASM_CACHE_SIZE", wasm.DefaultCacheSize),
+		WASMCacheDir:     getEnv("WASM_CACHE_DIR", ""),
 		TaskTimeout:      getEnvDuration("TASK_TIMEOUT", "30s"),
 		HypothesesDir:    getEnv("HYPOTHESES_DIR", "./hypotheses"),
 		ArtifactsDir:     getEnv("ARTIFACTS_DIR", "./artifacts"),
@@ -42,7 +74,21 @@ func LoadConfig() *Config {
 		LLMRouterURL: getEnv("LLM_ROUTER_URL", "http://llmrouter:8090"),
 		DefaultModel: getEnv("DEFAULT_MODEL", "openai:gpt-4o-mini"),
 		ModelTag:     getEnv("MODEL_TAG", "general"),
+
+		LLMRepairRounds:     getEnvInt("LLM_REPAIR_ROUNDS", 3),
+		LLMMutationsPerTask: getEnvInt("LLM_MUTATIONS_PER_TASK", 4),
+		PropertyRuns:        getEnvInt("PROPERTY_RUNS", testkit.DefaultPropertyRuns),
+		EvalWorkers:         getEnvInt("EVAL_WORKERS", runtime.NumCPU()),
+
+		Evolution: evolve.Config{
+			PopulationSize:  getEnvInt("EVOLVE_POPULATION", evolve.DefaultPopulationSize),
+			TournamentSize:  getEnvInt("EVOLVE_TOURNAMENT", evolve.DefaultTournamentSize),
+			Elites:          getEnvInt("EVOLVE_ELITES", evolve.DefaultElites),
+			StagnationLimit: getEnvInt("EVOLVE_STAGNATION", evolve.DefaultStagnationLimit),
+			MaxGenerations:  getEnvInt("EVOLVE_MAX_GENERATIONS", 0),
+		},
 	}
+	config.Evolution.Workers = config.EvalWorkers
 
 	return config
 }
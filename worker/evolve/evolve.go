@@ -0,0 +1,502 @@
+// Package evolve runs population-based evolution of hypotheses against a
+// task's tests.
+package evolve
+
+import (
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/snow-ghost/agent/core"
+)
+
+// Defaults for Config fields left at zero
+const (
+	DefaultPopulationSize  = 8
+	DefaultTournamentSize  = 3
+	DefaultElites          = 1
+	DefaultStagnationLimit = 5
+)
+
+// Config controls an evolution run
+type Config struct {
+	// PopulationSize is the number of individuals kept per generation and
+	// the number of offspring bred for the next
+	PopulationSize int
+	// TournamentSize is how many individuals compete to become a parent
+	TournamentSize int
+	// Elites is how many of the best individuals survive unchanged
+	Elites int
+	// StagnationLimit stops the run after this many generations without a
+	// better score
+	StagnationLimit int
+	// MaxGenerations caps the run; zero runs until the task's timeout
+	MaxGenerations int
+	// Seed seeds selection; zero uses the current time
+	Seed int64
+	// Workers is how many candidates are evaluated at once; zero uses one
+	// per CPU
+	Workers int
+}
+
+// withDefaults fills zero fields
+func (c Config) withDefaults() Config {
+	if c.PopulationSize <= 0 {
+		c.PopulationSize = DefaultPopulationSize
+	}
+	if c.TournamentSize <= 0 {
+		c.TournamentSize = DefaultTournamentSize
+	}
+	if c.Elites < 0 {
+		c.Elites = 0
+	}
+	if c.Elites == 0 && c.PopulationSize > 1 {
+		c.Elites = DefaultElites
+	}
+	if c.Elites > c.PopulationSize {
+		c.Elites = c.PopulationSize
+	}
+	if c.StagnationLimit <= 0 {
+		c.StagnationLimit = DefaultStagnationLimit
+	}
+	if c.Seed == 0 {
+		c.Seed = time.Now().UnixNano()
+	}
+	if c.Workers <= 0 {
+		c.Workers = runtime.GOMAXPROCS(0)
+	}
+	return c
+}
+
+// Observer receives progress once per generation; *telemetry.Telemetry
+// satisfies it
+type Observer interface {
+	LogIteration(ctx context.Context, iteration int, bestScore float64, candidates int)
+}
+
+// Individual is an evaluated hypothesis
+type Individual struct {
+	Hypothesis core.Hypothesis
+	Metrics    map[string]float64
+	Score      float64
+	Pass       bool // all tests passed
+	Accepted   bool // the critic accepted the metrics
+	// Counterexample is an input found to break the task's properties, or
+	// on which the hypothesis disagrees with the reference
+	Counterexample *core.Counterexample
+}
+
+// Outcome describes how a run ended
+type Outcome struct {
+	// Best is the accepted individual if there is one, otherwise the
+	// highest-scoring one
+	Best        Individual
+	Generations int
+	Evaluations int
+	// Stagnated is set when the run stopped for lack of progress
+	Stagnated bool
+	// Diversity is the share of distinct scores in the final population
+	Diversity float64
+	// Counterexamples are the inputs property testing and the reference
+	// found, in the order they were added to the tests
+	Counterexamples []core.Counterexample
+}
+
+// Engine evolves hypotheses. Mutators that implement core.GuidedMutator are
+// given each parent's metrics and failing tests, and the two best distinct
+// individuals are offered for crossover once per generation. Test runners
+// that implement core.TaskTestRunner run the tests with the task's domain
+// and budget. Mutators that implement core.RunScoped are told when the run
+// starts and ends.
+//
+// With Properties set, hypotheses that pass their tests are also property
+// tested. A counterexample fails the hypothesis and joins the tests, so
+// later candidates are evaluated against it and mutators see it as failing.
+// With Reference set, hypotheses that pass are also compared with a trusted
+// skill, and an input they disagree on is handled the same way.
+type Engine struct {
+	Tests      core.TestRunner
+	Interp     core.Interpreter
+	Fitness    core.FitnessEvaluator
+	Critic     core.Critic
+	Mut        core.Mutator
+	Properties core.PropertyTester  // optional
+	Reference  core.ReferenceTester // optional
+	Observer   Observer             // optional
+	Config     Config
+}
+
+// run holds the state of one Run call
+type run struct {
+	*Engine
+	cfg   Config
+	rng   *rand.Rand
+	task  core.Task
+	tests []core.TestCase
+	seen  map[[sha256.Size]byte]bool
+
+	failing         map[string][]core.TestCase // by hypothesis ID
+	evaluations     int
+	accepted        *Individual
+	counterexamples []core.Counterexample
+	propertyErr     sync.Once
+	referenceErr    sync.Once
+}
+
+// Run evolves the seeds until the critic accepts an individual, the run
+// stagnates, the generation cap is reached or the task's timeout expires.
+// The task's success criteria should already be set.
+func (e *Engine) Run(ctx context.Context, task core.Task, seeds []core.Hypothesis, tests []core.TestCase) Outcome {
+	cfg := e.Config.withDefaults()
+	if task.Budget.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, task.Budget.Timeout)
+		defer cancel()
+	}
+
+	r := &run{
+		Engine: e,
+		cfg:    cfg,
+		rng:    rand.New(rand.NewSource(cfg.Seed)),
+		task:   task,
+		tests:  tests,
+		seen:   make(map[[sha256.Size]byte]bool),
+
+		failing: make(map[string][]core.TestCase),
+	}
+	if scoped, ok := e.Mut.(core.RunScoped); ok {
+		defer scoped.BeginRun(task.ID)()
+	}
+
+	population := r.evaluateAll(ctx, seeds)
+	sortByScore(population)
+	if len(population) > cfg.PopulationSize {
+		population = population[:cfg.PopulationSize]
+	}
+
+	out := Outcome{Diversity: diversity(population)}
+	if len(population) == 0 {
+		return out
+	}
+	best := population[0]
+
+	stagnant := 0
+	for r.accepted == nil && ctx.Err() == nil {
+		if cfg.MaxGenerations > 0 && out.Generations >= cfg.MaxGenerations {
+			break
+		}
+		out.Generations++
+
+		offspring := r.breed(ctx, population)
+		population = r.survivors(population, offspring)
+		out.Diversity = diversity(population)
+
+		if population[0].Score > best.Score {
+			best = population[0]
+			stagnant = 0
+		} else {
+			stagnant++
+		}
+
+		if e.Observer != nil {
+			e.Observer.LogIteration(ctx, out.Generations, best.Score, len(offspring))
+		}
+		slog.DebugContext(ctx, "evolution generation",
+			"task_id", task.ID, "generation", out.Generations, "best_score", best.Score,
+			"offspring", len(offspring), "diversity", out.Diversity)
+
+		if stagnant >= cfg.StagnationLimit {
+			out.Stagnated = true
+			break
+		}
+	}
+
+	out.Best = best
+	if r.accepted != nil {
+		out.Best = *r.accepted
+	}
+	out.Evaluations = r.evaluations
+	out.Counterexamples = r.counterexamples
+	return out
+}
+
+// breed selects parents by tournament and evaluates their new offspring
+func (r *run) breed(ctx context.Context, population []Individual) []Individual {
+	guided, isGuided := r.Mut.(core.GuidedMutator)
+
+	var offspring []Individual
+	if isGuided && len(population) > 1 {
+		offspring = append(offspring, r.evaluateAll(ctx, guided.Crossover(ctx, r.task, population[0].Hypothesis, population[1].Hypothesis))...)
+	}
+
+	// Each attempt mutates one parent; mutators may return several
+	// candidates or only ones already seen
+	for attempt := 0; attempt < r.cfg.PopulationSize && len(offspring) < r.cfg.PopulationSize; attempt++ {
+		if r.accepted != nil || ctx.Err() != nil {
+			break
+		}
+
+		parent := r.tournament(population)
+		var children []core.Hypothesis
+		if isGuided {
+			children = guided.MutateGuided(ctx, r.task, parent.Hypothesis, r.feedback(ctx, parent))
+		} else {
+			children = r.Mut.Mutate(parent.Hypothesis)
+		}
+		offspring = append(offspring, r.evaluateAll(ctx, children)...)
+	}
+	return offspring
+}
+
+// survivors keeps the elites and fills the rest of the population with the
+// best of the remaining individuals and offspring
+func (r *run) survivors(population, offspring []Individual) []Individual {
+	elites := min(r.cfg.Elites, len(population))
+	next := append([]Individual(nil), population[:elites]...)
+
+	rest := append(append([]Individual(nil), population[elites:]...), offspring...)
+	sortByScore(rest)
+	for _, ind := range rest {
+		if len(next) == r.cfg.PopulationSize {
+			break
+		}
+		next = append(next, ind)
+	}
+
+	sortByScore(next)
+	return next
+}
+
+// tournament returns the best of TournamentSize randomly drawn individuals
+func (r *run) tournament(population []Individual) Individual {
+	winner := population[r.rng.Intn(len(population))]
+	for i := 1; i < r.cfg.TournamentSize; i++ {
+		if c := population[r.rng.Intn(len(population))]; c.Score > winner.Score {
+			winner = c
+		}
+	}
+	return winner
+}
+
+// feedback lists the tests an individual fails
+func (r *run) feedback(ctx context.Context, ind Individual) core.Feedback {
+	feedback := core.Feedback{Metrics: ind.Metrics}
+	if ind.Pass {
+		return feedback
+	}
+
+	failing, ok := r.failing[ind.Hypothesis.ID]
+	if !ok {
+		for _, tc := range r.tests {
+			if _, pass, _ := r.runTests(ctx, ind.Hypothesis, []core.TestCase{tc}); !pass {
+				failing = append(failing, tc)
+			}
+		}
+		r.failing[ind.Hypothesis.ID] = failing
+	}
+	feedback.Failing = failing
+	return feedback
+}
+
+// evaluateAll evaluates hypotheses not seen before on up to Workers
+// goroutines, returning them in input order. Once one is accepted, the
+// evaluation of those after it is cancelled, so the result matches a
+// sequential run that stops at the first accepted hypothesis.
+func (r *run) evaluateAll(ctx context.Context, hs []core.Hypothesis) []Individual {
+	if r.accepted != nil || ctx.Err() != nil {
+		return nil
+	}
+
+	var fresh []core.Hypothesis
+	for _, h := range hs {
+		sum := sha256.Sum256(h.Bytes)
+		if r.seen[sum] {
+			continue
+		}
+		r.seen[sum] = true
+		fresh = append(fresh, h)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, r.cfg.Workers)
+		results  = make([]*Individual, len(fresh))
+		cancels  = make([]context.CancelFunc, len(fresh))
+		accepted = len(fresh) // index of the first accepted hypothesis
+	)
+	skip := func(i int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return i > accepted
+	}
+
+	for i, h := range fresh {
+		if skip(i) || ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+
+		evalCtx, cancel := context.WithCancel(ctx)
+		mu.Lock()
+		cancels[i] = cancel
+		mu.Unlock()
+
+		wg.Add(1)
+		go func(i int, h core.Hypothesis) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer cancel()
+			if skip(i) {
+				return
+			}
+
+			ind := r.evaluate(evalCtx, h)
+			if evalCtx.Err() != nil {
+				// Cancelled or out of time: the metrics are incomplete
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[i] = &ind
+			if ind.Accepted && i < accepted {
+				accepted = i
+				for _, cancelLater := range cancels[i+1:] {
+					if cancelLater != nil {
+						cancelLater()
+					}
+				}
+			}
+		}(i, h)
+	}
+	wg.Wait()
+
+	var evaluated []Individual
+	for i, ind := range results {
+		if i > accepted {
+			break
+		}
+		if ind == nil {
+			continue
+		}
+		r.evaluations++
+		evaluated = append(evaluated, *ind)
+		if ind.Accepted {
+			r.accepted = ind
+		}
+		if ind.Counterexample != nil {
+			r.addCounterexample(ctx, *ind.Counterexample)
+		}
+	}
+	return evaluated
+}
+
+// runTests runs test cases on behalf of the task when the runner can
+func (r *run) runTests(ctx context.Context, h core.Hypothesis, cases []core.TestCase) (map[string]float64, bool, error) {
+	if runner, ok := r.Tests.(core.TaskTestRunner); ok {
+		return runner.RunTask(ctx, r.task, h, cases, r.Interp)
+	}
+	return r.Tests.Run(ctx, h, cases, r.Interp)
+}
+
+func (r *run) evaluate(ctx context.Context, h core.Hypothesis) Individual {
+	metrics, pass, _ := r.runTests(ctx, h, r.tests)
+
+	// A counterexample counts as one more failed test case
+	var counterexample *core.Counterexample
+	if pass && r.Properties != nil {
+		found, propertyMetrics, err := r.Properties.FindCounterexample(ctx, h, r.task, r.Interp)
+		if err != nil && ctx.Err() == nil {
+			r.propertyErr.Do(func() {
+				slog.WarnContext(ctx, "property testing failed", "task_id", r.task.ID, "error", err)
+			})
+		}
+		if metrics == nil {
+			metrics = make(map[string]float64, len(propertyMetrics))
+		}
+		for k, v := range propertyMetrics {
+			metrics[k] = v
+		}
+		if found != nil {
+			counterexample = found
+			pass = false
+			metrics["cases_total"]++
+			metrics["cases_failed"]++
+		}
+	}
+	if pass && r.Reference != nil {
+		found, referenceMetrics, err := r.Reference.FindDisagreement(ctx, h, r.task, r.tests, r.Interp)
+		if err != nil && ctx.Err() == nil {
+			r.referenceErr.Do(func() {
+				slog.WarnContext(ctx, "reference comparison failed", "task_id", r.task.ID, "error", err)
+			})
+		}
+		if metrics == nil {
+			metrics = make(map[string]float64, len(referenceMetrics))
+		}
+		for k, v := range referenceMetrics {
+			metrics[k] = v
+		}
+		if found != nil {
+			counterexample = found
+			pass = false
+			metrics["cases_total"]++
+			metrics["cases_failed"]++
+		}
+	}
+
+	score := r.Fitness.Score(r.task, metrics, len(h.Bytes))
+	accepted, _ := r.Critic.Accept(r.task, metrics)
+
+	return Individual{
+		Hypothesis:     h,
+		Metrics:        metrics,
+		Score:          score,
+		Pass:           pass,
+		Accepted:       accepted && counterexample == nil,
+		Counterexample: counterexample,
+	}
+}
+
+// addCounterexample adds a counterexample to the tests unless a case with the
+// same name and input is already there. A test on the same input under
+// another name may not check what the counterexample does, such as the
+// reference's output.
+func (r *run) addCounterexample(ctx context.Context, c core.Counterexample) {
+	for _, tc := range r.tests {
+		if tc.Name == c.Case.Name && string(tc.Input) == string(c.Case.Input) {
+			return
+		}
+	}
+	// The caller's slice is never appended to in place
+	r.tests = append(r.tests[:len(r.tests):len(r.tests)], c.Case)
+	r.counterexamples = append(r.counterexamples, c)
+	slog.InfoContext(ctx, "counterexample found",
+		"task_id", r.task.ID, "input", string(c.Case.Input), "reason", c.Reason, "shrinks", c.Shrinks)
+}
+
+// sortByScore orders individuals best first, keeping earlier ones first on
+// ties
+func sortByScore(population []Individual) {
+	sort.SliceStable(population, func(i, j int) bool {
+		return population[i].Score > population[j].Score
+	})
+}
+
+// diversity is the share of distinct scores in a population
+func diversity(population []Individual) float64 {
+	if len(population) == 0 {
+		return 0
+	}
+	scores := make(map[float64]bool, len(population))
+	for _, ind := range population {
+		scores[ind.Score] = true
+	}
+	return float64(len(scores)) / float64(len(population))
+}
@@ -0,0 +1,358 @@
+package evolve
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Hypotheses in these tests hold a number; its value is their fitness.
+
+func numbered(n int) core.Hypothesis {
+	return core.Hypothesis{ID: "h" + strconv.Itoa(n), Lang: "num", Bytes: []byte(strconv.Itoa(n))}
+}
+
+func value(h core.Hypothesis) int {
+	n, _ := strconv.Atoi(string(h.Bytes))
+	return n
+}
+
+// valueRunner reports a hypothesis's value; cases fail while it is negative
+type valueRunner struct{}
+
+func (valueRunner) Run(ctx context.Context, h core.Hypothesis, cases []core.TestCase, exec core.Interpreter) (map[string]float64, bool, error) {
+	v := float64(value(h))
+	failed := 0.0
+	if v < 0 {
+		failed = float64(len(cases))
+	}
+	return map[string]float64{"value": v, "cases_failed": failed}, failed == 0, nil
+}
+
+// targetCritic accepts values at or above target
+type targetCritic struct{ target float64 }
+
+func (c targetCritic) Accept(task core.Task, metrics map[string]float64) (bool, string) {
+	return metrics["value"] >= c.target, ""
+}
+
+// stepMutator proposes the neighbouring values
+type stepMutator struct{ steps []int }
+
+func (m stepMutator) Mutate(base core.Hypothesis) []core.Hypothesis {
+	var out []core.Hypothesis
+	for _, step := range m.steps {
+		out = append(out, numbered(value(base)+step))
+	}
+	return out
+}
+
+type iteration struct {
+	generation int
+	best       float64
+	candidates int
+}
+
+type recorder struct{ iterations []iteration }
+
+func (r *recorder) LogIteration(ctx context.Context, generation int, best float64, candidates int) {
+	r.iterations = append(r.iterations, iteration{generation, best, candidates})
+}
+
+func newEngine(mut core.Mutator, target float64, cfg Config) (*Engine, *recorder) {
+	rec := &recorder{}
+	cfg.Seed = 1
+	return &Engine{
+		Tests:    valueRunner{},
+		Fitness:  core.NewWeightedFitness(map[string]float64{"value": 1}, 0),
+		Critic:   targetCritic{target},
+		Mut:      mut,
+		Observer: rec,
+		Config:   cfg,
+	}, rec
+}
+
+func testTask() core.Task {
+	return core.Task{ID: "evolve-1", Budget: core.Budget{Timeout: 5 * time.Second}}
+}
+
+func TestRunClimbsToAcceptance(t *testing.T) {
+	engine, rec := newEngine(stepMutator{[]int{-1, 1, 2}}, 10, Config{PopulationSize: 4})
+
+	out := engine.Run(context.Background(), testTask(), []core.Hypothesis{numbered(0)}, nil)
+	require.True(t, out.Best.Accepted)
+	assert.GreaterOrEqual(t, value(out.Best.Hypothesis), 10)
+	assert.False(t, out.Stagnated)
+
+	// One observation per generation, with a non-decreasing best score
+	require.Len(t, rec.iterations, out.Generations)
+	for i, it := range rec.iterations {
+		assert.Equal(t, i+1, it.generation)
+		if i > 0 {
+			assert.GreaterOrEqual(t, it.best, rec.iterations[i-1].best)
+		}
+	}
+	assert.Greater(t, out.Evaluations, out.Generations)
+}
+
+func TestRunStopsOnStagnation(t *testing.T) {
+	// Offspring are always worse, so the elite seed stays best
+	engine, rec := newEngine(stepMutator{[]int{-1, -2, -3}}, 100, Config{PopulationSize: 3, StagnationLimit: 3})
+
+	out := engine.Run(context.Background(), testTask(), []core.Hypothesis{numbered(5)}, nil)
+	assert.True(t, out.Stagnated)
+	assert.Equal(t, 3, out.Generations)
+	assert.Len(t, rec.iterations, 3)
+	assert.False(t, out.Best.Accepted)
+	assert.Equal(t, "h5", out.Best.Hypothesis.ID)
+	assert.Greater(t, out.Diversity, 0.0)
+}
+
+func TestRunStopsWhenNothingNew(t *testing.T) {
+	// Only already-seen candidates: every generation is empty
+	engine, rec := newEngine(stepMutator{[]int{0}}, 100, Config{StagnationLimit: 2})
+
+	out := engine.Run(context.Background(), testTask(), []core.Hypothesis{numbered(1), numbered(1)}, nil)
+	assert.True(t, out.Stagnated)
+	assert.Equal(t, 1, out.Evaluations)
+	assert.Equal(t, []iteration{{1, 1, 0}, {2, 1, 0}}, rec.iterations)
+}
+
+func TestRunRespectsMaxGenerations(t *testing.T) {
+	engine, _ := newEngine(stepMutator{[]int{1}}, 100, Config{MaxGenerations: 3})
+
+	out := engine.Run(context.Background(), testTask(), []core.Hypothesis{numbered(0)}, nil)
+	assert.Equal(t, 3, out.Generations)
+	assert.False(t, out.Stagnated)
+	assert.Equal(t, 3, value(out.Best.Hypothesis))
+}
+
+func TestRunAcceptsSeed(t *testing.T) {
+	engine, rec := newEngine(stepMutator{[]int{1}}, 0, Config{})
+
+	out := engine.Run(context.Background(), testTask(), []core.Hypothesis{numbered(-1), numbered(3)}, nil)
+	require.True(t, out.Best.Accepted)
+	assert.Equal(t, "h3", out.Best.Hypothesis.ID)
+	assert.Zero(t, out.Generations)
+	assert.Empty(t, rec.iterations)
+}
+
+// taskRunner is a valueRunner that records the tasks it runs for
+type taskRunner struct {
+	valueRunner
+	mu    sync.Mutex
+	tasks []core.Task
+}
+
+func (r *taskRunner) RunTask(ctx context.Context, task core.Task, h core.Hypothesis, cases []core.TestCase, exec core.Interpreter) (map[string]float64, bool, error) {
+	r.mu.Lock()
+	r.tasks = append(r.tasks, task)
+	r.mu.Unlock()
+	return r.Run(ctx, h, cases, exec)
+}
+
+func TestRunPassesTaskToTests(t *testing.T) {
+	runner := &taskRunner{}
+	engine, _ := newEngine(stepMutator{[]int{1}}, 2, Config{})
+	engine.Tests = runner
+
+	task := testTask()
+	task.Domain = "algorithms"
+	out := engine.Run(context.Background(), task, []core.Hypothesis{numbered(0)}, nil)
+	require.True(t, out.Best.Accepted)
+	require.NotEmpty(t, runner.tasks)
+	for _, got := range runner.tasks {
+		assert.Equal(t, "algorithms", got.Domain)
+		assert.Equal(t, task.Budget, got.Budget)
+	}
+}
+
+// boundTester finds a counterexample for hypotheses whose value is below
+// bound
+type boundTester struct{ bound int }
+
+func (b boundTester) FindCounterexample(ctx context.Context, h core.Hypothesis, task core.Task, exec core.Interpreter) (*core.Counterexample, map[string]float64, error) {
+	metrics := map[string]float64{"property_runs": 1, "property_failures": 0}
+	if value(h) >= b.bound {
+		return nil, metrics, nil
+	}
+	metrics["property_failures"] = 1
+	return &core.Counterexample{
+		Case:   core.TestCase{Name: "counterexample:bound", Input: []byte(`{"bound":true}`)},
+		Reason: "below the bound",
+	}, metrics, nil
+}
+
+func TestRunPropertyCounterexamples(t *testing.T) {
+	mut := &guidedMutator{stepMutator: stepMutator{[]int{1}}}
+	engine, _ := newEngine(mut, 5, Config{PopulationSize: 2})
+	engine.Properties = boundTester{8}
+	tests := []core.TestCase{{Name: "a"}}
+
+	out := engine.Run(context.Background(), testTask(), []core.Hypothesis{numbered(5)}, tests)
+
+	// The critic would take 5, but not while its properties fail
+	require.True(t, out.Best.Accepted)
+	assert.GreaterOrEqual(t, value(out.Best.Hypothesis), 8)
+	assert.Equal(t, 1.0, out.Best.Metrics["property_runs"])
+	assert.Zero(t, out.Best.Metrics["property_failures"])
+
+	// The counterexample is added to the tests once and shown to mutators
+	require.Len(t, out.Counterexamples, 1)
+	assert.Equal(t, "below the bound", out.Counterexamples[0].Reason)
+	assert.Len(t, tests, 1, "the caller's tests are left alone")
+	require.NotEmpty(t, mut.feedback)
+	assert.Equal(t, 1.0, mut.feedback[0].Metrics["property_failures"])
+	assert.Equal(t, 1.0, mut.feedback[0].Metrics["cases_failed"])
+}
+
+// boundReference disagrees with hypotheses whose value is below bound, and
+// records the tests it is given
+type boundReference struct {
+	bound int
+	mu    sync.Mutex
+	cases [][]core.TestCase
+}
+
+func (b *boundReference) FindDisagreement(ctx context.Context, h core.Hypothesis, task core.Task, cases []core.TestCase, exec core.Interpreter) (*core.Counterexample, map[string]float64, error) {
+	b.mu.Lock()
+	b.cases = append(b.cases, cases)
+	b.mu.Unlock()
+
+	metrics := map[string]float64{"differential_disagreed": 0}
+	if value(h) >= b.bound {
+		return nil, metrics, nil
+	}
+	metrics["differential_disagreed"] = 1
+	return &core.Counterexample{
+		Case:   core.TestCase{Name: "differential:bound", Input: []byte(`{"bound":true}`)},
+		Reason: "differs from reference",
+	}, metrics, nil
+}
+
+func TestRunReferenceDisagreements(t *testing.T) {
+	mut := &guidedMutator{stepMutator: stepMutator{[]int{1}}}
+	engine, _ := newEngine(mut, 5, Config{PopulationSize: 2})
+	reference := &boundReference{bound: 8}
+	engine.Reference = reference
+	tests := []core.TestCase{{Name: "a"}}
+
+	out := engine.Run(context.Background(), testTask(), []core.Hypothesis{numbered(5)}, tests)
+
+	// The disagreement joins the tests like a property counterexample
+	require.True(t, out.Best.Accepted)
+	assert.GreaterOrEqual(t, value(out.Best.Hypothesis), 8)
+	require.Len(t, out.Counterexamples, 1)
+	assert.Equal(t, "differs from reference", out.Counterexamples[0].Reason)
+	require.NotEmpty(t, mut.feedback)
+	assert.Equal(t, 1.0, mut.feedback[0].Metrics["differential_disagreed"])
+
+	// The reference is compared on the tests
+	require.NotEmpty(t, reference.cases)
+	assert.Equal(t, tests, reference.cases[0])
+}
+
+// guidedMutator records the feedback and parents it is given
+type guidedMutator struct {
+	stepMutator
+	feedback []core.Feedback
+	parents  [][2]string
+}
+
+func (m *guidedMutator) MutateGuided(ctx context.Context, task core.Task, base core.Hypothesis, feedback core.Feedback) []core.Hypothesis {
+	m.feedback = append(m.feedback, feedback)
+	return m.Mutate(base)
+}
+
+func (m *guidedMutator) Crossover(ctx context.Context, task core.Task, a, b core.Hypothesis) []core.Hypothesis {
+	m.parents = append(m.parents, [2]string{a.ID, b.ID})
+	return []core.Hypothesis{numbered(value(a) + value(b))}
+}
+
+func TestRunGuidedMutator(t *testing.T) {
+	mut := &guidedMutator{stepMutator: stepMutator{[]int{1}}}
+	engine, _ := newEngine(mut, 100, Config{PopulationSize: 2, MaxGenerations: 2})
+	tests := []core.TestCase{{Name: "a"}, {Name: "b"}}
+
+	out := engine.Run(context.Background(), testTask(), []core.Hypothesis{numbered(-3), numbered(-5)}, tests)
+
+	// Failing parents come with their failing tests
+	require.NotEmpty(t, mut.feedback)
+	assert.Equal(t, tests, mut.feedback[0].Failing)
+	assert.Less(t, mut.feedback[0].Metrics["value"], 0.0)
+
+	// The two best are crossed once per generation
+	require.Len(t, mut.parents, 2)
+	assert.Equal(t, [2]string{"h-3", "h-5"}, mut.parents[0])
+	assert.Equal(t, 2, out.Generations)
+}
+
+// scopedMutator records the runs it is told about
+type scopedMutator struct {
+	stepMutator
+	runs []string
+}
+
+func (m *scopedMutator) BeginRun(taskID string) func() {
+	m.runs = append(m.runs, "begin "+taskID)
+	return func() { m.runs = append(m.runs, "end "+taskID) }
+}
+
+func TestRunScopesMutator(t *testing.T) {
+	mut := &scopedMutator{stepMutator: stepMutator{[]int{1}}}
+	engine, _ := newEngine(mut, 100, Config{MaxGenerations: 1})
+	task := testTask()
+
+	engine.Run(context.Background(), task, []core.Hypothesis{numbered(0)}, nil)
+	engine.Run(context.Background(), task, []core.Hypothesis{numbered(0)}, nil)
+	assert.Equal(t, []string{"begin " + task.ID, "end " + task.ID, "begin " + task.ID, "end " + task.ID}, mut.runs)
+}
+
+func TestRunParallelMatchesSequential(t *testing.T) {
+	run := func(workers int) Outcome {
+		engine, _ := newEngine(stepMutator{[]int{-2, -1, 1, 3}}, 25, Config{PopulationSize: 6, Workers: workers})
+		return engine.Run(context.Background(), testTask(), []core.Hypothesis{numbered(0), numbered(1)}, nil)
+	}
+
+	sequential := run(1)
+	require.True(t, sequential.Best.Accepted)
+	for _, workers := range []int{2, 8} {
+		parallel := run(workers)
+		assert.Equal(t, sequential.Best.Hypothesis.ID, parallel.Best.Hypothesis.ID, "workers=%d", workers)
+		assert.Equal(t, sequential.Generations, parallel.Generations, "workers=%d", workers)
+		assert.Equal(t, sequential.Evaluations, parallel.Evaluations, "workers=%d", workers)
+	}
+}
+
+// blockingRunner blocks on hypotheses other than the fast one until their
+// context is cancelled
+type blockingRunner struct{ fast string }
+
+func (b blockingRunner) Run(ctx context.Context, h core.Hypothesis, cases []core.TestCase, exec core.Interpreter) (map[string]float64, bool, error) {
+	if h.ID != b.fast {
+		<-ctx.Done()
+	}
+	return valueRunner{}.Run(ctx, h, cases, exec)
+}
+
+func TestRunCancelsEvaluationsAfterAcceptance(t *testing.T) {
+	engine, _ := newEngine(stepMutator{}, 10, Config{Workers: 4})
+	engine.Tests = blockingRunner{fast: "h10"}
+
+	start := time.Now()
+	out := engine.Run(context.Background(), testTask(),
+		[]core.Hypothesis{numbered(10), numbered(1), numbered(2), numbered(3)}, nil)
+
+	// All four start at once; h10 is accepted and the evaluations after it
+	// are cancelled rather than waited for
+	assert.Less(t, time.Since(start), testTask().Budget.Timeout)
+	require.True(t, out.Best.Accepted)
+	assert.Equal(t, "h10", out.Best.Hypothesis.ID)
+	assert.Equal(t, 1, out.Evaluations)
+}
@@ -10,11 +10,13 @@ import (
 	kbmem "github.com/snow-ghost/agent/kb/memory"
 	llmmock "github.com/snow-ghost/agent/llm/mock"
 	llmclient "github.com/snow-ghost/agent/pkg/llm/client"
+	"github.com/snow-ghost/agent/policy/local"
 	"github.com/snow-ghost/agent/testkit"
 	"github.com/snow-ghost/agent/worker/heavy"
 	"github.com/snow-ghost/agent/worker/light"
 	"github.com/snow-ghost/agent/worker/mutate"
 	"github.com/snow-ghost/agent/worker/telemetry"
+	"github.com/snow-ghost/agent/worker/tools"
 )
 
 // createLLMClient creates an LLM client based on configuration
@@ -47,7 +49,10 @@ func NewWorker(config *Config) (Worker, error) {
 	// Use artifact-based KB if artifacts directory is configured
 	var kb core.KnowledgeBase
 	if config.ArtifactsDir != "" {
-		interp := wasm.NewInterpreter()
+		interp, err := newInterpreter(config)
+		if err != nil {
+			return nil, err
+		}
 		kb = kbfs.NewArtifactKnowledgeBase(config.ArtifactsDir, interp)
 	} else {
 		// Fallback to memory-based KB
@@ -62,23 +67,72 @@ func NewWorker(config *Config) (Worker, error) {
 	case WorkerTypeHeavy:
 		// Create heavy worker components
 		llm := createLLMClient(config)
-		interp := wasm.NewInterpreter()
-		runner := testkit.NewRunner()
+		interp, err := newInterpreter(config)
+		if err != nil {
+			return nil, err
+		}
+		runner := testkit.NewRunnerWithWorkers(config.EvalWorkers)
 		fitness := core.NewWeightedFitness(map[string]float64{"cases_passed": 1.0, "cases_total": 0.0}, 0.0)
-		critic := core.NewSimpleCritic()
-		mut := mutate.NewSimpleMutator()
+		critic := core.NewRuleCritic()
+		mut := newMutator(llm, config)
 
-		return heavy.NewHeavyWorker(kb, llm, interp, runner, fitness, critic, mut, telemetry), nil
+		worker := heavy.NewHeavyWorker(kb, llm, interp, runner, fitness, critic, mut, telemetry)
+		worker.SetRepairRounds(config.LLMRepairRounds)
+		worker.SetEvolution(config.Evolution)
+		worker.SetProperties(testkit.NewPropertyTester(testkit.PropertyConfig{Runs: config.PropertyRuns}))
+		worker.SetReference(newReference(config))
+		return worker, nil
 
 	default:
 		// Default to heavy worker
 		llm := createLLMClient(config)
-		interp := wasm.NewInterpreter()
-		runner := testkit.NewRunner()
+		interp, err := newInterpreter(config)
+		if err != nil {
+			return nil, err
+		}
+		runner := testkit.NewRunnerWithWorkers(config.EvalWorkers)
 		fitness := core.NewWeightedFitness(map[string]float64{"cases_passed": 1.0, "cases_total": 0.0}, 0.0)
-		critic := core.NewSimpleCritic()
-		mut := mutate.NewSimpleMutator()
+		critic := core.NewRuleCritic()
+		mut := newMutator(llm, config)
 
-		return heavy.NewHeavyWorker(kb, llm, interp, runner, fitness, critic, mut, telemetry), nil
+		worker := heavy.NewHeavyWorker(kb, llm, interp, runner, fitness, critic, mut, telemetry)
+		worker.SetRepairRounds(config.LLMRepairRounds)
+		worker.SetEvolution(config.Evolution)
+		worker.SetProperties(testkit.NewPropertyTester(testkit.PropertyConfig{Runs: config.PropertyRuns}))
+		worker.SetReference(newReference(config))
+		return worker, nil
 	}
 }
+
+// newReference compares candidates with a KB skill on as many generated
+// inputs as property testing uses
+func newReference(config *Config) func(skill core.Skill) core.ReferenceTester {
+	return func(skill core.Skill) core.ReferenceTester {
+		return testkit.NewDifferential(skill, testkit.DifferentialConfig{Runs: config.PropertyRuns})
+	}
+}
+
+// newInterpreter creates the WASM sandbox; host functions reach the network
+// only through the tool allowlist
+func newInterpreter(config *Config) (*wasm.Interpreter, error) {
+	interp, err := wasm.NewInterpreterWithConfig(wasm.Config{
+		MemoryLimitMB: config.SandboxMemMB,
+		CacheSize:     config.WASMCacheSize,
+		CacheDir:      config.WASMCacheDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+	interp.SetTools(tools.NewAdapter(local.NewGuard(config.PolicyAllowTools)))
+	return interp, nil
+}
+
+// newMutator combines code edits with LLM mutations when the client supports
+// them
+func newMutator(llm core.LLMClient, config *Config) core.Mutator {
+	mut := mutate.NewWASMMutator(time.Now().UnixNano())
+	if evolver, ok := llm.(core.SourceEvolver); ok && config.LLMMutationsPerTask > 0 {
+		return mutate.NewLLMMutator(evolver, mut, config.LLMMutationsPerTask)
+	}
+	return mut
+}
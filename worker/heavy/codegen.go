@@ -0,0 +1,42 @@
+package heavy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wasm"
+)
+
+// DefaultRepairRounds is how many times a module that fails to build is sent
+// back to the LLM for repair
+const DefaultRepairRounds = 3
+
+// BuildModule turns LLM-proposed source into a validated WASM module.
+// Assembly and validation errors are sent back to the LLM for repair, up to
+// rounds times, when the client supports it; zero rounds disables repair. It
+// returns the module, the source it was built from and the number of
+// repairs.
+func BuildModule(ctx context.Context, llm core.LLMClient, task core.Task, source string, caller string, rounds int) ([]byte, string, int, error) {
+	repairer, canRepair := llm.(core.SourceRepairer)
+
+	for round := 0; ; round++ {
+		wasmBytes, err := wasm.Build(ctx, source)
+		if err == nil {
+			return wasmBytes, source, round, nil
+		}
+
+		if !canRepair || round >= rounds {
+			return nil, "", round, fmt.Errorf("LLM module failed to build after %d repair round(s): %w", round, err)
+		}
+
+		slog.WarnContext(ctx, "LLM module failed to build, requesting repair",
+			"task_id", task.ID, "round", round+1, "error", err)
+
+		source, err = repairer.RepairWithCaller(ctx, task, source, err.Error(), caller)
+		if err != nil {
+			return nil, "", round, fmt.Errorf("LLM repair failed: %w", err)
+		}
+	}
+}
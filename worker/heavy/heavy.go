@@ -2,26 +2,19 @@ package heavy
 
 import (
 	"context"
-	"fmt"
-	"log/slog"
 	"time"
 
 	"github.com/snow-ghost/agent/core"
-	llmmock "github.com/snow-ghost/agent/llm/mock"
 	"github.com/snow-ghost/agent/worker/capabilities"
 	"github.com/snow-ghost/agent/worker/common"
+	"github.com/snow-ghost/agent/worker/evolve"
 	"github.com/snow-ghost/agent/worker/telemetry"
 )
 
 // HeavyWorker implements the heavy worker type with LLM+WASM capabilities
 type HeavyWorker struct {
 	*common.BaseWorker
-	llm     core.LLMClient
-	interp  core.Interpreter
-	tests   core.TestRunner
-	fitness core.FitnessEvaluator
-	critic  core.Critic
-	mut     core.Mutator
+	pipeline Pipeline
 }
 
 // NewHeavyWorker creates a new heavy worker
@@ -31,15 +24,49 @@ func NewHeavyWorker(kb core.KnowledgeBase, llm core.LLMClient, interp core.Inter
 
 	baseWorker := common.NewBaseWorker(kb, telemetry, "heavy")
 
-	return &HeavyWorker{
+	h := &HeavyWorker{
 		BaseWorker: baseWorker,
-		llm:        llm,
-		interp:     interp,
-		tests:      tests,
-		fitness:    fitness,
-		critic:     critic,
-		mut:        mut,
+		pipeline: Pipeline{
+			KB:      kb,
+			LLM:     llm,
+			Interp:  interp,
+			Tests:   tests,
+			Fitness: fitness,
+			Critic:  critic,
+			Mut:     mut,
+
+			RepairRounds: DefaultRepairRounds,
+		},
 	}
+	if telemetry != nil {
+		h.pipeline.Observer = telemetry
+	}
+	return h
+}
+
+// SetRepairRounds sets how many times a module that fails to build is sent
+// back to the LLM for repair
+func (h *HeavyWorker) SetRepairRounds(rounds int) {
+	h.pipeline.RepairRounds = rounds
+}
+
+// SetEvolution sets the population, selection and stopping parameters of
+// evolution
+func (h *HeavyWorker) SetEvolution(config evolve.Config) {
+	h.pipeline.Evolution = config
+}
+
+// SetProperties sets the property tester evolution uses for tasks that
+// describe their inputs; without one only the proposed tests are run
+func (h *HeavyWorker) SetProperties(properties core.PropertyTester) {
+	h.pipeline.Properties = properties
+}
+
+// SetReference sets how a KB skill for the task's domain is made into a
+// reference that evolution compares candidates with; without one candidates
+// are not compared
+func (h *HeavyWorker) SetReference(reference func(skill core.Skill) core.ReferenceTester) {
+	h.pipeline.Reference = reference
 }
 
 // Caps returns the capabilities of the heavy worker
@@ -63,76 +90,8 @@ func (h *HeavyWorker) Solve(ctx context.Context, task core.Task) (core.Result, e
 		return result, nil
 	}
 
-	// 2) Request LLM (algorithm, tests, criteria)
-	slog.InfoContext(ctx, "requesting LLM proposal", "task_id", task.ID)
-
-	// Generate caller for cost tracking
-	caller := fmt.Sprintf("worker/%s/%s", task.Domain, task.ID)
-
-	algo, tests, criteria, err := h.llm.ProposeWithCaller(ctx, task, caller)
-	if err != nil {
-		slog.ErrorContext(ctx, "LLM proposal failed", "error", err, "task_id", task.ID, "caller", caller)
-		h.LogTaskEnd(ctx, task, core.Result{Success: false}, time.Since(start), 0)
-		return core.Result{Success: false}, err
-	}
-
-	// Convert algorithm string to WASM bytecode
-	var wasmBytes []byte
-	if mockLLM, ok := h.llm.(*llmmock.MockLLM); ok {
-		wasmBytes, err = mockLLM.GetWASMModule(algo)
-		if err != nil {
-			slog.ErrorContext(ctx, "failed to get WASM module", "error", err, "task_id", task.ID)
-			h.LogTaskEnd(ctx, task, core.Result{Success: false}, time.Since(start), 0)
-			return core.Result{Success: false}, err
-		}
-	} else {
-		// For other LLM implementations, assume algo is already bytecode
-		wasmBytes = []byte(algo)
-	}
-
-	hypothesis := core.Hypothesis{ID: "llm-0", Source: "llm", Lang: "wasm", Bytes: wasmBytes, Meta: map[string]string{"criteria": "set"}}
-	slog.InfoContext(ctx, "LLM proposal received", "tests_count", len(tests), "wasm_size", len(wasmBytes), "task_id", task.ID)
-
-	// 3) Evolutionary mini-cycle
-	best := hypothesis
-	bestScore := -1.0
-	deadline := time.Now().Add(task.Budget.Timeout)
-	slog.InfoContext(ctx, "starting evolution", "deadline", deadline, "task_id", task.ID)
-
-	iterations := 0
-	for time.Now().Before(deadline) {
-		iterations++
-		candidates := append([]core.Hypothesis{hypothesis}, h.mut.Mutate(best)...)
-		for _, c := range candidates {
-			// attach criteria to task spec for checks
-			task.Spec.SuccessCriteria = criteria
-			metrics, pass, _ := h.tests.Run(ctx, c, tests, h.interp)
-			score := h.fitness.Score(task, metrics, len(c.Bytes))
-			if pass && score > bestScore {
-				best, bestScore = c, score
-			}
-			ok, _ := h.critic.Accept(task, metrics)
-			if ok {
-				res, err := h.interp.Execute(ctx, c, task)
-				if err == nil && res.Success {
-					_ = h.GetKB().SaveHypothesis(ctx, c, score)
-					h.LogTaskEnd(ctx, task, res, time.Since(start), iterations)
-					return res, nil
-				}
-			}
-		}
-	}
-
-	// If we found a good hypothesis, try to execute it and save it
-	if bestScore > 0 {
-		res, err := h.interp.Execute(ctx, best, task)
-		if err == nil && res.Success {
-			_ = h.GetKB().SaveHypothesis(ctx, best, bestScore)
-			h.LogTaskEnd(ctx, task, res, time.Since(start), iterations)
-			return res, nil
-		}
-	}
-
-	h.LogTaskEnd(ctx, task, core.Result{Success: false}, time.Since(start), iterations)
-	return core.Result{Success: false}, nil
+	// 2) Propose, build and evolve
+	result, generations, err := h.pipeline.Run(ctx, task)
+	h.LogTaskEnd(ctx, task, result, time.Since(start), generations)
+	return result, err
 }
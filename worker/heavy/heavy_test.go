@@ -0,0 +1,164 @@
+package heavy
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wasm"
+	kbmem "github.com/snow-ghost/agent/kb/memory"
+	llmmock "github.com/snow-ghost/agent/llm/mock"
+	llmclient "github.com/snow-ghost/agent/pkg/llm/client"
+	"github.com/snow-ghost/agent/testkit"
+	"github.com/snow-ghost/agent/worker/mutate"
+	"github.com/snow-ghost/agent/worker/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// telemetry registers expvars, which may only happen once per process
+var testTelemetry = telemetry.NewTelemetry()
+
+// echoModule answers {"output":"test"}, matching echoProposal's test
+const echoModule = `(module
+  (memory (export "memory") 2)
+  (data (i32.const 65536) "{\"output\":\"test\"}")
+  (func (export "solve") (param $ptr i32) (param $len i32) (result i32 i32)
+    (i32.const 65536)
+    (i32.const 17)))`
+
+// echoProposal wraps a module in a proposal with a single echo test
+func echoProposal(module string) string {
+	proposal, _ := json.Marshal(map[string]any{
+		"algorithm": module,
+		"tests": []map[string]any{
+			{"name": "echo", "input": map[string]string{"input": "test"}, "oracle": map[string]string{"output": "test"}},
+		},
+		"criteria": []string{"echoes_input"},
+	})
+	return "```json\n" + string(proposal) + "\n```"
+}
+
+// newTestWorker creates a worker backed by llm, allowing llmMutations LLM
+// mutation requests per task on top of code edits
+func newTestWorker(t *testing.T, llm *llmmock.ScriptedChat, llmMutations int) *HeavyWorker {
+	t.Helper()
+
+	interp := wasm.NewInterpreter()
+	t.Cleanup(func() { interp.Close(context.Background()) })
+
+	adapter := llmclient.NewAdapter(llm)
+	var mut core.Mutator = mutate.NewWASMMutator(1)
+	if llmMutations > 0 {
+		mut = mutate.NewLLMMutator(adapter, mut, llmMutations)
+	}
+
+	return NewHeavyWorker(
+		kbmem.NewRegistryWithDir(t.TempDir()),
+		adapter,
+		interp,
+		testkit.NewRunner(),
+		core.NewWeightedFitness(map[string]float64{"cases_passed": 1.0}, 0.0),
+		core.NewSimpleCritic(),
+		mut,
+		testTelemetry,
+	)
+}
+
+func testTask() core.Task {
+	return core.Task{
+		ID:          "echo-1",
+		Domain:      "text",
+		Description: "Answer with the test output",
+		Spec:        core.Spec{Props: map[string]string{"type": "echo"}},
+		Input:       json.RawMessage(`{"input": "test"}`),
+		Budget:      core.Budget{CPUMillis: 2000, Timeout: 5 * time.Second},
+	}
+}
+
+func TestSolveRepairsGeneratedModule(t *testing.T) {
+	llm := llmmock.NewScriptedChat(
+		// Misspelled instruction
+		echoProposal(strings.Replace(echoModule, "(i32.const 17)", "(i32.cnst 17)", 1)),
+		// Assembles, but does not export memory
+		strings.Replace(echoModule, `(memory (export "memory") 2)`, `(memory 2)`, 1),
+		"Here is the fixed module:\n```wat\n"+echoModule+"\n```",
+	)
+	worker := newTestWorker(t, llm, 0)
+
+	result, err := worker.Solve(context.Background(), testTask())
+	require.NoError(t, err)
+	require.True(t, result.Success)
+	assert.JSONEq(t, `{"output":"test"}`, string(result.Output))
+
+	requests := llm.Requests()
+	require.Len(t, requests, 3)
+	for _, req := range requests {
+		assert.Equal(t, "worker/text/echo-1", req.Caller)
+	}
+
+	// Each repair request carries the failing source and its build error
+	firstRepair := requests[1].Messages
+	assert.Contains(t, firstRepair[2].Content, "i32.cnst")
+	assert.Contains(t, firstRepair[3].Content, `unknown instruction "i32.cnst"`)
+	assert.Contains(t, firstRepair[3].Content, "6:6")
+
+	secondRepair := requests[2].Messages
+	assert.Contains(t, secondRepair[3].Content, "does not export its memory")
+}
+
+func TestSolveGivesUpAfterRepairRounds(t *testing.T) {
+	llm := llmmock.NewScriptedChat(
+		echoProposal(`(module (func (export "solve")))`),
+		"I cannot write WebAssembly.",
+	)
+	worker := newTestWorker(t, llm, 0)
+	worker.SetRepairRounds(2)
+
+	result, err := worker.Solve(context.Background(), testTask())
+	require.Error(t, err)
+	assert.False(t, result.Success)
+	assert.Contains(t, err.Error(), "after 2 repair round(s)")
+	assert.Len(t, llm.Requests(), 3)
+}
+
+func TestSolveRejectsMismatchedProposal(t *testing.T) {
+	llm := llmmock.NewScriptedChat(
+		strings.Replace(echoProposal(echoModule), `{"input":"test"}`, `["test"]`, 1),
+	)
+	worker := newTestWorker(t, llm, 0)
+
+	result, err := worker.Solve(context.Background(), testTask())
+	require.Error(t, err)
+	assert.False(t, result.Success)
+	assert.Contains(t, err.Error(), `test "echo": input: expected object, got array`)
+	assert.Len(t, llm.Requests(), 1)
+}
+
+func TestSolveEvolvesWithLLMMutation(t *testing.T) {
+	wrong := strings.Replace(echoModule, `\"test\"`, `\"tset\"`, 1)
+	require.NotEqual(t, echoModule, wrong)
+
+	llm := llmmock.NewScriptedChat(
+		echoProposal(wrong),
+		"```wat\n"+echoModule+"\n```",
+	)
+	worker := newTestWorker(t, llm, 1)
+
+	result, err := worker.Solve(context.Background(), testTask())
+	require.NoError(t, err)
+	require.True(t, result.Success)
+	assert.JSONEq(t, `{"output":"test"}`, string(result.Output))
+
+	// The mutation request is billed to the task and quotes the failing test
+	requests := llm.Requests()
+	require.Len(t, requests, 2)
+	mutation := requests[1]
+	assert.Equal(t, "worker/text/echo-1", mutation.Caller)
+	assert.Contains(t, mutation.Messages[2].Content, "tset")
+	assert.Contains(t, mutation.Messages[3].Content, "fails these tests")
+	assert.Contains(t, mutation.Messages[3].Content, `- echo: input {"input":"test"}, expected {"output":"test"}`)
+}
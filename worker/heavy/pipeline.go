@@ -0,0 +1,130 @@
+package heavy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/snow-ghost/agent/core"
+	llmmock "github.com/snow-ghost/agent/llm/mock"
+	"github.com/snow-ghost/agent/worker/evolve"
+)
+
+// Pipeline solves a task from an LLM proposal: the proposed source is built
+// into a WASM module, with repair rounds, and evolved against the proposed
+// tests and criteria. HeavyWorker and worker.Solver both run it once the KB
+// has no skill for the task.
+type Pipeline struct {
+	KB      core.KnowledgeBase // receives the winning hypothesis
+	LLM     core.LLMClient
+	Interp  core.Interpreter
+	Tests   core.TestRunner
+	Fitness core.FitnessEvaluator
+	Critic  core.Critic
+	Mut     core.Mutator
+
+	// RepairRounds is how many times a module that fails to build is sent
+	// back to the LLM for repair; zero disables repair
+	RepairRounds int
+	// Evolution sets the population, selection and stopping parameters
+	Evolution evolve.Config
+	// Properties, if set, property tests candidates for tasks that describe
+	// their inputs
+	Properties core.PropertyTester
+	// Reference, if set, makes a reference tester from the most confident
+	// KB skill for the task's domain, and candidates are compared with it
+	Reference func(skill core.Skill) core.ReferenceTester
+	// Observer, if set, receives per-generation progress
+	Observer evolve.Observer
+}
+
+// Run asks the LLM for a solution to task and evolves it. It returns the
+// result of the accepted candidate, or failing that of the best one that
+// passes its tests, and the number of generations evolved.
+func (p *Pipeline) Run(ctx context.Context, task core.Task) (core.Result, int, error) {
+	// 1) Request LLM (algorithm, tests, criteria)
+	slog.InfoContext(ctx, "requesting LLM proposal", "task_id", task.ID)
+
+	// Generate caller for cost tracking
+	caller := fmt.Sprintf("worker/%s/%s", task.Domain, task.ID)
+
+	algo, tests, criteria, err := p.LLM.ProposeWithCaller(ctx, task, caller)
+	if err != nil {
+		slog.ErrorContext(ctx, "LLM proposal failed", "error", err, "task_id", task.ID, "caller", caller)
+		return core.Result{Success: false}, 0, err
+	}
+
+	// Convert algorithm string to WASM bytecode
+	var wasmBytes []byte
+	var source string
+	repairs := 0
+	if mockLLM, ok := p.LLM.(*llmmock.MockLLM); ok {
+		wasmBytes, err = mockLLM.GetWASMModule(algo)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to get WASM module", "error", err, "task_id", task.ID)
+			return core.Result{Success: false}, 0, err
+		}
+	} else {
+		// Other LLM implementations propose WAT source
+		wasmBytes, source, repairs, err = BuildModule(ctx, p.LLM, task, algo, caller, p.RepairRounds)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to build WASM module", "error", err, "task_id", task.ID, "repairs", repairs)
+			return core.Result{Success: false}, 0, err
+		}
+	}
+
+	hypothesis := core.Hypothesis{ID: "llm-0", Source: "llm", Lang: "wasm", Bytes: wasmBytes, Meta: map[string]string{"criteria": "set", "repairs": strconv.Itoa(repairs)}}
+	if source != "" {
+		hypothesis.Meta[core.MetaSource] = source
+	}
+	slog.InfoContext(ctx, "LLM proposal received", "tests_count", len(tests), "wasm_size", len(wasmBytes), "repairs", repairs, "task_id", task.ID)
+
+	// 2) Evolution
+	// attach criteria to task spec for checks
+	task.Spec.SuccessCriteria = criteria
+	slog.InfoContext(ctx, "starting evolution", "timeout", task.Budget.Timeout, "task_id", task.ID)
+
+	engine := &evolve.Engine{
+		Tests:      p.Tests,
+		Interp:     p.Interp,
+		Fitness:    p.Fitness,
+		Critic:     p.Critic,
+		Mut:        p.Mut,
+		Properties: p.Properties,
+		Reference:  p.reference(ctx, task),
+		Observer:   p.Observer,
+		Config:     p.Evolution,
+	}
+	outcome := engine.Run(ctx, task, []core.Hypothesis{hypothesis}, tests)
+	slog.InfoContext(ctx, "evolution finished", "task_id", task.ID, "generations", outcome.Generations,
+		"evaluations", outcome.Evaluations, "best_score", outcome.Best.Score, "accepted", outcome.Best.Accepted,
+		"stagnated", outcome.Stagnated, "diversity", outcome.Diversity, "counterexamples", len(outcome.Counterexamples))
+
+	// Run the accepted hypothesis, or failing that the best one that passes its tests
+	best := outcome.Best
+	if best.Accepted || (best.Pass && best.Score > 0) {
+		res, err := p.Interp.Execute(ctx, best.Hypothesis, task)
+		if err == nil && res.Success {
+			_ = p.KB.SaveHypothesis(ctx, best.Hypothesis, best.Score)
+			return res, outcome.Generations, nil
+		}
+	}
+
+	return core.Result{Success: false}, outcome.Generations, nil
+}
+
+// reference returns a tester comparing candidates with a KB skill for the
+// task's domain, or nil if there is none to compare with
+func (p *Pipeline) reference(ctx context.Context, task core.Task) core.ReferenceTester {
+	if p.Reference == nil {
+		return nil
+	}
+	for _, skill := range p.KB.Find(task) {
+		if skill.Domain() == task.Domain {
+			slog.InfoContext(ctx, "comparing candidates with reference skill", "task_id", task.ID, "skill_id", skill.Name())
+			return p.Reference(skill)
+		}
+	}
+	return nil
+}
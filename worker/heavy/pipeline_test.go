@@ -0,0 +1,79 @@
+package heavy
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wasm"
+	llmmock "github.com/snow-ghost/agent/llm/mock"
+	llmclient "github.com/snow-ghost/agent/pkg/llm/client"
+	"github.com/snow-ghost/agent/testkit"
+	"github.com/snow-ghost/agent/worker/mutate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoSkill answers {"output":"test"} for the text domain
+type echoSkill struct{}
+
+func (echoSkill) Name() string                            { return "text/echo" }
+func (echoSkill) Domain() string                          { return "text" }
+func (echoSkill) CanSolve(task core.Task) (bool, float64) { return task.Domain == "text", 1 }
+func (echoSkill) Tests() []core.TestCase                  { return nil }
+func (echoSkill) Execute(ctx context.Context, task core.Task) (core.Result, error) {
+	return core.Result{Success: true, Output: json.RawMessage(`{"output":"test"}`)}, nil
+}
+
+// skillKB finds its skills for every task and saves nothing
+type skillKB struct{ skills []core.Skill }
+
+func (k skillKB) Find(task core.Task) []core.Skill { return k.skills }
+func (k skillKB) SaveHypothesis(ctx context.Context, h core.Hypothesis, quality float64) error {
+	return nil
+}
+
+func TestPipelineComparesWithReferenceSkill(t *testing.T) {
+	// The proposed test has no oracle, so only the reference catches the
+	// wrong answer
+	wrong := strings.Replace(echoModule, `\"test\"`, `\"tset\"`, 1)
+	proposal, _ := json.Marshal(map[string]any{
+		"algorithm": wrong,
+		"tests":     []map[string]any{{"name": "echo", "input": map[string]string{"input": "test"}}},
+		"criteria":  []string{"echoes_input"},
+	})
+	llm := llmmock.NewScriptedChat(string(proposal), "```wat\n"+echoModule+"\n```")
+	adapter := llmclient.NewAdapter(llm)
+
+	interp := wasm.NewInterpreter()
+	defer interp.Close(context.Background())
+
+	var references []string
+	pipeline := &Pipeline{
+		KB:           skillKB{skills: []core.Skill{echoSkill{}}},
+		LLM:          adapter,
+		Interp:       interp,
+		Tests:        testkit.NewRunner(),
+		Fitness:      core.NewWeightedFitness(map[string]float64{"cases_passed": 1.0}, 0.0),
+		Critic:       core.NewSimpleCritic(),
+		Mut:          mutate.NewLLMMutator(adapter, mutate.NewWASMMutator(1), 1),
+		RepairRounds: DefaultRepairRounds,
+		Reference: func(skill core.Skill) core.ReferenceTester {
+			references = append(references, skill.Name())
+			return testkit.NewDifferential(skill, testkit.DifferentialConfig{})
+		},
+	}
+
+	result, _, err := pipeline.Run(context.Background(), testTask())
+	require.NoError(t, err)
+	require.True(t, result.Success)
+	assert.JSONEq(t, `{"output":"test"}`, string(result.Output))
+	assert.Equal(t, []string{"text/echo"}, references)
+
+	// The disagreement is a failing test the mutation request quotes
+	requests := llm.Requests()
+	require.Len(t, requests, 2)
+	assert.Contains(t, requests[1].Messages[3].Content, `expected {"output":"test"}`)
+}
@@ -0,0 +1,165 @@
+package mutate
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wasm"
+	"github.com/snow-ghost/agent/interp/wat"
+)
+
+// DefaultLLMMutationsPerTask bounds the LLM requests LLMMutator makes per
+// evolution run of a task
+const DefaultLLMMutationsPerTask = 4
+
+// LLMMutator asks an LLM for targeted repairs of a hypothesis's source and
+// for merges of two parents. Requests are made under the task's caller so
+// their cost is attributed to it, and each evolution run of a task gets a
+// fixed number of them; once spent, and for hypotheses without source, only
+// the next mutator's candidates are returned.
+type LLMMutator struct {
+	llm   core.SourceEvolver
+	next  core.Mutator
+	limit int
+
+	mu    sync.Mutex
+	usage map[string]*taskUsage
+}
+
+type taskUsage struct {
+	requests int
+}
+
+// NewLLMMutator creates an LLM mutator that allows limit requests per run
+// and also returns the candidates of next, if not nil
+func NewLLMMutator(llm core.SourceEvolver, next core.Mutator, limit int) *LLMMutator {
+	return &LLMMutator{
+		llm:   llm,
+		next:  next,
+		limit: limit,
+		usage: make(map[string]*taskUsage),
+	}
+}
+
+// Mutate implements core.Mutator. Without a task there is nothing to send to
+// the LLM, so only the next mutator's candidates are returned.
+func (m *LLMMutator) Mutate(base core.Hypothesis) []core.Hypothesis {
+	if m.next == nil {
+		return []core.Hypothesis{derive(base, "keep", base.Bytes, "keep")}
+	}
+	return m.next.Mutate(base)
+}
+
+// MutateGuided implements core.GuidedMutator
+func (m *LLMMutator) MutateGuided(ctx context.Context, task core.Task, base core.Hypothesis, feedback core.Feedback) []core.Hypothesis {
+	candidates := m.Mutate(base)
+
+	source := base.Meta[core.MetaSource]
+	if source == "" || !m.take(task.ID) {
+		return candidates
+	}
+
+	mutated, err := m.llm.MutateWithCaller(ctx, task, source, feedback, caller(task))
+	if err != nil {
+		slog.WarnContext(ctx, "LLM mutation failed", "task_id", task.ID, "base", base.ID, "error", err)
+		return candidates
+	}
+
+	if h, ok := m.build(ctx, task, base, mutated, "llm"); ok {
+		candidates = append(candidates, h)
+	}
+	return candidates
+}
+
+// Crossover implements core.GuidedMutator. It returns at most one child.
+func (m *LLMMutator) Crossover(ctx context.Context, task core.Task, a, b core.Hypothesis) []core.Hypothesis {
+	sourceA, sourceB := a.Meta[core.MetaSource], b.Meta[core.MetaSource]
+	if sourceA == "" || sourceB == "" || sourceA == sourceB || !m.take(task.ID) {
+		return nil
+	}
+
+	merged, err := m.llm.CrossoverWithCaller(ctx, task, sourceA, sourceB, caller(task))
+	if err != nil {
+		slog.WarnContext(ctx, "LLM crossover failed", "task_id", task.ID, "parents", a.ID+","+b.ID, "error", err)
+		return nil
+	}
+
+	h, ok := m.build(ctx, task, a, merged, "crossover")
+	if !ok {
+		return nil
+	}
+	h.Meta["parents"] = a.ID + "," + b.ID
+	return []core.Hypothesis{h}
+}
+
+// BeginRun implements core.RunScoped. The task gets a fresh budget, which is
+// forgotten when the run ends; outside a run, requests count against the
+// task until its next run begins.
+func (m *LLMMutator) BeginRun(taskID string) func() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u := &taskUsage{}
+	m.usage[taskID] = u
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		// A newer run of the task keeps its own budget
+		if m.usage[taskID] == u {
+			delete(m.usage, taskID)
+		}
+	}
+}
+
+// Used returns how many LLM requests a task has made in its current run
+func (m *LLMMutator) Used(taskID string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.usage[taskID]; ok {
+		return u.requests
+	}
+	return 0
+}
+
+// take claims one of the task's LLM requests
+func (m *LLMMutator) take(taskID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.usage[taskID]
+	if !ok {
+		u = &taskUsage{}
+		m.usage[taskID] = u
+	}
+	if u.requests >= m.limit {
+		return false
+	}
+	u.requests++
+	return true
+}
+
+// build turns LLM source into a hypothesis derived from base. Source that
+// fails to build is dropped; repairs are not worth another request here.
+func (m *LLMMutator) build(ctx context.Context, task core.Task, base core.Hypothesis, source string, op string) (core.Hypothesis, bool) {
+	bin, err := wasm.Build(ctx, source)
+	if err != nil {
+		slog.WarnContext(ctx, "LLM candidate failed to build", "task_id", task.ID, "base", base.ID, "op", op, "error", err)
+		return core.Hypothesis{}, false
+	}
+
+	sum := sha256.Sum256(bin)
+	h := derive(base, fmt.Sprintf("%s-%x", op, sum[:4]), bin, op)
+	h.Meta[core.MetaSource] = wat.Extract(source)
+	return h, true
+}
+
+// caller attributes requests to a task, as proposals are
+func caller(task core.Task) string {
+	return fmt.Sprintf("worker/%s/%s", task.Domain, task.ID)
+}
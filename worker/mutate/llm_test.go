@@ -0,0 +1,159 @@
+package mutate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const constModule = `(module
+  (memory (export "memory") 2)
+  (func (export "solve") (param i32 i32) (result i32 i32)
+    (i32.const 65536) (i32.const %d)))`
+
+// fakeEvolver answers every request with the same source and records calls
+type fakeEvolver struct {
+	reply    string
+	err      error
+	calls    []string
+	feedback []core.Feedback
+}
+
+func (f *fakeEvolver) MutateWithCaller(ctx context.Context, task core.Task, source string, feedback core.Feedback, caller string) (string, error) {
+	f.calls = append(f.calls, "mutate:"+caller)
+	f.feedback = append(f.feedback, feedback)
+	return f.reply, f.err
+}
+
+func (f *fakeEvolver) CrossoverWithCaller(ctx context.Context, task core.Task, a, b string, caller string) (string, error) {
+	f.calls = append(f.calls, "crossover:"+caller)
+	return f.reply, f.err
+}
+
+func sourceHypothesis(t *testing.T, id string, n int) core.Hypothesis {
+	t.Helper()
+	source := fmt.Sprintf(constModule, n)
+	bin, err := wat.Assemble(source)
+	require.NoError(t, err)
+	return core.Hypothesis{ID: id, Source: "llm", Lang: "wasm", Bytes: bin, Meta: map[string]string{core.MetaSource: source}}
+}
+
+func TestLLMMutator_MutateGuided(t *testing.T) {
+	evolver := &fakeEvolver{reply: "Try this:\n```wat\n" + fmt.Sprintf(constModule, 2) + "\n```"}
+	m := NewLLMMutator(evolver, nil, 2)
+	task := core.Task{ID: "t1", Domain: "text"}
+	base := sourceHypothesis(t, "llm-0", 1)
+	feedback := core.Feedback{
+		Metrics: map[string]float64{"cases_failed": 1},
+		Failing: []core.TestCase{{Name: "case_1", Input: []byte(`{}`)}},
+	}
+
+	cands := m.MutateGuided(context.Background(), task, base, feedback)
+	require.Len(t, cands, 2)
+	assert.Equal(t, "keep", cands[0].Meta["mut"])
+
+	child := cands[1]
+	assert.Equal(t, "llm", child.Meta["mut"])
+	assert.Contains(t, child.ID, "llm-0~llm-")
+	assert.Equal(t, fmt.Sprintf(constModule, 2), child.Meta[core.MetaSource])
+	assert.NotEqual(t, base.Bytes, child.Bytes)
+	assert.Equal(t, []string{"mutate:worker/text/t1"}, evolver.calls)
+	assert.Equal(t, feedback, evolver.feedback[0])
+
+	// The second request uses up the limit; later ones are not made
+	m.MutateGuided(context.Background(), task, base, feedback)
+	cands = m.MutateGuided(context.Background(), task, base, feedback)
+	assert.Len(t, cands, 1)
+	assert.Len(t, evolver.calls, 2)
+	assert.Equal(t, 2, m.Used("t1"))
+
+	// Limits are per task
+	m.MutateGuided(context.Background(), core.Task{ID: "t2", Domain: "text"}, base, feedback)
+	assert.Len(t, evolver.calls, 3)
+	assert.Equal(t, 1, m.Used("t2"))
+}
+
+func TestLLMMutator_BudgetPerRun(t *testing.T) {
+	evolver := &fakeEvolver{reply: fmt.Sprintf(constModule, 2)}
+	m := NewLLMMutator(evolver, nil, 1)
+	task := core.Task{ID: "t1", Domain: "text"}
+	base := sourceHypothesis(t, "llm-0", 1)
+
+	end := m.BeginRun(task.ID)
+	m.MutateGuided(context.Background(), task, base, core.Feedback{})
+	assert.Len(t, m.MutateGuided(context.Background(), task, base, core.Feedback{}), 1)
+	assert.Equal(t, 1, m.Used("t1"))
+	end()
+	assert.Equal(t, 0, m.Used("t1"))
+
+	// A retried task gets a fresh budget, and an earlier run ending late
+	// leaves it alone
+	end = m.BeginRun(task.ID)
+	retry := m.BeginRun(task.ID)
+	end()
+	assert.Len(t, m.MutateGuided(context.Background(), task, base, core.Feedback{}), 2)
+	assert.Equal(t, 1, m.Used("t1"))
+	retry()
+	assert.Len(t, evolver.calls, 2)
+}
+
+func TestLLMMutator_DropsUnusableReplies(t *testing.T) {
+	task := core.Task{ID: "t1", Domain: "text"}
+	base := sourceHypothesis(t, "llm-0", 1)
+
+	for name, evolver := range map[string]*fakeEvolver{
+		"error":       {err: errors.New("router down")},
+		"bad source":  {reply: "(module (func (export \"solve\")))"},
+		"no solution": {reply: "I don't know."},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := NewLLMMutator(evolver, NewWASMMutator(1), 4)
+			for _, c := range m.MutateGuided(context.Background(), task, base, core.Feedback{}) {
+				assert.NotEqual(t, "llm", c.Meta["mut"])
+			}
+			assert.Len(t, evolver.calls, 1)
+		})
+	}
+
+	// Without source there is nothing to send
+	evolver := &fakeEvolver{}
+	m := NewLLMMutator(evolver, nil, 4)
+	noSource := base
+	noSource.Meta = nil
+	m.MutateGuided(context.Background(), task, noSource, core.Feedback{})
+	assert.Empty(t, evolver.calls)
+}
+
+func TestLLMMutator_Crossover(t *testing.T) {
+	evolver := &fakeEvolver{reply: fmt.Sprintf(constModule, 3)}
+	m := NewLLMMutator(evolver, nil, 4)
+	task := core.Task{ID: "t1", Domain: "text"}
+	a, b := sourceHypothesis(t, "a", 1), sourceHypothesis(t, "b", 2)
+
+	children := m.Crossover(context.Background(), task, a, b)
+	require.Len(t, children, 1)
+	assert.Equal(t, "crossover", children[0].Meta["mut"])
+	assert.Equal(t, "a,b", children[0].Meta["parents"])
+	assert.Equal(t, []string{"crossover:worker/text/t1"}, evolver.calls)
+
+	// Identical parents are not worth a request
+	assert.Empty(t, m.Crossover(context.Background(), task, a, a))
+	assert.Len(t, evolver.calls, 1)
+}
+
+func TestWASMMutator_DropsSource(t *testing.T) {
+	base := sourceHypothesis(t, "llm-0", 1)
+	cands := NewWASMMutator(1).Mutate(base)
+	require.Greater(t, len(cands), 1)
+
+	assert.Equal(t, base.Meta[core.MetaSource], cands[0].Meta[core.MetaSource])
+	for _, c := range cands[1:] {
+		assert.NotContains(t, c.Meta, core.MetaSource)
+	}
+}
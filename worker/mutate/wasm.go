@@ -0,0 +1,329 @@
+package mutate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wasm"
+	"github.com/snow-ghost/agent/interp/wasmbin"
+)
+
+// DefaultWASMCandidates is how many mutants WASMMutator returns per call
+const DefaultWASMCandidates = 8
+
+// Mutation operators applied by WASMMutator
+const (
+	OpConst    = "const"    // perturb an integer or float constant
+	OpCompare  = "compare"  // swap a comparison for another of the same type
+	OpDelete   = "delete"   // delete a block, loop or if without results
+	OpDup      = "dup"      // duplicate a block or loop without results
+	OpLocal    = "local"    // read or write another local of the same type
+	OpInvert   = "invert"   // negate the condition of an if or br_if
+	OpRetarget = "retarget" // branch to another enclosing label
+)
+
+var wasmOps = []string{OpConst, OpCompare, OpDelete, OpDup, OpLocal, OpInvert, OpRetarget}
+
+// comparisonRanges are the opcode ranges of binary comparisons per type;
+// any two ops in a range take and produce the same types
+var comparisonRanges = [][2]byte{
+	{0x46, 0x4f}, // i32.eq .. i32.ge_u
+	{0x51, 0x5a}, // i64.eq .. i64.ge_u
+	{0x5b, 0x60}, // f32.eq .. f32.ge
+	{0x61, 0x66}, // f64.eq .. f64.ge
+}
+
+// WASMMutator edits the code of WASM hypotheses. Each candidate carries a
+// single edit and is validated before it is returned, so every candidate
+// compiles and keeps the interpreter's ABI.
+type WASMMutator struct {
+	// Candidates is the number of mutants to return per call
+	Candidates int
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewWASMMutator creates a WASM mutator with the given random seed
+func NewWASMMutator(seed int64) *WASMMutator {
+	return &WASMMutator{
+		Candidates: DefaultWASMCandidates,
+		rng:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+// site is a place in a function where an operator applies
+type site struct {
+	fn, at int
+}
+
+// Mutate returns the base clone followed by up to Candidates valid mutants.
+// Hypotheses that are not WASM or cannot be decoded get only the clone.
+func (m *WASMMutator) Mutate(base core.Hypothesis) []core.Hypothesis {
+	candidates := []core.Hypothesis{derive(base, "keep", base.Bytes, "keep")}
+	if base.Lang != "wasm" {
+		return candidates
+	}
+
+	mod, err := wasmbin.Decode(base.Bytes)
+	if err != nil {
+		return candidates
+	}
+	sites := collectSites(mod)
+	var ops []string
+	for _, op := range wasmOps {
+		if len(sites[op]) > 0 {
+			ops = append(ops, op)
+		}
+	}
+	if len(ops) == 0 {
+		return candidates
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := map[[sha256.Size]byte]bool{sha256.Sum256(base.Bytes): true}
+	for attempt := 0; attempt < m.Candidates*4 && len(candidates) <= m.Candidates; attempt++ {
+		op := ops[m.rng.Intn(len(ops))]
+		s := sites[op][m.rng.Intn(len(sites[op]))]
+
+		funcs := append([]*wasmbin.Func(nil), mod.Funcs...)
+		fn := *funcs[s.fn]
+		fn.Code = m.apply(op, &fn, s.at)
+		if fn.Code == nil {
+			continue
+		}
+		funcs[s.fn] = &fn
+
+		bin := mod.Encode(funcs)
+		sum := sha256.Sum256(bin)
+		if seen[sum] {
+			continue
+		}
+		seen[sum] = true
+
+		if err := wasm.Validate(context.Background(), bin); err != nil {
+			continue
+		}
+		suffix := fmt.Sprintf("%s-%x", op, sum[:4])
+		candidates = append(candidates, derive(base, suffix, bin, op))
+	}
+
+	return candidates
+}
+
+// derive clones base with new code and records the operator in Meta. Edited
+// code no longer matches the base's source, so only kept clones carry it.
+func derive(base core.Hypothesis, suffix string, code []byte, op string) core.Hypothesis {
+	h := core.Hypothesis{
+		ID:     fmt.Sprintf("%s~%s", base.ID, suffix),
+		Source: base.Source,
+		Lang:   base.Lang,
+		Bytes:  code,
+		Meta:   map[string]string{},
+	}
+	for k, v := range base.Meta {
+		h.Meta[k] = v
+	}
+	h.Meta["mut"] = op
+	if op != "keep" {
+		delete(h.Meta, core.MetaSource)
+	}
+	return h
+}
+
+// collectSites finds where each operator applies
+func collectSites(mod *wasmbin.Module) map[string][]site {
+	sites := map[string][]site{}
+	for f, fn := range mod.Funcs {
+		for i, in := range fn.Code {
+			s := site{f, i}
+			switch {
+			case in.Op >= wasmbin.OpI32Const && in.Op <= wasmbin.OpF64Const:
+				sites[OpConst] = append(sites[OpConst], s)
+			case comparisonRange(in.Op) >= 0:
+				sites[OpCompare] = append(sites[OpCompare], s)
+			case in.Op == wasmbin.OpBlock || in.Op == wasmbin.OpLoop || in.Op == wasmbin.OpIf:
+				if in.Imm[0] == wasmbin.BlockTypeEmpty {
+					sites[OpDelete] = append(sites[OpDelete], s)
+					if in.Op != wasmbin.OpIf {
+						sites[OpDup] = append(sites[OpDup], s)
+					}
+				}
+				if in.Op == wasmbin.OpIf {
+					sites[OpInvert] = append(sites[OpInvert], s)
+				}
+			case in.Op == wasmbin.OpLocalGet || in.Op == wasmbin.OpLocalSet || in.Op == wasmbin.OpLocalTee:
+				sites[OpLocal] = append(sites[OpLocal], s)
+			case in.Op == wasmbin.OpBrIf:
+				sites[OpInvert] = append(sites[OpInvert], s)
+				sites[OpRetarget] = append(sites[OpRetarget], s)
+			case in.Op == wasmbin.OpBr:
+				sites[OpRetarget] = append(sites[OpRetarget], s)
+			}
+		}
+	}
+	return sites
+}
+
+// apply returns a new copy of fn.Code with op applied at index at, or nil
+// when the edit would be a no-op
+func (m *WASMMutator) apply(op string, fn *wasmbin.Func, at int) []wasmbin.Instr {
+	code := fn.Code
+	in := code[at]
+
+	switch op {
+	case OpConst:
+		return replaceAt(code, at, wasmbin.Instr{Op: in.Op, Imm: m.perturb(in)})
+
+	case OpCompare:
+		r := comparisonRanges[comparisonRange(in.Op)]
+		next := r[0] + byte(m.rng.Intn(int(r[1]-r[0])))
+		if next >= in.Op {
+			next++
+		}
+		return replaceAt(code, at, wasmbin.Instr{Op: next})
+
+	case OpDelete:
+		end := matchingEnd(code, at)
+		if end < 0 {
+			return nil
+		}
+		var replacement []wasmbin.Instr
+		if in.Op == wasmbin.OpIf {
+			// Keep the stack balanced by dropping the condition
+			replacement = []wasmbin.Instr{{Op: wasmbin.OpDrop}}
+		}
+		return splice(code, at, end+1, replacement)
+
+	case OpDup:
+		end := matchingEnd(code, at)
+		if end < 0 {
+			return nil
+		}
+		return splice(code, end+1, end+1, code[at:end+1])
+
+	case OpLocal:
+		idx := wasmbin.ReadU32(in.Imm)
+		if int(idx) >= len(fn.LocalTypes) {
+			return nil
+		}
+		var same []uint32
+		for i, t := range fn.LocalTypes {
+			if uint32(i) != idx && t == fn.LocalTypes[idx] {
+				same = append(same, uint32(i))
+			}
+		}
+		if len(same) == 0 {
+			return nil
+		}
+		return replaceAt(code, at, wasmbin.Instr{Op: in.Op, Imm: wasmbin.AppendU32(nil, same[m.rng.Intn(len(same))])})
+
+	case OpInvert:
+		return splice(code, at, at, []wasmbin.Instr{{Op: wasmbin.OpI32Eqz}})
+
+	case OpRetarget:
+		depth := wasmbin.ReadU32(in.Imm)
+		// Branches to the function body must carry its results, so only
+		// retarget between enclosing blocks
+		labels := enclosingLabels(code, at)
+		if labels < 2 || int(depth) >= labels {
+			return nil
+		}
+		next := uint32(m.rng.Intn(labels - 1))
+		if next >= depth {
+			next++
+		}
+		return replaceAt(code, at, wasmbin.Instr{Op: in.Op, Imm: wasmbin.AppendU32(nil, next)})
+	}
+	return nil
+}
+
+// perturb returns new immediates for a constant
+func (m *WASMMutator) perturb(in wasmbin.Instr) []byte {
+	switch in.Op {
+	case wasmbin.OpI32Const, wasmbin.OpI64Const:
+		v := wasmbin.ReadS64(in.Imm)
+		choices := []int64{v + 1, v - 1, v * 2, v / 2, -v, 0, 1}
+		next := v
+		for next == v {
+			next = choices[m.rng.Intn(len(choices))]
+		}
+		if in.Op == wasmbin.OpI32Const {
+			next = int64(int32(next))
+		}
+		return wasmbin.AppendS64(nil, next)
+
+	case wasmbin.OpF32Const:
+		v := float64(math.Float32frombits(binary.LittleEndian.Uint32(in.Imm)))
+		return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(m.perturbFloat(v))))
+
+	default:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(in.Imm))
+		return binary.LittleEndian.AppendUint64(nil, math.Float64bits(m.perturbFloat(v)))
+	}
+}
+
+func (m *WASMMutator) perturbFloat(v float64) float64 {
+	choices := []float64{v * 1.1, v * 0.9, v + 1, v - 1, -v, 0}
+	return choices[m.rng.Intn(len(choices))]
+}
+
+func comparisonRange(op byte) int {
+	for i, r := range comparisonRanges {
+		if op >= r[0] && op <= r[1] {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchingEnd returns the index of the end closing the block opened at start
+func matchingEnd(code []wasmbin.Instr, start int) int {
+	depth := 0
+	for i := start; i < len(code); i++ {
+		switch code[i].Op {
+		case wasmbin.OpBlock, wasmbin.OpLoop, wasmbin.OpIf:
+			depth++
+		case wasmbin.OpEnd:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// enclosingLabels counts the blocks open at index at
+func enclosingLabels(code []wasmbin.Instr, at int) int {
+	depth := 0
+	for _, in := range code[:at] {
+		switch in.Op {
+		case wasmbin.OpBlock, wasmbin.OpLoop, wasmbin.OpIf:
+			depth++
+		case wasmbin.OpEnd:
+			depth--
+		}
+	}
+	return depth
+}
+
+func replaceAt(code []wasmbin.Instr, at int, in wasmbin.Instr) []wasmbin.Instr {
+	return splice(code, at, at+1, []wasmbin.Instr{in})
+}
+
+// splice returns a copy of code with code[from:to] replaced
+func splice(code []wasmbin.Instr, from, to int, replacement []wasmbin.Instr) []wasmbin.Instr {
+	out := make([]wasmbin.Instr, 0, len(code)-(to-from)+len(replacement))
+	out = append(out, code[:from]...)
+	out = append(out, replacement...)
+	return append(out, code[to:]...)
+}
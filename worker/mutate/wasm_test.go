@@ -0,0 +1,124 @@
+package mutate
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wasm"
+	"github.com/snow-ghost/agent/interp/wasmbin"
+	"github.com/snow-ghost/agent/interp/wat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countModule counts the elements of its input array that exceed a threshold
+// and answers {"n":<count>}
+const countModule = `(module
+  (memory (export "memory") 2)
+  (data (i32.const 65536) "{\"n\":0}")
+  (func (export "solve") (param $ptr i32) (param $len i32) (result i32 i32)
+    (local $i i32) (local $n i32) (local $c i32)
+    block $done
+      loop $next
+        (br_if $done (i32.ge_u (local.get $i) (local.get $len)))
+        (local.set $c (i32.load8_u (i32.add (local.get $ptr) (local.get $i))))
+        (if (i32.gt_u (local.get $c) (i32.const 52))
+          (then (local.set $n (i32.add (local.get $n) (i32.const 1)))))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        br $next
+      end
+    end
+    (i32.store8 offset=65541 (i32.const 0) (i32.add (i32.const 48) (local.get $n)))
+    (i32.const 65536)
+    (i32.const 7)))`
+
+func TestWASMMutator_MutatesCode(t *testing.T) {
+	bin, err := wat.Assemble(countModule)
+	require.NoError(t, err)
+
+	m := NewWASMMutator(1)
+	m.Candidates = 20
+	base := core.Hypothesis{ID: "count", Source: "llm", Lang: "wasm", Bytes: bin, Meta: map[string]string{"version": "v1"}}
+	cands := m.Mutate(base)
+	require.Greater(t, len(cands), 5)
+
+	assert.Equal(t, base.Bytes, cands[0].Bytes)
+	assert.Equal(t, "keep", cands[0].Meta["mut"])
+
+	interp := wasm.NewInterpreter()
+	defer interp.Close(context.Background())
+
+	task := core.Task{
+		ID:     "mut-test",
+		Domain: "algorithms",
+		Input:  json.RawMessage(`[1, 9, 7]`),
+		Budget: core.Budget{CPUMillis: 200, MemMB: 64, Timeout: 200 * time.Millisecond},
+	}
+
+	ops := map[string]bool{}
+	ids := map[string]bool{}
+	outputs := map[string]bool{}
+	for _, h := range cands[1:] {
+		assert.NotEqual(t, base.Bytes, h.Bytes)
+		assert.Equal(t, "v1", h.Meta["version"])
+		assert.False(t, ids[h.ID], "duplicate ID %s", h.ID)
+		ids[h.ID] = true
+		ops[h.Meta["mut"]] = true
+
+		require.NoError(t, wasm.Validate(context.Background(), h.Bytes), h.ID)
+
+		// Mutants may trap or loop, but never fail to instantiate
+		res, err := interp.Execute(context.Background(), h, task)
+		if err == nil && res.Success {
+			outputs[string(res.Output)] = true
+		}
+	}
+
+	assert.Greater(t, len(ops), 2, "expected several operators, got %v", ops)
+	assert.Greater(t, len(outputs), 1, "mutants should change behaviour")
+}
+
+func TestWASMMutator_Operators(t *testing.T) {
+	bin, err := wat.Assemble(countModule)
+	require.NoError(t, err)
+	mod, err := wasmbin.Decode(bin)
+	require.NoError(t, err)
+
+	sites := collectSites(mod)
+	m := NewWASMMutator(7)
+	for _, op := range wasmOps {
+		require.NotEmpty(t, sites[op], op)
+
+		t.Run(op, func(t *testing.T) {
+			s := sites[op][0]
+			fn := *mod.Funcs[s.fn]
+			code := m.apply(op, &fn, s.at)
+			require.NotNil(t, code)
+			assert.NotEqual(t, mod.Funcs[s.fn].Code, code)
+
+			fn.Code = code
+			assert.NoError(t, wasm.Validate(context.Background(), mod.Encode([]*wasmbin.Func{&fn})))
+		})
+	}
+
+	// Edits never write into the original code
+	again, err := wasmbin.Decode(bin)
+	require.NoError(t, err)
+	assert.Equal(t, again.Funcs[0].Code, mod.Funcs[0].Code)
+}
+
+func TestWASMMutator_KeepsUndecodable(t *testing.T) {
+	m := NewWASMMutator(1)
+
+	for _, base := range []core.Hypothesis{
+		{ID: "junk", Lang: "wasm", Bytes: []byte{1, 2, 3}},
+		{ID: "dsl", Lang: "dsl", Bytes: []byte("x")},
+	} {
+		cands := m.Mutate(base)
+		require.Len(t, cands, 1)
+		assert.Equal(t, base.ID+"~keep", cands[0].ID)
+	}
+}
@@ -4,10 +4,11 @@ package worker
 import (
 	"context"
 	"log/slog"
-	"time"
 
 	"github.com/snow-ghost/agent/core"
-	llmmock "github.com/snow-ghost/agent/llm/mock"
+	"github.com/snow-ghost/agent/worker/evolve"
+	"github.com/snow-ghost/agent/worker/heavy"
+	"github.com/snow-ghost/agent/worker/telemetry"
 )
 
 type Solver struct {
@@ -19,6 +20,21 @@ type Solver struct {
 	Critic  core.Critic
 	Mut     core.Mutator
 	Policy  core.PolicyGuard
+
+	// RepairRounds is how many times a module that fails to build is sent
+	// back to the LLM for repair, as with HeavyWorker.SetRepairRounds; zero
+	// disables repair
+	RepairRounds int
+	// Evolution configures the evolutionary search
+	Evolution evolve.Config
+	// Properties, if set, property tests hypotheses for tasks that describe
+	// their inputs
+	Properties core.PropertyTester
+	// Reference, if set, makes a KB skill for the task's domain into a
+	// reference that hypotheses are compared with
+	Reference func(skill core.Skill) core.ReferenceTester
+	// Telemetry, if set, receives per-generation progress
+	Telemetry *telemetry.Telemetry
 }
 
 func (s *Solver) Solve(ctx context.Context, task core.Task) (core.Result, error) {
@@ -35,65 +51,24 @@ func (s *Solver) Solve(ctx context.Context, task core.Task) (core.Result, error)
 			}
 		}
 	}
-	// 2) Request LLM (algorithm, tests, criteria)
-	slog.InfoContext(ctx, "requesting LLM proposal")
-	algo, tests, criteria, err := s.LLM.Propose(ctx, task)
-	if err != nil {
-		slog.ErrorContext(ctx, "LLM proposal failed", "error", err)
-		return core.Result{Success: false}, err
-	}
 
-	// Convert algorithm string to WASM bytecode
-	var wasmBytes []byte
-	if mockLLM, ok := s.LLM.(*llmmock.MockLLM); ok {
-		wasmBytes, err = mockLLM.GetWASMModule(algo)
-		if err != nil {
-			slog.ErrorContext(ctx, "failed to get WASM module", "error", err)
-			return core.Result{Success: false}, err
-		}
-	} else {
-		// For other LLM implementations, assume algo is already bytecode
-		wasmBytes = []byte(algo)
+	// 2) Propose, build and evolve, as the heavy worker does
+	pipeline := &heavy.Pipeline{
+		KB:           s.KB,
+		LLM:          s.LLM,
+		Interp:       s.Interp,
+		Tests:        s.Tests,
+		Fitness:      s.Fitness,
+		Critic:       s.Critic,
+		Mut:          s.Mut,
+		RepairRounds: s.RepairRounds,
+		Evolution:    s.Evolution,
+		Properties:   s.Properties,
+		Reference:    s.Reference,
 	}
-
-	h := core.Hypothesis{ID: "llm-0", Source: "llm", Lang: "wasm", Bytes: wasmBytes, Meta: map[string]string{"criteria": "set"}}
-	slog.InfoContext(ctx, "LLM proposal received", "tests_count", len(tests), "wasm_size", len(wasmBytes))
-
-	// 3) Evolutionary mini-cycle
-	best := h
-	bestScore := -1.0
-	deadline := time.Now().Add(task.Budget.Timeout)
-	slog.InfoContext(ctx, "starting evolution", "deadline", deadline)
-
-	for iter := 0; time.Now().Before(deadline); iter++ {
-		candidates := append([]core.Hypothesis{h}, s.Mut.Mutate(best)...)
-		for _, c := range candidates {
-			// attach criteria to task spec for checks
-			task.Spec.SuccessCriteria = criteria
-			metrics, pass, _ := s.Tests.Run(ctx, c, tests, s.Interp)
-			score := s.Fitness.Score(task, metrics, len(c.Bytes))
-			if pass && score > bestScore {
-				best, bestScore = c, score
-			}
-			ok, _ := s.Critic.Accept(task, metrics)
-			if ok {
-				res, err := s.Interp.Execute(ctx, c, task)
-				if err == nil && res.Success {
-					_ = s.KB.SaveHypothesis(ctx, c, score)
-					return res, nil
-				}
-			}
-		}
-	}
-
-	// If we found a good hypothesis, try to execute it and save it
-	if bestScore > 0 {
-		res, err := s.Interp.Execute(ctx, best, task)
-		if err == nil && res.Success {
-			_ = s.KB.SaveHypothesis(ctx, best, bestScore)
-			return res, nil
-		}
+	if s.Telemetry != nil {
+		pipeline.Observer = s.Telemetry
 	}
-
-	return core.Result{Success: false}, nil
+	res, _, err := pipeline.Run(ctx, task)
+	return res, err
 }
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snow-ghost/agent/core"
+	"github.com/snow-ghost/agent/interp/wasm"
+	kbmem "github.com/snow-ghost/agent/kb/memory"
+	llmmock "github.com/snow-ghost/agent/llm/mock"
+	llmclient "github.com/snow-ghost/agent/pkg/llm/client"
+	"github.com/snow-ghost/agent/testkit"
+	"github.com/snow-ghost/agent/worker/mutate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoModule answers {"output":"test"}, matching the proposal's test
+const echoModule = `(module
+  (memory (export "memory") 2)
+  (data (i32.const 65536) "{\"output\":\"test\"}")
+  (func (export "solve") (param $ptr i32) (param $len i32) (result i32 i32)
+    (i32.const 65536)
+    (i32.const 17)))`
+
+func newTestSolver(t *testing.T, llm *llmmock.ScriptedChat) *Solver {
+	t.Helper()
+
+	interp := wasm.NewInterpreter()
+	t.Cleanup(func() { interp.Close(context.Background()) })
+
+	return &Solver{
+		KB:      kbmem.NewRegistryWithDir(t.TempDir()),
+		LLM:     llmclient.NewAdapter(llm),
+		Interp:  interp,
+		Tests:   testkit.NewRunner(),
+		Fitness: core.NewWeightedFitness(map[string]float64{"cases_passed": 1.0}, 0.0),
+		Critic:  core.NewSimpleCritic(),
+		Mut:     mutate.NewWASMMutator(1),
+	}
+}
+
+func TestSolverBuildsAndRepairsProposedSource(t *testing.T) {
+	proposal, _ := json.Marshal(map[string]any{
+		// Misspelled instruction
+		"algorithm": strings.Replace(echoModule, "(i32.const 17)", "(i32.cnst 17)", 1),
+		"tests": []map[string]any{
+			{"name": "echo", "input": map[string]string{"input": "test"}, "oracle": map[string]string{"output": "test"}},
+		},
+		"criteria": []string{"echoes_input"},
+	})
+	llm := llmmock.NewScriptedChat(
+		"```json\n"+string(proposal)+"\n```",
+		"```wat\n"+echoModule+"\n```",
+	)
+	solver := newTestSolver(t, llm)
+	solver.RepairRounds = 1
+
+	task := core.Task{
+		ID:     "echo-1",
+		Domain: "text",
+		Spec:   core.Spec{Props: map[string]string{"type": "echo"}},
+		Input:  json.RawMessage(`{"input": "test"}`),
+		Budget: core.Budget{CPUMillis: 2000, Timeout: 5 * time.Second},
+	}
+	result, err := solver.Solve(context.Background(), task)
+	require.NoError(t, err)
+	require.True(t, result.Success)
+	assert.JSONEq(t, `{"output":"test"}`, string(result.Output))
+
+	requests := llm.Requests()
+	require.Len(t, requests, 2)
+	assert.Contains(t, requests[1].Messages[3].Content, `unknown instruction "i32.cnst"`)
+	for _, req := range requests {
+		assert.Equal(t, "worker/text/echo-1", req.Caller)
+	}
+}
+
+func TestSolverWithoutRepairRounds(t *testing.T) {
+	llm := llmmock.NewScriptedChat(
+		`{"algorithm": "(module (func (export \"solve\")))", "tests": [{"name": "echo", "input": {"input": "test"}}], "criteria": ["echoes_input"]}`,
+	)
+	solver := newTestSolver(t, llm)
+
+	task := core.Task{ID: "echo-2", Domain: "text", Input: json.RawMessage(`{"input": "test"}`)}
+	result, err := solver.Solve(context.Background(), task)
+	require.Error(t, err)
+	assert.False(t, result.Success)
+	assert.Contains(t, err.Error(), "after 0 repair round(s)")
+	assert.Len(t, llm.Requests(), 1)
+}